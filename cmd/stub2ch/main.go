@@ -1,24 +1,108 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"github.com/tempxla/stub2ch/internal/app/handle"
+	"github.com/tempxla/stub2ch/internal/app/service"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// フラグが無ければ環境変数、それも無ければ既定値を使う
 func main() {
 
-	router := handle.NewBoardRouter(nil)
+	webDir := handle.DefaultWebDir()
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-		log.Printf("Defaulting to port %s", port)
+	addr := flag.String("addr", envOr("ADDR", ":"+envOr("PORT", "8080")), "listen address (env ADDR or PORT)")
+	metricsAddr := flag.String("metrics-addr", envOr("METRICS_ADDR", "localhost:9090"), "metrics listen address, not to be exposed (env METRICS_ADDR)")
+	project := flag.String("project", envOr("PROJECT_ID", ""), "datastore project ID (env PROJECT_ID)")
+	templateDir := flag.String("template-dir", envOr("TEMPLATE_DIR", webDir.Template), "template directory (env TEMPLATE_DIR)")
+	staticDir := flag.String("static-dir", envOr("STATIC_DIR", webDir.Static), "static file directory (env STATIC_DIR)")
+	readTimeout := flag.Duration("read-timeout", envDuration("READ_TIMEOUT", 10*time.Second), "read timeout (env READ_TIMEOUT)")
+	writeTimeout := flag.Duration("write-timeout", envDuration("WRITE_TIMEOUT", 60*time.Second), "write timeout (env WRITE_TIMEOUT)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", envDuration("SHUTDOWN_TIMEOUT", 30*time.Second), "graceful shutdown timeout (env SHUTDOWN_TIMEOUT)")
+	flag.Parse()
+
+	service.ConfigureStorage(*project)
+	// 秘密なのでフラグにはしない
+	if err := service.ConfigureConfirmKeys(os.Getenv("CONFIRM_KEYS")); err != nil {
+		log.Fatal(err)
+	}
+	// App Engine (GAE_ENV=standard) 以外ではcronにCRON_SECRETを送らせる
+	handle.ConfigureCron(os.Getenv("GAE_ENV") == "standard", os.Getenv("CRON_SECRET"))
+	if err := handle.LoadWeb(&handle.WebDir{Template: *templateDir, Static: *staticDir}); err != nil {
+		log.Fatal(err)
+	}
+
+	srv := &http.Server{
+		Addr:         *addr,
+		Handler:      handle.NewBoardRouter(nil),
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+	}
+	// メトリクスは外から見えないところで公開する
+	metricsSrv := &http.Server{
+		Addr:    *metricsAddr,
+		Handler: handle.NewMetricsRouter(),
 	}
 
-	log.Printf("Listening on port %s", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), router))
+	go func() {
+		log.Printf("Metrics listening on %s", metricsSrv.Addr)
+		if err := metricsSrv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Print(err)
+		}
+	}()
 
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		log.Printf("Received %v, shutting down", <-sig)
+
+		// 処理中のリクエスト (bbs.cgiのトランザクションなど) が終わるのを待つ
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown: %v", err)
+		}
+		metricsSrv.Shutdown(ctx)
+	}()
+
+	log.Printf("Listening on %s", srv.Addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
+	if err := service.CloseStorage(); err != nil {
+		log.Printf("CloseStorage: %v", err)
+	}
+	log.Print("Server stopped")
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid %s: %v\n", name, err)
+		os.Exit(2)
+	}
+	return d
 }
@@ -0,0 +1,460 @@
+// 管理APIのクライアント
+//
+//	stub2chctl [flags] <func> <arg> [name=value ...]
+//
+// 例:
+//
+//	stub2chctl create-board news4vip
+//	stub2chctl write-limit get
+//	stub2chctl write-limit reset
+//	stub2chctl migrate threads
+//	stub2chctl migrate dat-sjis
+//	stub2chctl setting get board=news4vip
+//	stub2chctl setting update board=news4vip STUB_THREAD_COUNT=300
+//	stub2chctl thread stop board=news4vip key=1234567890
+//	stub2chctl thread rename board=news4vip key=1234567890 title=新しいスレタイ
+//	stub2chctl thread move board=news4vip key=1234567890 to=poverty
+//	stub2chctl maintenance set mode=read-only message=移転作業中です end=2020-01-18T12:00
+//	stub2chctl maintenance set board=poverty mode=closed start=2020-01-18T03:00 end=2020-01-18T04:00
+//	stub2chctl maintenance clear board=poverty
+//	stub2chctl hoshu preview board=news4vip
+//	stub2chctl hoshu sweep
+//	stub2chctl search rebuild [board=news4vip]
+//	stub2chctl session list
+//	stub2chctl archive export board=news4vip file=news4vip.zip
+//	stub2chctl archive import board=news4vip file=news4vip.zip
+//	stub2chctl archive import-dat board=news4vip dir=./dat [archived=1]  (大きいディレクトリは分けて送る)
+//	stub2chctl session revoke id=<session id>
+//	stub2chctl audit list action=login outcome=failure
+//
+// ログインは tools/auth/login.html と同じくパスフレーズとその署名で行う。
+// 署名は -key の秘密鍵で作るか、scripts/disp_signature.sh の出力を -sig で渡す。
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/tempxla/stub2ch/configs/app/admincfg"
+	jadmin "github.com/tempxla/stub2ch/internal/app/types/json/admin"
+	"github.com/tempxla/stub2ch/internal/app/util"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	user_agent = "Monazilla/1.00 stub2chctl"
+	api_path   = "/test/_admin/api"
+)
+
+func main() {
+	baseUrl := flag.String("url", "http://localhost:8080", "server URL")
+	passFile := flag.String("passphrase", filepath.Join("tools", "auth", "passphrase.txt"), "passphrase file")
+	keyFile := flag.String("key", filepath.Join(os.Getenv("HOME"), ".openssl", "stub2ch_private.pem"), "RSA private key (PEM)")
+	sig := flag.String("sig", "", "base64 signature of the passphrase (instead of -key)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <func> <arg> [name=value ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ok, err := run(*baseUrl, *passFile, *keyFile, *sig, flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func run(baseUrl, passFile, keyFile, sig string, args []string) (bool, error) {
+
+	passphrase, err := ioutil.ReadFile(passFile)
+	if err != nil {
+		return false, err
+	}
+
+	if sig == "" {
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return false, err
+		}
+		if sig, err = util.SignPKCS1v15(key, sha256.Sum256(passphrase)); err != nil {
+			return false, err
+		}
+	}
+
+	c := &client{baseUrl: strings.TrimRight(baseUrl, "/")}
+
+	// サーバー側でアンエスケープされる
+	login := url.Values{}
+	login.Set(admincfg.LOGIN_PASSPHRASE_PARAM, html.EscapeString(string(passphrase)))
+	login.Set(admincfg.LOGIN_SIGNATURE_PARAM, strings.TrimSpace(sig))
+	if _, err := c.post("/login", login); err != nil {
+		return false, fmt.Errorf("login: %v", err)
+	}
+	defer func() {
+		if _, err := c.post("/logout", nil); err != nil {
+			fmt.Fprintf(os.Stderr, "logout: %v\n", err)
+		}
+	}()
+
+	params := url.Values{}
+	for _, arg := range args[2:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return false, fmt.Errorf("invalid parameter: %v", arg)
+		}
+		params.Add(kv[0], kv[1])
+	}
+
+	var result *jadmin.Result
+	if args[0] == "archive" {
+		result, err = c.archive(args[1], params)
+	} else {
+		result, err = c.post("/func/"+url.PathEscape(args[0])+"/"+url.PathEscape(args[1]), params)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	fmt.Println(string(out))
+
+	return result.Ok, nil
+}
+
+type client struct {
+	baseUrl string
+	session *http.Cookie
+}
+
+func (c *client) post(path string, form url.Values) (*jadmin.Result, error) {
+	resp, err := c.do(path, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return c.readResult(resp)
+}
+
+// file=<zipファイル> に書き出す、または読み込む。
+// import-dat は dir=<datのディレクトリ> をzipにして送る。
+func (c *client) archive(mode string, params url.Values) (*jadmin.Result, error) {
+	if mode == "import-dat" {
+		dir := params.Get("dir")
+		if dir == "" {
+			return nil, fmt.Errorf("dir=<dat directory> is required")
+		}
+		params.Del("dir")
+		return c.importDatDir(dir, params)
+	}
+
+	file := params.Get("file")
+	if file == "" {
+		return nil, fmt.Errorf("file=<zip file> is required")
+	}
+	params.Del("file")
+
+	switch mode {
+	case "export":
+		resp, err := c.do("/archive/export", "application/x-www-form-urlencoded", strings.NewReader(params.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(resp.Body)
+			return nil, fmt.Errorf("%v: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		out, err := os.Create(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(out, resp.Body); err != nil {
+			out.Close()
+			return nil, err
+		}
+		if err := out.Close(); err != nil {
+			return nil, err
+		}
+		return &jadmin.Result{Ok: true, Message: "exported to " + file}, nil
+
+	case "import":
+		zipBytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return c.upload("/archive/import", params, filepath.Base(file), zipBytes)
+
+	default:
+		return nil, fmt.Errorf("unsupported: archive %v", mode)
+	}
+}
+
+func (c *client) upload(path string, params url.Values, filename string, zipBytes []byte) (*jadmin.Result, error) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for name := range params {
+		mw.WriteField(name, params.Get(name))
+	}
+	fw, err := mw.CreateFormFile("archive", filename)
+	if err != nil {
+		return nil, err
+	}
+	fw.Write(zipBytes)
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(path, mw.FormDataContentType(), body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return c.readResult(resp)
+}
+
+// 1回で送るdatの大きさ。サーバーの上限 (64MB) より小さくしておく
+const upload_batch_max = 32 * 1024 * 1024
+
+// 1回分のアップロード
+type datBatch struct {
+	names    []string
+	subject  []byte // このバッチで一覧に入れるスレのsubject.txt
+	archived bool
+}
+
+// ディレクトリのdatを何回かに分けて送る。サーバー側はスレッドキーで上書きするだけなので、
+// 途中で失敗したらもう一度同じディレクトリを送ればよい。
+//
+// 取り込んだスレは送るたびに一覧の上に並ぶので、subject.txtにあるスレは下の方から送る。
+// 一覧に入れる数は板のSTUB_THREAD_COUNTまでで、はみ出したスレとsubject.txtに無いスレは
+// 先にdat落ちとして送る。subject.txtが無ければ並びを保てないので、1回で送れないときは
+// archived=1 を付けてもらう。
+func (c *client) importDatDir(dir string, params url.Values) (*jadmin.Result, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]int64)
+	var names []string
+	var subject []string
+	for _, info := range infos {
+		name := info.Name()
+		switch {
+		case info.IsDir():
+		case name == "subject.txt":
+			b, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			for _, line := range strings.Split(string(b), "\n") {
+				if strings.TrimSpace(line) != "" {
+					subject = append(subject, line)
+				}
+			}
+		case strings.HasSuffix(name, ".dat"):
+			sizes[name] = info.Size()
+			names = append(names, name)
+		}
+	}
+
+	var batches []datBatch
+	if params.Get("archived") != "" || subject == nil {
+		batches = splitDats(names, sizes, nil, params.Get("archived") != "")
+		if len(batches) > 1 && params.Get("archived") == "" {
+			return nil, fmt.Errorf("%v: too large to send at once without subject.txt; add archived=1", dir)
+		}
+	} else {
+		limit, err := c.threadCount(params.Get("board"))
+		if err != nil {
+			return nil, err
+		}
+		if len(subject) > limit {
+			subject = subject[:limit]
+		}
+		listed := make(map[string]bool)
+		var live []string
+		for _, line := range subject {
+			name := strings.SplitN(line, "<>", 2)[0]
+			listed[name] = true
+			live = append(live, name)
+		}
+		var rest []string
+		for _, name := range names {
+			if !listed[name] {
+				rest = append(rest, name)
+			}
+		}
+		batches = splitDats(rest, sizes, nil, true)
+		liveBatches := splitDats(live, sizes, subject, false)
+		for i := len(liveBatches) - 1; i >= 0; i-- {
+			batches = append(batches, liveBatches[i])
+		}
+	}
+	if len(batches) == 0 {
+		return nil, fmt.Errorf("%v: no dat", dir)
+	}
+
+	count := 0
+	for i, batch := range batches {
+		zipBytes, err := zipDats(dir, batch)
+		if err != nil {
+			return nil, err
+		}
+		bp := url.Values{}
+		bp.Set("board", params.Get("board"))
+		if batch.archived {
+			bp.Set("archived", "1")
+		}
+		filename := fmt.Sprintf("%s-%d.zip", filepath.Base(dir), i+1)
+		result, err := c.upload("/archive/import-dat", bp, filename, zipBytes)
+		if err == nil && !result.Ok {
+			err = fmt.Errorf("%v", result.Message)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("upload %d/%d: %v (imported %d dats so far; rerun to resume)", i+1, len(batches), err, count)
+		}
+		if result.ImportCount != nil {
+			count += *result.ImportCount
+		}
+	}
+
+	return &jadmin.Result{
+		Ok:          true,
+		Message:     fmt.Sprintf("imported in %d uploads", len(batches)),
+		ImportCount: &count,
+	}, nil
+}
+
+// datを大きさで分ける。subjectがあれば各バッチのdatの行だけ持たせる
+func splitDats(names []string, sizes map[string]int64, subject []string, archived bool) []datBatch {
+	var batches []datBatch
+	var cur datBatch
+	var size int64
+	for i, name := range names {
+		if len(cur.names) > 0 && size+sizes[name] > upload_batch_max {
+			batches = append(batches, cur)
+			cur, size = datBatch{}, 0
+		}
+		cur.names = append(cur.names, name)
+		cur.archived = archived
+		if subject != nil {
+			cur.subject = append(cur.subject, subject[i]+"\n"...)
+		}
+		size += sizes[name]
+	}
+	if len(cur.names) > 0 {
+		batches = append(batches, cur)
+	}
+	return batches
+}
+
+// 板のSTUB_THREAD_COUNT (管理者の変更も含む)
+func (c *client) threadCount(boardName string) (int, error) {
+	form := url.Values{}
+	form.Set("board", boardName)
+	result, err := c.post("/func/setting/get", form)
+	if err != nil {
+		return 0, err
+	}
+	if result.BoardSetting != nil {
+		for _, v := range result.BoardSetting.Values {
+			if v.Name == "STUB_THREAD_COUNT" {
+				return strconv.Atoi(v.Value)
+			}
+		}
+	}
+	return 0, fmt.Errorf("STUB_THREAD_COUNT not found: %v", result.Message)
+}
+
+// バッチのdatとsubject.txtをzipにする
+func zipDats(dir string, batch datBatch) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	if batch.subject != nil {
+		fw, err := zw.Create("subject.txt")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(batch.subject); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range batch.names {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		fw, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(b); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// セッションクッキーはSecure付きなので、httpでも送れるように自分で持つ
+func (c *client) do(path, contentType string, body io.Reader) (*http.Response, error) {
+
+	req, err := http.NewRequest("POST", c.baseUrl+api_path+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", user_agent)
+	if c.session != nil {
+		req.AddCookie(&http.Cookie{Name: c.session.Name, Value: c.session.Value})
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func (c *client) readResult(resp *http.Response) (*jadmin.Result, error) {
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &jadmin.Result{}
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(result); err != nil {
+		return nil, fmt.Errorf("%v: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", resp.Status, result.Message)
+	}
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == admincfg.LOGIN_COOKIE_NAME {
+			c.session = cookie
+		}
+	}
+	return result, nil
+}
@@ -1,5 +1,9 @@
 package admincfg
 
+import (
+	"time"
+)
+
 // 管理者用の設定
 // 公開してもまあ問題ないけど、非公開の方がよい
 const (
@@ -15,3 +19,12 @@ const (
 	// シグネチャのリクエストパラメータ名
 	LOGIN_SIGNATURE_PARAM = "ADMIN_SIGNATURE"
 )
+
+// 管理者のセッション
+const (
+	// 操作が無いままこの時間が過ぎるとセッションが切れる。操作するたびに延長する。
+	SESSION_IDLE_TIMEOUT = 30 * time.Minute
+
+	// 延長してもログインからこの時間が過ぎるとセッションが切れる
+	SESSION_MAX_LIFETIME = 12 * time.Hour
+)
@@ -2,7 +2,7 @@ package bbscfg
 
 var (
 	heads = map[string][]byte{
-		"new4vip": []byte(`<pre>
+		"news4vip": []byte(`<pre>
 　　／⌒ヽ
 　 ∩ ^ω^) な ん だ
 　 |　 ⊂ﾉ
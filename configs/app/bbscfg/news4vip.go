@@ -9,6 +9,8 @@ func (_ *News4vip) BBS_SUBJECT_COUNT() int       { return 128 }
 func (_ *News4vip) BBS_NAME_COUNT() int          { return 96 }
 func (_ *News4vip) BBS_MAIL_COUNT() int          { return 32 }
 func (_ *News4vip) BBS_MESSAGE_COUNT() int       { return 4096 }
+func (_ *News4vip) BBS_LINE_NUMBER() int         { return 32 }
+func (_ *News4vip) BBS_COLUMN_NUMBER() int       { return 256 }
 func (_ *News4vip) BBS_THREAD_TATESUGI() int     { return 8 }
 func (_ *News4vip) BBS_SLIP() string             { return "verbose" }
 func (_ *News4vip) BBS_DISP_IP() string          { return "" }
@@ -23,4 +25,6 @@ func (_ *News4vip) BBS_DISP_MSEC() int           { return 3 }
 func (_ *News4vip) STUB_WRITE_ENTITY_LIMIT() int { return 4000 } // 5000までいける
 func (_ *News4vip) STUB_THREAD_COUNT() int       { return 500 }
 func (_ *News4vip) STUB_MESSAGE_COUNT() int      { return 1000 }
-func (_ *News4vip) STUB_DAT_CAPACITY() int       { return 500 * 1024 }
+func (_ *News4vip) STUB_DAT_CAPACITY() int       { return 2 * 1024 * 1024 } // datはチャンクに分けるので1MiBを超えてよい
+func (_ *News4vip) STUB_HOSHU_HOURS() int        { return 24 }
+func (_ *News4vip) STUB_SOKUOCHI_RES() int       { return 5 }
@@ -0,0 +1,198 @@
+package bbscfg
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// 設定項目
+type field struct {
+	name  string
+	isInt bool
+	get   func(Setting) string
+}
+
+func strField(name string, get func(Setting) string) field {
+	return field{name: name, get: get}
+}
+
+func intField(name string, get func(Setting) int) field {
+	return field{name: name, isInt: true, get: func(s Setting) string { return strconv.Itoa(get(s)) }}
+}
+
+var (
+	// SETTING.TXT の順番
+	fields = []field{
+		strField("BBS_TITLE", Setting.BBS_TITLE),
+		strField("BBS_NONAME_NAME", Setting.BBS_NONAME_NAME),
+		strField("BBS_UNICODE", Setting.BBS_UNICODE),
+		intField("BBS_SUBJECT_COUNT", Setting.BBS_SUBJECT_COUNT),
+		intField("BBS_NAME_COUNT", Setting.BBS_NAME_COUNT),
+		intField("BBS_MAIL_COUNT", Setting.BBS_MAIL_COUNT),
+		intField("BBS_MESSAGE_COUNT", Setting.BBS_MESSAGE_COUNT),
+		intField("BBS_LINE_NUMBER", Setting.BBS_LINE_NUMBER),
+		intField("BBS_COLUMN_NUMBER", Setting.BBS_COLUMN_NUMBER),
+		intField("BBS_THREAD_TATESUGI", Setting.BBS_THREAD_TATESUGI),
+		strField("BBS_SLIP", Setting.BBS_SLIP),
+		strField("BBS_DISP_IP", Setting.BBS_DISP_IP),
+		strField("BBS_FORCE_ID", Setting.BBS_FORCE_ID),
+		strField("BBS_NO_ID", Setting.BBS_NO_ID),
+		strField("BBS_JP_CHECK", Setting.BBS_JP_CHECK),
+		strField("BBS_4WORLD", Setting.BBS_4WORLD),
+		strField("BBS_YMD_WEEKS", Setting.BBS_YMD_WEEKS),
+		strField("BBS_ARR", Setting.BBS_ARR),
+		strField("BBS_SOKO", Setting.BBS_SOKO),
+		intField("BBS_DISP_MSEC", Setting.BBS_DISP_MSEC),
+		intField("STUB_WRITE_ENTITY_LIMIT", Setting.STUB_WRITE_ENTITY_LIMIT),
+		intField("STUB_THREAD_COUNT", Setting.STUB_THREAD_COUNT),
+		intField("STUB_MESSAGE_COUNT", Setting.STUB_MESSAGE_COUNT),
+		intField("STUB_DAT_CAPACITY", Setting.STUB_DAT_CAPACITY),
+		intField("STUB_HOSHU_HOURS", Setting.STUB_HOSHU_HOURS),
+		intField("STUB_SOKUOCHI_RES", Setting.STUB_SOKUOCHI_RES),
+	}
+)
+
+type NameValue struct {
+	Name  string
+	Value string
+}
+
+// 全項目の名前
+func SettingNames() []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+	}
+	return names
+}
+
+// 全項目の名前と値
+func SettingValues(setting Setting) []NameValue {
+	values := make([]NameValue, len(fields))
+	for i, f := range fields {
+		values[i] = NameValue{Name: f.name, Value: f.get(setting)}
+	}
+	return values
+}
+
+// 上書きできる値か確認する
+func ValidateValue(name, value string) error {
+	for _, f := range fields {
+		if f.name != name {
+			continue
+		}
+		if f.isInt {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: not a number: %v", name, value)
+			}
+			if n < 0 {
+				return fmt.Errorf("%s: negative number: %v", name, value)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown setting: %v", name)
+}
+
+// 実行時に変更された設定
+// Valuesに無い項目は元の設定の値を返す
+type Override struct {
+	Base   Setting
+	Values map[string]string
+}
+
+func (s *Override) str(name string, def string) string {
+	if v, ok := s.Values[name]; ok {
+		return v
+	}
+	return def
+}
+
+func (s *Override) num(name string, def int) int {
+	if v, ok := s.Values[name]; ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func (s *Override) BBS_TITLE() string {
+	return s.str("BBS_TITLE", s.Base.BBS_TITLE())
+}
+func (s *Override) BBS_NONAME_NAME() string {
+	return s.str("BBS_NONAME_NAME", s.Base.BBS_NONAME_NAME())
+}
+func (s *Override) BBS_UNICODE() string {
+	return s.str("BBS_UNICODE", s.Base.BBS_UNICODE())
+}
+func (s *Override) BBS_SUBJECT_COUNT() int {
+	return s.num("BBS_SUBJECT_COUNT", s.Base.BBS_SUBJECT_COUNT())
+}
+func (s *Override) BBS_NAME_COUNT() int {
+	return s.num("BBS_NAME_COUNT", s.Base.BBS_NAME_COUNT())
+}
+func (s *Override) BBS_MAIL_COUNT() int {
+	return s.num("BBS_MAIL_COUNT", s.Base.BBS_MAIL_COUNT())
+}
+func (s *Override) BBS_MESSAGE_COUNT() int {
+	return s.num("BBS_MESSAGE_COUNT", s.Base.BBS_MESSAGE_COUNT())
+}
+func (s *Override) BBS_LINE_NUMBER() int {
+	return s.num("BBS_LINE_NUMBER", s.Base.BBS_LINE_NUMBER())
+}
+func (s *Override) BBS_COLUMN_NUMBER() int {
+	return s.num("BBS_COLUMN_NUMBER", s.Base.BBS_COLUMN_NUMBER())
+}
+func (s *Override) BBS_THREAD_TATESUGI() int {
+	return s.num("BBS_THREAD_TATESUGI", s.Base.BBS_THREAD_TATESUGI())
+}
+func (s *Override) BBS_SLIP() string {
+	return s.str("BBS_SLIP", s.Base.BBS_SLIP())
+}
+func (s *Override) BBS_DISP_IP() string {
+	return s.str("BBS_DISP_IP", s.Base.BBS_DISP_IP())
+}
+func (s *Override) BBS_FORCE_ID() string {
+	return s.str("BBS_FORCE_ID", s.Base.BBS_FORCE_ID())
+}
+func (s *Override) BBS_NO_ID() string {
+	return s.str("BBS_NO_ID", s.Base.BBS_NO_ID())
+}
+func (s *Override) BBS_JP_CHECK() string {
+	return s.str("BBS_JP_CHECK", s.Base.BBS_JP_CHECK())
+}
+func (s *Override) BBS_4WORLD() string {
+	return s.str("BBS_4WORLD", s.Base.BBS_4WORLD())
+}
+func (s *Override) BBS_YMD_WEEKS() string {
+	return s.str("BBS_YMD_WEEKS", s.Base.BBS_YMD_WEEKS())
+}
+func (s *Override) BBS_ARR() string {
+	return s.str("BBS_ARR", s.Base.BBS_ARR())
+}
+func (s *Override) BBS_SOKO() string {
+	return s.str("BBS_SOKO", s.Base.BBS_SOKO())
+}
+func (s *Override) BBS_DISP_MSEC() int {
+	return s.num("BBS_DISP_MSEC", s.Base.BBS_DISP_MSEC())
+}
+func (s *Override) STUB_WRITE_ENTITY_LIMIT() int {
+	return s.num("STUB_WRITE_ENTITY_LIMIT", s.Base.STUB_WRITE_ENTITY_LIMIT())
+}
+func (s *Override) STUB_THREAD_COUNT() int {
+	return s.num("STUB_THREAD_COUNT", s.Base.STUB_THREAD_COUNT())
+}
+func (s *Override) STUB_MESSAGE_COUNT() int {
+	return s.num("STUB_MESSAGE_COUNT", s.Base.STUB_MESSAGE_COUNT())
+}
+func (s *Override) STUB_DAT_CAPACITY() int {
+	return s.num("STUB_DAT_CAPACITY", s.Base.STUB_DAT_CAPACITY())
+}
+func (s *Override) STUB_HOSHU_HOURS() int {
+	return s.num("STUB_HOSHU_HOURS", s.Base.STUB_HOSHU_HOURS())
+}
+func (s *Override) STUB_SOKUOCHI_RES() int {
+	return s.num("STUB_SOKUOCHI_RES", s.Base.STUB_SOKUOCHI_RES())
+}
@@ -20,6 +20,8 @@ type Setting interface {
 	BBS_NAME_COUNT() int
 	BBS_MAIL_COUNT() int
 	BBS_MESSAGE_COUNT() int
+	BBS_LINE_NUMBER() int   // 本文の最大行数 (スレ立てはこの2倍)
+	BBS_COLUMN_NUMBER() int // 本文の1行の最大バイト数
 	BBS_THREAD_TATESUGI() int
 	BBS_SLIP() string
 	BBS_DISP_IP() string
@@ -35,6 +37,8 @@ type Setting interface {
 	STUB_THREAD_COUNT() int       // 許容スレッド数
 	STUB_MESSAGE_COUNT() int      // 許容レス数
 	STUB_DAT_CAPACITY() int       // 許容バイト数
+	STUB_HOSHU_HOURS() int        // 最後の書き込みからこの時間でdat落ち (0なら落とさない)
+	STUB_SOKUOCHI_RES() int       // 立ってから1時間でこのレス数に届かなければdat落ち (0なら落とさない)
 }
 
 func GetSetting(boardName string) Setting {
@@ -50,6 +54,8 @@ func MakeSettingTxt(setting Setting) []byte {
 	fmt.Fprintf(sb, "BBS_NAME_COUNT=%d\n", setting.BBS_NAME_COUNT())
 	fmt.Fprintf(sb, "BBS_MAIL_COUNT=%d\n", setting.BBS_MAIL_COUNT())
 	fmt.Fprintf(sb, "BBS_MESSAGE_COUNT=%d\n", setting.BBS_MESSAGE_COUNT())
+	fmt.Fprintf(sb, "BBS_LINE_NUMBER=%d\n", setting.BBS_LINE_NUMBER())
+	fmt.Fprintf(sb, "BBS_COLUMN_NUMBER=%d\n", setting.BBS_COLUMN_NUMBER())
 	fmt.Fprintf(sb, "BBS_THREAD_TATESUGI=%d\n", setting.BBS_THREAD_TATESUGI())
 	fmt.Fprintf(sb, "BBS_SLIP=%s\n", setting.BBS_SLIP())
 	fmt.Fprintf(sb, "BBS_DISP_IP=%s\n", setting.BBS_DISP_IP())
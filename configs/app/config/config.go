@@ -5,7 +5,4 @@ package config
 const (
 	// appengineのプロジェクトID
 	PROJECT_ID = "stub2ch"
-
-	// アクセスを遮断する
-	KEEP_OUT = false
 )
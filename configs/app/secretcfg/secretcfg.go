@@ -5,4 +5,10 @@ package secretcfg
 const (
 	// ID計算用お塩
 	COMPUTE_ID_SALT = "200f3e1c-5a0b-4e55-b113-9dfb09726ae9"
+	// 書き込み確認クッキーに署名する鍵のID
+	// 鍵そのものは起動時に環境変数 CONFIRM_KEYS から読む (service.ConfigureConfirmKeys)。
+	// 鍵を替えるときは CONFIRM_KEYS に新しい鍵を足してこのIDを替える。
+	// 古い鍵は発行済みのクッキーが切れるまで (7日) 残しておく。
+	// 2020-01 の鍵はソースに載せてしまったので使わないこと。
+	CONFIRM_KEY_ID = "2020-02"
 )
@@ -7,4 +7,5 @@ require (
 	github.com/google/uuid v1.1.1
 	github.com/julienschmidt/httprouter v1.3.0
 	golang.org/x/text v0.3.8
+	google.golang.org/api v0.8.0
 )
@@ -1,12 +1,25 @@
 package handle
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"github.com/tempxla/stub2ch/configs/app/admincfg"
+	"github.com/tempxla/stub2ch/configs/app/bbscfg"
 	"github.com/tempxla/stub2ch/internal/app/service"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/audit"
+	jadmin "github.com/tempxla/stub2ch/internal/app/types/json/admin"
 	"log"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	audit_date_layout = "2006-01-02"
+	audit_time_layout = "2006/01/02 15:04:05"
 )
 
 func authenticate(sh ServiceHandle) ServiceHandle {
@@ -19,7 +32,7 @@ func authenticate(sh ServiceHandle) ServiceHandle {
 			http.Error(w, invalid_user_message, http.StatusForbidden) // 403
 			return
 		}
-		if err != sv.Admin.VerifySession(c.Value) {
+		if err := sv.Admin.VerifySession(c.Value); err != nil {
 			log.Println(err)
 			http.Error(w, invalid_user_message, http.StatusForbidden) // 403
 			return
@@ -29,48 +42,60 @@ func authenticate(sh ServiceHandle) ServiceHandle {
 	}
 }
 
+const (
+	login_fail_message = "login failed."
+)
+
 func handleAdminLogin() ServiceHandle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
-
-		const login_fail_message = "login failed."
-
-		pass, err := process(requireOne(r, admincfg.LOGIN_PASSPHRASE_PARAM), htmlUnescapeString)
-		if err != nil {
-			log.Print(err)
-			http.Error(w, login_fail_message, http.StatusForbidden) // 403
-			return
-		}
-		sig, err := process(requireOne(r, admincfg.LOGIN_SIGNATURE_PARAM))
-		if err != nil {
+		if err := adminLogin(w, r, sv); err != nil {
 			log.Print(err)
 			http.Error(w, login_fail_message, http.StatusForbidden) // 403
 			return
 		}
+		executeAdminIndex(w, r, newAdminView())
+	}
+}
 
-		sid, err := sv.Admin.Login(pass, sig)
-		if err != nil {
+func handleAdminApiLogin() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		if err := adminLogin(w, r, sv); err != nil {
 			log.Print(err)
-			http.Error(w, login_fail_message, http.StatusForbidden) // 403
+			writeAdminJson(w, http.StatusForbidden, &jadmin.Result{Message: login_fail_message}) // 403
 			return
 		}
+		writeAdminJson(w, http.StatusOK, newAdminResult(newAdminView()))
+	}
+}
 
-		cookie := &http.Cookie{
-			Name:  admincfg.LOGIN_COOKIE_NAME,
-			Value: sid,
-			Path:  "/",
-			//Secure:   true,
-			HttpOnly: true,
-		}
-		http.SetCookie(w, cookie)
+// ログインしてセッションクッキーを設定する
+func adminLogin(w http.ResponseWriter, r *http.Request, sv *service.BoardService) error {
 
-		executeAdminIndex(w, r, newAdminView())
+	// パスフレーズとシグネチャは記録しない
+	pass, err := process(requireOne(r, admincfg.LOGIN_PASSPHRASE_PARAM), htmlUnescapeString)
+	if err != nil {
+		writeAuditLog(r, sv, "login", "", nil, err)
+		return err
+	}
+	sig, err := process(requireOne(r, admincfg.LOGIN_SIGNATURE_PARAM))
+	if err != nil {
+		writeAuditLog(r, sv, "login", "", nil, err)
+		return err
+	}
+
+	sid, err := sv.Admin.Login(pass, sig, getIP(r), r.UserAgent())
+	writeAuditLog(r, sv, "login", sid, nil, err)
+	if err != nil {
+		return err
 	}
+
+	http.SetCookie(w, newAdminCookie(sid, 0))
+	return nil
 }
 
 func handleAdminLogout() ServiceHandle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
-		err := sv.Admin.Logout()
-		if err != nil {
+		if err := adminLogout(w, r, sv); err != nil {
 			fmt.Fprint(w, "Logout failed.")
 			return
 		}
@@ -78,48 +103,302 @@ func handleAdminLogout() ServiceHandle {
 	}
 }
 
+func handleAdminApiLogout() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		view := newAdminView()
+		view.Error = adminLogout(w, r, sv)
+		writeAdminJson(w, http.StatusOK, newAdminResult(view))
+	}
+}
+
+func adminLogout(w http.ResponseWriter, r *http.Request, sv *service.BoardService) error {
+	sid := adminSessionId(r)
+	err := sv.Admin.Logout(sid)
+	writeAuditLog(r, sv, "logout", sid, nil, err)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, newAdminCookie("", -1))
+	return nil
+}
+
+// 有効期限はサーバー側で管理するので、ブラウザを閉じたら消えるクッキーにする
+// maxAge < 0 で削除
+func newAdminCookie(sid string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     admincfg.LOGIN_COOKIE_NAME,
+		Value:    sid,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
 type adminView struct {
-	Error      error
-	Message    string
-	WriteCount int
+	Error        error
+	Message      string
+	WriteCount   int
+	MigrateCount int
+	ImportCount  int
+	AuditFilter  *auditFilterView
+	AuditLogs    []*auditLogView
+	Sessions     []*sessionView
+	BoardSetting *service.BoardSetting
+	Maintenance  []*maintenanceView
+	SweepCount   int
+	StaleThreads []*staleThreadView
+	IndexCount   int
+}
+
+type sessionView struct {
+	Id         string
+	AuditId    string
+	CreatedAt  string
+	LastAccess string
+	ExpiresAt  string
+	IpAddr     string
+	UserAgent  string
+	Current    bool
+}
+
+type auditFilterView struct {
+	Action    string
+	SessionId string
+	IpAddr    string
+	Outcome   string
+	Since     string
+	Until     string
+}
+
+type auditLogView struct {
+	Time      string
+	Action    string
+	SessionId string
+	IpAddr    string
+	Params    string
+	Outcome   string
+	Message   string
 }
 
 func newAdminView() *adminView {
 	return &adminView{
-		WriteCount: -1,
+		WriteCount:   -1,
+		MigrateCount: -1,
+		ImportCount:  -1,
+		SweepCount:   -1,
+		IndexCount:   -1,
+		AuditFilter:  &auditFilterView{},
 	}
 }
 
 func handleAdmin() ServiceHandle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		executeAdminIndex(w, r, runAdminFunc(r, ps, sv))
+	}
+}
 
-		view := newAdminView()
+// JSON版
+func handleAdminApi() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		writeAdminJson(w, http.StatusOK, newAdminResult(runAdminFunc(r, ps, sv)))
+	}
+}
+
+func runAdminFunc(r *http.Request, ps httprouter.Params, sv *service.BoardService) *adminView {
+	view := newAdminView()
 
-		fp1 := ps.ByName("fp1")
-		fp2 := ps.ByName("fp2")
+	fp1 := ps.ByName("fp1")
+	fp2 := ps.ByName("fp2")
+
+	// 参照するだけなので記録しない
+	switch fp1 + "/" + fp2 {
+	case "audit/list":
+		view.AuditFilter, view.AuditLogs, view.Error = listAuditLogs(r, sv)
+		return view
+	case "session/list":
+		view.Sessions, view.Error = listSessions(r, sv)
+		return view
+	case "setting/get":
+		view.BoardSetting, view.Error = sv.Admin.GetBoardSetting(r.PostFormValue("board"))
+		return view
+	case "maintenance/list":
+		view.Maintenance, view.Error = listMaintenance(sv)
+		return view
+	case "hoshu/preview":
+		view.StaleThreads, view.Error = listStaleThreads(sv, r.PostFormValue("board"))
+		return view
+	}
 
-		switch fp1 {
-		case "create-board":
-			switch fp2 {
-			case "news4vip", "poverty":
-				view.Error = sv.Admin.CreateBoard(fp2)
-			default:
-				view.Error = fmt.Errorf("unsupported: %v", fp2)
+	switch fp1 {
+	case "create-board":
+		switch fp2 {
+		case "news4vip", "poverty":
+			view.Error = sv.Admin.CreateBoard(fp2)
+		default:
+			view.Error = fmt.Errorf("unsupported: %v", fp2)
+		}
+	case "write-limit":
+		switch fp2 {
+		case "get":
+			view.WriteCount, view.Error = sv.Admin.GetWriteCount()
+		case "reset":
+			view.Error = sv.Admin.ResetWriteCount()
+		default:
+			view.Error = fmt.Errorf("unsupported: %v", fp2)
+		}
+	case "migrate":
+		switch fp2 {
+		case "dat-sjis":
+			view.MigrateCount, view.Error = sv.Admin.MigrateDatSjis()
+		case "threads":
+			view.MigrateCount, view.Error = sv.Admin.MigrateThreads()
+		default:
+			view.Error = fmt.Errorf("unsupported: %v", fp2)
+		}
+	case "setting":
+		boardName := r.PostFormValue("board")
+		switch fp2 {
+		case "update":
+			values := make(map[string]string)
+			for _, name := range bbscfg.SettingNames() {
+				if _, ok := r.PostForm[name]; ok {
+					values[name] = r.PostFormValue(name)
+				}
 			}
-		case "write-limit":
-			switch fp2 {
-			case "get":
-				view.WriteCount, view.Error = sv.Admin.GetWriteCount()
-			case "reset":
-				view.Error = sv.Admin.ResetWriteCount()
-			default:
-				view.Error = fmt.Errorf("unsupported: %v", fp2)
+			var headTxt *string
+			if _, ok := r.PostForm["head_txt"]; ok {
+				h := r.PostFormValue("head_txt")
+				headTxt = &h
 			}
+			view.Error = sv.Admin.UpdateBoardSetting(boardName, values, headTxt)
+		case "reset":
+			view.Error = sv.Admin.ResetBoardSetting(boardName)
+		default:
+			view.Error = fmt.Errorf("unsupported: %v", fp2)
+		}
+		if view.Error == nil {
+			view.BoardSetting, view.Error = sv.Admin.GetBoardSetting(boardName)
+		}
+	case "thread":
+		boardName := r.PostFormValue("board")
+		threadKey := r.PostFormValue("key")
+		switch fp2 {
+		case "stop":
+			view.Error = sv.Admin.StopThread(boardName, threadKey)
+		case "rename":
+			view.Error = sv.Admin.RenameThread(boardName, threadKey, r.PostFormValue("title"))
+		case "pin":
+			view.Error = sv.Admin.PinThread(boardName, threadKey, true)
+		case "unpin":
+			view.Error = sv.Admin.PinThread(boardName, threadKey, false)
+		case "move":
+			view.Error = sv.Admin.MoveThread(boardName, threadKey, r.PostFormValue("to"))
+		default:
+			view.Error = fmt.Errorf("unsupported: %v", fp2)
+		}
+	case "hoshu":
+		switch fp2 {
+		case "sweep":
+			view.SweepCount, view.Error = sv.Admin.SweepStaleThreads()
 		default:
-			view.Error = fmt.Errorf("unknown func %v/%v", fp1, fp2)
+			view.Error = fmt.Errorf("unsupported: %v", fp2)
 		}
-		executeAdminIndex(w, r, view)
+	case "search":
+		switch fp2 {
+		case "rebuild":
+			view.IndexCount, view.Error = sv.Admin.RebuildSearchIndex(r.PostFormValue("board"))
+		default:
+			view.Error = fmt.Errorf("unsupported: %v", fp2)
+		}
+	case "maintenance":
+		switch fp2 {
+		case "set":
+			view.Error = setMaintenance(r, sv)
+		case "clear":
+			view.Error = sv.Admin.ClearMaintenance(r.PostFormValue("board"))
+		default:
+			view.Error = fmt.Errorf("unsupported: %v", fp2)
+		}
+		if view.Error == nil {
+			view.Maintenance, view.Error = listMaintenance(sv)
+		}
+	case "session":
+		switch fp2 {
+		case "revoke":
+			if view.Error = sv.Admin.RevokeSession(r.PostFormValue("id")); view.Error == nil {
+				view.Sessions, view.Error = listSessions(r, sv)
+			}
+		default:
+			view.Error = fmt.Errorf("unsupported: %v", fp2)
+		}
+	default:
+		view.Error = fmt.Errorf("unknown func %v/%v", fp1, fp2)
+	}
+	writeAuditLog(r, sv, fp1+"/"+fp2, adminSessionId(r), formParams(r), view.Error)
+	return view
+}
+
+func newAdminResult(view *adminView) *jadmin.Result {
+	result := &jadmin.Result{
+		Ok:      view.Error == nil,
+		Message: "NO ERRORS.",
+	}
+	if view.Error != nil {
+		result.Message = fmt.Sprint(view.Error)
+	}
+	if view.WriteCount >= 0 {
+		result.WriteCount = &view.WriteCount
+	}
+	if view.MigrateCount >= 0 {
+		result.MigrateCount = &view.MigrateCount
+	}
+	if view.ImportCount >= 0 {
+		result.ImportCount = &view.ImportCount
+	}
+	if view.SweepCount >= 0 {
+		result.SweepCount = &view.SweepCount
+	}
+	if view.IndexCount >= 0 {
+		result.IndexCount = &view.IndexCount
 	}
+	for _, v := range view.Sessions {
+		result.Sessions = append(result.Sessions, jadmin.Session(*v))
+	}
+	for _, v := range view.AuditLogs {
+		result.AuditLogs = append(result.AuditLogs, jadmin.AuditLog(*v))
+	}
+	for _, v := range view.Maintenance {
+		result.Maintenance = append(result.Maintenance, jadmin.Maintenance(*v))
+	}
+	for _, v := range view.StaleThreads {
+		result.StaleThreads = append(result.StaleThreads, jadmin.StaleThread(*v))
+	}
+	if bs := view.BoardSetting; bs != nil {
+		result.BoardSetting = &jadmin.BoardSetting{
+			BoardName:      bs.BoardName,
+			Values:         []jadmin.SettingValue{},
+			HeadTxt:        bs.HeadTxt,
+			DefaultHeadTxt: bs.DefaultHeadTxt,
+		}
+		for _, v := range bs.Values {
+			result.BoardSetting.Values = append(result.BoardSetting.Values, jadmin.SettingValue(v))
+		}
+	}
+	return result
+}
+
+func writeAdminJson(w http.ResponseWriter, code int, result *jadmin.Result) {
+	b, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("ERROR: writeAdminJson. %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(b)
 }
 
 func executeAdminIndex(w http.ResponseWriter, r *http.Request, view *adminView) {
@@ -135,3 +414,125 @@ func executeAdminIndex(w http.ResponseWriter, r *http.Request, view *adminView)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
+
+func adminSessionId(r *http.Request) string {
+	if c, err := r.Cookie(admincfg.LOGIN_COOKIE_NAME); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// 管理者の操作を監査ログに残す。
+// 記録に失敗しても操作の結果は変えない。
+func writeAuditLog(r *http.Request, sv *service.BoardService,
+	action, sessionId string, params []string, actErr error) {
+
+	entity := &audit.Entity{
+		Time:      sv.StartedAt(),
+		Action:    action,
+		SessionId: service.AuditSessionId(sessionId),
+		IpAddr:    getIP(r),
+		Params:    params,
+	}
+	sv.Admin.WriteAuditLog(entity, actErr)
+}
+
+// name=value の形で並べる
+func formParams(r *http.Request) []string {
+	params := []string{}
+	for name, values := range r.PostForm {
+		for _, v := range values {
+			params = append(params, name+"="+v)
+		}
+	}
+	sort.Strings(params)
+	return params
+}
+
+func listAuditLogs(r *http.Request, sv *service.BoardService) (
+	_ *auditFilterView, _ []*auditLogView, err error) {
+
+	fv := &auditFilterView{
+		Action:    strings.TrimSpace(r.PostFormValue("action")),
+		SessionId: strings.TrimSpace(r.PostFormValue("session")),
+		IpAddr:    strings.TrimSpace(r.PostFormValue("ip")),
+		Outcome:   r.PostFormValue("outcome"),
+		Since:     r.PostFormValue("since"),
+		Until:     r.PostFormValue("until"),
+	}
+	filter := &service.AuditFilter{
+		Action:    fv.Action,
+		SessionId: fv.SessionId,
+		IpAddr:    fv.IpAddr,
+	}
+
+	switch fv.Outcome {
+	case "", audit.OUTCOME_SUCCESS, audit.OUTCOME_FAILURE:
+		filter.Outcome = fv.Outcome
+	default:
+		return fv, nil, fmt.Errorf("invalid outcome: %v", fv.Outcome)
+	}
+
+	loc := sv.StartedAt().Location()
+	if fv.Since != "" {
+		if filter.Since, err = time.ParseInLocation(audit_date_layout, fv.Since, loc); err != nil {
+			return fv, nil, err
+		}
+	}
+	if fv.Until != "" {
+		// その日の終わりまで含める
+		if filter.Until, err = time.ParseInLocation(audit_date_layout, fv.Until, loc); err != nil {
+			return fv, nil, err
+		}
+		filter.Until = filter.Until.AddDate(0, 0, 1)
+	}
+	if limit := r.PostFormValue("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil {
+			return fv, nil, err
+		}
+	}
+
+	entities, err := sv.Admin.GetAuditLogs(filter)
+	if err != nil {
+		return fv, nil, err
+	}
+
+	logs := make([]*auditLogView, len(entities))
+	for i, e := range entities {
+		logs[i] = &auditLogView{
+			Time:      e.Time.In(loc).Format(audit_time_layout),
+			Action:    e.Action,
+			SessionId: e.SessionId,
+			IpAddr:    e.IpAddr,
+			Params:    strings.Join(e.Params, " "),
+			Outcome:   e.Outcome,
+			Message:   e.Message,
+		}
+	}
+	return fv, logs, nil
+}
+
+func listSessions(r *http.Request, sv *service.BoardService) (_ []*sessionView, err error) {
+	sessions, err := sv.Admin.ListSessions()
+	if err != nil {
+		return
+	}
+
+	loc := sv.StartedAt().Location()
+	current := service.AuditSessionId(adminSessionId(r))
+
+	views := make([]*sessionView, len(sessions))
+	for i, s := range sessions {
+		views[i] = &sessionView{
+			Id:         s.Id,
+			AuditId:    s.AuditId(),
+			CreatedAt:  s.CreatedAt.In(loc).Format(audit_time_layout),
+			LastAccess: s.LastAccess.In(loc).Format(audit_time_layout),
+			ExpiresAt:  s.ExpiresAt.In(loc).Format(audit_time_layout),
+			IpAddr:     s.IpAddr,
+			UserAgent:  s.UserAgent,
+			Current:    s.AuditId() == current,
+		}
+	}
+	return views, nil
+}
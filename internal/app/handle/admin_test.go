@@ -1,16 +1,22 @@
 package handle
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"github.com/tempxla/stub2ch/configs/app/admincfg"
 	"github.com/tempxla/stub2ch/internal/app/service"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/audit"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/session"
+	jadmin "github.com/tempxla/stub2ch/internal/app/types/json/admin"
 	"github.com/tempxla/stub2ch/tools/app/testutil"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func authenticatedRequest(t *testing.T,
@@ -29,7 +35,7 @@ func authenticatedRequest(t *testing.T,
 		t.Error(err)
 	}
 
-	sid, err := sv.Admin.Login(string(passphrase), string(base64Sig))
+	sid, err := sv.Admin.Login(string(passphrase), string(base64Sig), "", "")
 	if err != nil {
 		t.Errorf("setup failed. %v", err)
 	}
@@ -51,7 +57,7 @@ func TestAuthenticate(t *testing.T) {
 		return
 	}
 
-	sv, _ := service.DefaultBoardService()
+	sv, _ := service.DefaultBoardService(context.Background())
 
 	router := httprouter.New()
 	router.POST("/test/_admin/", injectService(sv)(authenticate(handleOK)))
@@ -66,7 +72,7 @@ func TestAuthenticate(t *testing.T) {
 		t.Error(err)
 	}
 
-	sid, err := sv.Admin.Login(string(passphrase), string(base64Sig))
+	sid, err := sv.Admin.Login(string(passphrase), string(base64Sig), "", "")
 	if err != nil {
 		t.Errorf("setup failed. %v", err)
 	}
@@ -99,7 +105,7 @@ func TestAuthenticate_CookieMissing(t *testing.T) {
 		return
 	}
 
-	sv, _ := service.DefaultBoardService()
+	sv, _ := service.DefaultBoardService(context.Background())
 
 	router := httprouter.New()
 	router.POST("/test/_admin/", injectService(sv)(authenticate(handleOK)))
@@ -128,7 +134,7 @@ func TestAuthenticate_WrongSession(t *testing.T) {
 		return
 	}
 
-	sv, _ := service.DefaultBoardService()
+	sv, _ := service.DefaultBoardService(context.Background())
 
 	router := httprouter.New()
 	router.POST("/test/_admin/", injectService(sv)(authenticate(handleOK)))
@@ -171,7 +177,7 @@ func TestHandleAdminLogin(t *testing.T) {
 	request.PostForm.Add(admincfg.LOGIN_PASSPHRASE_PARAM, string(passphrase))
 	request.PostForm.Add(admincfg.LOGIN_SIGNATURE_PARAM, string(base64Sig))
 
-	sv, _ := service.DefaultBoardService()
+	sv, _ := service.DefaultBoardService(context.Background())
 	router := NewBoardRouter(sv)
 
 	// Exercise
@@ -205,7 +211,7 @@ func TestHandleAdminLogin_MissingPassphrase(t *testing.T) {
 	//request.PostForm.Add(admincfg.LOGIN_PASSPHRASE_PARAM, string(passphrase)) missing
 	request.PostForm.Add(admincfg.LOGIN_SIGNATURE_PARAM, string(base64Sig))
 
-	sv, _ := service.DefaultBoardService()
+	sv, _ := service.DefaultBoardService(context.Background())
 	router := NewBoardRouter(sv)
 
 	// Exercise
@@ -235,7 +241,7 @@ func TestHandleAdminLogin_MissingSignature(t *testing.T) {
 	request.PostForm.Add(admincfg.LOGIN_PASSPHRASE_PARAM, string(passphrase))
 	//request.PostForm.Add(admincfg.LOGIN_SIGNATURE_PARAM, string(base64Sig)) missing
 
-	sv, _ := service.DefaultBoardService()
+	sv, _ := service.DefaultBoardService(context.Background())
 	router := NewBoardRouter(sv)
 
 	// Exercise
@@ -265,7 +271,7 @@ func TestHandleAdminLogin_WrongSignature(t *testing.T) {
 	request.PostForm.Add(admincfg.LOGIN_PASSPHRASE_PARAM, string(passphrase))
 	request.PostForm.Add(admincfg.LOGIN_SIGNATURE_PARAM, "wrong sig")
 
-	sv, _ := service.DefaultBoardService()
+	sv, _ := service.DefaultBoardService(context.Background())
 	router := NewBoardRouter(sv)
 
 	// Exercise
@@ -283,7 +289,7 @@ func TestHandleAdminLogin_WrongSignature(t *testing.T) {
 
 func TestHandleLogout(t *testing.T) {
 
-	sv, _ := service.DefaultBoardService()
+	sv, _ := service.DefaultBoardService(context.Background())
 
 	// Session Cookie
 	passphrase, err := ioutil.ReadFile("/tmp/pass_stub2ch.txt")
@@ -296,7 +302,7 @@ func TestHandleLogout(t *testing.T) {
 		t.Error(err)
 	}
 
-	sid, err := sv.Admin.Login(string(passphrase), string(base64Sig))
+	sid, err := sv.Admin.Login(string(passphrase), string(base64Sig), "", "")
 	if err != nil {
 		t.Errorf("setup failed. %v", err)
 	}
@@ -329,7 +335,7 @@ func TestHandleAdmin_CreateBoard(t *testing.T) {
 	// Clean Datastore
 	testutil.CleanDatastore(t)
 
-	sv, _ := service.DefaultBoardService()
+	sv, _ := service.DefaultBoardService(context.Background())
 	request := authenticatedRequest(t, sv, "POST", "/test/_admin/func/create-board/poverty")
 	request.Header.Add("User-Agent", "Monazilla/1.00")
 	writer := httptest.NewRecorder()
@@ -351,7 +357,7 @@ func TestHandleAdmin_CreateBoard(t *testing.T) {
 
 func TestHandleAdmin_UnknownFunc(t *testing.T) {
 
-	sv, _ := service.DefaultBoardService()
+	sv, _ := service.DefaultBoardService(context.Background())
 	request := authenticatedRequest(t, sv, "POST", "/test/_admin/func/FUNCX/X")
 	request.Header.Add("User-Agent", "Monazilla/1.00")
 	writer := httptest.NewRecorder()
@@ -373,7 +379,7 @@ func TestHandleAdmin_UnknownFunc(t *testing.T) {
 
 func TestHandleAdmin_CreateBoard_NoSupports(t *testing.T) {
 
-	sv, _ := service.DefaultBoardService()
+	sv, _ := service.DefaultBoardService(context.Background())
 	request := authenticatedRequest(t, sv, "POST", "/test/_admin/func/create-board/nosupp")
 	request.Header.Add("User-Agent", "Monazilla/1.00")
 	writer := httptest.NewRecorder()
@@ -392,3 +398,320 @@ func TestHandleAdmin_CreateBoard_NoSupports(t *testing.T) {
 		t.Errorf("%v", body)
 	}
 }
+
+func TestAdminAuditLog(t *testing.T) {
+
+	// Setup
+	repo := testutil.InitialBoardStub()
+	sysEnv := &service.SysEnv{
+		StartedTime: time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC),
+	}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv), service.AdminConf(repo, nil))
+
+	router := httprouter.New()
+	router.POST("/:board/_admin/func/:fp1/:fp2", handleParseForm(injectService(sv)(handleAdmin())))
+
+	request, _ := http.NewRequest("POST", "/test/_admin/func/create-board/nosupp", nil)
+	request.RemoteAddr = "192.0.2.1:1234"
+	request.AddCookie(&http.Cookie{Name: admincfg.LOGIN_COOKIE_NAME, Value: "SID"})
+
+	// Exercise
+	router.ServeHTTP(httptest.NewRecorder(), request)
+
+	// Verify
+	if len(repo.AuditLog) != 1 {
+		t.Fatalf("len = %v", len(repo.AuditLog))
+	}
+	e := repo.AuditLog[0]
+	if e.Action != "create-board/nosupp" || e.IpAddr != "192.0.2.1" ||
+		e.SessionId != service.AuditSessionId("SID") || e.Outcome != audit.OUTCOME_FAILURE {
+		t.Errorf("%v", e)
+	}
+
+	// Exercise: list
+	writer := httptest.NewRecorder()
+	request, _ = http.NewRequest("POST", "/test/_admin/func/audit/list",
+		strings.NewReader("action=create&outcome=failure&since=2019-11-23&until=2019-11-23"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(writer, request)
+
+	// Verify
+	body := writer.Body.String()
+	if !strings.Contains(body, "NO ERRORS.") || !strings.Contains(body, "create-board/nosupp") {
+		t.Errorf("%v", body)
+	}
+	if len(repo.AuditLog) != 1 {
+		t.Errorf("audit list is recorded. len = %v", len(repo.AuditLog))
+	}
+}
+
+func TestAdminAuditLog_InvalidFilter(t *testing.T) {
+
+	repo := testutil.InitialBoardStub()
+	sysEnv := &service.SysEnv{StartedTime: time.Now()}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv), service.AdminConf(repo, nil))
+
+	router := httprouter.New()
+	router.POST("/:board/_admin/func/:fp1/:fp2", handleParseForm(injectService(sv)(handleAdmin())))
+
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/test/_admin/func/audit/list", strings.NewReader("since=xxx"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Exercise
+	router.ServeHTTP(writer, request)
+
+	// Verify
+	if body := writer.Body.String(); strings.Contains(body, "NO ERRORS.") {
+		t.Errorf("%v", body)
+	}
+}
+
+func TestNewAdminCookie(t *testing.T) {
+	c := newAdminCookie("SID", 0)
+	if c.Name != admincfg.LOGIN_COOKIE_NAME || c.Value != "SID" ||
+		!c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteStrictMode {
+		t.Errorf("%v", c)
+	}
+	if c := newAdminCookie("", -1); c.MaxAge != -1 {
+		t.Errorf("%v", c)
+	}
+}
+
+func TestAdminSession_ListAndRevoke(t *testing.T) {
+
+	// Setup
+	now := time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)
+	repo := testutil.InitialBoardStub()
+	for _, id := range []string{"0123456789abcdef0000", "fedcba98765432100000"} {
+		repo.PutSession(repo.SessionKey(id), &session.Entity{
+			CreatedAt: now,
+			ExpiresAt: now.Add(time.Minute),
+			IpAddr:    "192.0.2.1",
+		})
+	}
+	sysEnv := &service.SysEnv{StartedTime: now}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv), service.AdminConf(repo, nil))
+
+	router := httprouter.New()
+	router.POST("/:board/_admin/func/:fp1/:fp2", handleParseForm(injectService(sv)(handleAdmin())))
+
+	// Exercise: list
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/test/_admin/func/session/list", nil)
+	router.ServeHTTP(writer, request)
+
+	// Verify
+	body := writer.Body.String()
+	if !strings.Contains(body, "0123456789abcdef0000") || !strings.Contains(body, "fedcba98765432100000") {
+		t.Errorf("%v", body)
+	}
+
+	// Exercise: revoke
+	writer = httptest.NewRecorder()
+	request, _ = http.NewRequest("POST", "/test/_admin/func/session/revoke",
+		strings.NewReader("id=0123456789abcdef0000"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(writer, request)
+
+	// Verify
+	body = writer.Body.String()
+	if !strings.Contains(body, "NO ERRORS.") ||
+		strings.Contains(body, "0123456789abcdef0000") || !strings.Contains(body, "fedcba98765432100000") {
+		t.Errorf("%v", body)
+	}
+	if _, ok := repo.SessionMap["0123456789abcdef0000"]; ok {
+		t.Error("session is not revoked")
+	}
+	if len(repo.AuditLog) != 1 || repo.AuditLog[0].Action != "session/revoke" {
+		t.Errorf("AuditLog = %v", repo.AuditLog)
+	}
+}
+
+func TestHandleAdminApi(t *testing.T) {
+
+	// Setup
+	repo := testutil.InitialBoardStub("news4test")
+	repo.SetWriteCount("news4test", 3)
+	sysEnv := &service.SysEnv{StartedTime: time.Now()}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv), service.AdminConf(repo, nil))
+
+	router := httprouter.New()
+	router.POST("/:board/_admin/api/func/:fp1/:fp2", handleParseForm(injectService(sv)(handleAdminApi())))
+
+	tests := []struct {
+		path string
+		ok   bool
+		body string
+	}{
+		{"/test/_admin/api/func/write-limit/get", true, `"write_count":3`},
+		{"/test/_admin/api/func/create-board/nosupp", false, `"message":"unsupported: nosupp"`},
+		{"/test/_admin/api/func/hoshu/sweep", true, `"sweep_count":0`},
+		{"/test/_admin/api/func/hoshu/nosupp", false, `"message":"unsupported: nosupp"`},
+	}
+
+	for i, tt := range tests {
+		// Exercise
+		writer := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", tt.path, nil)
+		router.ServeHTTP(writer, request)
+
+		// Verify
+		if writer.Code != 200 {
+			t.Errorf("%d: Response code is %v", i, writer.Code)
+		}
+		if ct := writer.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("%d: Content-Type = %v", i, ct)
+		}
+		result := &jadmin.Result{}
+		if err := json.Unmarshal(writer.Body.Bytes(), result); err != nil {
+			t.Errorf("%d: %v", i, err)
+		}
+		if result.Ok != tt.ok || !strings.Contains(writer.Body.String(), tt.body) {
+			t.Errorf("%d: %v", i, writer.Body.String())
+		}
+	}
+}
+
+func TestHandleAdminApiLogin_Fail(t *testing.T) {
+
+	repo := testutil.InitialBoardStub()
+	sysEnv := &service.SysEnv{StartedTime: time.Now()}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv), service.AdminConf(repo, nil))
+
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/test/_admin/api/login", nil)
+	request.Header.Add("User-Agent", "Monazilla/1.00")
+	request.PostForm = make(map[string][]string)
+	request.PostForm.Add(admincfg.LOGIN_PASSPHRASE_PARAM, "wrong pass")
+	request.PostForm.Add(admincfg.LOGIN_SIGNATURE_PARAM, "wrong sig")
+
+	router := NewBoardRouter(sv)
+
+	// Exercise
+	router.ServeHTTP(writer, request)
+
+	// Verify
+	if writer.Code != 403 {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+	result := &jadmin.Result{}
+	if err := json.Unmarshal(writer.Body.Bytes(), result); err != nil || result.Ok {
+		t.Errorf("%v, %v", err, writer.Body.String())
+	}
+	if len(writer.Result().Cookies()) != 0 {
+		t.Errorf("cookie is set: %v", writer.Result().Cookies())
+	}
+	if len(repo.AuditLog) != 1 || repo.AuditLog[0].Outcome != "failure" {
+		t.Errorf("AuditLog = %v", repo.AuditLog)
+	}
+}
+
+func TestAdminBoardSetting(t *testing.T) {
+
+	// Setup
+	repo := testutil.InitialBoardStub("news4vip")
+	sysEnv := &service.SysEnv{StartedTime: time.Now()}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv), service.AdminConf(repo, nil))
+
+	router := NewBoardRouter(sv)
+	router.POST("/:board/_admin/test/:fp1/:fp2", handleParseForm(injectService(sv)(handleAdmin())))
+
+	// Exercise: update
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/test/_admin/test/setting/update",
+		strings.NewReader("board=news4vip&BBS_TITLE=UPDATED&head_txt=HEADHEAD"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(writer, request)
+
+	// Verify
+	if body := writer.Body.String(); !strings.Contains(body, "NO ERRORS.") || !strings.Contains(body, "UPDATED") {
+		t.Errorf("%v", body)
+	}
+
+	// SETTING.TXT と head.txt にすぐ反映される
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/news4vip/SETTING.TXT", "BBS_TITLE=UPDATED\n"},
+		{"/news4vip/head.txt", "HEADHEAD"},
+	}
+	for _, tt := range tests {
+		writer = httptest.NewRecorder()
+		request, _ = http.NewRequest("GET", tt.path, nil)
+		request.Header.Add("User-Agent", "Monazilla/1.00")
+		router.ServeHTTP(writer, request)
+
+		if writer.Code != 200 || !strings.Contains(writer.Body.String(), tt.want) {
+			t.Errorf("%v: %v %v", tt.path, writer.Code, writer.Body.String())
+		}
+	}
+
+	// Exercise: reset
+	writer = httptest.NewRecorder()
+	request, _ = http.NewRequest("POST", "/test/_admin/test/setting/reset", strings.NewReader("board=news4vip"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(writer, request)
+
+	if _, ok := repo.SettingMap["news4vip"]; ok {
+		t.Error("setting is not reset")
+	}
+}
+
+func TestAdminThread(t *testing.T) {
+
+	// Setup
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{
+			ThreadKey:    "1234567890",
+			ThreadTitle:  "スレタイ",
+			MessageCount: 1,
+			Dat:          "名前<>メール<>日付 ID:X<> 本文1 <>スレタイ\n",
+		},
+	})
+	sysEnv := &service.SysEnv{StartedTime: time.Now()}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv), service.AdminConf(repo, nil))
+
+	router := httprouter.New()
+	router.POST("/:board/_admin/test/:fp1/:fp2", handleParseForm(injectService(sv)(handleAdmin())))
+
+	tests := []struct {
+		path string
+		form string
+	}{
+		{"/test/_admin/test/thread/rename", "board=news4vip&key=1234567890&title=NEWTITLE"},
+		{"/test/_admin/test/thread/pin", "board=news4vip&key=1234567890"},
+		{"/test/_admin/test/thread/stop", "board=news4vip&key=1234567890"},
+	}
+	for _, tt := range tests {
+		// Exercise
+		writer := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", tt.path, strings.NewReader(tt.form))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(writer, request)
+
+		// Verify
+		if body := writer.Body.String(); !strings.Contains(body, "NO ERRORS.") {
+			t.Errorf("%v: %v", tt.path, body)
+		}
+	}
+
+	sbj := repo.Subjects("news4vip")[0]
+	if sbj.ThreadTitle != "NEWTITLE" || !sbj.Pinned || !sbj.Stopped {
+		t.Errorf("subject = %v", sbj)
+	}
+	if n := len(repo.AuditLog); n != len(tests) {
+		t.Errorf("len(AuditLog) = %v, want: %v", n, len(tests))
+	}
+
+	// Exercise: 不明な機能
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/test/_admin/test/thread/xxxx", strings.NewReader("board=news4vip&key=1234567890"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(writer, request)
+
+	if body := writer.Body.String(); !strings.Contains(body, "unsupported: xxxx") {
+		t.Errorf("%v", body)
+	}
+}
@@ -0,0 +1,107 @@
+package handle
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/tempxla/stub2ch/internal/app/service"
+	"log"
+	"mime/multipart"
+	"net/http"
+)
+
+const (
+	archive_upload_max  = 64 * 1024 * 1024 // アップロードできるアーカイブの上限
+	archive_memory_max  = 8 * 1024 * 1024  // 超えた分は一時ファイルになる
+	archive_file_param  = "archive"
+	archive_time_layout = "20060102150405"
+)
+
+// 板をzipでダウンロードする
+func handleAdminExport() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		boardName := r.PostFormValue("board")
+
+		// エラーを返せるように全部作ってから送る
+		buf := &bytes.Buffer{}
+		err := sv.Admin.ExportBoard(boardName, buf)
+		writeAuditLog(r, sv, "archive/export", adminSessionId(r), formParams(r), err)
+		if err != nil {
+			log.Printf("ERROR: handleAdminExport. %v", err)
+			http.Error(w, fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+
+		filename := fmt.Sprintf("%s-%s.zip", boardName, sv.StartedAt().Format(archive_time_layout))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		w.Write(buf.Bytes())
+	}
+}
+
+func handleAdminImport() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		executeAdminIndex(w, r, runAdminImport(w, r, sv, "archive/import", importBoard))
+	}
+}
+
+// JSON版
+func handleAdminApiImport() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		writeAdminJson(w, http.StatusOK, newAdminResult(runAdminImport(w, r, sv, "archive/import", importBoard)))
+	}
+}
+
+func handleAdminImportDat() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		executeAdminIndex(w, r, runAdminImport(w, r, sv, "archive/import-dat", importDats))
+	}
+}
+
+// JSON版
+func handleAdminApiImportDat() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		writeAdminJson(w, http.StatusOK, newAdminResult(runAdminImport(w, r, sv, "archive/import-dat", importDats)))
+	}
+}
+
+type importFunc func(r *http.Request, sv *service.BoardService, f multipart.File, size int64) (int, error)
+
+// multipartで送られたアーカイブを取り込む
+func runAdminImport(w http.ResponseWriter, r *http.Request, sv *service.BoardService,
+	action string, fn importFunc) *adminView {
+
+	view := newAdminView()
+	count, err := receiveArchive(w, r, sv, fn)
+	if err == nil {
+		view.ImportCount = count
+	}
+	view.Error = err
+	writeAuditLog(r, sv, action, adminSessionId(r), formParams(r), err)
+	return view
+}
+
+func receiveArchive(w http.ResponseWriter, r *http.Request, sv *service.BoardService, fn importFunc) (int, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, archive_upload_max)
+	if err := r.ParseMultipartForm(archive_memory_max); err != nil {
+		return 0, err
+	}
+	f, fh, err := r.FormFile(archive_file_param)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return fn(r, sv, f, fh.Size)
+}
+
+// ExportBoardで書き出したzip
+func importBoard(r *http.Request, sv *service.BoardService, f multipart.File, size int64) (int, error) {
+	return sv.Admin.ImportBoard(r.PostFormValue("board"), f, size)
+}
+
+// 2ch形式のdatをまとめたzip
+func importDats(r *http.Request, sv *service.BoardService, f multipart.File, size int64) (int, error) {
+	archived := r.PostFormValue("archived") != ""
+	return sv.Admin.ImportDats(r.PostFormValue("board"), f, size, archived)
+}
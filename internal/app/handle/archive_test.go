@@ -0,0 +1,163 @@
+package handle
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/tempxla/stub2ch/internal/app/service"
+	jadmin "github.com/tempxla/stub2ch/internal/app/types/json/admin"
+	"github.com/tempxla/stub2ch/internal/app/util"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminArchive(t *testing.T) {
+
+	// Setup
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{
+			ThreadKey:    "1234567890",
+			ThreadTitle:  "スレタイ",
+			MessageCount: 1,
+			LastModified: time.Date(2020, 1, 18, 12, 0, 0, 0, time.UTC),
+			Dat:          "名前<>メール<>日付 ID:X<> 本文1 <>スレタイ\n",
+		},
+	})
+	sysEnv := &service.SysEnv{StartedTime: time.Date(2020, 1, 18, 12, 0, 0, 0, time.UTC)}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv), service.AdminConf(repo, nil))
+
+	router := httprouter.New()
+	router.POST("/:board/_admin/test/export", handleParseForm(injectService(sv)(handleAdminExport())))
+
+	// Exercise: export
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/test/_admin/test/export", strings.NewReader("board=news4vip"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(writer, request)
+
+	// Verify
+	if writer.Code != http.StatusOK || writer.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("%v %v", writer.Code, writer.Body.String())
+	}
+	if cd := writer.Header().Get("Content-Disposition"); cd != `attachment; filename="news4vip-20200118120000.zip"` {
+		t.Errorf("Content-Disposition = %v", cd)
+	}
+	archive := writer.Body.Bytes()
+
+	// Setup: 別の環境
+	repo2 := testutil.EmptyBoardStub()
+	sv2 := service.NewBoardService(service.RepoConf(repo2), service.EnvConf(sysEnv), service.AdminConf(repo2, nil))
+	router.POST("/:board/_admin/test/import", handleParseForm(injectService(sv2)(handleAdminApiImport())))
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, _ := mw.CreateFormFile("archive", "news4vip.zip")
+	fw.Write(archive)
+	mw.Close()
+
+	// Exercise: import
+	writer = httptest.NewRecorder()
+	request, _ = http.NewRequest("POST", "/test/_admin/test/import", body)
+	request.Header.Set("Content-Type", mw.FormDataContentType())
+	router.ServeHTTP(writer, request)
+
+	// Verify
+	result := &jadmin.Result{}
+	if err := json.Unmarshal(writer.Body.Bytes(), result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Ok || result.ImportCount == nil || *result.ImportCount != 1 {
+		t.Errorf("%v", writer.Body.String())
+	}
+	testutil.EqualBoardEntity(t, repo.BoardMap["news4vip"], repo2.BoardMap["news4vip"])
+	if len(repo.AuditLog) != 1 || len(repo2.AuditLog) != 1 {
+		t.Errorf("AuditLog = %v, %v", repo.AuditLog, repo2.AuditLog)
+	}
+
+	// Exercise: ファイルが無い
+	writer = httptest.NewRecorder()
+	request, _ = http.NewRequest("POST", "/test/_admin/test/import", strings.NewReader("board=news4vip"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(writer, request)
+
+	result = &jadmin.Result{}
+	json.Unmarshal(writer.Body.Bytes(), result)
+	if result.Ok || result.ImportCount != nil {
+		t.Errorf("%v", writer.Body.String())
+	}
+}
+
+func TestAdminExport_Error(t *testing.T) {
+
+	// Setup
+	repo := testutil.EmptyBoardStub()
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(&service.SysEnv{}), service.AdminConf(repo, nil))
+
+	router := httprouter.New()
+	router.POST("/:board/_admin/test/export", handleParseForm(injectService(sv)(handleAdminExport())))
+
+	// Exercise
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/test/_admin/test/export", strings.NewReader("board=xxxx"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(writer, request)
+
+	// Verify
+	if writer.Code != http.StatusBadRequest {
+		t.Errorf("%v %v", writer.Code, writer.Body.String())
+	}
+}
+
+func TestAdminImportDat(t *testing.T) {
+
+	// Setup
+	repo := testutil.InitialBoardStub("news4vip")
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(&service.SysEnv{}), service.AdminConf(repo, nil))
+
+	router := httprouter.New()
+	router.POST("/:board/_admin/test/import-dat", handleParseForm(injectService(sv)(handleAdminApiImportDat())))
+
+	zipBuf := &bytes.Buffer{}
+	zw := zip.NewWriter(zipBuf)
+	fw, _ := zw.Create("1575162000.dat")
+	fw.Write(util.UTF8toSJIS([]byte("名無し<><>2019/12/01(日) 10:00:00.12 ID:aaa<> 本文 <>スレタイ\n")))
+	zw.Close()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	mw.WriteField("board", "news4vip")
+	mw.WriteField("archived", "1")
+	fw, _ = mw.CreateFormFile("archive", "dat.zip")
+	fw.Write(zipBuf.Bytes())
+	mw.Close()
+
+	// Exercise
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/test/_admin/test/import-dat", body)
+	request.Header.Set("Content-Type", mw.FormDataContentType())
+	router.ServeHTTP(writer, request)
+
+	// Verify
+	result := &jadmin.Result{}
+	if err := json.Unmarshal(writer.Body.Bytes(), result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Ok || result.ImportCount == nil || *result.ImportCount != 1 {
+		t.Errorf("%v", writer.Body.String())
+	}
+	if _, ok := repo.DatMap["news4vip"]["1575162000"]; !ok {
+		t.Error("dat is not imported")
+	}
+	if n := len(repo.Subjects("news4vip")); n != 0 {
+		t.Errorf("len(Subjects) = %v, want: 0", n)
+	}
+	if len(repo.AuditLog) != 1 || repo.AuditLog[0].Action != "archive/import-dat" {
+		t.Errorf("AuditLog = %v", repo.AuditLog)
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/tempxla/stub2ch/configs/app/bbscfg"
 	"github.com/tempxla/stub2ch/internal/app/service"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
 	"github.com/tempxla/stub2ch/internal/app/types/errors"
+	jdat "github.com/tempxla/stub2ch/internal/app/types/json/dat"
 	"github.com/tempxla/stub2ch/internal/app/util"
+	"html/template"
 	"log"
 	"net/http"
 	"strconv"
@@ -19,6 +22,13 @@ const (
 	param_error_format = "bad parameter '%s' is: %v"
 	top_load_delay     = 10 // seconds
 	top_subject_limit  = 10
+	board_top_links    = 30 // 板トップのスレ一覧
+	board_top_threads  = 10 // 本文まで出すスレ
+	board_top_recent   = 10 // >>1の後に出すレス
+	// 書き込み確認を済ませた印
+	confirm_cookie_name = "PON"
+	// bbs.cgiでサーバー側の都合で失敗したとき
+	server_error_message = "サーバーの調子が悪いみたいです。しばらくしてから書き込んでください。"
 )
 
 func handleBbsCgi() ServiceHandle {
@@ -26,10 +36,18 @@ func handleBbsCgi() ServiceHandle {
 
 		submit, err := process(requireOne(r, "submit"), sjisToUtf8String)
 		if err != nil {
-			http.Error(w, fmt.Sprintf(param_error_format, "submit", err), http.StatusBadRequest)
+			rejectParam(w, "submit", "フォーム情報", err)
 			return
 		}
 
+		switch submit {
+		case "書き込む", "新規スレッド作成", "上記全てを承諾して書き込む":
+			// メンテナンス中は書き込めない
+			if rejectMaintenance(w, r, ps, sv, true) {
+				return
+			}
+		}
+
 		switch submit {
 		case "書き込む":
 			// レスを書き込む
@@ -44,7 +62,7 @@ func handleBbsCgi() ServiceHandle {
 				handleCreateThread(w, r, sv)
 			}
 		default:
-			fmt.Fprint(w, util.UTF8toSJISString("SJISで書いてね？"))
+			executeWriteErrorTmpl(w, http.StatusBadRequest, "SJISで書いてね？")
 		}
 	}
 }
@@ -62,9 +80,8 @@ func handleWriteDat(w http.ResponseWriter, r *http.Request, sv *service.BoardSer
 	if !ok {
 		return
 	}
-	setting := bbscfg.GetSetting(boardName)
-	if setting == nil {
-		http.Error(w, "Not Found", http.StatusNotFound)
+	setting, ok := requireWriteSetting(w, sv, boardName)
+	if !ok {
 		return
 	}
 	name, ok := requireName(w, r, setting)
@@ -75,7 +92,7 @@ func handleWriteDat(w http.ResponseWriter, r *http.Request, sv *service.BoardSer
 	if !ok {
 		return
 	}
-	message, ok := requireMessage(w, r, setting)
+	message, ok := requireMessage(w, r, setting, false)
 	if !ok {
 		return
 	}
@@ -86,20 +103,20 @@ func handleWriteDat(w http.ResponseWriter, r *http.Request, sv *service.BoardSer
 	ipAddr := getIP(r)
 
 	// クッキー確認
-	if executeWriteDatConfirmTmpl(w, r, ipAddr,
-		boardName, name, mail, message, sv.StartedAt(), "", threadKey) {
+	if executeWriteDatConfirmTmpl(w, r, sv, ipAddr,
+		boardName, name, mail, message, "", threadKey) {
 		return
 	}
 	// 書き込み
 	id := sv.ComputeId(ipAddr, boardName)
 	resnum, err := sv.WriteDat(setting, boardName, threadKey, name, mail, id, message)
 	if err != nil {
-		// 存在しない or dat落ち or 1001 or 容量オーバー
-		executeWriteDatNotFoundTmpl(w, r, boardName, threadKey, sv.StartedAt())
+		rejectWrite(w, r, boardName, threadKey, err)
 		return
 	}
 	// 書き込み完了
 	logPrintWriteDone(boardName, threadKey, resnum, id, ipAddr)
+	metricPosts.Inc(boardName)
 
 	executeWriteDoneTmpl(w, r, boardName, threadKey, id, resnum, sv.StartedAt())
 }
@@ -126,36 +143,45 @@ func executeWriteDoneTmpl(w http.ResponseWriter, r *http.Request,
 	}
 }
 
-func executeWriteDatNotFoundTmpl(w http.ResponseWriter, r *http.Request,
-	boardName, threadKey string, startedAt time.Time) {
+func executeWriteDatNotFoundTmpl(w http.ResponseWriter, r *http.Request, boardName, threadKey string) {
 
 	setContentTypeHtmlSjis(w)
+	w.WriteHeader(http.StatusNotFound)
 
 	// //hebi.5ch.net/test/read.cgi/news4vip/1575543566/
 	view := fmt.Sprintf("//%s/test/read.cgi/%s/%s/", r.Host, boardName, threadKey)
 	if err := writeDatNotFoundTmpl.Execute(w, view); err != nil {
 		log.Printf("Error executing template: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
 
 // Returns false if Cookie Found.
-func executeWriteDatConfirmTmpl(w http.ResponseWriter, r *http.Request, ipAddr string,
-	boardName, name, mail, message string, startedAt time.Time, title, threadKey string) bool {
+// 確認済みのクッキーは署名付きのトークンで、IPアドレスとUser-Agentが変わると無効になる。
+func executeWriteDatConfirmTmpl(w http.ResponseWriter, r *http.Request, sv *service.BoardService, ipAddr string,
+	boardName, name, mail, message string, title, threadKey string) bool {
 
-	if c, err := r.Cookie("PON"); err == nil && c.Value == ipAddr {
-		if c, err := r.Cookie("yuki"); err == nil && c.Value == "akari" {
+	if c, err := r.Cookie(confirm_cookie_name); err == nil {
+		err := sv.VerifyConfirmToken(c.Value, ipAddr, r.UserAgent())
+		if err == nil {
 			// Cookie Found. Need not to forward Confirm page.
 			return false
 		}
+		log.Printf("confirm cookie: %v", err)
 	}
 
+	token, err := sv.IssueConfirmToken(ipAddr, r.UserAgent())
+	if err != nil {
+		log.Printf("ERROR: IssueConfirmToken. %v", err)
+		executeWriteErrorTmpl(w, http.StatusInternalServerError, server_error_message)
+		return true
+	}
+	startedAt := sv.StartedAt()
+
 	setContentTypeHtmlSjis(w)
 
 	// Domain属性を指定しないCookieは、Cookieを発行したホストのみに送信される
-	expires := startedAt.Add(time.Duration(7*24) * time.Hour).UTC().Format(http.TimeFormat)
-	w.Header().Add("Set-Cookie", fmt.Sprintf("PON=%s; expires=%s; path=/", ipAddr, expires))
-	w.Header().Add("Set-Cookie", fmt.Sprintf("yuki=akari; expires=%s; path=/", expires))
+	expires := startedAt.Add(service.CONFIRM_TOKEN_TTL).UTC().Format(http.TimeFormat)
+	w.Header().Add("Set-Cookie", fmt.Sprintf("%s=%s; expires=%s; path=/; HttpOnly", confirm_cookie_name, token, expires))
 
 	// Body
 	view := map[string]string{
@@ -181,9 +207,8 @@ func handleCreateThread(w http.ResponseWriter, r *http.Request, sv *service.Boar
 	if !ok {
 		return
 	}
-	setting := bbscfg.GetSetting(boardName)
-	if setting == nil {
-		http.Error(w, "Not Found", http.StatusNotFound)
+	setting, ok := requireWriteSetting(w, sv, boardName)
+	if !ok {
 		return
 	}
 	title, ok := requireTitle(w, r, setting)
@@ -202,7 +227,7 @@ func handleCreateThread(w http.ResponseWriter, r *http.Request, sv *service.Boar
 	if !ok {
 		return
 	}
-	message, ok := requireMessage(w, r, setting)
+	message, ok := requireMessage(w, r, setting, true)
 	if !ok {
 		return
 	}
@@ -213,8 +238,8 @@ func handleCreateThread(w http.ResponseWriter, r *http.Request, sv *service.Boar
 	ipAddr := getIP(r)
 
 	// クッキー確認
-	if executeWriteDatConfirmTmpl(w, r, ipAddr,
-		boardName, name, mail, message, sv.StartedAt(), title, "") {
+	if executeWriteDatConfirmTmpl(w, r, sv, ipAddr,
+		boardName, name, mail, message, title, "") {
 		return
 	}
 	// スレ立て
@@ -222,26 +247,67 @@ func handleCreateThread(w http.ResponseWriter, r *http.Request, sv *service.Boar
 	threadKey, err := sv.CreateThread(setting, boardName, name, mail, id, message, title)
 	if err != nil {
 		// スレ立て失敗
-		executeCreateThreadErrorTmpl(w, r, sv.StartedAt())
+		rejectWrite(w, r, boardName, "", err)
 		return
 	}
 	// 書き込み完了
 	logPrintWriteDone(boardName, threadKey, 1, id, ipAddr)
+	metricThreads.Inc(boardName)
 
 	executeWriteDoneTmpl(w, r, boardName, threadKey, id, 1, sv.StartedAt())
 }
 
+// 書き込めなかった理由ごとの画面
+var writeRejections = map[errors.Kind]struct {
+	code    int
+	message string
+}{
+	errors.BOARD_NOT_FOUND:   {http.StatusNotFound, "その板は存在しません！"},
+	errors.THREAD_STOPPED:    {http.StatusForbidden, "このスレッドは停止されています。もう書けない。。。"},
+	errors.THREAD_FULL:       {http.StatusForbidden, "このスレッドにはもう書き込めません。新しいスレッドを立ててください。"},
+	errors.DAT_OVER_CAPACITY: {http.StatusForbidden, "このスレッドは容量が一杯です。新しいスレッドを立ててください。"},
+	errors.THREAD_LIMIT:      {http.StatusServiceUnavailable, "この板はスレッドが一杯です。しばらくしてから立ててください。"},
+	errors.WRITE_LIMIT:       {http.StatusServiceUnavailable, "今日はこれ以上書き込めません。。。"},
+	errors.DUPLICATE_KEY:     {http.StatusConflict, "スレッドが立てられすぎています。しばらくしてから立ててください。"},
+}
+
+// WriteDat, CreateThread のエラーを画面にする。
+// 決まりで弾いた書き込みと、サーバーの障害はログでも分ける。
+func rejectWrite(w http.ResponseWriter, r *http.Request, boardName, threadKey string, err error) {
+	kind := errors.KindOf(err)
+	if kind == 0 {
+		log.Printf("ERROR: write /%s/%s. %v", boardName, threadKey, err)
+		executeWriteErrorTmpl(w, http.StatusInternalServerError, server_error_message)
+		return
+	}
+	log.Printf("[WRITE REJECTED] /%s/%s %v", boardName, threadKey, err)
+
+	if kind == errors.THREAD_NOT_FOUND {
+		executeWriteDatNotFoundTmpl(w, r, boardName, threadKey)
+		return
+	}
+	rj, ok := writeRejections[kind]
+	if !ok {
+		rj.code, rj.message = http.StatusBadRequest, err.Error()
+	}
+	executeWriteErrorTmpl(w, rj.code, rj.message)
+}
+
 func logPrintWriteDone(boardName, threadKey string, resnum int, id, ipAddr string) {
 	log.Printf("[WRITE DONE] /%s/%s/%d id:%s ip:%s ", boardName, threadKey, resnum, id, ipAddr)
 }
 
-func executeCreateThreadErrorTmpl(w http.ResponseWriter, r *http.Request, startedAt time.Time) {
+// bbs.cgiのエラー画面。専ブラは 2ch_X:error の印とタイトルで失敗を判定する
+func executeWriteErrorTmpl(w http.ResponseWriter, code int, message string) {
 
 	setContentTypeHtmlSjis(w)
+	w.WriteHeader(code)
 
-	if err := createThreadErrorTmpl.Execute(w, nil); err != nil {
+	view := map[string]string{
+		"Message": util.UTF8toSJISString(message),
+	}
+	if err := writeErrorTmpl.Execute(w, view); err != nil {
 		log.Printf("Error executing template: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
 
@@ -249,7 +315,7 @@ func handleDat() ServiceHandle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
 		board := ps.ByName("board")
 		threadKey := strings.Replace(ps.ByName("dat"), ".dat", "", 1)
-		dat, lastModifiedTime, err := sv.MakeDat(board, threadKey)
+		sjisDat, lastModifiedTime, err := sv.MakeDat(board, threadKey)
 		if err != nil {
 			if err == datastore.ErrNoSuchEntity {
 				http.Error(w, "Not found", http.StatusNotFound)
@@ -260,7 +326,6 @@ func handleDat() ServiceHandle {
 			return
 		}
 
-		sjisDat := util.UTF8toSJIS(dat)
 		lastModified := lastModifiedTime.UTC().Format(http.TimeFormat)
 
 		// 差分取得判定
@@ -269,7 +334,7 @@ func handleDat() ServiceHandle {
 		if ifModifiedSince == "" {
 			setContentTypePlainSjis(w)
 			w.Header().Add("Last-Modified", lastModified)
-			fmt.Fprintf(w, string(sjisDat))
+			w.Write(sjisDat)
 			return
 		}
 		// 更新されていない
@@ -290,7 +355,7 @@ func handleDat() ServiceHandle {
 			setContentTypePlainSjis(w)
 			w.Header().Add("Last-Modified", lastModified)
 			w.WriteHeader(http.StatusPartialContent) // 206
-			fmt.Fprintf(w, string(sjisDat[rangeBytes:]))
+			w.Write(sjisDat[rangeBytes:])
 		}
 	}
 }
@@ -327,12 +392,41 @@ func handleSubjectTxt() ServiceHandle {
 	}
 }
 
-func handleSettingTxt() httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+// 板の設定を取得する
+func requireSetting(w http.ResponseWriter, sv *service.BoardService, boardName string) (bbscfg.Setting, bool) {
+	stng, err := sv.GetSetting(boardName)
+	if err != nil {
+		log.Printf("ERROR: GetSetting. %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if stng == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return nil, false
+	}
+	return stng, true
+}
+
+// bbs.cgi用。requireSettingと同じだが、エラーは専ブラが読める画面で返す
+func requireWriteSetting(w http.ResponseWriter, sv *service.BoardService, boardName string) (bbscfg.Setting, bool) {
+	stng, err := sv.GetSetting(boardName)
+	if err != nil {
+		log.Printf("ERROR: GetSetting. %v", err)
+		executeWriteErrorTmpl(w, http.StatusInternalServerError, server_error_message)
+		return nil, false
+	}
+	if stng == nil {
+		executeWriteErrorTmpl(w, http.StatusNotFound, "その板は存在しません！")
+		return nil, false
+	}
+	return stng, true
+}
+
+func handleSettingTxt() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
 		board := ps.ByName("board")
-		stng := bbscfg.GetSetting(board)
-		if stng == nil {
-			http.Error(w, "Not Found", http.StatusNotFound)
+		stng, ok := requireSetting(w, sv, board)
+		if !ok {
 			return
 		}
 		settingTxt := bbscfg.MakeSettingTxt(stng)
@@ -341,10 +435,15 @@ func handleSettingTxt() httprouter.Handle {
 	}
 }
 
-func handleHeadTxt() httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func handleHeadTxt() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
 		board := ps.ByName("board")
-		headTxt := bbscfg.MakeHeadTxt(board)
+		headTxt, err := sv.MakeHeadTxt(board)
+		if err != nil {
+			log.Printf("ERROR: handleHeadTxt. %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
 		if headTxt == nil {
 			http.Error(w, "Not Found", http.StatusNotFound)
 			return
@@ -355,39 +454,147 @@ func handleHeadTxt() httprouter.Handle {
 	}
 }
 
+// 板トップ (head.txt、スレ一覧、上のスレの>>1と最新レス)
 func handleBoard() ServiceHandle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
 		board := ps.ByName("board")
-		stng := bbscfg.GetSetting(board)
-		if stng == nil {
-			http.Error(w, "Not Found", http.StatusNotFound)
+		stng, ok := requireSetting(w, sv, board)
+		if !ok {
+			return
+		}
+
+		headTxt, err := sv.MakeHeadTxt(board)
+		if err != nil {
+			log.Printf("ERROR: handleBoard. %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		top, err := sv.MakeBoardTop(board, board_top_threads, board_top_recent)
+		if err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				http.Error(w, "Not found", http.StatusNotFound)
+			} else {
+				log.Printf("ERROR: handleBoard. %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		subjects := top.Subjects
+		if len(subjects) > board_top_links {
+			subjects = subjects[:board_top_links]
+		}
+		inPage := make(map[string]bool)
+		for _, th := range top.Threads {
+			inPage[th.ThreadKey] = true
+		}
+		view := &struct {
+			BoardName   string
+			BoardTitle  string
+			HeadTxt     template.HTML
+			Subjects    []subjectView
+			Threads     []boardThreadView
+			SubjectRest int
+		}{
+			board,
+			util.UTF8toSJISString(stng.BBS_TITLE()),
+			template.HTML(util.UTF8toSJISString(string(headTxt))),
+			newSubjectViews(subjects, inPage),
+			newBoardThreadViews(top.Threads),
+			len(top.Subjects) - len(subjects),
+		}
+
+		writeHtmlSjis(w, r, boardTmpl, view, top.LastModified)
+	}
+}
+
+// 全スレの一覧
+func handleSubback() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		board := ps.ByName("board")
+		stng, ok := requireSetting(w, sv, board)
+		if !ok {
 			return
 		}
+
+		top, err := sv.MakeBoardTop(board, 0, 0)
+		if err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				http.Error(w, "Not found", http.StatusNotFound)
+			} else {
+				log.Printf("ERROR: handleSubback. %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
 		view := &struct {
 			BoardName  string
 			BoardTitle string
-			Precure    int64
+			Subjects   []subjectView
 		}{
 			board,
 			util.UTF8toSJISString(stng.BBS_TITLE()),
-			sv.StartedAt().Unix() - top_load_delay, // 初回ロードで待たせないため時間をずらしておく
+			newSubjectViews(top.Subjects, nil),
 		}
 
-		setContentTypeHtmlSjis(w)
+		writeHtmlSjis(w, r, subbackTmpl, view, top.LastModified)
+	}
+}
 
-		if err := boardTmpl.Execute(w, view); err != nil {
-			log.Printf("Error executing template: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+// スレ一覧の1行分
+// 板トップに本文があるスレはページ内にリンクする
+type subjectView struct {
+	Num          int
+	ThreadKey    string
+	ThreadTitle  template.HTML
+	MessageCount int
+	InPage       bool
+}
+
+// inPageは本文を出したスレのスレッドキー
+func newSubjectViews(subjects []board.Subject, inPage map[string]bool) []subjectView {
+	views := make([]subjectView, len(subjects))
+	for i, s := range subjects {
+		views[i] = subjectView{
+			Num:          i + 1,
+			ThreadKey:    s.ThreadKey,
+			ThreadTitle:  template.HTML(util.UTF8toSJISString(s.ThreadTitle)),
+			MessageCount: s.MessageCount,
+			InPage:       inPage[s.ThreadKey],
 		}
 	}
+	return views
+}
+
+// 板トップに本文まで出すスレ
+type boardThreadView struct {
+	Num          int
+	ThreadKey    string
+	ThreadTitle  template.HTML
+	MessageCount int
+	Messages     []datMessageView
+}
+
+func newBoardThreadViews(threads []*service.BoardTopThread) []boardThreadView {
+	views := make([]boardThreadView, len(threads))
+	for i, th := range threads {
+		views[i] = boardThreadView{
+			Num:          th.Num,
+			ThreadKey:    th.ThreadKey,
+			ThreadTitle:  template.HTML(util.UTF8toSJISString(th.ThreadTitle)),
+			MessageCount: th.MessageCount,
+			Messages:     newDatMessageViews(th.Messages),
+		}
+	}
+	return views
 }
 
 func handleSubjectJson() ServiceHandle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
 
 		board := ps.ByName("board")
-		stng := bbscfg.GetSetting(board)
-		if stng == nil {
+		if bbscfg.GetSetting(board) == nil {
 			http.Error(w, "Not Found", http.StatusNotFound)
 			return
 		}
@@ -410,23 +617,37 @@ func handleSubjectJson() ServiceHandle {
 func handleReadCgi() ServiceHandle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
 		boardName := ps.ByName("boardName")
-		stng := bbscfg.GetSetting(boardName)
-		if stng == nil {
-			http.Error(w, "Not Found", http.StatusNotFound)
+		stng, ok := requireSetting(w, sv, boardName)
+		if !ok {
 			return
 		}
 
 		threadKey := ps.ByName("threadKey")
 
+		datObj, err := sv.MakeDatObject(boardName, threadKey)
+		if err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				http.Error(w, "Not found", http.StatusNotFound)
+			} else {
+				log.Printf("ERROR: handleReadCgi. %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
 		view := &struct {
-			BoardName  string
-			ThreadKey  string
-			BoardTitle string
-			Precure    int64
+			BoardName   string
+			ThreadKey   string
+			BoardTitle  string
+			ThreadTitle template.HTML
+			Messages    []datMessageView
+			Precure     int64
 		}{
 			boardName,
 			threadKey,
 			util.UTF8toSJISString(stng.BBS_TITLE()),
+			template.HTML(util.UTF8toSJISString(datObj.ThreadTitle)),
+			newDatMessageViews(datObj.Messages),
 			sv.StartedAt().Unix() - top_load_delay, // 初回ロードで待たせないため時間をずらしておく
 		}
 
@@ -439,6 +660,34 @@ func handleReadCgi() ServiceHandle {
 	}
 }
 
+// read.cgi の1レス分
+// dat内の文字列はエスケープ済みなのでtemplate.HTMLとする
+type datMessageView struct {
+	Num       int
+	Name      template.HTML
+	Mail      template.HTML
+	DateAndId string
+	Content   template.HTML
+	Anchors   []int
+	Replies   []int
+}
+
+func newDatMessageViews(msgs []jdat.Message) []datMessageView {
+	views := make([]datMessageView, len(msgs))
+	for i, m := range msgs {
+		views[i] = datMessageView{
+			Num:       m.Num,
+			Name:      template.HTML(util.UTF8toSJISString(m.Name)),
+			Mail:      template.HTML(util.UTF8toSJISString(m.Mail)),
+			DateAndId: util.UTF8toSJISString(m.DateAndId),
+			Content:   template.HTML(util.UTF8toSJISString(m.Content)),
+			Anchors:   m.Anchors,
+			Replies:   m.Replies,
+		}
+	}
+	return views
+}
+
 func handleDatJson() ServiceHandle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
 
@@ -3,20 +3,34 @@ package handle
 import (
 	"fmt"
 	"github.com/tempxla/stub2ch/internal/app/service"
-	"github.com/tempxla/stub2ch/internal/app/service/repository"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/errors"
 	"github.com/tempxla/stub2ch/internal/app/util"
 	"github.com/tempxla/stub2ch/tools/app/testutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 )
 
+var testConfirmKeys = map[string]string{"test": "secret"}
+
+// 書き込み確認を済ませたクッキーを付ける
+func addConfirmCookie(t *testing.T, request *http.Request, sv *service.BoardService) {
+	t.Helper()
+	token, err := sv.IssueConfirmToken(getIP(request), request.UserAgent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.AddCookie(&http.Cookie{Name: confirm_cookie_name, Value: token})
+}
+
 // bbs.cgi がない
 func TestHandleBbsCgi_404(t *testing.T) {
 	// Setup
-	var repo repository.BoardRepository
+	repo := testutil.EmptyBoardStub()
 	var sysEnv service.BoardEnvironment
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -38,7 +52,7 @@ func TestHandleBbsCgi_404(t *testing.T) {
 // bbs.cgi
 func TestHandleBbsCgi_MissingSubmit(t *testing.T) {
 	// Setup
-	var repo repository.BoardRepository
+	repo := testutil.EmptyBoardStub()
 	var sysEnv service.BoardEnvironment
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -58,7 +72,7 @@ func TestHandleBbsCgi_MissingSubmit(t *testing.T) {
 
 func TestHandleBbsCgi_WrongSubmit(t *testing.T) {
 	// Setup
-	var repo repository.BoardRepository
+	repo := testutil.EmptyBoardStub()
 	var sysEnv service.BoardEnvironment
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -74,12 +88,13 @@ func TestHandleBbsCgi_WrongSubmit(t *testing.T) {
 	router.ServeHTTP(writer, request)
 
 	// Verify
-	if writer.Code != 200 {
+	if writer.Code != 400 {
 		t.Errorf("Response code is %v", writer.Code)
 	}
-	txt := writer.Body.String()
-	if txt != util.UTF8toSJISString("SJISで書いてね？") {
-		t.Errorf("actual: %v", txt)
+	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
+	if !strings.Contains(body, "<!-- 2ch_X:error -->") ||
+		!strings.Contains(body, "ERROR: SJISで書いてね？") {
+		t.Errorf("actual: %v", body)
 	}
 }
 
@@ -96,7 +111,9 @@ func TestHandleBbsCgi_writeDatOK(t *testing.T) {
 	},
 	)
 	sysEnv := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -113,8 +130,7 @@ func TestHandleBbsCgi_writeDatOK(t *testing.T) {
 		"mail":    []string{"sage"},
 		"MESSAGE": []string{util.UTF8toSJISString("書き")},
 	}
-	request.AddCookie(&http.Cookie{Name: "PON", Value: request.RemoteAddr})
-	request.AddCookie(&http.Cookie{Name: "yuki", Value: "akari"})
+	addConfirmCookie(t, request, sv)
 	request.Header.Add("Referer", "http://"+request.Host+"/news4vip/")
 
 	// Exercise
@@ -127,11 +143,82 @@ func TestHandleBbsCgi_writeDatOK(t *testing.T) {
 	}
 	// body
 	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
-	if !strings.Contains(body, "<title>書きこみました。</title>") {
+	if !strings.Contains(body, "<title>書きこみました。</title>") ||
+		!strings.Contains(body, "<!-- 2ch_X:true -->") {
 		t.Errorf("NOT write_dat_done.html : %v", body)
 	}
 }
 
+// UTF-8で送られたフォーム
+func TestHandleBbsCgi_writeDatUTF8(t *testing.T) {
+
+	tests := []struct {
+		contentType string
+		charset     string
+	}{
+		{"application/x-www-form-urlencoded; charset=UTF-8", ""},
+		{"application/x-www-form-urlencoded", "UTF-8"},
+	}
+
+	for i, tt := range tests {
+		// Setup
+		repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+			{
+				ThreadKey:    "1234567890",
+				ThreadTitle:  "XXXX",
+				MessageCount: 1,
+				LastModified: time.Now().Add(time.Duration(-1) * time.Hour),
+				Dat:          "1行目",
+			},
+		},
+		)
+		sysEnv := &service.SysEnv{
+			StartedTime:  time.Now(),
+			ConfirmKeyId: "test",
+			ConfirmKeys:  testConfirmKeys,
+		}
+		sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
+
+		// request
+		writer := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "/test/bbs.cgi", nil)
+		request.Header.Add("User-Agent", "Monazilla/1.00")
+		request.Header.Set("Content-Type", tt.contentType)
+		request.PostForm = map[string][]string{
+			"submit":  []string{"書き込む"},
+			"bbs":     []string{"news4vip"},
+			"key":     []string{"1234567890"},
+			"time":    []string{"1"},
+			"FROM":    []string{"名無し"},
+			"mail":    []string{"sage"},
+			"MESSAGE": []string{"書き😀"},
+		}
+		if tt.charset != "" {
+			request.PostForm.Set("_charset_", tt.charset)
+		}
+		addConfirmCookie(t, request, sv)
+		request.Header.Add("Referer", "http://"+request.Host+"/news4vip/")
+
+		// Exercise
+		router := NewBoardRouter(sv)
+		router.ServeHTTP(writer, request)
+
+		// Verify
+		body := string(util.SJIStoUTF8(writer.Body.Bytes()))
+		if writer.Code != 200 || !strings.Contains(body, "<!-- 2ch_X:true -->") {
+			t.Errorf("%d: Response code is %v, body: %v", i, writer.Code, body)
+		}
+		entity := repo.DatMap["news4vip"]["1234567890"]
+		dat := string(entity.Bytes)
+		if entity.Sjis {
+			dat = util.SJIStoUTF8String(dat)
+		}
+		if !strings.Contains(dat, "名無し<>sage<>") || !strings.Contains(dat, "<> 書き&#128512; <>") {
+			t.Errorf("%d: dat: %v", i, dat)
+		}
+	}
+}
+
 func TestHandleBbsCgi_writeDatOKthroughConfirm(t *testing.T) {
 	// Setup
 	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
@@ -145,7 +232,9 @@ func TestHandleBbsCgi_writeDatOKthroughConfirm(t *testing.T) {
 	},
 	)
 	sysEnv := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -162,8 +251,7 @@ func TestHandleBbsCgi_writeDatOKthroughConfirm(t *testing.T) {
 		"mail":    []string{"sage"},
 		"MESSAGE": []string{util.UTF8toSJISString("書き")},
 	}
-	request.AddCookie(&http.Cookie{Name: "PON", Value: request.RemoteAddr})
-	request.AddCookie(&http.Cookie{Name: "yuki", Value: "akari"})
+	addConfirmCookie(t, request, sv)
 	request.Header.Add("Referer", "http://"+request.Host+"/news4vip/")
 
 	// Exercise
@@ -176,7 +264,8 @@ func TestHandleBbsCgi_writeDatOKthroughConfirm(t *testing.T) {
 	}
 	// body
 	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
-	if !strings.Contains(body, "<title>書きこみました。</title>") {
+	if !strings.Contains(body, "<title>書きこみました。</title>") ||
+		!strings.Contains(body, "<!-- 2ch_X:true -->") {
 		t.Errorf("NOT write_dat_done.html : %v", body)
 	}
 }
@@ -188,7 +277,9 @@ func TestHandleBbsCgi_createThreadOK(t *testing.T) {
 	},
 	)
 	sysEnv := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -205,8 +296,7 @@ func TestHandleBbsCgi_createThreadOK(t *testing.T) {
 		"mail":    []string{"sage"},
 		"MESSAGE": []string{util.UTF8toSJISString("書き")},
 	}
-	request.AddCookie(&http.Cookie{Name: "PON", Value: request.RemoteAddr})
-	request.AddCookie(&http.Cookie{Name: "yuki", Value: "akari"})
+	addConfirmCookie(t, request, sv)
 	request.Header.Add("Referer", "http://"+request.Host+"/news4vip/")
 
 	// Exercise
@@ -219,7 +309,8 @@ func TestHandleBbsCgi_createThreadOK(t *testing.T) {
 	}
 	// body
 	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
-	if !strings.Contains(body, "<title>書きこみました。</title>") {
+	if !strings.Contains(body, "<title>書きこみました。</title>") ||
+		!strings.Contains(body, "<!-- 2ch_X:true -->") {
 		t.Errorf("NOT write_dat_done.html : %v", body)
 	}
 }
@@ -231,7 +322,9 @@ func TestHandleBbsCgi_createThreadOKthroughConfirm(t *testing.T) {
 	},
 	)
 	sysEnv := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -248,8 +341,7 @@ func TestHandleBbsCgi_createThreadOKthroughConfirm(t *testing.T) {
 		"mail":    []string{"sage"},
 		"MESSAGE": []string{util.UTF8toSJISString("書き")},
 	}
-	request.AddCookie(&http.Cookie{Name: "PON", Value: request.RemoteAddr})
-	request.AddCookie(&http.Cookie{Name: "yuki", Value: "akari"})
+	addConfirmCookie(t, request, sv)
 	request.Header.Add("Referer", "http://"+request.Host+"/news4vip/")
 
 	// Exercise
@@ -262,7 +354,8 @@ func TestHandleBbsCgi_createThreadOKthroughConfirm(t *testing.T) {
 	}
 	// body
 	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
-	if !strings.Contains(body, "<title>書きこみました。</title>") {
+	if !strings.Contains(body, "<title>書きこみました。</title>") ||
+		!strings.Contains(body, "<!-- 2ch_X:true -->") {
 		t.Errorf("NOT write_dat_done.html : %v", body)
 	}
 }
@@ -271,7 +364,7 @@ func TestHandleBbsCgi_createThreadOKthroughConfirm(t *testing.T) {
 // 本当は「ERROR: 送られてきたデータが壊れています」ページが返されると思う
 func TestWriteDat_400(t *testing.T) {
 	// Setup
-	var repo repository.BoardRepository
+	repo := testutil.EmptyBoardStub()
 	var sysEnv service.BoardEnvironment
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -350,15 +443,20 @@ func TestWriteDat_400(t *testing.T) {
 		if writer.Code != 400 {
 			t.Errorf("case %d . Response code is %v", i, writer.Code)
 		}
+		if body := string(util.SJIStoUTF8(writer.Body.Bytes())); !strings.Contains(body, "<!-- 2ch_X:error -->") {
+			t.Errorf("case %d . NOT write_error.html : %v", i, body)
+		}
 	}
 }
 
 func TestWriteDat_CookieMissing(t *testing.T) {
 
 	// Setup
-	var repo repository.BoardRepository
+	repo := testutil.EmptyBoardStub()
 	sysEnv := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -395,27 +493,78 @@ func TestWriteDat_CookieMissing(t *testing.T) {
 			for _, v := range vs {
 				if strings.HasPrefix(v, "PON=") {
 					cookieCount++
-				} else if strings.HasPrefix(v, "yuki=akari") {
-					cookieCount++
 				}
 			}
 		}
 	}
-	if cookieCount != 2 {
+	if cookieCount != 1 {
 		t.Errorf("header: %v", writer.HeaderMap)
 	}
 	// body
 	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
-	if !strings.Contains(body, "<title>■ 書き込み確認 ■</title>") {
+	if !strings.Contains(body, "<title>■ 書き込み確認 ■</title>") ||
+		!strings.Contains(body, "<!-- 2ch_X:cookie -->") {
 		t.Errorf("NOT write_dat_confirm.html: %v", body)
 	}
 }
 
+// 古い形式のクッキーや、別の人のクッキーでは確認を飛ばせない
+func TestWriteDat_CookieForged(t *testing.T) {
+
+	// Setup
+	repo := testutil.EmptyBoardStub()
+	sysEnv := &service.SysEnv{
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
+	}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
+
+	otherToken, err := sv.IssueConfirmToken("198.51.100.1", "Monazilla/1.00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, cookies := range [][]*http.Cookie{
+		{{Name: "PON", Value: "192.0.2.1"}, {Name: "yuki", Value: "akari"}},
+		{{Name: "PON", Value: otherToken}},
+	} {
+		// request
+		writer := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "/test/bbs.cgi", nil)
+		request.RemoteAddr = "192.0.2.1:1234"
+		request.Header.Add("User-Agent", "Monazilla/1.00")
+		request.Header.Add("Referer", "http://"+request.Host+"/news4vip/")
+		for _, c := range cookies {
+			request.AddCookie(c)
+		}
+		request.PostForm = map[string][]string{
+			"bbs":     []string{"news4vip"},
+			"key":     []string{"1234567890"},
+			"time":    []string{"1"},
+			"FROM":    []string{"xxxx"},
+			"mail":    []string{"yyyy"},
+			"MESSAGE": []string{"aaaa"},
+		}
+
+		// Exercise
+		handleWriteDat(writer, request, sv)
+
+		// Verify
+		body := string(util.SJIStoUTF8(writer.Body.Bytes()))
+		if !strings.Contains(body, "<title>■ 書き込み確認 ■</title>") {
+			t.Errorf("%d: NOT write_dat_confirm.html: %v", i, body)
+		}
+	}
+}
+
 func TestWriteDat_NotFound(t *testing.T) {
 	// Setup
 	repo := testutil.EmptyBoardStub()
 	sysEnv := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -431,8 +580,7 @@ func TestWriteDat_NotFound(t *testing.T) {
 	// request
 	writer := httptest.NewRecorder()
 	request, _ := http.NewRequest("POST", "/test/bbs.cgi", nil)
-	request.AddCookie(&http.Cookie{Name: "PON", Value: request.RemoteAddr})
-	request.AddCookie(&http.Cookie{Name: "yuki", Value: "akari"})
+	addConfirmCookie(t, request, sv)
 	request.Header.Add("Referer", "http://"+request.Host+"/news4vip/")
 
 	// Exercise
@@ -443,12 +591,13 @@ func TestWriteDat_NotFound(t *testing.T) {
 	handleWriteDat(writer, request, sv)
 
 	// Verify
-	if writer.Code != 200 {
+	if writer.Code != 404 {
 		t.Errorf("Response code is %v", writer.Code)
 	}
 	// body
 	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
-	if !strings.Contains(body, "ERROR: 該当するスレッドがありません。") {
+	if !strings.Contains(body, "ERROR: 該当するスレッドがありません。") ||
+		!strings.Contains(body, "<!-- 2ch_X:error -->") {
 		t.Errorf("NOT write_dat_not_found.html : %v", body)
 	}
 }
@@ -467,7 +616,9 @@ func TestWriteDat_Done(t *testing.T) {
 	},
 	)
 	sysEnv := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -483,8 +634,7 @@ func TestWriteDat_Done(t *testing.T) {
 	// request
 	writer := httptest.NewRecorder()
 	request, _ := http.NewRequest("POST", "/test/bbs.cgi", nil)
-	request.AddCookie(&http.Cookie{Name: "PON", Value: request.RemoteAddr})
-	request.AddCookie(&http.Cookie{Name: "yuki", Value: "akari"})
+	addConfirmCookie(t, request, sv)
 	request.Header.Add("Referer", "http://"+request.Host+"/news4vip/")
 
 	// Exercise
@@ -500,7 +650,8 @@ func TestWriteDat_Done(t *testing.T) {
 	}
 	// body
 	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
-	if !strings.Contains(body, "<title>書きこみました。</title>") {
+	if !strings.Contains(body, "<title>書きこみました。</title>") ||
+		!strings.Contains(body, "<!-- 2ch_X:true -->") {
 		t.Errorf("NOT write_dat_done.html : %v", body)
 	}
 }
@@ -509,7 +660,7 @@ func TestWriteDat_Done(t *testing.T) {
 // 本当は「ERROR: 送られてきたデータが壊れています」ページが返されると思う
 func TestCreateThread_400(t *testing.T) {
 	// Setup
-	var repo repository.BoardRepository
+	repo := testutil.EmptyBoardStub()
 	var sysEnv service.BoardEnvironment
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -588,15 +739,20 @@ func TestCreateThread_400(t *testing.T) {
 		if writer.Code != 400 {
 			t.Errorf("case %d . Response code is %v", i, writer.Code)
 		}
+		if body := string(util.SJIStoUTF8(writer.Body.Bytes())); !strings.Contains(body, "<!-- 2ch_X:error -->") {
+			t.Errorf("case %d . NOT write_error.html : %v", i, body)
+		}
 	}
 }
 
 func TestCreateThread_CookieMissing(t *testing.T) {
 
 	// Setup
-	var repo repository.BoardRepository
+	repo := testutil.EmptyBoardStub()
 	sysEnv := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -633,18 +789,17 @@ func TestCreateThread_CookieMissing(t *testing.T) {
 			for _, v := range vs {
 				if strings.HasPrefix(v, "PON=") {
 					cookieCount++
-				} else if strings.HasPrefix(v, "yuki=akari") {
-					cookieCount++
 				}
 			}
 		}
 	}
-	if cookieCount != 2 {
+	if cookieCount != 1 {
 		t.Errorf("header: %v", writer.HeaderMap)
 	}
 	// body
 	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
-	if !strings.Contains(body, "<title>■ 書き込み確認 ■</title>") {
+	if !strings.Contains(body, "<title>■ 書き込み確認 ■</title>") ||
+		!strings.Contains(body, "<!-- 2ch_X:cookie -->") {
 		t.Errorf("NOT write_dat_confirm.html: %v", body)
 	}
 }
@@ -653,7 +808,9 @@ func TestCreateThread_NotFound(t *testing.T) {
 	// Setup
 	repo := testutil.EmptyBoardStub()
 	sysEnv := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -669,8 +826,7 @@ func TestCreateThread_NotFound(t *testing.T) {
 	// request
 	writer := httptest.NewRecorder()
 	request, _ := http.NewRequest("POST", "/test/bbs.cgi", nil)
-	request.AddCookie(&http.Cookie{Name: "PON", Value: request.RemoteAddr})
-	request.AddCookie(&http.Cookie{Name: "yuki", Value: "akari"})
+	addConfirmCookie(t, request, sv)
 	request.Header.Add("Referer", "http://"+request.Host+"/news4vip/")
 
 	// Exercise
@@ -681,13 +837,46 @@ func TestCreateThread_NotFound(t *testing.T) {
 	handleCreateThread(writer, request, sv)
 
 	// Verify
-	if writer.Code != 200 {
+	if writer.Code != 404 {
 		t.Errorf("Response code is %v", writer.Code)
 	}
 	// body
 	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
-	if !strings.Contains(body, "ERROR: XXXXXXX") {
-		t.Errorf("NOT create_thread_error.html : %v", body)
+	if !strings.Contains(body, "<!-- 2ch_X:error -->") ||
+		!strings.Contains(body, "ERROR: その板は存在しません！") {
+		t.Errorf("NOT write_error.html : %v", body)
+	}
+}
+
+func TestRejectWrite(t *testing.T) {
+
+	tests := []struct {
+		err  error
+		code int
+		body string
+	}{
+		{errors.New(errors.THREAD_NOT_FOUND, "no such thread"), 404, "ERROR: 該当するスレッドがありません。"},
+		{errors.New(errors.THREAD_STOPPED, "stopped"), 403, "ERROR: このスレッドは停止されています。"},
+		{errors.New(errors.THREAD_FULL, "1001"), 403, "ERROR: このスレッドにはもう書き込めません。"},
+		{errors.New(errors.DAT_OVER_CAPACITY, "capacity"), 403, "ERROR: このスレッドは容量が一杯です。"},
+		{errors.New(errors.WRITE_LIMIT, "limit"), 503, "ERROR: 今日はこれ以上書き込めません。"},
+		{errors.New(errors.DUPLICATE_KEY, "duplicate"), 409, "ERROR: スレッドが立てられすぎています。"},
+		{fmt.Errorf("datastore: broken"), 500, "ERROR: サーバーの調子が悪いみたいです。"},
+	}
+
+	for i, tt := range tests {
+		writer := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "/test/bbs.cgi", nil)
+
+		rejectWrite(writer, request, "news4vip", "1234567890", tt.err)
+
+		if writer.Code != tt.code {
+			t.Errorf("%d: Response code is %v, want: %v", i, writer.Code, tt.code)
+		}
+		body := string(util.SJIStoUTF8(writer.Body.Bytes()))
+		if !strings.Contains(body, "<!-- 2ch_X:error -->") || !strings.Contains(body, tt.body) {
+			t.Errorf("%d: body: %v", i, body)
+		}
 	}
 }
 
@@ -698,7 +887,9 @@ func TestCreateThread_Done(t *testing.T) {
 	},
 	)
 	sysEnv := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
 
@@ -714,8 +905,7 @@ func TestCreateThread_Done(t *testing.T) {
 	// request
 	writer := httptest.NewRecorder()
 	request, _ := http.NewRequest("POST", "/test/bbs.cgi", nil)
-	request.AddCookie(&http.Cookie{Name: "PON", Value: request.RemoteAddr})
-	request.AddCookie(&http.Cookie{Name: "yuki", Value: "akari"})
+	addConfirmCookie(t, request, sv)
 	request.Header.Add("Referer", "http://"+request.Host+"/news4vip/")
 
 	// Exercise
@@ -731,11 +921,60 @@ func TestCreateThread_Done(t *testing.T) {
 	}
 	// body
 	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
-	if !strings.Contains(body, "<title>書きこみました。</title>") {
+	if !strings.Contains(body, "<title>書きこみました。</title>") ||
+		!strings.Contains(body, "<!-- 2ch_X:true -->") {
 		t.Errorf("NOT write_dat_done.html : %v", body)
 	}
 }
 
+func TestCreateThread_DoneDuplicateKey(t *testing.T) {
+	// Setup
+	now := time.Now()
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{ThreadKey: strconv.FormatInt(now.Unix(), 10)},
+	},
+	)
+	sysEnv := &service.SysEnv{
+		StartedTime:  now,
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
+	}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv))
+
+	param := map[string]string{
+		"bbs":     "news4vip",
+		"time":    "1",
+		"subject": "AAAAA",
+		"FROM":    "xxxx",
+		"mail":    "yyyy",
+		"MESSAGE": "aaaa",
+	}
+
+	// request
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/test/bbs.cgi", nil)
+	addConfirmCookie(t, request, sv)
+	request.Header.Add("Referer", "http://"+request.Host+"/news4vip/")
+
+	// Exercise
+	request.PostForm = make(map[string][]string)
+	for k, v := range param {
+		request.PostForm.Add(k, v)
+	}
+	handleCreateThread(writer, request, sv)
+
+	// Verify
+	if writer.Code != 200 {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+	// body
+	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
+	url := fmt.Sprintf("/test/read.cgi/news4vip/%d/l50", now.Unix()+1)
+	if !strings.Contains(body, url) {
+		t.Errorf("URL %v not found : %v", url, body)
+	}
+}
+
 func TestHandleDat_200(t *testing.T) {
 	// Setup
 	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
@@ -745,7 +984,9 @@ func TestHandleDat_200(t *testing.T) {
 		},
 	})
 	env := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(env))
 
@@ -778,7 +1019,9 @@ func TestHandleDat_404(t *testing.T) {
 		},
 	})
 	env := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(env))
 
@@ -808,7 +1051,9 @@ func TestHandleDat_IfModified_304(t *testing.T) {
 		},
 	})
 	env := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(env))
 
@@ -839,7 +1084,9 @@ func TestHandleDat_IfModified_416(t *testing.T) {
 		},
 	})
 	env := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(env))
 
@@ -871,7 +1118,9 @@ func TestHandleDat_IfModified_206(t *testing.T) {
 		},
 	})
 	env := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(env))
 
@@ -907,7 +1156,9 @@ func TestHandleDat_IfModified_Err(t *testing.T) {
 		},
 	})
 	env := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(env))
 
@@ -970,7 +1221,9 @@ func TestHandleSubjectTxt_200(t *testing.T) {
 		},
 	})
 	env := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(env))
 
@@ -998,7 +1251,9 @@ func TestHandleSubjectTxt_404(t *testing.T) {
 	// Setup
 	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{})
 	env := &service.SysEnv{
-		StartedTime: time.Now(),
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
 	}
 	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(env))
 
@@ -1016,3 +1271,196 @@ func TestHandleSubjectTxt_404(t *testing.T) {
 		t.Errorf("Response code is %v", writer.Code)
 	}
 }
+
+func TestHandleReadCgi_200(t *testing.T) {
+	// Setup
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{
+			ThreadKey:    "1234567890",
+			ThreadTitle:  "スレタイ",
+			MessageCount: 2,
+			Dat: "名前<>メール<>日付 ID:X<> 本文1 <>スレタイ\n" +
+				"名前<><>日付 ID:Y<> &gt;&gt;1 <>\n",
+		},
+	})
+	repo.DatMap["news4vip"]["1234567890"].Anchors = []dat.Anchor{{From: 2, To: 1}}
+	env := &service.SysEnv{
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
+	}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(env))
+
+	// request
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/test/read.cgi/news4vip/1234567890/", nil)
+	request.Header.Add("User-Agent", "Monazilla/1.00")
+
+	// Exercise
+	router := NewBoardRouter(sv)
+	router.ServeHTTP(writer, request)
+
+	// Verify
+	if writer.Code != 200 {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
+	if !strings.Contains(body, "<title>スレタイ</title>") {
+		t.Errorf("title not found: %v", body)
+	}
+	if !strings.Contains(body, "本文1") || !strings.Contains(body, "&gt;&gt;1") {
+		t.Errorf("messages not found: %v", body)
+	}
+	if !strings.Contains(body, `<a href="#2">&lt;&lt;2</a>`) {
+		t.Errorf("replies not found: %v", body)
+	}
+}
+
+func TestHandleReadCgi_404(t *testing.T) {
+	// Setup
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{})
+	env := &service.SysEnv{
+		StartedTime:  time.Now(),
+		ConfirmKeyId: "test",
+		ConfirmKeys:  testConfirmKeys,
+	}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(env))
+
+	// request
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/test/read.cgi/news4vip/1234567890/", nil)
+	request.Header.Add("User-Agent", "Monazilla/1.00")
+
+	// Exercise
+	router := NewBoardRouter(sv)
+	router.ServeHTTP(writer, request)
+
+	// Verify
+	if writer.Code != 404 {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+}
+
+func TestHandleBoard(t *testing.T) {
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	threads := []testutil.ThreadStub{}
+	for i := 0; i < board_top_links+1; i++ {
+		key := strconv.Itoa(1579316400 + i)
+		threads = append(threads, testutil.ThreadStub{
+			ThreadKey:    key,
+			ThreadTitle:  "スレ" + strconv.Itoa(i+1),
+			MessageCount: 1,
+			LastModified: time.Date(2020, 1, 18, 12, 0, i, 0, jst),
+			Dat:          "名無し<><>2020/01/18(土) 12:00:00.000 ID:a<> 本文" + key + " <>スレ\n",
+		})
+	}
+	repo := testutil.NewBoardStub("news4vip", threads)
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(&service.SysEnv{StartedTime: time.Now()}))
+	router := NewBoardRouter(sv)
+
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/news4vip/", nil)
+	request.Header.Set("User-Agent", "Monazilla/1.00")
+	router.ServeHTTP(writer, request)
+
+	if writer.Code != http.StatusOK {
+		t.Fatalf("Response code is %v", writer.Code)
+	}
+	if ct := writer.Header().Get("Content-Type"); ct != "text/html; charset=Shift_JIS" {
+		t.Errorf("Content-Type = %v", ct)
+	}
+	if lm := writer.Header().Get("Last-Modified"); lm != "Sat, 18 Jan 2020 03:00:30 GMT" {
+		t.Errorf("Last-Modified = %v", lm)
+	}
+	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
+	wants := []string{
+		"おっおっ", // head.txt
+		// 本文があるスレはページ内にリンク
+		`<a href="#1">1: スレ1 (1)</a>`,
+		`<a href="/test/read.cgi/news4vip/1579316410/">11: スレ11 (1)</a>`,
+		"threads (+1)",
+		"本文1579316409",
+	}
+	for _, want := range wants {
+		if !strings.Contains(body, want) {
+			t.Errorf("%v not found: %v", want, body)
+		}
+	}
+	for _, notWant := range []string{"本文1579316410", "スレ31 "} {
+		if strings.Contains(body, notWant) {
+			t.Errorf("%v found: %v", notWant, body)
+		}
+	}
+
+	// 変わっていなければ304
+	writer2 := httptest.NewRecorder()
+	request.Header.Set("If-None-Match", writer.Header().Get("ETag"))
+	router.ServeHTTP(writer2, request)
+	if writer2.Code != http.StatusNotModified {
+		t.Errorf("Response code is %v", writer2.Code)
+	}
+}
+
+// datが無いスレがあってもページ内リンクがずれない
+func TestHandleBoard_MissingDat(t *testing.T) {
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{ThreadKey: "1579316400", ThreadTitle: "スレ1", MessageCount: 1, Dat: "名無し<><>2020/01/18(土) 12:00:00.000 ID:a<> 本文1 <>スレ1\n"},
+		{ThreadKey: "1579316401", ThreadTitle: "スレ2", MessageCount: 1},
+		{ThreadKey: "1579316402", ThreadTitle: "スレ3", MessageCount: 1, Dat: "名無し<><>2020/01/18(土) 12:00:00.000 ID:a<> 本文3 <>スレ3\n"},
+	})
+	delete(repo.DatMap["news4vip"], "1579316401")
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(&service.SysEnv{StartedTime: time.Now()}))
+	router := NewBoardRouter(sv)
+
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/news4vip/", nil)
+	request.Header.Set("User-Agent", "Monazilla/1.00")
+	router.ServeHTTP(writer, request)
+
+	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
+	wants := []string{
+		`<a href="#1">1: スレ1 (1)</a>`,
+		`<a href="/test/read.cgi/news4vip/1579316401/">2: スレ2 (1)</a>`,
+		`<a href="#3">3: スレ3 (1)</a>`,
+		`<div id="3">`,
+	}
+	for _, want := range wants {
+		if !strings.Contains(body, want) {
+			t.Errorf("%v not found: %v", want, body)
+		}
+	}
+	if strings.Contains(body, `<div id="2">`) {
+		t.Errorf("anchor 2 found: %v", body)
+	}
+}
+
+func TestHandleSubback(t *testing.T) {
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{ThreadKey: "1579316400", ThreadTitle: "スレ&amp;1", MessageCount: 3},
+		{ThreadKey: "1579316401", ThreadTitle: "スレ2", MessageCount: 1},
+	})
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(&service.SysEnv{StartedTime: time.Now()}))
+	router := NewBoardRouter(sv)
+
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/news4vip/subback.html", nil)
+	request.Header.Set("User-Agent", "Monazilla/1.00")
+	router.ServeHTTP(writer, request)
+
+	if writer.Code != http.StatusOK {
+		t.Fatalf("Response code is %v", writer.Code)
+	}
+	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
+	if !strings.Contains(body, `<a href="/test/read.cgi/news4vip/1579316400/">1: スレ&amp;1 (3)</a>`) ||
+		!strings.Contains(body, `<a href="/test/read.cgi/news4vip/1579316401/">2: スレ2 (1)</a>`) {
+		t.Errorf("body = %v", body)
+	}
+
+	writer = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/unknown/subback.html", nil)
+	request.Header.Set("User-Agent", "Monazilla/1.00")
+	router.ServeHTTP(writer, request)
+	if writer.Code != http.StatusNotFound {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+}
@@ -0,0 +1,68 @@
+package handle
+
+import (
+	"cloud.google.com/go/datastore"
+	"github.com/julienschmidt/httprouter"
+	"github.com/tempxla/stub2ch/internal/app/service"
+	"log"
+	"net/http"
+)
+
+// 板の新着スレッド (RSS 1.0)
+func handleBoardRdf() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		board := ps.ByName("board")
+		stng, ok := requireSetting(w, sv, board)
+		if !ok {
+			return
+		}
+
+		rdf, lastModified, err := sv.MakeBoardRdf(stng, board, requestBaseURL(r))
+		if err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				http.Error(w, "Not found", http.StatusNotFound)
+			} else {
+				log.Printf("ERROR: handleBoardRdf. %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		writeCacheable(w, r, "application/rdf+xml; charset=utf-8", rdf, lastModified)
+	}
+}
+
+// スレの新着レス (Atom)
+func handleThreadAtom() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		board := ps.ByName("board")
+		stng, ok := requireSetting(w, sv, board)
+		if !ok {
+			return
+		}
+
+		atom, lastModified, err := sv.MakeThreadAtom(stng, board, ps.ByName("threadKey"),
+			requestBaseURL(r), service.FEED_ITEM_MAX)
+		if err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				http.Error(w, "Not found", http.StatusNotFound)
+			} else {
+				log.Printf("ERROR: handleThreadAtom. %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		writeCacheable(w, r, "application/atom+xml; charset=utf-8", atom, lastModified)
+	}
+}
+
+// フィードのリンクに使う http://host
+// App Engineの前段でTLSが終わるので X-Forwarded-Proto を見る
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
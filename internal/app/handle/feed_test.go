@@ -0,0 +1,146 @@
+package handle
+
+import (
+	"github.com/tempxla/stub2ch/internal/app/service"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleThreadAtom(t *testing.T) {
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{
+			ThreadKey:    "1579316400",
+			ThreadTitle:  "スレタイ",
+			MessageCount: 2,
+			LastModified: time.Date(2020, 1, 18, 12, 1, 0, 0, jst),
+			Dat: "名無し<><>2020/01/18(土) 12:00:00.000 ID:aaa<> 本文1 <>スレタイ\n" +
+				"名無し<><>2020/01/18(土) 12:01:00.000 ID:bbb<> &gt;&gt;1<br>乙 <>\n",
+		},
+	})
+	sv := service.NewBoardService(service.RepoConf(repo),
+		service.EnvConf(&service.SysEnv{StartedTime: time.Date(2020, 1, 18, 13, 0, 0, 0, jst)}))
+	router := NewBoardRouter(sv)
+
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://example.com/news4vip/atom/1579316400", nil)
+	request.Header.Set("X-Forwarded-Proto", "https")
+	router.ServeHTTP(writer, request)
+
+	if writer.Code != http.StatusOK {
+		t.Fatalf("Response code is %v", writer.Code)
+	}
+	if ct := writer.Header().Get("Content-Type"); ct != "application/atom+xml; charset=utf-8" {
+		t.Errorf("Content-Type = %v", ct)
+	}
+	if lm := writer.Header().Get("Last-Modified"); lm != "Sat, 18 Jan 2020 03:01:00 GMT" {
+		t.Errorf("Last-Modified = %v", lm)
+	}
+	etag := writer.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || len(etag) != 34 {
+		t.Errorf("ETag = %v", etag)
+	}
+	body := writer.Body.String()
+	if !strings.Contains(body, `<link rel="self" type="application/atom+xml" href="https://example.com/news4vip/atom/1579316400"></link>`) ||
+		!strings.Contains(body, `<content type="html">&amp;gt;&amp;gt;1&lt;br&gt;乙</content>`) {
+		t.Errorf("body = %v", body)
+	}
+
+	tests := []struct {
+		header, value string
+		code          int
+	}{
+		{"If-None-Match", etag, http.StatusNotModified},
+		{"If-None-Match", `"xxxx", W/` + etag, http.StatusNotModified},
+		{"If-None-Match", `"xxxx"`, http.StatusOK},
+		{"If-Modified-Since", "Sat, 18 Jan 2020 03:01:00 GMT", http.StatusNotModified},
+		{"If-Modified-Since", "Sat, 18 Jan 2020 03:00:59 GMT", http.StatusOK},
+	}
+	for _, tt := range tests {
+		writer = httptest.NewRecorder()
+		request, _ = http.NewRequest("GET", "http://example.com/news4vip/atom/1579316400", nil)
+		request.Header.Set("X-Forwarded-Proto", "https")
+		request.Header.Set(tt.header, tt.value)
+		router.ServeHTTP(writer, request)
+		if writer.Code != tt.code {
+			t.Errorf("%v: %v: Response code is %v, want: %v", tt.header, tt.value, writer.Code, tt.code)
+		}
+		if tt.code == http.StatusNotModified && writer.Body.Len() != 0 {
+			t.Errorf("%v: body = %v", tt.header, writer.Body.String())
+		}
+	}
+}
+
+func TestHandleBoardRdf(t *testing.T) {
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{
+			ThreadKey:    "1579316400",
+			ThreadTitle:  "スレタイ",
+			MessageCount: 2,
+			LastModified: time.Date(2020, 1, 18, 12, 1, 0, 0, jst),
+			Dat: "名無し<><>2020/01/18(土) 12:00:00.000 ID:aaa<> 本文1 <>スレタイ\n" +
+				"名無し<><>2020/01/18(土) 12:01:00.000 ID:bbb<> &gt;&gt;1<br>乙 <>\n",
+		},
+	})
+	sv := service.NewBoardService(service.RepoConf(repo),
+		service.EnvConf(&service.SysEnv{StartedTime: time.Date(2020, 1, 18, 13, 0, 0, 0, jst)}))
+	router := NewBoardRouter(sv)
+
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "http://example.com/news4vip/index.rdf", nil)
+	router.ServeHTTP(writer, request)
+
+	if writer.Code != http.StatusOK {
+		t.Fatalf("Response code is %v", writer.Code)
+	}
+	if ct := writer.Header().Get("Content-Type"); ct != "application/rdf+xml; charset=utf-8" {
+		t.Errorf("Content-Type = %v", ct)
+	}
+	// スレが立った時刻
+	if lm := writer.Header().Get("Last-Modified"); lm != "Sat, 18 Jan 2020 03:00:00 GMT" {
+		t.Errorf("Last-Modified = %v", lm)
+	}
+	if body := writer.Body.String(); !strings.Contains(body, `<item rdf:about="http://example.com/test/read.cgi/news4vip/1579316400/">`) {
+		t.Errorf("body = %v", body)
+	}
+
+	writer2 := httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/news4vip/index.rdf", nil)
+	request.Host = "example.com"
+	request.Header.Set("If-None-Match", writer.Header().Get("ETag"))
+	router.ServeHTTP(writer2, request)
+	if writer2.Code != http.StatusNotModified {
+		t.Errorf("Response code is %v", writer2.Code)
+	}
+}
+
+func TestHandleFeed_404(t *testing.T) {
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{
+			ThreadKey:    "1579316400",
+			ThreadTitle:  "スレタイ",
+			MessageCount: 2,
+			LastModified: time.Date(2020, 1, 18, 12, 1, 0, 0, jst),
+			Dat: "名無し<><>2020/01/18(土) 12:00:00.000 ID:aaa<> 本文1 <>スレタイ\n" +
+				"名無し<><>2020/01/18(土) 12:01:00.000 ID:bbb<> &gt;&gt;1<br>乙 <>\n",
+		},
+	})
+	sv := service.NewBoardService(service.RepoConf(repo),
+		service.EnvConf(&service.SysEnv{StartedTime: time.Date(2020, 1, 18, 13, 0, 0, 0, jst)}))
+	router := NewBoardRouter(sv)
+
+	for _, path := range []string{"/unknown/index.rdf", "/unknown/atom/1579316400", "/news4vip/atom/1234567890"} {
+		writer := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(writer, request)
+		if writer.Code != http.StatusNotFound {
+			t.Errorf("%v: Response code is %v", path, writer.Code)
+		}
+	}
+}
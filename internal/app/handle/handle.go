@@ -1,125 +1,283 @@
 package handle
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
-	"github.com/tempxla/stub2ch/configs/app/config"
 	"github.com/tempxla/stub2ch/internal/app/service"
 	"github.com/tempxla/stub2ch/internal/app/util"
 	"html/template"
 	"log"
+	"mime"
 	"net"
 	"net/http"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const (
 	user_agent = "Monazilla/1.00"
+	// フォームの文字コードを教えてもらう隠しフィールド (HTMLの決まり)
+	form_charset_name = "_charset_"
 )
 
 var (
-	indexTmpl             = template.Must(template.ParseFiles(filepath.Join("web", "template", "index.html")))
-	boardTmpl             = template.Must(template.ParseFiles(filepath.Join("web", "template", "board.html")))
-	datTmpl               = template.Must(template.ParseFiles(filepath.Join("web", "template", "dat.html")))
-	writeDatConfirmTmpl   = template.Must(template.ParseFiles(filepath.Join("web", "template", "write_dat_confirm.html")))
-	writeDatNotFoundTmpl  = template.Must(template.ParseFiles(filepath.Join("web", "template", "write_dat_not_found.html")))
-	writeDatDoneTmpl      = template.Must(template.ParseFiles(filepath.Join("web", "template", "write_dat_done.html")))
-	createThreadErrorTmpl = template.Must(template.ParseFiles(filepath.Join("web", "template", "create_thread_error.html")))
-	adminIndexTmpl        = template.Must(template.ParseFiles(filepath.Join("web", "template", "admin", "index.html")))
+	indexTmpl            *template.Template
+	boardTmpl            *template.Template
+	subbackTmpl          *template.Template
+	datTmpl              *template.Template
+	writeDatConfirmTmpl  *template.Template
+	writeDatNotFoundTmpl *template.Template
+	writeDatDoneTmpl     *template.Template
+	writeErrorTmpl       *template.Template
+	writeMaintenanceTmpl *template.Template
+	searchTmpl           *template.Template
+	adminIndexTmpl       *template.Template
+
+	staticDir string
+
+	tmplFuncs = template.FuncMap{
+		// html/templateはコメントを消してしまうので、専ブラ向けの印はこれで出す
+		"comment": func(s string) template.HTML {
+			return template.HTML("<!-- " + s + " -->")
+		},
+	}
 )
 
+// テンプレートと静的ファイルの場所
+type WebDir struct {
+	Template string
+	Static   string
+}
+
+func DefaultWebDir() *WebDir {
+	return &WebDir{
+		Template: filepath.Join("web", "template"),
+		Static:   filepath.Join("web", "static"),
+	}
+}
+
+// テンプレートを読み込む。ルーターを作る前に呼ぶこと。
+func LoadWeb(dir *WebDir) error {
+	tmpls := []struct {
+		tmpl **template.Template
+		name string
+	}{
+		{&indexTmpl, "index.html"},
+		{&boardTmpl, "board.html"},
+		{&subbackTmpl, "subback.html"},
+		{&datTmpl, "dat.html"},
+		{&writeDatConfirmTmpl, "write_dat_confirm.html"},
+		{&writeDatNotFoundTmpl, "write_dat_not_found.html"},
+		{&writeDatDoneTmpl, "write_dat_done.html"},
+		{&writeErrorTmpl, "write_error.html"},
+		{&writeMaintenanceTmpl, "write_maintenance.html"},
+		{&searchTmpl, "search.html"},
+		{&adminIndexTmpl, filepath.Join("admin", "index.html")},
+	}
+	// 全部読めたときだけ差し替える
+	parsed := make([]*template.Template, len(tmpls))
+	for i, t := range tmpls {
+		tmpl, err := template.New(filepath.Base(t.name)).Funcs(tmplFuncs).
+			ParseFiles(filepath.Join(dir.Template, t.name))
+		if err != nil {
+			return err
+		}
+		parsed[i] = tmpl
+	}
+	for i, t := range tmpls {
+		*t.tmpl = parsed[i]
+	}
+	staticDir = dir.Static
+	return nil
+}
+
 type ServiceHandle func(http.ResponseWriter, *http.Request, httprouter.Params, *service.BoardService)
 
 // HTTP routing
+// LoadWebを呼んでいなければ既定の場所から読む。読めなければpanicする。
 func NewBoardRouter(sv *service.BoardService) *httprouter.Router {
-	router := httprouter.New()
+	if indexTmpl == nil {
+		if err := LoadWeb(DefaultWebDir()); err != nil {
+			panic(err)
+		}
+	}
+	router := &instrumentedRouter{httprouter.New()}
 
 	// トップ
 	router.GET("/", handleIndex())
 
 	// 管理ページ
 	router.POST("/:board/_admin/login",
-		protect(config.KEEP_OUT)(
-			handleTestDir(
-				handleParseForm(
-					injectService(sv)(
-						handleAdminLogin())))))
+		handleTestDir(
+			handleParseForm(
+				injectService(sv)(
+					handleAdminLogin()))))
 	router.POST("/:board/_admin/logout",
-		protect(config.KEEP_OUT)(
-			handleTestDir(
-				handleParseForm(
-					injectService(sv)(
-						authenticate(
-							handleAdminLogout()))))))
+		handleTestDir(
+			handleParseForm(
+				injectService(sv)(
+					authenticate(
+						handleAdminLogout())))))
 	router.POST("/:board/_admin/func/:fp1/:fp2",
-		protect(config.KEEP_OUT)(
-			handleTestDir(
-				handleParseForm(
-					injectService(sv)(
-						authenticate(
-							handleAdmin()))))))
+		handleTestDir(
+			handleParseForm(
+				injectService(sv)(
+					authenticate(
+						handleAdmin())))))
+
+	router.POST("/:board/_admin/archive/export",
+		handleTestDir(
+			handleParseForm(
+				injectService(sv)(
+					authenticate(
+						handleAdminExport())))))
+	router.POST("/:board/_admin/archive/import",
+		handleTestDir(
+			handleParseForm(
+				injectService(sv)(
+					authenticate(
+						handleAdminImport())))))
+	router.POST("/:board/_admin/archive/import-dat",
+		handleTestDir(
+			handleParseForm(
+				injectService(sv)(
+					authenticate(
+						handleAdminImportDat())))))
+
+	// 管理API (JSON)
+	router.POST("/:board/_admin/api/login",
+		handleTestDir(
+			handleParseForm(
+				injectService(sv)(
+					handleAdminApiLogin()))))
+	router.POST("/:board/_admin/api/logout",
+		handleTestDir(
+			handleParseForm(
+				injectService(sv)(
+					authenticate(
+						handleAdminApiLogout())))))
+	router.POST("/:board/_admin/api/func/:fp1/:fp2",
+		handleTestDir(
+			handleParseForm(
+				injectService(sv)(
+					authenticate(
+						handleAdminApi())))))
+	router.POST("/:board/_admin/api/archive/export",
+		handleTestDir(
+			handleParseForm(
+				injectService(sv)(
+					authenticate(
+						handleAdminExport())))))
+	router.POST("/:board/_admin/api/archive/import",
+		handleTestDir(
+			handleParseForm(
+				injectService(sv)(
+					authenticate(
+						handleAdminApiImport())))))
+	router.POST("/:board/_admin/api/archive/import-dat",
+		handleTestDir(
+			handleParseForm(
+				injectService(sv)(
+					authenticate(
+						handleAdminApiImportDat())))))
+
+	// 定期実行 (deployments/cron.yaml)
+	router.GET("/:board/_cron/hoshu",
+		handleTestDir(
+			injectService(sv)(
+				handleCron(
+					handleCronHoshu()))))
 
 	// 掲示板
 	router.GET("/:board/",
-		protect(config.KEEP_OUT)(
-			handleUserAgent(
-				injectService(sv)(
+		handleUserAgent(
+			injectService(sv)(
+				handleMaintenance(
 					handleBoard()))))
+	router.GET("/:board/subback.html",
+		handleUserAgent(
+			injectService(sv)(
+				handleMaintenance(
+					handleSubback()))))
 	router.GET("/:board/read.cgi/:boardName/:threadKey/",
-		protect(config.KEEP_OUT)(
-			handleTestDir(
-				handleUserAgent(
-					injectService(sv)(
+		handleTestDir(
+			handleUserAgent(
+				injectService(sv)(
+					handleMaintenance(
 						handleReadCgi())))))
 	router.GET("/:board/SETTING.TXT",
-		protect(config.KEEP_OUT)(
-			handleUserAgent(
-				handleSettingTxt())))
+		handleUserAgent(
+			injectService(sv)(
+				handleMaintenance(
+					handleSettingTxt()))))
 	router.GET("/:board/head.txt",
-		protect(config.KEEP_OUT)(
-			handleUserAgent(
-				handleHeadTxt())))
+		handleUserAgent(
+			injectService(sv)(
+				handleMaintenance(
+					handleHeadTxt()))))
 	router.GET("/:board/subject.txt",
-		protect(config.KEEP_OUT)(
-			handleUserAgent(
-				injectService(sv)(
+		handleUserAgent(
+			injectService(sv)(
+				handleMaintenance(
 					handleSubjectTxt()))))
 	router.GET("/:board/dat/:dat",
-		protect(config.KEEP_OUT)(
-			handleUserAgent(
-				injectService(sv)(
+		handleUserAgent(
+			injectService(sv)(
+				handleMaintenance(
 					handleDat()))))
+	router.GET("/:board/index.rdf",
+		injectService(sv)(
+			handleMaintenance(
+				handleBoardRdf())))
+	router.GET("/:board/atom/:threadKey",
+		injectService(sv)(
+			handleMaintenance(
+				handleThreadAtom())))
 	router.POST("/:board/bbs.cgi",
-		protect(config.KEEP_OUT)(
-			handleUserAgent(
-				handleTestDir(
-					handleParseForm(
+		handleUserAgent(
+			handleTestDir(
+				handleParseForm(
+					handleSjisForm(
 						injectService(sv)(
 							handleBbsCgi()))))))
 
+	// 検索
+	router.GET("/:board/search.cgi",
+		handleTestDir(
+			injectService(sv)(
+				handleMaintenance(
+					handleSearchCgi()))))
+	router.GET("/:board/search.json",
+		handleTestDir(
+			injectService(sv)(
+				handleMaintenance(
+					handleSearchJson()))))
+
 	// Jsonデモ
 	router.POST("/:board/subject.json",
-		protect(config.KEEP_OUT)(
-			handleUserAgent(
-				handleParseForm(
-					injectService(sv)(
+		handleUserAgent(
+			handleParseForm(
+				injectService(sv)(
+					handleMaintenance(
 						handlePrecure(
 							handleSubjectJson()))))))
 	router.POST("/:board/json/:dat",
-		protect(config.KEEP_OUT)(
-			handleUserAgent(
-				handleParseForm(
-					injectService(sv)(
+		handleUserAgent(
+			handleParseForm(
+				injectService(sv)(
+					handleMaintenance(
 						handlePrecure(
 							handleDatJson()))))))
 
 	// 静的ファイル
 	// GAEの設定はapp.yamlなので、これは開発用
 	// The path must end with "/*filepath"
-	router.ServeFiles("/:board/_static/*filepath", http.Dir("web/static"))
+	router.ServeFiles("/:board/_static/*filepath", http.Dir(staticDir))
 
-	return router
+	return router.Router
 }
 
 func injectService(sv *service.BoardService) func(ServiceHandle) httprouter.Handle {
@@ -132,7 +290,7 @@ func injectService(sv *service.BoardService) func(ServiceHandle) httprouter.Hand
 			if sv != nil {
 				boardService = sv
 			} else {
-				boardService, err = service.DefaultBoardService()
+				boardService, err = service.DefaultBoardService(r.Context())
 				if err != nil {
 					http.Error(w, fmt.Sprintf("%v", err), http.StatusServiceUnavailable) // 503
 					return
@@ -155,18 +313,6 @@ func handleIndex() httprouter.Handle {
 	}
 }
 
-func protect(keepOut bool) func(httprouter.Handle) httprouter.Handle {
-	return func(h httprouter.Handle) httprouter.Handle {
-		return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-			if keepOut {
-				http.Error(w, "KEEP OUT", http.StatusServiceUnavailable) // 503
-				return
-			}
-			h(w, r, ps)
-		}
-	}
-}
-
 func handleTestDir(h httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		board := ps.ByName("board")
@@ -185,6 +331,39 @@ func handleParseForm(h httprouter.Handle) httprouter.Handle {
 	}
 }
 
+// bbs.cgiはSJISで読むので、UTF-8で送られたフォームはSJISに直しておく。
+// SJISに無い文字は数値文字参照にするので、バイト数の制限はどちらで送っても同じになる。
+func handleSjisForm(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if isUTF8Form(r) {
+			for _, vs := range r.PostForm {
+				for i, v := range vs {
+					vs[i] = util.UTF8toSJISStringNCR(v)
+				}
+			}
+		}
+		h(w, r, ps)
+	}
+}
+
+// Content-Type の charset か、ブラウザが埋める _charset_ でUTF-8か判定する。
+// どちらも無ければSJIS。
+func isUTF8Form(r *http.Request) bool {
+	charset := ""
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+		charset = params["charset"]
+	}
+	if charset == "" {
+		charset = r.PostForm.Get(form_charset_name)
+	}
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8":
+		return true
+	default:
+		return false
+	}
+}
+
 func handleUserAgent(h httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
@@ -199,6 +378,54 @@ func handleUserAgent(h httprouter.Handle) httprouter.Handle {
 	}
 }
 
+// テンプレートをShift_JISのHTMLとして書き出し、ETagなどを付けて送る
+func writeHtmlSjis(w http.ResponseWriter, r *http.Request, tmpl *template.Template, view interface{}, lastModified time.Time) {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, view); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeCacheable(w, r, "text/html; charset=Shift_JIS", buf.Bytes(), lastModified)
+}
+
+// ETagとLast-Modifiedを付けて送る。
+// 変わっていなければ304を返す。If-None-Matchがあればそちらを優先する。
+func writeCacheable(w http.ResponseWriter, r *http.Request, contentType string, body []byte, lastModified time.Time) {
+	sum := sha256.Sum256(body)
+	etag := fmt.Sprintf(`"%x"`, sum[:16])
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified) // 304
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		// ヘッダーの時刻は秒まで
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
 func setContentTypeHtmlSjis(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "text/html; charset=Shift_JIS")
 }
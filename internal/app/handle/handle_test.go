@@ -3,14 +3,49 @@ package handle
 import (
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"github.com/tempxla/stub2ch/configs/app/secretcfg"
 	"github.com/tempxla/stub2ch/internal/app/service"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
 )
 
+func TestMain(m *testing.M) {
+	if err := LoadWeb(DefaultWebDir()); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// DefaultBoardServiceを使うテスト用
+	if err := service.ConfigureConfirmKeys(secretcfg.CONFIRM_KEY_ID + ":test"); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+func TestLoadWeb(t *testing.T) {
+	defer LoadWeb(DefaultWebDir())
+
+	if err := LoadWeb(&WebDir{Template: "xxxx", Static: "xxxx"}); err == nil {
+		t.Error("err is nil")
+	}
+}
+
+// LoadWebを呼ばずにルーターを作る
+func TestNewBoardRouter_DefaultWeb(t *testing.T) {
+	defer LoadWeb(DefaultWebDir())
+	indexTmpl, staticDir = nil, ""
+
+	NewBoardRouter(nil)
+
+	if indexTmpl == nil || staticDir != DefaultWebDir().Static {
+		t.Errorf("indexTmpl = %v, staticDir = %v", indexTmpl, staticDir)
+	}
+}
+
 // トップページ表示
 func TestHandleIndex(t *testing.T) {
 	// Setup
@@ -86,56 +121,6 @@ func TestInjectService_Default(t *testing.T) {
 	}
 }
 
-func TestProtect(t *testing.T) {
-	// Setup
-	handleOK := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		fmt.Fprintf(w, "OK")
-		return
-	}
-
-	writer := httptest.NewRecorder()
-	request, _ := http.NewRequest("GET", "/", nil)
-
-	// Exercise
-	router := httprouter.New()
-	router.GET("/", protect(true)(handleOK))
-	router.ServeHTTP(writer, request)
-
-	// Verify
-	if writer.Code != 503 {
-		t.Errorf("Response code is %v", writer.Code)
-	}
-	body := writer.Body.String()
-	if body == "OK" {
-		t.Error("body is OK.")
-	}
-}
-
-func TestProtect_NotProtect(t *testing.T) {
-	// Setup
-	handleOK := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		fmt.Fprintf(w, "OK")
-		return
-	}
-
-	writer := httptest.NewRecorder()
-	request, _ := http.NewRequest("GET", "/", nil)
-
-	// Exercise
-	router := httprouter.New()
-	router.GET("/", protect(false)(handleOK))
-	router.ServeHTTP(writer, request)
-
-	// Verify
-	if writer.Code != 200 {
-		t.Errorf("Response code is %v", writer.Code)
-	}
-	body := writer.Body.String()
-	if body != "OK" {
-		t.Errorf("body is %v", body)
-	}
-}
-
 func TestHandleTestDir(t *testing.T) {
 	// Setup
 	handleOK := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -267,3 +252,74 @@ func TestHandleUserAgent_Missing(t *testing.T) {
 		t.Errorf("body is ok")
 	}
 }
+
+func TestInstrument(t *testing.T) {
+	// Setup
+	h := instrument("GET", "/:board/test-instrument", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+	})
+
+	// メトリクスはプロセス全体で共有なので差分を見る
+	requests := metricRequests.Value("GET", "/:board/test-instrument", "404")
+	count := metricRequestSeconds.Count("GET", "/:board/test-instrument")
+
+	// Exercise
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/news4vip/test-instrument", nil)
+	h(writer, request, nil)
+
+	// Verify
+	if writer.Code != 404 {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+	if n := metricRequests.Value("GET", "/:board/test-instrument", "404") - requests; n != 1 {
+		t.Errorf("metricRequests = %v, want: 1", n)
+	}
+	if n := metricRequestSeconds.Count("GET", "/:board/test-instrument") - count; n != 1 {
+		t.Errorf("metricRequestSeconds count = %v, want: 1", n)
+	}
+}
+
+// GETとPOST以外で登録したルートも数える
+func TestInstrumentedRouter(t *testing.T) {
+	router := &instrumentedRouter{httprouter.New()}
+	router.ServeFiles("/:board/_instrument/*filepath", http.Dir("web/static"))
+	router.HandlerFunc("DELETE", "/:board/instrument", func(w http.ResponseWriter, r *http.Request) {
+		if httprouter.ParamsFromContext(r.Context()).ByName("board") != "news4vip" {
+			t.Error("no params")
+		}
+	})
+
+	tests := []struct {
+		method, path, route, code string
+	}{
+		{"GET", "/news4vip/_instrument/css/normalize.css", "/:board/_instrument/*filepath", "200"},
+		{"DELETE", "/news4vip/instrument", "/:board/instrument", "200"},
+	}
+	for _, tt := range tests {
+		before := metricRequests.Value(tt.method, tt.route, tt.code)
+		writer := httptest.NewRecorder()
+		request, _ := http.NewRequest(tt.method, tt.path, nil)
+		router.ServeHTTP(writer, request)
+		if n := metricRequests.Value(tt.method, tt.route, tt.code) - before; n != 1 {
+			t.Errorf("%v %v: metricRequests = %v, want: 1 (code %v)", tt.method, tt.path, n, writer.Code)
+		}
+	}
+}
+
+func TestNewMetricsRouter(t *testing.T) {
+	// request
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/metrics", nil)
+
+	// Exercise
+	NewMetricsRouter().ServeHTTP(writer, request)
+
+	// Verify
+	if writer.Code != 200 {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+	if body := writer.Body.String(); !strings.Contains(body, "# TYPE stub2ch_http_requests_total counter") {
+		t.Errorf("body: %v", body)
+	}
+}
@@ -0,0 +1,98 @@
+package handle
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/tempxla/stub2ch/internal/app/service"
+	"log"
+	"net/http"
+)
+
+const (
+	// App Engine のcronから呼ばれたときだけ付く (外からのリクエストでは消される)
+	cron_header = "X-Appengine-Cron"
+	// App Engine以外のcronは共有の秘密をこのヘッダで送る
+	cron_secret_header = "X-Stub2ch-Cron-Secret"
+)
+
+var (
+	// App Engine以外ではcron_headerを誰でも付けられるので信じない
+	cronOnAppEngine bool
+	// 空ならApp Engine以外でcronを受け付けない
+	cronSecret string
+)
+
+// cronからのリクエストの確かめ方を設定する。起動時に一度だけ呼ぶ。
+func ConfigureCron(onAppEngine bool, secret string) {
+	cronOnAppEngine = onAppEngine
+	cronSecret = secret
+}
+
+func isCronRequest(r *http.Request) bool {
+	if cronOnAppEngine {
+		return r.Header.Get(cron_header) == "true"
+	}
+	if cronSecret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(cron_secret_header)), []byte(cronSecret)) == 1
+}
+
+type staleThreadView struct {
+	ThreadKey    string
+	ThreadTitle  string
+	MessageCount int
+	LastModified string
+	DropAt       string
+	Reason       string
+	Due          bool
+}
+
+// cronからのリクエストでなければ403を返す
+func handleCron(sh ServiceHandle) ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		if !isCronRequest(r) {
+			log.Printf("not a cron request: %v", getIP(r))
+			http.Error(w, "Forbidden", http.StatusForbidden) // 403
+			return
+		}
+		sh(w, r, ps, sv)
+	}
+}
+
+// 保守の決まりで落ちるスレを落とす
+func handleCronHoshu() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		count, err := sv.Admin.SweepStaleThreads()
+		if err != nil {
+			log.Printf("ERROR: SweepStaleThreads. %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "%d\n", count)
+	}
+}
+
+func listStaleThreads(sv *service.BoardService, boardName string) (_ []*staleThreadView, err error) {
+	list, err := sv.Admin.PreviewStaleThreads(boardName)
+	if err != nil {
+		return
+	}
+
+	now := sv.StartedAt()
+	loc := now.Location()
+	views := make([]*staleThreadView, len(list))
+	for i, s := range list {
+		views[i] = &staleThreadView{
+			ThreadKey:    s.ThreadKey,
+			ThreadTitle:  s.ThreadTitle,
+			MessageCount: s.MessageCount,
+			LastModified: s.LastModified.In(loc).Format(audit_time_layout),
+			DropAt:       s.DropAt.In(loc).Format(audit_time_layout),
+			Reason:       s.Reason,
+			Due:          !s.DropAt.After(now),
+		}
+	}
+	return views, nil
+}
@@ -0,0 +1,87 @@
+package handle
+
+import (
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/tempxla/stub2ch/internal/app/service"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHandleCronHoshu(t *testing.T) {
+
+	now := time.Now()
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		// 立ってすぐ伸びなかった
+		{ThreadKey: strconv.FormatInt(now.Add(-2*time.Hour).Unix(), 10), MessageCount: 1, LastModified: now.Add(-2 * time.Hour)},
+	})
+	sysEnv := &service.SysEnv{StartedTime: now}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv), service.AdminConf(repo, nil))
+
+	defer ConfigureCron(false, "")
+	ConfigureCron(true, "")
+
+	router := httprouter.New()
+	router.GET("/:board/_cron/hoshu", injectService(sv)(handleCron(handleCronHoshu())))
+
+	// cronからでなければ落とさない
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/test/_cron/hoshu", nil)
+	router.ServeHTTP(writer, request)
+	if writer.Code != http.StatusForbidden {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+	if n := len(repo.Subjects("news4vip")); n != 1 {
+		t.Errorf("len(Subjects) = %v, want: 1", n)
+	}
+
+	writer = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/test/_cron/hoshu", nil)
+	request.Header.Set("X-Appengine-Cron", "true")
+	router.ServeHTTP(writer, request)
+	if writer.Code != http.StatusOK || writer.Body.String() != "1\n" {
+		t.Errorf("Response = %v, %q", writer.Code, writer.Body.String())
+	}
+	if n := len(repo.Subjects("news4vip")); n != 0 {
+		t.Errorf("len(Subjects) = %v, want: 0", n)
+	}
+}
+
+// App Engine以外ではX-Appengine-Cronを信じず、共有の秘密を確かめる
+func TestHandleCron_NotAppEngine(t *testing.T) {
+
+	handleOK := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		fmt.Fprint(w, "OK")
+	}
+	router := httprouter.New()
+	router.GET("/:board/_cron/hoshu", injectService(service.NewBoardService())(handleCron(handleOK)))
+
+	defer ConfigureCron(false, "")
+	tests := []struct {
+		secret string
+		header map[string]string
+		code   int
+	}{
+		{"", map[string]string{"X-Appengine-Cron": "true"}, http.StatusForbidden},
+		{"", map[string]string{"X-Stub2ch-Cron-Secret": ""}, http.StatusForbidden},
+		{"secret1", map[string]string{"X-Appengine-Cron": "true"}, http.StatusForbidden},
+		{"secret1", map[string]string{"X-Stub2ch-Cron-Secret": "secret2"}, http.StatusForbidden},
+		{"secret1", map[string]string{"X-Stub2ch-Cron-Secret": "secret1"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		ConfigureCron(false, tt.secret)
+		writer := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/test/_cron/hoshu", nil)
+		for k, v := range tt.header {
+			request.Header.Set(k, v)
+		}
+		router.ServeHTTP(writer, request)
+		if writer.Code != tt.code {
+			t.Errorf("%q %v: Response code is %v, want: %v", tt.secret, tt.header, writer.Code, tt.code)
+		}
+	}
+}
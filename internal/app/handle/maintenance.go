@@ -0,0 +1,152 @@
+package handle
+
+import (
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/tempxla/stub2ch/internal/app/service"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/maintenance"
+	"github.com/tempxla/stub2ch/internal/app/util"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maintenance_default_message = "メンテナンス中です。"
+	// <input type="datetime-local"> の形式
+	maintenance_time_layout = "2006-01-02T15:04"
+)
+
+type maintenanceView struct {
+	Board   string
+	Mode    string
+	Message string
+	StartAt string
+	EndAt   string
+	Active  bool
+}
+
+// closedのメンテナンス中なら読むのも止める
+func handleMaintenance(sh ServiceHandle) ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		if rejectMaintenance(w, r, ps, sv, false) {
+			return
+		}
+		sh(w, r, ps, sv)
+	}
+}
+
+// メンテナンス中ならエラーを返してtrueを返す。
+// read-onlyは書き込み (write) だけ、closedは全部止める。
+func rejectMaintenance(w http.ResponseWriter, r *http.Request, ps httprouter.Params,
+	sv *service.BoardService, write bool) bool {
+
+	m, err := sv.GetMaintenance(maintenanceBoardName(r, ps))
+	if err != nil {
+		// 確認できないときは止めない
+		log.Printf("GetMaintenance: %v", err)
+	}
+	if m == nil || (!write && m.Mode != maintenance.MODE_CLOSED) {
+		return false
+	}
+
+	message := m.Message
+	if message == "" {
+		message = maintenance_default_message
+	}
+	if write {
+		executeWriteMaintenanceTmpl(w, sv, m, message)
+		return true
+	}
+	if !m.EndAt.IsZero() {
+		if sec := int(m.EndAt.Sub(sv.StartedAt()).Seconds()); sec > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(sec))
+		}
+	}
+	setContentTypePlainSjis(w)
+	w.WriteHeader(http.StatusServiceUnavailable) // 503
+	fmt.Fprintln(w, util.UTF8toSJISString(message))
+	return true
+}
+
+// bbs.cgiとread.cgiは /test/ の下なので、板名は別のところにある
+func maintenanceBoardName(r *http.Request, ps httprouter.Params) string {
+	if boardName := ps.ByName("boardName"); boardName != "" {
+		return boardName
+	}
+	if board := ps.ByName("board"); board != "test" {
+		return board
+	}
+	// bbs.cgiはフォーム、search.cgiはクエリで板を渡す
+	return r.FormValue("bbs")
+}
+
+// 専ブラが読めるようにエラー画面と同じ形で返す
+func executeWriteMaintenanceTmpl(w http.ResponseWriter, sv *service.BoardService,
+	m *maintenance.Entity, message string) {
+
+	setContentTypeHtmlSjis(w)
+
+	view := map[string]string{
+		"Message": util.UTF8toSJISString(message),
+	}
+	if !m.EndAt.IsZero() {
+		view["EndAt"] = m.EndAt.In(sv.StartedAt().Location()).Format(audit_time_layout)
+	}
+	if err := writeMaintenanceTmpl.Execute(w, view); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func listMaintenance(sv *service.BoardService) (_ []*maintenanceView, err error) {
+	list, err := sv.Admin.ListMaintenance()
+	if err != nil {
+		return
+	}
+
+	loc := sv.StartedAt().Location()
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.In(loc).Format(maintenance_time_layout)
+	}
+
+	views := make([]*maintenanceView, len(list))
+	for i, m := range list {
+		views[i] = &maintenanceView{
+			Board:   m.BoardName,
+			Mode:    m.Mode,
+			Message: m.Message,
+			StartAt: format(m.StartAt),
+			EndAt:   format(m.EndAt),
+			Active:  m.Active,
+		}
+	}
+	return views, nil
+}
+
+func setMaintenance(r *http.Request, sv *service.BoardService) (err error) {
+	loc := sv.StartedAt().Location()
+	parse := func(name string) (time.Time, error) {
+		v := strings.TrimSpace(r.PostFormValue(name))
+		if v == "" {
+			return time.Time{}, nil
+		}
+		return time.ParseInLocation(maintenance_time_layout, v, loc)
+	}
+
+	startAt, err := parse("start")
+	if err != nil {
+		return
+	}
+	endAt, err := parse("end")
+	if err != nil {
+		return
+	}
+	return sv.Admin.SetMaintenance(r.PostFormValue("board"), r.PostFormValue("mode"),
+		strings.TrimSpace(r.PostFormValue("message")), startAt, endAt)
+}
@@ -0,0 +1,233 @@
+package handle
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/tempxla/stub2ch/internal/app/service"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/maintenance"
+	jadmin "github.com/tempxla/stub2ch/internal/app/types/json/admin"
+	"github.com/tempxla/stub2ch/internal/app/util"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newWriteDatRequest(t *testing.T, sv *service.BoardService) *http.Request {
+	request, _ := http.NewRequest("POST", "/test/bbs.cgi", nil)
+	request.Header.Add("User-Agent", "Monazilla/1.00")
+	request.PostForm = map[string][]string{
+		"submit":  []string{util.UTF8toSJISString("書き込む")},
+		"bbs":     []string{"news4vip"},
+		"key":     []string{"1234567890"},
+		"time":    []string{"1"},
+		"FROM":    []string{"xxxx"},
+		"mail":    []string{"sage"},
+		"MESSAGE": []string{util.UTF8toSJISString("書き")},
+	}
+	addConfirmCookie(t, request, sv)
+	request.Header.Add("Referer", "http://"+request.Host+"/news4vip/")
+	return request
+}
+
+func TestHandleMaintenance_ReadOnly(t *testing.T) {
+	// Setup
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{
+			ThreadKey:    "1234567890",
+			ThreadTitle:  "XXXX",
+			MessageCount: 1,
+			LastModified: time.Now().Add(-time.Hour),
+			Dat:          "1行目\n",
+		},
+	})
+	sysEnv := &service.SysEnv{StartedTime: time.Now(), ConfirmKeyId: "test", ConfirmKeys: testConfirmKeys}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv), service.AdminConf(repo, nil))
+	if err := sv.Admin.SetMaintenance("news4vip", maintenance.MODE_READ_ONLY, "移転作業中", time.Time{}, sv.StartedAt().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	router := NewBoardRouter(sv)
+
+	// Exercise: 読むのはできる
+	for _, path := range []string{"/news4vip/subject.txt", "/news4vip/dat/1234567890.dat"} {
+		writer := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", path, nil)
+		request.Header.Add("User-Agent", "Monazilla/1.00")
+		router.ServeHTTP(writer, request)
+
+		if writer.Code != 200 {
+			t.Errorf("%v: Response code is %v", path, writer.Code)
+		}
+	}
+
+	// Exercise: 書き込みはできない
+	writer := httptest.NewRecorder()
+	router.ServeHTTP(writer, newWriteDatRequest(t, sv))
+
+	// Verify
+	if writer.Code != 200 {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
+	if !strings.Contains(body, "<title>ＥＲＲＯＲ！</title>") ||
+		!strings.Contains(body, "<!-- 2ch_X:error -->") ||
+		!strings.Contains(body, "ERROR: 移転作業中") ||
+		!strings.Contains(body, "終了予定: ") {
+		t.Errorf("body: %v", body)
+	}
+	if dat := string(repo.DatMap["news4vip"]["1234567890"].Bytes); dat != "1行目\n" {
+		t.Errorf("dat: %v", dat)
+	}
+}
+
+func TestHandleMaintenance_Closed(t *testing.T) {
+	// Setup
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{
+			ThreadKey:    "1234567890",
+			ThreadTitle:  "XXXX",
+			MessageCount: 1,
+			LastModified: time.Now().Add(-time.Hour),
+			Dat:          "1行目\n",
+		},
+	})
+	sysEnv := &service.SysEnv{StartedTime: time.Now(), ConfirmKeyId: "test", ConfirmKeys: testConfirmKeys}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv), service.AdminConf(repo, nil))
+	if err := sv.Admin.SetMaintenance("", maintenance.MODE_CLOSED, "", time.Time{}, sv.StartedAt().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	router := NewBoardRouter(sv)
+
+	for _, path := range []string{
+		"/news4vip/subject.txt",
+		"/poverty/SETTING.TXT",
+		"/test/read.cgi/news4vip/1234567890/",
+	} {
+		// Exercise
+		writer := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", path, nil)
+		request.Header.Add("User-Agent", "Monazilla/1.00")
+		router.ServeHTTP(writer, request)
+
+		// Verify
+		if writer.Code != 503 {
+			t.Errorf("%v: Response code is %v", path, writer.Code)
+		}
+		if ra := writer.Header().Get("Retry-After"); ra != "3600" {
+			t.Errorf("%v: Retry-After: %v", path, ra)
+		}
+		if body := string(util.SJIStoUTF8(writer.Body.Bytes())); body != maintenance_default_message+"\n" {
+			t.Errorf("%v: body: %v", path, body)
+		}
+	}
+
+	// Exercise: 書き込み
+	writer := httptest.NewRecorder()
+	router.ServeHTTP(writer, newWriteDatRequest(t, sv))
+
+	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
+	if !strings.Contains(body, "ERROR: "+maintenance_default_message) {
+		t.Errorf("body: %v", body)
+	}
+}
+
+func TestHandleMaintenance_Scheduled(t *testing.T) {
+	// Setup
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{
+			ThreadKey:    "1234567890",
+			ThreadTitle:  "XXXX",
+			MessageCount: 1,
+			LastModified: time.Now().Add(-time.Hour),
+			Dat:          "1行目\n",
+		},
+	})
+	sysEnv := &service.SysEnv{StartedTime: time.Now(), ConfirmKeyId: "test", ConfirmKeys: testConfirmKeys}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv), service.AdminConf(repo, nil))
+	if err := sv.Admin.SetMaintenance("news4vip", maintenance.MODE_CLOSED, "", sv.StartedAt().Add(time.Hour), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Exercise
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/news4vip/subject.txt", nil)
+	request.Header.Add("User-Agent", "Monazilla/1.00")
+	NewBoardRouter(sv).ServeHTTP(writer, request)
+
+	// Verify: まだ始まっていない
+	if writer.Code != 200 {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+}
+
+func TestAdminMaintenance(t *testing.T) {
+	// Setup
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{
+			ThreadKey:    "1234567890",
+			ThreadTitle:  "XXXX",
+			MessageCount: 1,
+			LastModified: time.Now().Add(-time.Hour),
+			Dat:          "1行目\n",
+		},
+	})
+	sysEnv := &service.SysEnv{StartedTime: time.Now(), ConfirmKeyId: "test", ConfirmKeys: testConfirmKeys}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv), service.AdminConf(repo, nil))
+
+	router := httprouter.New()
+	router.POST("/:board/_admin/test/:fp1/:fp2", handleParseForm(injectService(sv)(handleAdminApi())))
+
+	post := func(path, form string) *jadmin.Result {
+		writer := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", path, strings.NewReader(form))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(writer, request)
+
+		result := &jadmin.Result{}
+		if err := json.Unmarshal(writer.Body.Bytes(), result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// Exercise: set
+	result := post("/test/_admin/test/maintenance/set", "board=news4vip&mode=closed&message=MSG&start=2020-01-18T03:00&end=2020-01-18T04:00")
+	if !result.Ok || len(result.Maintenance) != 1 {
+		t.Fatalf("result: %v", result)
+	}
+	if m := result.Maintenance[0]; m.Board != "news4vip" || m.Mode != "closed" || m.Message != "MSG" ||
+		m.StartAt != "2020-01-18T03:00" || m.EndAt != "2020-01-18T04:00" || m.Active {
+		t.Errorf("maintenance: %v", m)
+	}
+
+	// Exercise: 不正な値
+	for _, form := range []string{
+		"board=news4vip&mode=xxxx",
+		"board=news4vip&mode=closed&start=xxxx",
+		"board=news4vip&mode=closed&start=2020-01-18T04:00&end=2020-01-18T03:00",
+	} {
+		if result := post("/test/_admin/test/maintenance/set", form); result.Ok {
+			t.Errorf("%v: result: %v", form, result)
+		}
+	}
+
+	// Exercise: list
+	if result := post("/test/_admin/test/maintenance/list", ""); !result.Ok || len(result.Maintenance) != 1 {
+		t.Errorf("result: %v", result)
+	}
+
+	// Exercise: clear
+	if result := post("/test/_admin/test/maintenance/clear", "board=news4vip"); !result.Ok || len(result.Maintenance) != 0 {
+		t.Errorf("result: %v", result)
+	}
+	if len(repo.MaintMap) != 0 {
+		t.Errorf("MaintMap: %v", repo.MaintMap)
+	}
+
+	// listは記録しない
+	if n := len(repo.AuditLog); n != 5 {
+		t.Errorf("len(AuditLog) = %v, want: 5", n)
+	}
+}
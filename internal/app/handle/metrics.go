@@ -0,0 +1,114 @@
+package handle
+
+import (
+	"context"
+	"github.com/julienschmidt/httprouter"
+	"github.com/tempxla/stub2ch/internal/app/metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	metricRequests = metrics.NewCounter("stub2ch_http_requests_total",
+		"HTTP requests by route and status code.", "method", "route", "code")
+	metricRequestSeconds = metrics.NewHistogram("stub2ch_http_request_duration_seconds",
+		"HTTP request latency by route.", metrics.DefaultBuckets, "method", "route")
+	metricPosts = metrics.NewCounter("stub2ch_posts_total",
+		"Responses written by board.", "board")
+	metricThreads = metrics.NewCounter("stub2ch_threads_created_total",
+		"Threads created by board.", "board")
+)
+
+// 内部向けのポートで公開する
+func NewMetricsRouter() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+// ルートごとにリクエスト数と処理時間を数える
+type instrumentedRouter struct {
+	*httprouter.Router
+}
+
+// httprouter.Routerの登録メソッドは埋め込み先のHandleを直接呼ぶので、
+// 数え漏れがないように全部上書きしてこちらのHandleを通す
+func (router *instrumentedRouter) Handle(method, path string, h httprouter.Handle) {
+	router.Router.Handle(method, path, instrument(method, path, h))
+}
+
+func (router *instrumentedRouter) GET(path string, h httprouter.Handle) {
+	router.Handle(http.MethodGet, path, h)
+}
+
+func (router *instrumentedRouter) HEAD(path string, h httprouter.Handle) {
+	router.Handle(http.MethodHead, path, h)
+}
+
+func (router *instrumentedRouter) OPTIONS(path string, h httprouter.Handle) {
+	router.Handle(http.MethodOptions, path, h)
+}
+
+func (router *instrumentedRouter) POST(path string, h httprouter.Handle) {
+	router.Handle(http.MethodPost, path, h)
+}
+
+func (router *instrumentedRouter) PUT(path string, h httprouter.Handle) {
+	router.Handle(http.MethodPut, path, h)
+}
+
+func (router *instrumentedRouter) PATCH(path string, h httprouter.Handle) {
+	router.Handle(http.MethodPatch, path, h)
+}
+
+func (router *instrumentedRouter) DELETE(path string, h httprouter.Handle) {
+	router.Handle(http.MethodDelete, path, h)
+}
+
+func (router *instrumentedRouter) Handler(method, path string, handler http.Handler) {
+	router.Handle(method, path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if len(ps) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, ps))
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (router *instrumentedRouter) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	router.Handler(method, path, handler)
+}
+
+func (router *instrumentedRouter) ServeFiles(path string, root http.FileSystem) {
+	if !strings.HasSuffix(path, "/*filepath") {
+		panic("path must end with /*filepath in path '" + path + "'")
+	}
+	fileServer := http.FileServer(root)
+	router.GET(path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		r.URL.Path = ps.ByName("filepath")
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+func instrument(method, route string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		h(rec, r, ps)
+
+		metricRequests.Inc(method, route, strconv.Itoa(rec.status))
+		metricRequestSeconds.Observe(time.Since(start).Seconds(), method, route)
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,236 @@
+package handle
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/tempxla/stub2ch/configs/app/bbscfg"
+	"github.com/tempxla/stub2ch/internal/app/service"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/maintenance"
+	"github.com/tempxla/stub2ch/internal/app/types/errors"
+	jsearch "github.com/tempxla/stub2ch/internal/app/types/json/search"
+	"github.com/tempxla/stub2ch/internal/app/util"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	search_date_layout = "2006-01-02"
+	search_time_layout = "2006/01/02 15:04:05"
+)
+
+// 検索フォームの値 (UTF-8)
+type searchForm struct {
+	Text  string
+	Board string
+	Id    string
+	Name  string
+	Since string
+	Until string
+}
+
+// 検索結果の1件
+// datの文字列はエスケープ済みなのでtemplate.HTMLとする
+type searchResultView struct {
+	Board       string
+	ThreadKey   string
+	ResNum      int
+	ThreadTitle template.HTML
+	Name        template.HTML
+	Id          string
+	Date        string
+	Content     template.HTML
+}
+
+// 検索ページ。条件が無ければフォームだけ出す。
+func handleSearchCgi() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		form := readSearchForm(r, true)
+
+		view := &struct {
+			Text      string
+			Board     string
+			Id        string
+			Name      string
+			Since     string
+			Until     string
+			Searched  bool
+			Truncated bool // 読み切れずに打ち切った
+			Message   string
+			Results   []searchResultView
+		}{
+			Text:  util.UTF8toSJISString(form.Text),
+			Board: form.Board,
+			Id:    form.Id,
+			Name:  util.UTF8toSJISString(form.Name),
+			Since: form.Since,
+			Until: form.Until,
+		}
+
+		code := http.StatusOK
+		if form.Text != "" || form.Id != "" {
+			results, truncated, c, err := searchPosts(sv, form)
+			if err != nil {
+				code = c
+				view.Message = util.UTF8toSJISString(fmt.Sprint(err))
+			}
+			view.Searched = err == nil
+			view.Truncated = truncated
+			view.Results = newSearchResultViews(sv, results)
+		}
+
+		setContentTypeHtmlSjis(w)
+		w.WriteHeader(code)
+
+		if err := searchTmpl.Execute(w, view); err != nil {
+			log.Printf("Error executing template: %v", err)
+		}
+	}
+}
+
+// JSON版。文字コードはUTF-8
+func handleSearchJson() ServiceHandle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, sv *service.BoardService) {
+		form := readSearchForm(r, false)
+		if form.Text == "" && form.Id == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest) // 400
+			return
+		}
+
+		results, truncated, code, err := searchPosts(sv, form)
+		if err != nil {
+			http.Error(w, fmt.Sprint(err), code)
+			return
+		}
+
+		loc := sv.StartedAt().Location()
+		obj := &jsearch.Object{Results: []jsearch.Result{}, Truncated: truncated}
+		for _, res := range results {
+			obj.Results = append(obj.Results, jsearch.Result{
+				Board:       res.Board,
+				ThreadKey:   res.ThreadKey,
+				ResNum:      res.ResNum,
+				ThreadTitle: res.ThreadTitle,
+				Name:        res.Name,
+				Id:          res.Id,
+				Date:        res.Date.In(loc).Format(search_time_layout),
+				Content:     res.Content,
+			})
+		}
+		b, err := json.Marshal(obj)
+		if err != nil {
+			log.Printf("ERROR: handleSearchJson. %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(b)
+	}
+}
+
+// 検索ページのフォームはUTF-8で送らせるが、sjisFormなら _charset_ が無いときはSJISとして読む。
+// JSON版はUTF-8だけ。
+func readSearchForm(r *http.Request, sjisForm bool) *searchForm {
+	r.ParseForm()
+	get := func(name string) string {
+		return strings.TrimSpace(r.Form.Get(name))
+	}
+	sjis := sjisForm && !strings.EqualFold(get(form_charset_name), "utf-8")
+	text := get("q")
+	name := get("name")
+	if sjis {
+		text = util.SJIStoUTF8String(text)
+		name = util.SJIStoUTF8String(name)
+	}
+	return &searchForm{
+		Text:  text,
+		Board: get("bbs"),
+		Id:    get("id"),
+		Name:  name,
+		Since: get("since"),
+		Until: get("until"),
+	}
+}
+
+// 失敗したときは返すステータスコードも返す
+func searchPosts(sv *service.BoardService, form *searchForm) (_ []*service.SearchResult, truncated bool, code int, err error) {
+	if form.Board != "" && bbscfg.GetSetting(form.Board) == nil {
+		return nil, false, http.StatusNotFound, fmt.Errorf("unknown board: %v", form.Board)
+	}
+
+	q := &service.SearchQuery{
+		Board: form.Board,
+		Text:  form.Text,
+		Id:    form.Id,
+		Name:  form.Name,
+	}
+	loc := sv.StartedAt().Location()
+	if form.Since != "" {
+		if q.Since, err = time.ParseInLocation(search_date_layout, form.Since, loc); err != nil {
+			return nil, false, http.StatusBadRequest, fmt.Errorf("invalid since: %v", form.Since)
+		}
+	}
+	if form.Until != "" {
+		// その日の終わりまで含める
+		if q.Until, err = time.ParseInLocation(search_date_layout, form.Until, loc); err != nil {
+			return nil, false, http.StatusBadRequest, fmt.Errorf("invalid until: %v", form.Until)
+		}
+		q.Until = q.Until.AddDate(0, 0, 1)
+	}
+
+	results, truncated, err := sv.SearchPosts(q, service.SEARCH_RESULT_MAX)
+	if err == errors.NO_SEARCH_TERMS {
+		return nil, false, http.StatusBadRequest, err
+	}
+	if err != nil {
+		log.Printf("ERROR: SearchPosts. %v", err)
+		return nil, false, http.StatusInternalServerError, fmt.Errorf("Internal server error")
+	}
+	if form.Board == "" {
+		results = dropClosedBoards(sv, results)
+	}
+	return results, truncated, http.StatusOK, nil
+}
+
+// 板を指定しない検索では、closedのメンテナンス中の板のレスを外す
+func dropClosedBoards(sv *service.BoardService, results []*service.SearchResult) []*service.SearchResult {
+	closed := make(map[string]bool)
+	kept := results[:0]
+	for _, res := range results {
+		c, ok := closed[res.Board]
+		if !ok {
+			m, err := sv.GetMaintenance(res.Board)
+			if err != nil {
+				// 確認できないときは止めない
+				log.Printf("GetMaintenance: %v", err)
+			}
+			c = m != nil && m.Mode == maintenance.MODE_CLOSED
+			closed[res.Board] = c
+		}
+		if !c {
+			kept = append(kept, res)
+		}
+	}
+	return kept
+}
+
+func newSearchResultViews(sv *service.BoardService, results []*service.SearchResult) []searchResultView {
+	loc := sv.StartedAt().Location()
+	views := make([]searchResultView, len(results))
+	for i, res := range results {
+		views[i] = searchResultView{
+			Board:       res.Board,
+			ThreadKey:   res.ThreadKey,
+			ResNum:      res.ResNum,
+			ThreadTitle: template.HTML(util.UTF8toSJISString(res.ThreadTitle)),
+			Name:        template.HTML(util.UTF8toSJISString(res.Name)),
+			Id:          res.Id,
+			Date:        res.Date.In(loc).Format(search_time_layout),
+			Content:     template.HTML(util.UTF8toSJISString(res.Content)),
+		}
+	}
+	return views
+}
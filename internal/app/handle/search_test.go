@@ -0,0 +1,231 @@
+package handle
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/tempxla/stub2ch/internal/app/service"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/maintenance"
+	jadmin "github.com/tempxla/stub2ch/internal/app/types/json/admin"
+	jsearch "github.com/tempxla/stub2ch/internal/app/types/json/search"
+	"github.com/tempxla/stub2ch/internal/app/util"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleSearchCgi(t *testing.T) {
+	repo := testutil.InitialBoardStub("news4vip")
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	sv := service.NewBoardService(service.RepoConf(repo),
+		service.EnvConf(&service.SysEnv{StartedTime: time.Date(2020, 1, 18, 12, 0, 0, 0, jst)}))
+	threadKey, err := sv.CreateThread(testutil.NewSettingStub(), "news4vip",
+		"名無し", "", "AAA", "ＶＩＰから来ました<br>", "検索のテスト")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewBoardRouter(sv)
+
+	// フォームだけ
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/test/search.cgi", nil)
+	router.ServeHTTP(writer, request)
+	if writer.Code != http.StatusOK {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+	if body := writer.Body.String(); strings.Contains(body, "hits") {
+		t.Errorf("searched: %v", body)
+	}
+
+	// フォームから (UTF-8)
+	q := url.Values{"q": {"vip"}, "bbs": {"news4vip"}, "_charset_": {"UTF-8"}}
+	writer = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/test/search.cgi?"+q.Encode(), nil)
+	router.ServeHTTP(writer, request)
+	if writer.Code != http.StatusOK {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+	if ct := writer.Header().Get("Content-Type"); ct != "text/html; charset=Shift_JIS" {
+		t.Errorf("Content-Type = %v", ct)
+	}
+	body := string(util.SJIStoUTF8(writer.Body.Bytes()))
+	if !strings.Contains(body, "1 hits") ||
+		!strings.Contains(body, `<a href="/test/read.cgi/news4vip/`+threadKey+`/#1">検索のテスト</a>`) ||
+		!strings.Contains(body, "&lt;br&gt;") {
+		t.Errorf("result not found: %v", body)
+	}
+
+	// SJISのリンク
+	writer = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/test/search.cgi?q="+url.QueryEscape(util.UTF8toSJISString("検索")), nil)
+	router.ServeHTTP(writer, request)
+	body = string(util.SJIStoUTF8(writer.Body.Bytes()))
+	if writer.Code != http.StatusOK || !strings.Contains(body, "1 hits") || !strings.Contains(body, `value="検索"`) {
+		t.Errorf("Response = %v, %v", writer.Code, body)
+	}
+
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{"q=vip&bbs=unknown", http.StatusNotFound},
+		{"q=vip&since=2020/01/01", http.StatusBadRequest},
+		{"q=%E3%80%81&_charset_=UTF-8", http.StatusBadRequest}, // 記号だけ
+	}
+	for _, tt := range tests {
+		writer = httptest.NewRecorder()
+		request, _ = http.NewRequest("GET", "/test/search.cgi?"+tt.query, nil)
+		router.ServeHTTP(writer, request)
+		if writer.Code != tt.code {
+			t.Errorf("%v: Response code is %v, want: %v", tt.query, writer.Code, tt.code)
+		}
+	}
+}
+
+func TestHandleSearchJson(t *testing.T) {
+	repo := testutil.InitialBoardStub("news4vip")
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	sv := service.NewBoardService(service.RepoConf(repo),
+		service.EnvConf(&service.SysEnv{StartedTime: time.Date(2020, 1, 18, 12, 0, 0, 0, jst)}))
+	threadKey, err := sv.CreateThread(testutil.NewSettingStub(), "news4vip",
+		"名無し", "", "AAA", "ＶＩＰから来ました<br>", "検索のテスト")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewBoardRouter(sv)
+
+	q := url.Values{"q": {"ｖｉｐ"}, "id": {"AAA"}, "since": {"2020-01-18"}, "until": {"2020-01-18"}}
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/test/search.json?"+q.Encode(), nil)
+	router.ServeHTTP(writer, request)
+	if writer.Code != http.StatusOK {
+		t.Fatalf("Response code is %v", writer.Code)
+	}
+
+	obj := &jsearch.Object{}
+	if err := json.Unmarshal(writer.Body.Bytes(), obj); err != nil {
+		t.Fatal(err)
+	}
+	want := jsearch.Result{
+		Board:       "news4vip",
+		ThreadKey:   threadKey,
+		ResNum:      1,
+		ThreadTitle: "検索のテスト",
+		Name:        "名無し",
+		Id:          "AAA",
+		Date:        "2020/01/18 12:00:00",
+		Content:     "ＶＩＰから来ました&lt;br&gt;",
+	}
+	if len(obj.Results) != 1 || obj.Results[0] != want || obj.Truncated {
+		t.Errorf("results = %+v, truncated = %v", obj.Results, obj.Truncated)
+	}
+
+	// 日付で外れる
+	q.Set("since", "2020-01-19")
+	q.Del("until")
+	writer = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/test/search.json?"+q.Encode(), nil)
+	router.ServeHTTP(writer, request)
+	if writer.Code != http.StatusOK || strings.TrimSpace(writer.Body.String()) != `{"results":[],"truncated":false}` {
+		t.Errorf("Response = %v, %v", writer.Code, writer.Body.String())
+	}
+
+	// 条件が無い
+	writer = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/test/search.json?name=x", nil)
+	router.ServeHTTP(writer, request)
+	if writer.Code != http.StatusBadRequest {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+}
+
+func TestHandleSearchJson_Error(t *testing.T) {
+	sv := service.NewBoardService(service.RepoConf(&testutil.BrokenBoardStub{}),
+		service.EnvConf(&service.SysEnv{StartedTime: time.Now()}))
+	router := NewBoardRouter(sv)
+
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/test/search.json?q=vip", nil)
+	router.ServeHTTP(writer, request)
+	if writer.Code != http.StatusInternalServerError {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+}
+
+// closedのメンテナンス中の板は検索しない
+func TestHandleSearchJson_Maintenance(t *testing.T) {
+	repo := testutil.InitialBoardStub("news4vip", "poverty")
+	sv := service.NewBoardService(service.RepoConf(repo),
+		service.EnvConf(&service.SysEnv{StartedTime: time.Now()}), service.AdminConf(repo, nil))
+	for _, boardName := range []string{"news4vip", "poverty"} {
+		if _, err := sv.CreateThread(testutil.NewSettingStub(), boardName, "名無し", "", "AAA", "vip", "スレタイ"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sv.Admin.SetMaintenance("poverty", maintenance.MODE_CLOSED, "", time.Time{}, sv.StartedAt().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	router := NewBoardRouter(sv)
+
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/test/search.json?q=vip", nil)
+	router.ServeHTTP(writer, request)
+
+	obj := &jsearch.Object{}
+	if err := json.Unmarshal(writer.Body.Bytes(), obj); err != nil {
+		t.Fatal(err)
+	}
+	if len(obj.Results) != 1 || obj.Results[0].Board != "news4vip" {
+		t.Errorf("results = %v", obj.Results)
+	}
+
+	writer = httptest.NewRecorder()
+	request, _ = http.NewRequest("GET", "/test/search.json?q=vip&bbs=poverty", nil)
+	router.ServeHTTP(writer, request)
+	if writer.Code != http.StatusServiceUnavailable {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+}
+
+func TestAdminSearchRebuild(t *testing.T) {
+
+	// Setup
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{
+			ThreadKey:    "1234567890",
+			ThreadTitle:  "スレタイ",
+			MessageCount: 2,
+			Dat: "名前<>メール<>2020/01/18(土) 12:00:00.000 ID:X<> 本文1 <>スレタイ\n" +
+				"名前<><>2020/01/18(土) 12:01:00.000 ID:Y<> 本文2 <>\n",
+		},
+	})
+	sysEnv := &service.SysEnv{StartedTime: time.Now()}
+	sv := service.NewBoardService(service.RepoConf(repo), service.EnvConf(sysEnv), service.AdminConf(repo, nil))
+
+	router := httprouter.New()
+	router.POST("/:board/_admin/api/:fp1/:fp2", handleParseForm(injectService(sv)(handleAdminApi())))
+
+	// Exercise
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "/test/_admin/api/search/rebuild", strings.NewReader("board=news4vip"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(writer, request)
+
+	// Verify
+	result := &jadmin.Result{}
+	if err := json.Unmarshal(writer.Body.Bytes(), result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Ok || result.IndexCount == nil || *result.IndexCount != 2 {
+		t.Errorf("result = %+v", result)
+	}
+	if n := len(repo.PostMap); n != 2 {
+		t.Errorf("len(PostMap) = %v, want: 2", n)
+	}
+	if n := len(repo.AuditLog); n != 1 {
+		t.Errorf("len(AuditLog) = %v, want: 1", n)
+	}
+}
@@ -1,23 +1,36 @@
 package handle
 
 import (
+	"errors"
 	"fmt"
 	"github.com/tempxla/stub2ch/configs/app/bbscfg"
 	"github.com/tempxla/stub2ch/internal/app/util"
 	"html"
+	"log"
 	"net/http"
 	"strings"
 	"unicode/utf8"
 )
 
+// エラーの理由を画面に出すので、種類ごとに分けておく
+var (
+	errMissing    = errors.New("missing")
+	errTooMany    = errors.New("too many")
+	errBlank      = errors.New("blank")
+	errOutOfRange = errors.New("out of range")
+	errTooLong    = errors.New("too long")
+	errManyLines  = errors.New("too many lines")
+	errLongLine   = errors.New("too long line")
+)
+
 func requireOne(r *http.Request, name string) func() (string, error) {
 	return func() (str string, err error) {
 		if param, ok := r.PostForm[name]; !ok {
-			err = fmt.Errorf("missing")
+			err = errMissing
 		} else if len(param) == 0 {
-			err = fmt.Errorf("empty")
+			err = errMissing
 		} else if len(param) != 1 {
-			err = fmt.Errorf("too many")
+			err = errTooMany
 		} else {
 			str = param[0]
 		}
@@ -27,7 +40,7 @@ func requireOne(r *http.Request, name string) func() (string, error) {
 
 func notEmpty(s string) (str string, err error) {
 	if s == "" {
-		err = fmt.Errorf("0 byte")
+		err = errBlank
 	} else {
 		str = s
 	}
@@ -36,7 +49,7 @@ func notEmpty(s string) (str string, err error) {
 
 func notBlank(s string) (str string, err error) {
 	if strings.TrimSpace(s) == "" {
-		err = fmt.Errorf("blank")
+		err = errBlank
 	} else {
 		str = s
 	}
@@ -46,7 +59,7 @@ func notBlank(s string) (str string, err error) {
 func between(a, b string) func(string) (string, error) {
 	return func(s string) (str string, err error) {
 		if s < a || b < s {
-			err = fmt.Errorf("%s < %s or %s < %s", s, a, b, s)
+			err = errOutOfRange
 		} else {
 			str = s
 		}
@@ -57,7 +70,7 @@ func between(a, b string) func(string) (string, error) {
 func maxLen(max int) func(string) (string, error) {
 	return func(s string) (str string, err error) {
 		if utf8.RuneCountInString(s) > max {
-			err = fmt.Errorf(" len %s > %d", s, max)
+			err = errTooLong
 		} else {
 			str = s
 		}
@@ -68,7 +81,7 @@ func maxLen(max int) func(string) (string, error) {
 func maxByte(max int) func(string) (string, error) {
 	return func(s string) (str string, err error) {
 		if len(s) > max {
-			err = fmt.Errorf(" byte len %s > %d", s, max)
+			err = errTooLong
 		} else {
 			str = s
 		}
@@ -76,6 +89,32 @@ func maxByte(max int) func(string) (string, error) {
 	}
 }
 
+// 0なら制限しない
+func maxLines(max int) func(string) (string, error) {
+	return func(s string) (str string, err error) {
+		if max > 0 && strings.Count(s, "\n")+1 > max {
+			err = errManyLines
+		} else {
+			str = s
+		}
+		return
+	}
+}
+
+// 1行ごとのバイト数。0なら制限しない
+func maxColumns(max int) func(string) (string, error) {
+	return func(s string) (str string, err error) {
+		if max > 0 {
+			for _, line := range strings.Split(s, "\n") {
+				if len(line) > max {
+					return "", errLongLine
+				}
+			}
+		}
+		return s, nil
+	}
+}
+
 // \t, \n 以外の制御文字を削除する
 // \t, \nは後の工程でなんとかする。
 func delBadChar(s string) (string, error) {
@@ -102,17 +141,17 @@ func sjisToUtf8String(s string) (string, error) {
 	return util.SJIStoUTF8String(s), nil
 }
 
-// トリップの関係でhtml.EscapeStringはここでやる
+// トリップの関係でエスケープはここでやる
 func trip(s string) (string, error) {
 	idx := strings.IndexRune(s, '#')
 	if idx != -1 {
 		// トリップじゃい
 		trip := util.ComputeTrip(util.UTF8toSJISString(s[idx+1:]))
-		name := strings.ReplaceAll(html.EscapeString(s[:idx]), "◆", "◇")
+		name := strings.ReplaceAll(util.EscapeHTML(s[:idx]), "◆", "◇")
 		return fmt.Sprintf("%s </b>◆%s <b>", name, trip), nil
 	} else {
 		// トリップ無し
-		name := strings.ReplaceAll(html.EscapeString(s), "◆", "◇")
+		name := strings.ReplaceAll(util.EscapeHTML(s), "◆", "◇")
 		return name, nil
 	}
 }
@@ -145,7 +184,7 @@ func requireBoardName(w http.ResponseWriter, r *http.Request) (string, bool) {
 	)
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf(param_error_format, "bbs", err), http.StatusBadRequest)
+		rejectParam(w, "bbs", "板名", err)
 		return "", false
 	}
 	return boardName, true
@@ -158,7 +197,7 @@ func requireThreadKey(w http.ResponseWriter, r *http.Request) (string, bool) {
 	)
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf(param_error_format, "key", err), http.StatusBadRequest)
+		rejectParam(w, "key", "スレッドキー", err)
 		return "", false
 	}
 	return threadKey, true
@@ -170,7 +209,7 @@ func requireTime(w http.ResponseWriter, r *http.Request) (string, bool) {
 	)
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf(param_error_format, "time", err), http.StatusBadRequest)
+		rejectParam(w, "time", "フォーム情報", err)
 		return "", false
 	}
 	return t, true
@@ -186,7 +225,7 @@ func requireName(w http.ResponseWriter, r *http.Request, setting bbscfg.Setting)
 	)
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf(param_error_format, "FROM", err), http.StatusBadRequest)
+		rejectParam(w, "FROM", "名前", err)
 		return "", false
 	}
 	if name == "" {
@@ -204,23 +243,30 @@ func requireMail(w http.ResponseWriter, r *http.Request, setting bbscfg.Setting)
 	)
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf(param_error_format, "mail", err), http.StatusBadRequest)
+		rejectParam(w, "mail", "メール欄", err)
 		return "", false
 	}
 	return mail, true
 }
 
-func requireMessage(w http.ResponseWriter, r *http.Request, setting bbscfg.Setting) (string, bool) {
+// スレ立て (newThread) は行数を2倍まで許す
+func requireMessage(w http.ResponseWriter, r *http.Request, setting bbscfg.Setting, newThread bool) (string, bool) {
+	lines := setting.BBS_LINE_NUMBER()
+	if newThread {
+		lines *= 2
+	}
 	message, err := process(requireOne(r, "MESSAGE"),
 		maxByte(setting.BBS_MESSAGE_COUNT()),
+		maxColumns(setting.BBS_COLUMN_NUMBER()),
 		sjisToUtf8String,
 		delBadChar,
 		notBlank,
 		trimWhitespace,
+		maxLines(lines),
 	)
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf(param_error_format, "MESSAGE", err), http.StatusBadRequest)
+		rejectParam(w, "MESSAGE", "本文", err)
 		return "", false
 	}
 	return message, true
@@ -236,7 +282,7 @@ func requireTitle(w http.ResponseWriter, r *http.Request, setting bbscfg.Setting
 	)
 
 	if err != nil {
-		http.Error(w, fmt.Sprintf(param_error_format, "subject", err), http.StatusBadRequest)
+		rejectParam(w, "subject", "サブジェクト", err)
 		return "", false
 	}
 	return title, true
@@ -245,8 +291,33 @@ func requireTitle(w http.ResponseWriter, r *http.Request, setting bbscfg.Setting
 func requireReferer(w http.ResponseWriter, r *http.Request, boardName string) (string, bool) {
 	ref := r.Referer()
 	if !strings.Contains(ref, r.Host) || !strings.Contains(ref, boardName) {
-		http.Error(w, fmt.Sprintf(param_error_format, "referer", "BAD"), http.StatusBadRequest)
+		log.Printf(param_error_format, "referer", ref)
+		executeWriteErrorTmpl(w, http.StatusBadRequest, "ブラウザ変ですよん。")
 		return "", false
 	}
 	return ref, true
 }
+
+// パラメータがおかしいときに、専ブラが読めるエラー画面を返す
+func rejectParam(w http.ResponseWriter, param, label string, err error) {
+	log.Printf(param_error_format, param, err)
+	executeWriteErrorTmpl(w, http.StatusBadRequest, paramErrorReason(label, err))
+}
+
+// 2chと同じような言い回しで理由を返す
+func paramErrorReason(label string, err error) string {
+	switch err {
+	case errMissing, errBlank:
+		return label + "がありません！"
+	case errTooLong:
+		return label + "が長すぎます！"
+	case errOutOfRange:
+		return label + "が不正です！"
+	case errManyLines:
+		return label + "の改行が多すぎます！"
+	case errLongLine:
+		return label + "に長すぎる行があります！"
+	default:
+		return "フォーム情報が不正です！"
+	}
+}
@@ -150,6 +150,50 @@ func TestMaxByte(t *testing.T) {
 	}
 }
 
+func TestMaxLines(t *testing.T) {
+
+	tests := []struct {
+		max       int
+		arg, want string
+		err       error
+	}{
+		{2, "1\n2", "1\n2", nil},
+		{2, "1\n2\n3", "", errManyLines},
+		{0, "1\n2\n3", "1\n2\n3", nil},
+	}
+
+	for i, tt := range tests {
+		value, err := maxLines(tt.max)(tt.arg)
+		if value != tt.want || err != tt.err {
+			t.Errorf("%d: maxLines(%v)(%v) = (%v, %v), want: (%v, %v)",
+				i, tt.max, tt.arg, value, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestMaxColumns(t *testing.T) {
+
+	tests := []struct {
+		max       int
+		arg, want string
+		err       error
+	}{
+		{3, "123\n456", "123\n456", nil},
+		{3, "123\n4567", "", errLongLine},
+		{4, util.UTF8toSJISString("亜細\n亜"), util.UTF8toSJISString("亜細\n亜"), nil},
+		{3, util.UTF8toSJISString("亜細\n亜"), "", errLongLine},
+		{0, "1234", "1234", nil},
+	}
+
+	for i, tt := range tests {
+		value, err := maxColumns(tt.max)(tt.arg)
+		if value != tt.want || err != tt.err {
+			t.Errorf("%d: maxColumns(%v)(%v) = (%v, %v), want: (%v, %v)",
+				i, tt.max, tt.arg, value, err, tt.want, tt.err)
+		}
+	}
+}
+
 func TestDelBadChar(t *testing.T) {
 	rs := []rune{'a', '\n', 'b', '\t', 'c', '[', '\u0000', '\u000B', '\u001F', '\u007F', ']'}
 
@@ -435,25 +479,36 @@ func TestRequireMessage(t *testing.T) {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		s, ok := requireMessage(w, r, stng) // *** Execute ***
+		s, ok := requireMessage(w, r, stng, r.URL.Path == "/thread") // *** Execute ***
 		if !ok {
 			return
 		}
 		fmt.Fprint(w, s)
 	})
 
+	lines := strings.Repeat("本文\n", stng.BBS_LINE_NUMBER())
 	tests := []struct {
+		path  string
 		param string
 		code  int
 		body  string
 	}{
-		{util.UTF8toSJISString("\n本文\n本文\n"), 200, "本文\n本文"},
-		{strings.Repeat("s", stng.BBS_MESSAGE_COUNT()+1), 400, ""},
+		{"/", util.UTF8toSJISString("\n本文\n本文\n"), 200, "本文\n本文"},
+		{"/", strings.Repeat("s", stng.BBS_MESSAGE_COUNT()+1), 400, "ERROR: 本文が長すぎます！"},
+		{"/", " ", 400, "ERROR: 本文がありません！"},
+		// 行数
+		{"/", util.UTF8toSJISString(lines), 200, strings.TrimSpace(lines)},
+		{"/", util.UTF8toSJISString(lines + "本文"), 400, "ERROR: 本文の改行が多すぎます！"},
+		{"/thread", util.UTF8toSJISString(lines + "本文"), 200, lines + "本文"},
+		{"/thread", util.UTF8toSJISString(lines + lines + "本文"), 400, "ERROR: 本文の改行が多すぎます！"},
+		// 1行の長さ
+		{"/", strings.Repeat("s", stng.BBS_COLUMN_NUMBER()) + "\ns", 200, strings.Repeat("s", stng.BBS_COLUMN_NUMBER()) + "\ns"},
+		{"/", "s\n" + strings.Repeat("s", stng.BBS_COLUMN_NUMBER()+1), 400, "ERROR: 本文に長すぎる行があります！"},
 	}
 
 	for i, tt := range tests {
 		writer := httptest.NewRecorder()
-		request, _ := http.NewRequest("POST", "/", nil)
+		request, _ := http.NewRequest("POST", tt.path, nil)
 		request.PostForm = map[string][]string{"MESSAGE": {tt.param}}
 		mux.ServeHTTP(writer, request)
 
@@ -463,6 +518,9 @@ func TestRequireMessage(t *testing.T) {
 		if tt.code == 200 && writer.Body.String() != tt.body {
 			t.Errorf("%d: writer.Body.String() = %s, want: %s", i, writer.Body.String(), tt.body)
 		}
+		if body := util.SJIStoUTF8String(writer.Body.String()); tt.code != 200 && !strings.Contains(body, tt.body) {
+			t.Errorf("%d: writer.Body.String() = %s, want: %s", i, body, tt.body)
+		}
 	}
 }
 
@@ -483,7 +541,7 @@ func TestRequireReferer(t *testing.T) {
 		body  string
 	}{
 		{"/localhost/news4test/", 200, "/localhost/news4test/"},
-		{"", 400, ""},
+		{"", 400, "ERROR: ブラウザ変ですよん。"},
 	}
 
 	for i, tt := range tests {
@@ -498,5 +556,26 @@ func TestRequireReferer(t *testing.T) {
 		if tt.code == 200 && writer.Body.String() != tt.body {
 			t.Errorf("%d: writer.Body.String() = %s, want: %s", i, writer.Body.String(), tt.body)
 		}
+		if body := util.SJIStoUTF8String(writer.Body.String()); tt.code != 200 && !strings.Contains(body, tt.body) {
+			t.Errorf("%d: writer.Body.String() = %s, want: %s", i, body, tt.body)
+		}
+	}
+}
+
+func TestParamErrorReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errMissing, "本文がありません！"},
+		{errBlank, "本文がありません！"},
+		{errTooLong, "本文が長すぎます！"},
+		{errOutOfRange, "本文が不正です！"},
+		{errTooMany, "フォーム情報が不正です！"},
+	}
+	for _, tt := range tests {
+		if reason := paramErrorReason("本文", tt.err); reason != tt.want {
+			t.Errorf("paramErrorReason(本文, %v) = %v, want: %v", tt.err, reason, tt.want)
+		}
 	}
 }
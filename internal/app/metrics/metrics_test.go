@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_counter_total", "counter for test", "board")
+	c.Inc("news4vip")
+	c.Inc("news4vip")
+	c.Add(3, "poverty")
+
+	if v := c.Value("news4vip"); v != 2 {
+		t.Errorf(`c.Value("news4vip") = %v, want: 2`, v)
+	}
+
+	buf := &bytes.Buffer{}
+	WriteText(buf)
+	txt := buf.String()
+	for _, want := range []string{
+		"# HELP test_counter_total counter for test\n",
+		"# TYPE test_counter_total counter\n",
+		"test_counter_total{board=\"news4vip\"} 2\n",
+		"test_counter_total{board=\"poverty\"} 3\n",
+	} {
+		if !strings.Contains(txt, want) {
+			t.Errorf("%q not found in: \n%s", want, txt)
+		}
+	}
+}
+
+func TestGauge(t *testing.T) {
+	g := NewGauge("test_gauge", "gauge for test")
+	g.Set(10)
+	g.Set(7)
+
+	buf := &bytes.Buffer{}
+	WriteText(buf)
+	if txt := buf.String(); !strings.Contains(txt, "\ntest_gauge 7\n") {
+		t.Errorf("gauge not found in: \n%s", txt)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_seconds", "histogram for test", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "/")
+	h.Observe(0.5, "/")
+	h.Observe(5, "/")
+
+	if n := h.Count("/"); n != 3 {
+		t.Errorf(`h.Count("/") = %v, want: 3`, n)
+	}
+
+	buf := &bytes.Buffer{}
+	WriteText(buf)
+	txt := buf.String()
+	for _, want := range []string{
+		"# TYPE test_seconds histogram\n",
+		"test_seconds_bucket{route=\"/\",le=\"0.1\"} 1\n",
+		"test_seconds_bucket{route=\"/\",le=\"1\"} 2\n",
+		"test_seconds_bucket{route=\"/\",le=\"+Inf\"} 3\n",
+		"test_seconds_sum{route=\"/\"} 5.55\n",
+		"test_seconds_count{route=\"/\"} 3\n",
+	} {
+		if !strings.Contains(txt, want) {
+			t.Errorf("%q not found in: \n%s", want, txt)
+		}
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	if s := escapeLabelValue("a\"b\\c\nd"); s != `a\"b\\c\nd` {
+		t.Errorf("escapeLabelValue = %v", s)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	writer := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/metrics", nil)
+
+	Handler().ServeHTTP(writer, request)
+
+	if writer.Code != 200 {
+		t.Errorf("Response code is %v", writer.Code)
+	}
+	if ct := writer.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %v", ct)
+	}
+}
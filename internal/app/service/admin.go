@@ -1,6 +1,7 @@
 package service
 
 import (
+	"bytes"
 	"cloud.google.com/go/datastore"
 	"crypto/sha256"
 	"fmt"
@@ -8,29 +9,53 @@ import (
 	"github.com/tempxla/stub2ch/configs/app/admincfg"
 	"github.com/tempxla/stub2ch/internal/app/service/repository"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
-	"github.com/tempxla/stub2ch/internal/app/types/entity/memcache"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/counter"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/session"
 	"github.com/tempxla/stub2ch/internal/app/util"
 	"log"
+	"strings"
 	"time"
 )
 
 type AdminFunction struct {
 	repo repository.BoardRepository
 	mem  BoardMemcache
+	env  BoardEnvironment
 }
 
+// 一覧用のセッション情報
+type AdminSession struct {
+	Id string // セッションIDのハッシュ
+	session.Entity
+}
+
+// セッションを確認して有効期限を延長する。
+// 期限切れのセッションは削除する。
 func (admin *AdminFunction) VerifySession(sessionId string) error {
-	cache, err := admin.mem.Get(admincfg.LOGIN_COOKIE_NAME)
-	if err != nil {
+	now := admin.env.StartedAt()
+	key := admin.repo.SessionKey(hashSessionId(sessionId))
+	entity := &session.Entity{}
+
+	if err := admin.repo.GetSession(key, entity); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return fmt.Errorf("invalid session id.")
+		}
 		return err
 	}
-	if string(cache.Value) != sessionId {
-		return fmt.Errorf("invalid session id.")
+	if !now.Before(entity.ExpiresAt) {
+		if err := admin.repo.DeleteSession(key); err != nil {
+			log.Printf("VerifySession: %v", err)
+		}
+		return fmt.Errorf("session expired.")
 	}
-	return nil
+
+	entity.LastAccess = now
+	entity.ExpiresAt = sessionExpiresAt(entity.CreatedAt, now)
+	return admin.repo.PutSession(key, entity)
 }
 
-func (admin *AdminFunction) Login(passphrase, signature string) (string, error) {
+func (admin *AdminFunction) Login(passphrase, signature, ipAddr, userAgent string) (string, error) {
 
 	sha256phrase := sha256.Sum256([]byte(passphrase))
 
@@ -42,22 +67,71 @@ func (admin *AdminFunction) Login(passphrase, signature string) (string, error)
 		return "", err
 	}
 
+	now := admin.env.StartedAt()
 	sessionId := uuid.New().String()
-	item := &memcache.Item{
-		Key:        admincfg.LOGIN_COOKIE_NAME,
-		Value:      []byte(sessionId),
-		Expiration: time.Duration(30) * time.Minute, // 30分たっても削除されないよ！
+	entity := &session.Entity{
+		CreatedAt:  now,
+		LastAccess: now,
+		ExpiresAt:  sessionExpiresAt(now, now),
+		IpAddr:     ipAddr,
+		UserAgent:  userAgent,
 	}
-	err := admin.mem.Set(item)
-	if err != nil {
+	if err := admin.repo.PutSession(admin.repo.SessionKey(hashSessionId(sessionId)), entity); err != nil {
 		return "", err
 	}
 
 	return sessionId, nil
 }
 
-func (admin *AdminFunction) Logout() error {
-	return admin.mem.Delete(admincfg.LOGIN_COOKIE_NAME)
+// 自分のセッションを破棄する
+func (admin *AdminFunction) Logout(sessionId string) error {
+	return admin.repo.DeleteSession(admin.repo.SessionKey(hashSessionId(sessionId)))
+}
+
+// 一覧のIDを指定してセッションを破棄する
+func (admin *AdminFunction) RevokeSession(id string) error {
+	log.Printf("RevokeSession: %v", id)
+	key := admin.repo.SessionKey(id)
+	if err := admin.repo.GetSession(key, &session.Entity{}); err != nil {
+		return err
+	}
+	return admin.repo.DeleteSession(key)
+}
+
+// 有効なセッションをログインした順に返す
+func (admin *AdminFunction) ListSessions() ([]*AdminSession, error) {
+	now := admin.env.StartedAt()
+
+	var entities []*session.Entity
+	keys, err := admin.repo.GetAllSession(&entities)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []*AdminSession{}
+	for i, key := range keys {
+		if !now.Before(entities[i].ExpiresAt) {
+			continue
+		}
+		sessions = append(sessions, &AdminSession{
+			Id:     key.DSKey.Name,
+			Entity: *entities[i],
+		})
+	}
+	return sessions, nil
+}
+
+// セッションIDはそのまま保存しない
+func hashSessionId(sessionId string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(sessionId)))
+}
+
+func sessionExpiresAt(createdAt, now time.Time) time.Time {
+	expiresAt := now.Add(admincfg.SESSION_IDLE_TIMEOUT)
+	if limit := createdAt.Add(admincfg.SESSION_MAX_LIFETIME); expiresAt.After(limit) {
+		return limit
+	}
+	return expiresAt
 }
 
 // 空の板を作成する。
@@ -66,9 +140,7 @@ func (admin *AdminFunction) CreateBoard(boardName string) error {
 	log.Printf("CreateBoard: %v", boardName)
 
 	key := admin.repo.BoardKey(boardName)
-	newEntity := &board.Entity{
-		Subjects: []board.Subject{},
-	}
+	newEntity := &board.Entity{}
 	entity := &board.Entity{}
 
 	return admin.repo.RunInTransaction(func(tx *datastore.Transaction) error {
@@ -88,32 +160,173 @@ func (admin *AdminFunction) CreateBoard(boardName string) error {
 
 func (admin *AdminFunction) GetWriteCount() (_ int, err error) {
 
-	var entities []*board.Entity
-	_, err = admin.repo.GetAllBoard(&entities)
+	keys, err := admin.repo.GetAllBoard(&[]*board.Entity{})
 	if err != nil {
 		return
 	}
 
 	count := 0
-	for _, entity := range entities {
-		count += entity.WriteCount
+	for _, key := range keys {
+		n, err := loadWriteCount(admin.repo, key)
+		if err != nil {
+			return 0, err
+		}
+		count += n
 	}
 
 	return count, nil
 }
 
 func (admin *AdminFunction) ResetWriteCount() error {
-	return admin.repo.RunInTransaction(func(tx *datastore.Transaction) error {
-		var entities []*board.Entity
-		keys, err := admin.repo.TxGetAllBoard(tx, &entities)
+	keys, err := admin.repo.GetAllBoard(&[]*board.Entity{})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		var entities []*counter.Entity
+		counterKeys, err := admin.repo.GetWriteCounters(key, &entities)
 		if err != nil {
 			return err
 		}
+		if err := admin.repo.DeleteWriteCounters(counterKeys); err != nil {
+			return err
+		}
+		metricWriteCount.Set(0, key.DSKey.Name)
+	}
+	return nil
+}
 
-		for i, _ := range keys {
-			entities[i].WriteCount = 0
+// 1トランザクションで書くThreadの数。Datastoreの上限 (500) より少なくしておく
+const migrate_batch_max = 400
+
+// 板エンティティに持っていたスレッド一覧と書き込み数を、
+// Threadエンティティと書き込み数カウンタに移す。
+// 移したスレッドの数を返す。
+// スレッド一覧はThreadからしか読まないので、板をclosedのメンテナンスにしてから
+// 新しい版に切り替え、すぐに実行すること。手順は deployments/README.md にある。
+// Threadはmigrate_batch_maxずつ書き、最後に書き込み数を移して板エンティティを空にする。
+// 途中で失敗しても同じキーに書き直すだけなので、もう一度実行すればよい。
+func (admin *AdminFunction) MigrateThreads() (count int, err error) {
+
+	var entities []*board.Entity
+	keys, err := admin.repo.GetAllBoard(&entities)
+	if err != nil {
+		return
+	}
+
+	now := admin.env.StartedAt()
+	for i, boardKey := range keys {
+		subjects := entities[i].Subjects
+		if len(subjects) == 0 && entities[i].WriteCount == 0 {
+			continue
 		}
 
-		return admin.repo.TxPutMultiBoard(tx, keys, entities)
-	})
+		// 一覧の並び順をAgedAtで再現する
+		for from := 0; from < len(subjects); from += migrate_batch_max {
+			to := from + migrate_batch_max
+			if to > len(subjects) {
+				to = len(subjects)
+			}
+			err = admin.repo.RunInTransaction(func(tx *datastore.Transaction) error {
+				for j := from; j < to; j++ {
+					agedAt := now.Add(-time.Duration(j) * time.Millisecond)
+					threadKey := admin.repo.ThreadKey(subjects[j].ThreadKey, boardKey)
+					if err := admin.repo.TxPutThread(tx, threadKey, newThreadEntity(&subjects[j], agedAt, true)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return
+			}
+			count += to - from
+		}
+
+		err = admin.repo.RunInTransaction(func(tx *datastore.Transaction) error {
+			entity := &board.Entity{}
+			if err := admin.repo.TxGetBoard(tx, boardKey, entity); err != nil {
+				return err
+			}
+			if entity.WriteCount > 0 {
+				if err := txAddWriteCount(admin.repo, tx, boardKey, entity.WriteCount); err != nil {
+					return err
+				}
+			}
+			entity.Subjects = nil
+			entity.WriteCount = 0
+			return admin.repo.TxPutBoard(tx, boardKey, entity)
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	log.Printf("MigrateThreads: %v threads", count)
+	return count, nil
+}
+
+// 旧形式(UTF-8)のdatをShift_JISに変換する。
+// 一覧から落ちてThreadエンティティが無いdatもあるので、板の下のdatを全部見る。
+// Shift_JISに無い文字を含むdatは元に戻せなくなるので変換せずに残し、最後にエラーで知らせる。
+// 変換したdatの数を返す。
+func (admin *AdminFunction) MigrateDatSjis() (count int, err error) {
+
+	keys, err := admin.repo.GetAllBoard(&[]*board.Entity{})
+	if err != nil {
+		return
+	}
+
+	var skipped []string
+	for _, boardKey := range keys {
+		var datKeys []*dat.Key
+		datKeys, err = admin.repo.GetDatKeys(boardKey)
+		if err != nil {
+			return
+		}
+		for _, datKey := range datKeys {
+			migrated, roundTrip := false, true
+			err = admin.repo.RunInTransaction(func(tx *datastore.Transaction) error {
+				migrated, roundTrip = false, true
+				entity := &dat.Entity{}
+				if err := admin.repo.TxGetDat(tx, datKey, entity); err != nil {
+					return err
+				}
+				if entity.Sjis {
+					return nil
+				}
+				utf8Dat := entity.Bytes
+				encodeDatSjis(entity)
+				if !bytes.Equal(util.SJIStoUTF8(entity.Bytes), utf8Dat) {
+					roundTrip = false
+					return nil
+				}
+				migrated = true
+				return admin.repo.TxPutDat(tx, datKey, entity)
+			})
+			if err == datastore.ErrNoSuchEntity {
+				// datが無いなら何もしない
+				err = nil
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if !roundTrip {
+				name := boardKey.DSKey.Name + "/" + datKey.DSKey.Name
+				log.Printf("MigrateDatSjis: cannot convert: %v", name)
+				skipped = append(skipped, name)
+			}
+			if migrated {
+				count++
+			}
+		}
+	}
+
+	log.Printf("MigrateDatSjis: %v dats", count)
+	if len(skipped) > 0 {
+		return count, fmt.Errorf("%d dats are not converted: %v", len(skipped), strings.Join(skipped, ", "))
+	}
+	return count, nil
 }
@@ -1,78 +1,102 @@
 package service
 
 import (
+	"cloud.google.com/go/datastore"
+	"fmt"
 	"github.com/tempxla/stub2ch/configs/app/admincfg"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
-	"github.com/tempxla/stub2ch/internal/app/types/entity/memcache"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/session"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/thread"
+	"github.com/tempxla/stub2ch/internal/app/util"
 	"github.com/tempxla/stub2ch/tools/app/testutil"
 	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 )
 
-func TestVerifySession_notfound(t *testing.T) {
-
-	ctx, client := testutil.NewContextAndClient(t)
-	testutil.CleanDatastoreBy(t, ctx, client)
+func putTestSession(repo *testutil.BoardStub, sessionId string, createdAt, expiresAt time.Time) {
+	repo.PutSession(repo.SessionKey(hashSessionId(sessionId)), &session.Entity{
+		CreatedAt:  createdAt,
+		LastAccess: createdAt,
+		ExpiresAt:  expiresAt,
+	})
+}
 
-	mem := NewAlterMemcache(ctx, client)
+func TestVerifySession_notfound(t *testing.T) {
 
+	repo := testutil.EmptyBoardStub()
 	admin := &AdminFunction{
-		mem: mem,
+		repo: repo,
+		env:  &SysEnv{StartedTime: time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)},
 	}
 
-	mem.Delete(admincfg.LOGIN_COOKIE_NAME)
-
 	// Exercise
 	err := admin.VerifySession("x")
 
 	// Verify
 	if err == nil {
-		t.Errorf(`err is nil. want: cache miss error.`)
+		t.Errorf(`err is nil. want: invalid session id.`)
 	}
 }
 
-func TestVerifySession_unmatch(t *testing.T) {
+func TestVerifySession_expired(t *testing.T) {
 
-	ctx, client := testutil.NewContextAndClient(t)
-	testutil.CleanDatastoreBy(t, ctx, client)
-
-	mem := NewAlterMemcache(ctx, client)
+	repo := testutil.EmptyBoardStub()
+	now := time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)
 	admin := &AdminFunction{
-		mem: mem,
-	}
-
-	item := &memcache.Item{
-		Key:   admincfg.LOGIN_COOKIE_NAME,
-		Value: []byte("XXXX"),
+		repo: repo,
+		env:  &SysEnv{StartedTime: now},
 	}
-
-	mem.Set(item)
+	putTestSession(repo, "XXXX", now.Add(-time.Hour), now)
 
 	// Exercise
-	err := admin.VerifySession("x")
+	err := admin.VerifySession("XXXX")
 
 	// Verify
 	if err == nil {
-		t.Errorf(`err is nil. want: unmatch value.`)
+		t.Errorf(`err is nil. want: session expired.`)
+	}
+	if len(repo.SessionMap) != 0 {
+		t.Errorf("expired session is not deleted: %v", repo.SessionMap)
 	}
 }
 
 func TestVerifySession(t *testing.T) {
 
-	ctx, client := testutil.NewContextAndClient(t)
-	testutil.CleanDatastoreBy(t, ctx, client)
-
-	mem := NewAlterMemcache(ctx, client)
+	repo := testutil.EmptyBoardStub()
+	now := time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)
 	admin := &AdminFunction{
-		mem: mem,
+		repo: repo,
+		env:  &SysEnv{StartedTime: now},
 	}
+	putTestSession(repo, "XXXX", now.Add(-10*time.Minute), now.Add(time.Minute))
 
-	item := &memcache.Item{
-		Key:   admincfg.LOGIN_COOKIE_NAME,
-		Value: []byte("XXXX"),
+	// Exercise
+	err := admin.VerifySession("XXXX")
+
+	// Verify
+	if err != nil {
+		t.Error(err)
+	}
+	entity := repo.SessionMap[hashSessionId("XXXX")]
+	if !entity.LastAccess.Equal(now) ||
+		!entity.ExpiresAt.Equal(now.Add(admincfg.SESSION_IDLE_TIMEOUT)) {
+		t.Errorf("session is not renewed: %v", entity)
 	}
+}
 
-	mem.Set(item)
+func TestVerifySession_maxLifetime(t *testing.T) {
+
+	repo := testutil.EmptyBoardStub()
+	now := time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)
+	admin := &AdminFunction{
+		repo: repo,
+		env:  &SysEnv{StartedTime: now},
+	}
+	createdAt := now.Add(-admincfg.SESSION_MAX_LIFETIME + time.Minute)
+	putTestSession(repo, "XXXX", createdAt, now.Add(time.Minute))
 
 	// Exercise
 	err := admin.VerifySession("XXXX")
@@ -81,16 +105,19 @@ func TestVerifySession(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
+	entity := repo.SessionMap[hashSessionId("XXXX")]
+	if !entity.ExpiresAt.Equal(createdAt.Add(admincfg.SESSION_MAX_LIFETIME)) {
+		t.Errorf("ExpiresAt = %v", entity.ExpiresAt)
+	}
 }
 
 func TestLogin(t *testing.T) {
 
-	ctx, client := testutil.NewContextAndClient(t)
-	testutil.CleanDatastoreBy(t, ctx, client)
-
-	mem := NewAlterMemcache(ctx, client)
+	repo := testutil.EmptyBoardStub()
+	now := time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)
 	admin := &AdminFunction{
-		mem: mem,
+		repo: repo,
+		env:  &SysEnv{StartedTime: now},
 	}
 
 	passphrase, err := ioutil.ReadFile("/tmp/pass_stub2ch.txt")
@@ -103,24 +130,40 @@ func TestLogin(t *testing.T) {
 		t.Error(err)
 	}
 
-	sid, err := admin.Login(string(passphrase), string(base64Sig))
+	sid1, err := admin.Login(string(passphrase), string(base64Sig), "192.0.2.1", "Monazilla/1.00")
 	if err != nil {
 		t.Error(err)
 	}
 
-	if len(sid) < 32 { // 16 byte
-		t.Errorf("len(sid) < 32: weakness!! %v", sid)
+	if len(sid1) < 32 { // 16 byte
+		t.Errorf("len(sid) < 32: weakness!! %v", sid1)
+	}
+
+	// 2回目のログインで1回目のセッションは切れない
+	sid2, err := admin.Login(string(passphrase), string(base64Sig), "192.0.2.2", "Monazilla/1.00")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(repo.SessionMap) != 2 {
+		t.Errorf("len(SessionMap) = %v", len(repo.SessionMap))
+	}
+	for _, sid := range []string{sid1, sid2} {
+		if err := admin.VerifySession(sid); err != nil {
+			t.Errorf("%v: %v", sid, err)
+		}
+	}
+	entity := repo.SessionMap[hashSessionId(sid1)]
+	if !entity.CreatedAt.Equal(now) || entity.IpAddr != "192.0.2.1" {
+		t.Errorf("entity = %v", entity)
 	}
 }
 
 func TestLogin_fail(t *testing.T) {
 
-	ctx, client := testutil.NewContextAndClient(t)
-	testutil.CleanDatastoreBy(t, ctx, client)
-
-	mem := NewAlterMemcache(ctx, client)
+	repo := testutil.EmptyBoardStub()
 	admin := &AdminFunction{
-		mem: mem,
+		repo: repo,
+		env:  &SysEnv{StartedTime: time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)},
 	}
 
 	passphrase, err := ioutil.ReadFile("/tmp/pass_stub2ch.txt")
@@ -141,20 +184,20 @@ func TestLogin_fail(t *testing.T) {
 		{pass: []byte("wrong pass"), sig: base64Sig},
 	}
 	for i, tt := range tests {
-		if _, err := admin.Login(string(tt.pass), string(tt.sig)); err == nil {
+		if _, err := admin.Login(string(tt.pass), string(tt.sig), "", ""); err == nil {
 			t.Errorf("%d: admin.Login(%s, %s) = nil. want: a error. ", i, tt.pass, tt.sig)
 		}
 	}
+	if len(repo.SessionMap) != 0 {
+		t.Errorf("len(SessionMap) = %v", len(repo.SessionMap))
+	}
 }
 
-func TestLogin_MemcacheError(t *testing.T) {
-
-	ctx, client := testutil.NewContextAndClient(t)
-	testutil.CleanDatastoreBy(t, ctx, client)
+func TestLogin_DatastoreError(t *testing.T) {
 
-	mem := testutil.NewBrokenMemcache()
 	admin := &AdminFunction{
-		mem: mem,
+		repo: testutil.NewBrokenBoardStub(),
+		env:  &SysEnv{},
 	}
 
 	passphrase, err := ioutil.ReadFile("/tmp/pass_stub2ch.txt")
@@ -167,32 +210,80 @@ func TestLogin_MemcacheError(t *testing.T) {
 		t.Error(err)
 	}
 
-	_, err = admin.Login(string(passphrase), string(base64Sig))
+	_, err = admin.Login(string(passphrase), string(base64Sig), "", "")
 	if err == nil {
-		t.Error("err is nil. want: [memcache error] Set()")
+		t.Error("err is nil. want: [boardstub dummy error] PutSession()")
 	}
 }
 
 func TestLogout(t *testing.T) {
 
-	ctx, client := testutil.NewContextAndClient(t)
-	testutil.CleanDatastoreBy(t, ctx, client)
-
-	mem := NewAlterMemcache(ctx, client)
+	repo := testutil.EmptyBoardStub()
+	now := time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)
 	admin := &AdminFunction{
-		mem: mem,
+		repo: repo,
+		env:  &SysEnv{StartedTime: now},
 	}
+	putTestSession(repo, "xxx", now, now.Add(time.Minute))
+	putTestSession(repo, "yyy", now, now.Add(time.Minute))
 
-	item := &memcache.Item{
-		Key:   admincfg.LOGIN_COOKIE_NAME,
-		Value: []byte("xxx"),
+	err := admin.Logout("xxx")
+	if err != nil {
+		t.Error(err)
+	}
+	if _, ok := repo.SessionMap[hashSessionId("xxx")]; ok {
+		t.Error("session xxx is not deleted")
 	}
-	mem.Set(item)
+	if _, ok := repo.SessionMap[hashSessionId("yyy")]; !ok {
+		t.Error("session yyy is deleted")
+	}
+}
+
+func TestListSessions(t *testing.T) {
+
+	repo := testutil.EmptyBoardStub()
+	now := time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)
+	admin := &AdminFunction{
+		repo: repo,
+		env:  &SysEnv{StartedTime: now},
+	}
+	putTestSession(repo, "aaa", now.Add(-2*time.Minute), now.Add(time.Minute))
+	putTestSession(repo, "bbb", now.Add(-time.Minute), now.Add(time.Minute))
+	putTestSession(repo, "ccc", now.Add(-time.Hour), now) // 期限切れ
+
+	sessions, err := admin.ListSessions()
 
-	err := admin.Logout()
 	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 ||
+		sessions[0].Id != hashSessionId("aaa") || sessions[1].Id != hashSessionId("bbb") {
+		t.Errorf("sessions = %v", sessions)
+	}
+	if sessions[0].AuditId() != AuditSessionId("aaa") {
+		t.Errorf("AuditId() = %v", sessions[0].AuditId())
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+
+	repo := testutil.EmptyBoardStub()
+	now := time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)
+	admin := &AdminFunction{
+		repo: repo,
+		env:  &SysEnv{StartedTime: now},
+	}
+	putTestSession(repo, "aaa", now, now.Add(time.Minute))
+
+	if err := admin.RevokeSession(hashSessionId("aaa")); err != nil {
 		t.Error(err)
 	}
+	if err := admin.VerifySession("aaa"); err == nil {
+		t.Error("revoked session is valid")
+	}
+	if err := admin.RevokeSession(hashSessionId("aaa")); err == nil {
+		t.Error("err is nil. want: no such entity")
+	}
 }
 
 func TestCreateBoard(t *testing.T) {
@@ -219,12 +310,10 @@ func TestGetWriteCount(t *testing.T) {
 		repo: repo,
 	}
 
-	repo.PutBoard(repo.BoardKey("news4test1"), &board.Entity{
-		WriteCount: 7,
-	})
-	repo.PutBoard(repo.BoardKey("news4test2"), &board.Entity{
-		WriteCount: 13,
-	})
+	repo.PutBoard(repo.BoardKey("news4test1"), &board.Entity{})
+	repo.SetWriteCount("news4test1", 7)
+	repo.PutBoard(repo.BoardKey("news4test2"), &board.Entity{})
+	repo.SetWriteCount("news4test2", 13)
 
 	// Verify
 	count, err := admin.GetWriteCount()
@@ -240,12 +329,10 @@ func TestResetWriteCount(t *testing.T) {
 		repo: repo,
 	}
 
-	repo.PutBoard(repo.BoardKey("news4test1"), &board.Entity{
-		WriteCount: 7,
-	})
-	repo.PutBoard(repo.BoardKey("news4test2"), &board.Entity{
-		WriteCount: 13,
-	})
+	repo.PutBoard(repo.BoardKey("news4test1"), &board.Entity{})
+	repo.SetWriteCount("news4test1", 7)
+	repo.PutBoard(repo.BoardKey("news4test2"), &board.Entity{})
+	repo.SetWriteCount("news4test2", 13)
 
 	err := admin.ResetWriteCount()
 	if err != nil {
@@ -260,6 +347,143 @@ func TestResetWriteCount(t *testing.T) {
 	}
 }
 
+func TestMigrateThreads(t *testing.T) {
+
+	repo := testutil.EmptyBoardStub()
+	admin := &AdminFunction{
+		repo: repo,
+		env:  &SysEnv{StartedTime: time.Now()},
+	}
+
+	subjects := []board.Subject{
+		{ThreadKey: "111", ThreadTitle: "title1", MessageCount: 1},
+		{ThreadKey: "333", ThreadTitle: "title3", MessageCount: 3, Pinned: true},
+		{ThreadKey: "222", ThreadTitle: "title2", MessageCount: 2, Stopped: true},
+	}
+	repo.PutBoard(repo.BoardKey("news4test"), &board.Entity{
+		Subjects:   subjects,
+		WriteCount: 7,
+	})
+
+	count, err := admin.MigrateThreads()
+	if count != 3 || err != nil {
+		t.Errorf("admin.MigrateThreads() = %v, %v. want: 3, nil", count, err)
+	}
+	// 並び順を保つ
+	expected := &board.Entity{
+		Subjects:   []board.Subject{subjects[1], subjects[0], subjects[2]},
+		WriteCount: 7,
+	}
+	if !testutil.EqualBoardEntity(t, repo.BoardView("news4test"), expected) {
+		t.Errorf("BoardView = %v", repo.BoardView("news4test"))
+	}
+	if e := repo.BoardMap["news4test"]; len(e.Subjects) != 0 || e.WriteCount != 0 {
+		t.Errorf("board entity = %v", e)
+	}
+
+	// 2回目は何もしない
+	count, err = admin.MigrateThreads()
+	if count != 0 || err != nil {
+		t.Errorf("admin.MigrateThreads() = %v, %v. want: 0, nil", count, err)
+	}
+	if n := repo.WriteCount("news4test"); n != 7 {
+		t.Errorf("WriteCount = %v, want: 7", n)
+	}
+}
+
+// トランザクションごとのThreadの書き込み数を数える
+type txCountStub struct {
+	*testutil.BoardStub
+	puts    int
+	maxPuts int
+}
+
+func (repo *txCountStub) RunInTransaction(f func(tx *datastore.Transaction) error) error {
+	repo.puts = 0
+	err := repo.BoardStub.RunInTransaction(f)
+	if repo.puts > repo.maxPuts {
+		repo.maxPuts = repo.puts
+	}
+	return err
+}
+
+func (repo *txCountStub) TxPutThread(tx *datastore.Transaction, key *thread.Key, entity *thread.Entity) error {
+	repo.puts++
+	return repo.BoardStub.TxPutThread(tx, key, entity)
+}
+
+func TestMigrateThreads_Batch(t *testing.T) {
+
+	repo := &txCountStub{BoardStub: testutil.EmptyBoardStub()}
+	admin := &AdminFunction{
+		repo: repo,
+		env:  &SysEnv{StartedTime: time.Now()},
+	}
+
+	var subjects []board.Subject
+	for i := 0; i < 900; i++ {
+		subjects = append(subjects, board.Subject{ThreadKey: fmt.Sprintf("%010d", 2000000000-i), MessageCount: 1})
+	}
+	repo.PutBoard(repo.BoardKey("news4test"), &board.Entity{
+		Subjects:   subjects,
+		WriteCount: 7,
+	})
+
+	count, err := admin.MigrateThreads()
+	if count != 900 || err != nil {
+		t.Errorf("admin.MigrateThreads() = %v, %v. want: 900, nil", count, err)
+	}
+	if repo.maxPuts != migrate_batch_max {
+		t.Errorf("maxPuts = %v, want: %v", repo.maxPuts, migrate_batch_max)
+	}
+	// 並び順を保つ
+	view := repo.Subjects("news4test")
+	if len(view) != 900 || view[0].ThreadKey != subjects[0].ThreadKey || view[899].ThreadKey != subjects[899].ThreadKey {
+		t.Errorf("len(subjects) = %v", len(view))
+	}
+	if n := repo.WriteCount("news4test"); n != 7 {
+		t.Errorf("WriteCount = %v, want: 7", n)
+	}
+}
+
+func TestMigrateDatSjis(t *testing.T) {
+
+	repo := testutil.NewBoardStub("news4test", []testutil.ThreadStub{
+		{ThreadKey: "111", Dat: "あいうえお\n"},
+		{ThreadKey: "222", Dat: "かきくけこ\n"},
+		{ThreadKey: "333"}, // datが無い
+	})
+	delete(repo.DatMap["news4test"], "333")
+	// 一覧から落ちてThreadエンティティが無いdat
+	repo.PutDat(repo.DatKey("444", repo.BoardKey("news4test")), &dat.Entity{Bytes: []byte("さしすせそ\n")})
+	// Shift_JISに無い文字があるdat
+	repo.PutDat(repo.DatKey("555", repo.BoardKey("news4test")), &dat.Entity{Bytes: []byte("たち😀つ\nてと\n")})
+	admin := &AdminFunction{
+		repo: repo,
+	}
+
+	count, err := admin.MigrateDatSjis()
+	if count != 3 || err == nil || !strings.Contains(err.Error(), "news4test/555") {
+		t.Errorf("admin.MigrateDatSjis() = %v, %v. want: 3, news4test/555", count, err)
+	}
+	entity := repo.DatMap["news4test"]["111"]
+	if !entity.Sjis || string(entity.Bytes) != util.UTF8toSJISString("あいうえお\n") || entity.Size != 11 {
+		t.Errorf("entity = %v", entity)
+	}
+	if entity := repo.DatMap["news4test"]["444"]; !entity.Sjis {
+		t.Errorf("entity = %v", entity)
+	}
+	if entity := repo.DatMap["news4test"]["555"]; entity.Sjis || string(entity.Bytes) != "たち😀つ\nてと\n" {
+		t.Errorf("entity = %v", entity)
+	}
+
+	// 2回目は変換できないdatだけ残る
+	count, err = admin.MigrateDatSjis()
+	if count != 0 || err == nil {
+		t.Errorf("admin.MigrateDatSjis() = %v, %v. want: 0, error", count, err)
+	}
+}
+
 func Test_DatastoreError(t *testing.T) {
 
 	admin := &AdminFunction{
@@ -280,4 +504,25 @@ func Test_DatastoreError(t *testing.T) {
 	if err := admin.ResetWriteCount(); err == nil {
 		t.Error("ResetWriteCount(); err == nil, want a error")
 	}
+
+	// *** MigrateThreads ***
+	if _, err := admin.MigrateThreads(); err == nil {
+		t.Error("MigrateThreads(); err == nil, want a error")
+	}
+
+	// *** MigrateDatSjis ***
+	if _, err := admin.MigrateDatSjis(); err == nil {
+		t.Error("MigrateDatSjis(); err == nil, want a error")
+	}
+
+	// *** ListSessions ***
+	admin.env = &SysEnv{}
+	if _, err := admin.ListSessions(); err == nil {
+		t.Error("ListSessions(); err == nil, want a error")
+	}
+
+	// *** VerifySession ***
+	if err := admin.VerifySession("x"); err == nil {
+		t.Error("VerifySession(); err == nil, want a error")
+	}
 }
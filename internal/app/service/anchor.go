@@ -0,0 +1,94 @@
+package service
+
+import (
+	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"sort"
+	"strings"
+)
+
+const (
+	anchor_prefix    = ">>"
+	anchor_range_max = 10 // >>1-1000 みたいなのは先頭から10個まで
+	anchor_digit_max = 4  // 1001より大きいレス番号は無い
+)
+
+// 本文から安価 (>>N, >>N-M, >>N,M) を抜き出す。
+// 自分以降のレスへの安価は無視する。
+func parseAnchors(message string, resnum int) []int {
+	ret := []int{}
+	seen := make(map[int]bool)
+	add := func(n int) {
+		if 0 < n && n < resnum && !seen[n] {
+			seen[n] = true
+			ret = append(ret, n)
+		}
+	}
+
+	s := message
+	for {
+		idx := strings.Index(s, anchor_prefix)
+		if idx == -1 {
+			break
+		}
+		s = s[idx+len(anchor_prefix):]
+
+		// >>1,3-5,8 みたいに続くこともある
+		for {
+			n, rest, ok := readAnchorNum(s)
+			if !ok {
+				break
+			}
+			s = rest
+			if strings.HasPrefix(s, "-") {
+				if m, rest, ok := readAnchorNum(s[1:]); ok {
+					s = rest
+					for i := n; i <= m && i < n+anchor_range_max; i++ {
+						add(i)
+					}
+				} else {
+					add(n)
+				}
+			} else {
+				add(n)
+			}
+			if !strings.HasPrefix(s, ",") {
+				break
+			}
+			s = s[1:]
+		}
+	}
+
+	sort.Ints(ret)
+	return ret
+}
+
+func readAnchorNum(s string) (n int, rest string, ok bool) {
+	i := 0
+	for ; i < len(s) && '0' <= s[i] && s[i] <= '9'; i++ {
+		if i < anchor_digit_max {
+			n = n*10 + int(s[i]-'0')
+		}
+	}
+	if i == 0 || i > anchor_digit_max {
+		return 0, s[i:], false
+	}
+	return n, s[i:], true
+}
+
+// 書き込んだレスの安価を索引に追加する
+func indexAnchors(entity *dat.Entity, resnum int, message string) {
+	for _, to := range parseAnchors(message, resnum) {
+		entity.Anchors = append(entity.Anchors, dat.Anchor{From: resnum, To: to})
+	}
+}
+
+// 索引から レス番号 -> 安価先, レス番号 -> 安価元 を作る
+func makeAnchorMaps(anchors []dat.Anchor) (forward, reverse map[int][]int) {
+	forward = make(map[int][]int)
+	reverse = make(map[int][]int)
+	for _, a := range anchors {
+		forward[a.From] = append(forward[a.From], a.To)
+		reverse[a.To] = append(reverse[a.To], a.From)
+	}
+	return
+}
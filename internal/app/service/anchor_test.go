@@ -0,0 +1,56 @@
+package service
+
+import (
+	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"reflect"
+	"testing"
+)
+
+func TestParseAnchors(t *testing.T) {
+	tests := []struct {
+		message string
+		resnum  int
+		want    []int
+	}{
+		{"安価なし", 10, []int{}},
+		{">>1", 10, []int{1}},
+		{">>1 >>3\n>>2", 10, []int{1, 2, 3}},
+		{">>2-4", 10, []int{2, 3, 4}},
+		{">>2,5", 10, []int{2, 5}},
+		{">>1,3-4,6", 10, []int{1, 3, 4, 6}},
+		{">>1>>1", 10, []int{1}},
+		{">>5", 5, []int{}},
+		{">>0", 5, []int{}},
+		{">>1-100", 1000, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{">>99999", 1000, []int{}},
+		{">>3-", 10, []int{3}},
+		{">>,3", 10, []int{}},
+		{">>>>4", 10, []int{4}},
+	}
+
+	for i, tt := range tests {
+		actual := parseAnchors(tt.message, tt.resnum)
+		if !reflect.DeepEqual(actual, tt.want) {
+			t.Errorf("%d: parseAnchors(%q, %d) = %v, want: %v", i, tt.message, tt.resnum, actual, tt.want)
+		}
+	}
+}
+
+func TestIndexAnchors(t *testing.T) {
+	entity := &dat.Entity{}
+	indexAnchors(entity, 3, ">>1-2")
+	indexAnchors(entity, 4, ">>1")
+
+	want := []dat.Anchor{{From: 3, To: 1}, {From: 3, To: 2}, {From: 4, To: 1}}
+	if !reflect.DeepEqual(entity.Anchors, want) {
+		t.Errorf("entity.Anchors = %v, want: %v", entity.Anchors, want)
+	}
+
+	forward, reverse := makeAnchorMaps(entity.Anchors)
+	if !reflect.DeepEqual(forward[3], []int{1, 2}) || !reflect.DeepEqual(forward[4], []int{1}) {
+		t.Errorf("forward = %v", forward)
+	}
+	if !reflect.DeepEqual(reverse[1], []int{3, 4}) || !reflect.DeepEqual(reverse[2], []int{3}) {
+		t.Errorf("reverse = %v", reverse)
+	}
+}
@@ -0,0 +1,395 @@
+package service
+
+import (
+	"archive/zip"
+	"cloud.google.com/go/datastore"
+	"encoding/json"
+	"fmt"
+	"github.com/tempxla/stub2ch/configs/app/bbscfg"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/thread"
+	jarchive "github.com/tempxla/stub2ch/internal/app/types/json/archive"
+	"github.com/tempxla/stub2ch/internal/app/util"
+	"io"
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+const (
+	archive_version      = 1
+	archive_meta_name    = "meta.json"
+	archive_subject_name = "subject.txt"
+	archive_setting_name = "SETTING.TXT"
+	archive_head_name    = "head.txt"
+	archive_dat_dir      = "dat/"
+	archive_file_max     = 16 * 1024 * 1024 // 展開後のファイルサイズの上限
+)
+
+// 板をzipで書き出す。
+// subject.txt, dat, SETTING.TXT, head.txt はShift_JISで、
+// 取り込みに使う管理情報は meta.json に入れる。
+// dat落ちのスレもdatを入れ、meta.jsonにarchivedとして書く。
+func (admin *AdminFunction) ExportBoard(boardName string, w io.Writer) error {
+	log.Printf("ExportBoard: %v", boardName)
+
+	stng, stngEntity, err := loadSetting(admin.repo, boardName)
+	if err != nil {
+		return err
+	}
+	if stng == nil {
+		return fmt.Errorf("unknown board: %v", boardName)
+	}
+
+	boardKey := admin.repo.BoardKey(boardName)
+	subjects, err := loadSubjects(admin.repo, boardName)
+	if err != nil {
+		return err
+	}
+	var entities []*thread.Entity
+	threadKeys, err := admin.repo.GetThreads(boardKey, false, &entities)
+	if err != nil {
+		return err
+	}
+	writeCount, err := loadWriteCount(admin.repo, boardKey)
+	if err != nil {
+		return err
+	}
+
+	now := admin.env.StartedAt()
+	meta := &jarchive.Meta{
+		Version:    archive_version,
+		BoardName:  boardName,
+		ExportedAt: now,
+		WriteCount: writeCount,
+		Threads:    []jarchive.Thread{},
+		HeadTxt:    stngEntity.HeadTxt,
+	}
+	for _, v := range stngEntity.Values {
+		meta.Settings = append(meta.Settings, jarchive.Setting{Name: v.Name, Value: v.Value})
+	}
+
+	zw := zip.NewWriter(w)
+	exportThread := func(sbj *board.Subject, archived bool) error {
+		datEntity := &dat.Entity{}
+		err := admin.repo.GetDat(admin.repo.DatKey(sbj.ThreadKey, boardKey), datEntity)
+		if err == datastore.ErrNoSuchEntity {
+			// datが無いスレは書き出さない
+			log.Printf("ExportBoard: dat not found: %v", sbj.ThreadKey)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		b := datEntity.Bytes
+		if !datEntity.Sjis {
+			b = util.UTF8toSJIS(b)
+		}
+		if err := writeArchiveFile(zw, archive_dat_dir+sbj.ThreadKey+".dat", b, datEntity.LastModified); err != nil {
+			return err
+		}
+
+		th := jarchive.Thread{
+			ThreadKey:       sbj.ThreadKey,
+			ThreadTitle:     sbj.ThreadTitle,
+			MessageCount:    sbj.MessageCount,
+			LastModified:    sbj.LastModified,
+			DatLastModified: datEntity.LastModified,
+			Stopped:         sbj.Stopped,
+			Pinned:          sbj.Pinned,
+			Archived:        archived,
+		}
+		for _, a := range datEntity.Anchors {
+			th.Anchors = append(th.Anchors, jarchive.Anchor{From: a.From, To: a.To})
+		}
+		meta.Threads = append(meta.Threads, th)
+		return nil
+	}
+	// 一覧の順に書き、dat落ちのスレはその後に新しい順で書く
+	for i := range subjects {
+		if err := exportThread(&subjects[i], false); err != nil {
+			return err
+		}
+	}
+	for i, key := range threadKeys {
+		if entities[i].Live {
+			continue
+		}
+		sbj := toSubject(key.DSKey.Name, entities[i])
+		if err := exportThread(&sbj, true); err != nil {
+			return err
+		}
+	}
+
+	headTxt := []byte(stngEntity.HeadTxt)
+	if len(headTxt) == 0 {
+		headTxt = bbscfg.MakeHeadTxt(boardName)
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{archive_subject_name, util.UTF8toSJIS(makeSubjectTxt(subjects))},
+		{archive_setting_name, util.UTF8toSJIS(bbscfg.MakeSettingTxt(stng))},
+		{archive_head_name, util.UTF8toSJIS(headTxt)},
+	}
+	for _, f := range files {
+		if err := writeArchiveFile(zw, f.name, f.data, now); err != nil {
+			return err
+		}
+	}
+
+	metaJson, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeArchiveFile(zw, archive_meta_name, metaJson, now); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeArchiveFile(zw *zip.Writer, name string, data []byte, modified time.Time) error {
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+// ExportBoardで書き出したzipを板に取り込む。
+// boardNameが空ならアーカイブの板名を使う。
+// 既存の板ではアーカイブにあるスレを上書きし、それ以外のスレは残す。
+// archivedのスレと、板のSTUB_THREAD_COUNTからはみ出したスレはdat落ちとして取り込む。
+// datはまとめて読まず、スレを書き込むときに1つずつ読む。
+// 書き込み数は板を新しく作るときだけ引き継ぐ。
+// 取り込んだdatの数を返す。
+// 中身の確認は書き込む前に済ませるが、書き込みは板、スレごと、設定の順に
+// 別々のトランザクションで行うので、途中で失敗すると一部だけ取り込まれた板が残る。
+// そのときはそれまでに取り込んだ数とエラーを返す。どの書き込みもキーが決まっていて
+// 同じアーカイブをもう一度取り込めば上書きされるだけなので、やり直しで復旧する。
+func (admin *AdminFunction) ImportBoard(boardName string, r io.ReaderAt, size int64) (count int, err error) {
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	meta, err := readArchiveMeta(files)
+	if err != nil {
+		return
+	}
+	if boardName == "" {
+		boardName = meta.BoardName
+	}
+	log.Printf("ImportBoard: %v <- %v (%v threads)", boardName, meta.BoardName, len(meta.Threads))
+
+	// 書き込む前に確認する
+	if bbscfg.GetSetting(boardName) == nil {
+		return 0, fmt.Errorf("unknown board: %v", boardName)
+	}
+	values := make(map[string]string)
+	for _, v := range meta.Settings {
+		if err = bbscfg.ValidateValue(v.Name, v.Value); err != nil {
+			return
+		}
+		values[v.Name] = v.Value
+	}
+	for _, t := range meta.Threads {
+		if !isThreadKey(t.ThreadKey) {
+			return 0, fmt.Errorf("invalid thread key: %v", t.ThreadKey)
+		}
+		f, ok := files[archive_dat_dir+t.ThreadKey+".dat"]
+		if !ok {
+			return 0, fmt.Errorf("dat not found in archive: %v", t.ThreadKey)
+		}
+		// zipはヘッダのサイズより多くは展開しない
+		if f.UncompressedSize64 > archive_file_max {
+			return 0, fmt.Errorf("file too large: %v", f.Name)
+		}
+	}
+
+	threads := []*importThread{}
+	for _, t := range meta.Threads {
+		it := &importThread{
+			Subject: board.Subject{
+				ThreadKey:    t.ThreadKey,
+				ThreadTitle:  t.ThreadTitle,
+				MessageCount: t.MessageCount,
+				LastModified: t.LastModified,
+				Stopped:      t.Stopped,
+				Pinned:       t.Pinned,
+			},
+			Dat: &dat.Entity{
+				LastModified: t.DatLastModified,
+				Sjis:         true,
+			},
+			DatFile: files[archive_dat_dir+t.ThreadKey+".dat"],
+			Live:    !t.Archived,
+		}
+		for _, a := range t.Anchors {
+			it.Dat.Anchors = append(it.Dat.Anchors, dat.Anchor{From: a.From, To: a.To})
+		}
+		threads = append(threads, it)
+	}
+
+	// 取り込む設定のSTUB_THREAD_COUNTで数える
+	stng, _, err := loadSetting(admin.repo, boardName)
+	if err != nil {
+		return
+	}
+	if err = admin.limitLiveThreads(boardName, &bbscfg.Override{Base: stng, Values: values}, threads); err != nil {
+		return
+	}
+
+	if count, err = admin.importThreads(boardName, meta.WriteCount, threads); err != nil {
+		return
+	}
+
+	// 変更した設定が無ければ今の設定のまま
+	if len(values) > 0 || meta.HeadTxt != "" {
+		if err = admin.UpdateBoardSetting(boardName, values, &meta.HeadTxt); err != nil {
+			return
+		}
+	}
+
+	return count, nil
+}
+
+// 取り込むスレ
+type importThread struct {
+	Subject board.Subject
+	Dat     *dat.Entity
+	DatFile *zip.File // nilでなければ書き込むときにdatを読む
+	Live    bool      // falseならdat落ちとしてスレッド一覧に入れない
+}
+
+// datを書き込み、スレッド一覧の先頭に取り込んだスレを並べる。
+// 取り込んだスレと同じスレッドキーの既存のスレは置き換える。
+// 板が無ければwriteCountを引き継いで作る。
+// 検索の索引もスレごとに作る。
+// スレごとにトランザクションを分けるので、失敗したときはそれまでの数を返す。
+// 書き込んだスレはthreadsから外してdatを手放す。
+func (admin *AdminFunction) importThreads(boardName string, writeCount int, threads []*importThread) (count int, err error) {
+
+	jst, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		return
+	}
+	now := admin.env.StartedAt()
+	boardKey := admin.repo.BoardKey(boardName)
+	err = admin.repo.RunInTransaction(func(tx *datastore.Transaction) error {
+		err := admin.repo.TxGetBoard(tx, boardKey, &board.Entity{})
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if err := admin.repo.TxPutBoard(tx, boardKey, &board.Entity{}); err != nil {
+			return err
+		}
+		if writeCount == 0 {
+			return nil
+		}
+		return txAddWriteCount(admin.repo, tx, boardKey, writeCount)
+	})
+	if err != nil {
+		return
+	}
+
+	// スレごとに書き込む
+	for i, t := range threads {
+		datKey := admin.repo.DatKey(t.Subject.ThreadKey, boardKey)
+		threadKey := admin.repo.ThreadKey(t.Subject.ThreadKey, boardKey)
+		// 既存のスレより上に、取り込んだ順で並べる
+		agedAt := now.Add(-time.Duration(i) * time.Millisecond)
+		if t.DatFile != nil {
+			if t.Dat.Bytes, err = readArchiveFile(t.DatFile); err != nil {
+				return
+			}
+			t.Dat.Size = len(t.Dat.Bytes)
+		}
+		err = admin.repo.RunInTransaction(func(tx *datastore.Transaction) error {
+			old := &dat.Entity{}
+			err := admin.repo.TxGetDatMeta(tx, datKey, old)
+			if err != nil && err != datastore.ErrNoSuchEntity {
+				return err
+			}
+			// 余ったチャンクを消すため
+			t.Dat.ChunkCount = old.ChunkCount
+			if err := admin.repo.TxPutDat(tx, datKey, t.Dat); err != nil {
+				return err
+			}
+			return admin.repo.TxPutThread(tx, threadKey, newThreadEntity(&t.Subject, agedAt, t.Live))
+		})
+		if err != nil {
+			return
+		}
+		keys, entities := threadPosts(admin.repo, boardName, t.Subject.ThreadKey, t.Dat, jst)
+		if err = admin.repo.PutMultiPost(keys, entities); err != nil {
+			return
+		}
+		// 書いたdatは持っておかない
+		threads[i] = nil
+		count++
+	}
+	return
+}
+
+// スレッドキーは10桁の数字
+func isThreadKey(s string) bool {
+	if len(s) != 10 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func readArchiveMeta(files map[string]*zip.File) (*jarchive.Meta, error) {
+	f, ok := files[archive_meta_name]
+	if !ok {
+		return nil, fmt.Errorf("%v not found in archive", archive_meta_name)
+	}
+	b, err := readArchiveFile(f)
+	if err != nil {
+		return nil, err
+	}
+	meta := &jarchive.Meta{}
+	if err := json.Unmarshal(b, meta); err != nil {
+		return nil, fmt.Errorf("%v: %v", archive_meta_name, err)
+	}
+	if meta.Version != archive_version {
+		return nil, fmt.Errorf("unsupported archive version: %v", meta.Version)
+	}
+	return meta, nil
+}
+
+func readArchiveFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(rc, archive_file_max+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > archive_file_max {
+		return nil, fmt.Errorf("file too large: %v", f.Name)
+	}
+	return b, nil
+}
@@ -0,0 +1,316 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/setting"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/thread"
+	"github.com/tempxla/stub2ch/internal/app/util"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"testing"
+	"time"
+)
+
+// エクスポート元の板を作る
+// 書き出すスレを用意する。停止・固定したスレと板の設定を含む
+func putArchiveTestThreads(t *testing.T, sv *BoardService, env *SysEnv) {
+	stng := testutil.NewSettingStub()
+
+	var keys []string
+	for _, title := range []string{"title1", "title2"} {
+		env.StartedTime = env.StartedTime.Add(time.Second)
+		key, err := sv.CreateThread(stng, "news4vip", "name", "", "ABCDEFGH01", "message", title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if _, err := sv.WriteDat(stng, "news4vip", keys[0], "名前", "", "ABCDEFGH02", ">>1 本文"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sv.Admin.StopThread("news4vip", keys[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := sv.Admin.PinThread("news4vip", keys[1], true); err != nil {
+		t.Fatal(err)
+	}
+	headTxt := "ローカルルール"
+	if err := sv.Admin.UpdateBoardSetting("news4vip", map[string]string{"BBS_TITLE": "TEST"}, &headTxt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportImportBoard(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4vip")
+	sysEnv := &SysEnv{StartedTime: testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")}
+	sv := NewBoardService(RepoConf(repo), EnvConf(sysEnv), AdminConf(repo, nil))
+	putArchiveTestThreads(t, sv, sysEnv)
+
+	buf := &bytes.Buffer{}
+	if err := sv.Admin.ExportBoard("news4vip", buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// 新しい環境に取り込む
+	repo2 := testutil.EmptyBoardStub()
+	sv2 := NewBoardService(RepoConf(repo2), EnvConf(&SysEnv{StartedTime: time.Now()}), AdminConf(repo2, nil))
+	count, err := sv2.Admin.ImportBoard("", bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("count = %v, want: 2", count)
+	}
+
+	testutil.EqualBoardEntity(t, repo.BoardMap["news4vip"], repo2.BoardMap["news4vip"])
+	for key, want := range repo.DatMap["news4vip"] {
+		got, ok := repo2.DatMap["news4vip"][key]
+		if !ok {
+			t.Errorf("dat not found: %v", key)
+			continue
+		}
+		testutil.EqualDatEntity(t, want, got)
+	}
+	if len(repo.DatMap["news4vip"][repo.Subjects("news4vip")[1].ThreadKey].Anchors) == 0 {
+		t.Error("anchors are not exported")
+	}
+
+	// 設定
+	stng, err := sv2.GetSetting("news4vip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stng.BBS_TITLE() != "TEST" {
+		t.Errorf("BBS_TITLE() = %v", stng.BBS_TITLE())
+	}
+	headTxt, err := sv2.MakeHeadTxt("news4vip")
+	if err != nil || string(headTxt) != "ローカルルール" {
+		t.Errorf("MakeHeadTxt() = %v, %v", string(headTxt), err)
+	}
+
+	// もう一度取り込んでも同じ
+	if _, err := sv2.Admin.ImportBoard("news4vip", bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		t.Fatal(err)
+	}
+	testutil.EqualBoardEntity(t, repo.BoardMap["news4vip"], repo2.BoardMap["news4vip"])
+}
+
+func TestExportBoard_Files(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4vip")
+	sysEnv := &SysEnv{StartedTime: testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")}
+	sv := NewBoardService(RepoConf(repo), EnvConf(sysEnv), AdminConf(repo, nil))
+	putArchiveTestThreads(t, sv, sysEnv)
+
+	buf := &bytes.Buffer{}
+	if err := sv.Admin.ExportBoard("news4vip", buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	subjectTxt, _ := sv.MakeSubjectTxt("news4vip")
+	tests := []struct {
+		name string
+		want []byte
+	}{
+		{"subject.txt", util.UTF8toSJIS(subjectTxt)},
+		{"head.txt", util.UTF8toSJIS([]byte("ローカルルール"))},
+	}
+	for _, sbj := range repo.Subjects("news4vip") {
+		tests = append(tests, struct {
+			name string
+			want []byte
+		}{"dat/" + sbj.ThreadKey + ".dat", repo.DatMap["news4vip"][sbj.ThreadKey].Bytes})
+	}
+	for _, tt := range tests {
+		f, ok := files[tt.name]
+		if !ok {
+			t.Errorf("%v not found", tt.name)
+			continue
+		}
+		b, err := readArchiveFile(f)
+		if err != nil || !bytes.Equal(b, tt.want) {
+			t.Errorf("%v = %v, %v", tt.name, string(util.SJIStoUTF8(b)), err)
+		}
+	}
+	for _, name := range []string{"SETTING.TXT", "meta.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("%v not found", name)
+		}
+	}
+}
+
+func TestImportBoard_Merge(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4vip")
+	sysEnv := &SysEnv{StartedTime: testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")}
+	sv := NewBoardService(RepoConf(repo), EnvConf(sysEnv), AdminConf(repo, nil))
+	putArchiveTestThreads(t, sv, sysEnv)
+	buf := &bytes.Buffer{}
+	if err := sv.Admin.ExportBoard("news4vip", buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// 既存のスレは残り、書き込み数は変わらない
+	repo2 := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{ThreadKey: "1111111111", ThreadTitle: "既存", MessageCount: 1, Dat: "a<>b<>c<>d<>既存\n"},
+	})
+	repo2.SetWriteCount("news4vip", 10)
+	repo2.PutBoardSetting(repo2.SettingKey("news4vip"), &setting.Entity{
+		Values: []setting.Value{{Name: "STUB_THREAD_COUNT", Value: "3"}},
+	})
+	sv2 := NewBoardService(RepoConf(repo2), EnvConf(&SysEnv{StartedTime: time.Now()}), AdminConf(repo2, nil))
+	if _, err := sv2.Admin.ImportBoard("news4vip", bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		t.Fatal(err)
+	}
+
+	entity := repo2.BoardView("news4vip")
+	if len(entity.Subjects) != 3 || entity.Subjects[2].ThreadKey != "1111111111" {
+		t.Errorf("Subjects = %v", entity.Subjects)
+	}
+	if entity.WriteCount != 10 {
+		t.Errorf("WriteCount = %v, want: 10", entity.WriteCount)
+	}
+	stng, _ := sv2.GetSetting("news4vip")
+	if stng.BBS_TITLE() != "TEST" || stng.STUB_THREAD_COUNT() != 3 {
+		t.Errorf("setting = %v, %v", stng.BBS_TITLE(), stng.STUB_THREAD_COUNT())
+	}
+}
+
+func TestExportImportBoard_Archived(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4vip")
+	sysEnv := &SysEnv{StartedTime: testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")}
+	sv := NewBoardService(RepoConf(repo), EnvConf(sysEnv), AdminConf(repo, nil))
+	putArchiveTestThreads(t, sv, sysEnv)
+	// 固定していないスレをdat落ちにする
+	subjects := repo.Subjects("news4vip")
+	live, archived := subjects[0].ThreadKey, subjects[1].ThreadKey
+	repo.ThreadMap["news4vip"][archived].Live = false
+
+	buf := &bytes.Buffer{}
+	if err := sv.Admin.ExportBoard("news4vip", buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// dat落ちのスレはdat落ちのまま戻る
+	repo2 := testutil.EmptyBoardStub()
+	sv2 := NewBoardService(RepoConf(repo2), EnvConf(&SysEnv{StartedTime: time.Now()}), AdminConf(repo2, nil))
+	count, err := sv2.Admin.ImportBoard("", bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("count = %v, want: 2", count)
+	}
+	if th := repo2.ThreadMap["news4vip"][archived]; th == nil || th.Live {
+		t.Errorf("archived thread = %v", th)
+	}
+	testutil.EqualDatEntity(t, repo.DatMap["news4vip"][archived], repo2.DatMap["news4vip"][archived])
+	if sbj := repo2.Subjects("news4vip"); len(sbj) != 1 || sbj[0].ThreadKey != live {
+		t.Errorf("Subjects = %v", sbj)
+	}
+
+	// STUB_THREAD_COUNTからはみ出したスレもdat落ちになる
+	repo3 := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{ThreadKey: "1111111111", ThreadTitle: "既存", MessageCount: 1, Dat: "a<>b<>c<>d<>既存\n"},
+	})
+	repo3.PutBoardSetting(repo3.SettingKey("news4vip"), &setting.Entity{
+		Values: []setting.Value{{Name: "STUB_THREAD_COUNT", Value: "1"}},
+	})
+	sv3 := NewBoardService(RepoConf(repo3), EnvConf(&SysEnv{StartedTime: time.Now()}), AdminConf(repo3, nil))
+	if _, err := sv3.Admin.ImportBoard("news4vip", bytes.NewReader(buf.Bytes()), int64(buf.Len())); err != nil {
+		t.Fatal(err)
+	}
+	if sbj := repo3.Subjects("news4vip"); len(sbj) != 1 || sbj[0].ThreadKey != "1111111111" {
+		t.Errorf("Subjects = %v", sbj)
+	}
+	if th := repo3.ThreadMap["news4vip"][live]; th == nil || th.Live {
+		t.Errorf("live thread = %v", th)
+	}
+}
+
+func TestImportBoard_Invalid(t *testing.T) {
+
+	writeZip := func(files map[string]string) []byte {
+		buf := &bytes.Buffer{}
+		zw := zip.NewWriter(buf)
+		for name, data := range files {
+			writeArchiveFile(zw, name, []byte(data), time.Now())
+		}
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name      string
+		boardName string
+		archive   []byte
+	}{
+		{"not zip", "", []byte("not zip")},
+		{"no meta", "", writeZip(map[string]string{"subject.txt": ""})},
+		{"broken meta", "", writeZip(map[string]string{"meta.json": "{"})},
+		{"version", "", writeZip(map[string]string{"meta.json": `{"version":2,"board_name":"news4vip"}`})},
+		{"unknown board", "xxxx", writeZip(map[string]string{"meta.json": `{"version":1,"board_name":"news4vip"}`})},
+		{"no dat", "", writeZip(map[string]string{
+			"meta.json": `{"version":1,"board_name":"news4vip","threads":[{"thread_key":"1234567890"}]}`})},
+		{"thread key", "", writeZip(map[string]string{
+			"meta.json":    `{"version":1,"board_name":"news4vip","threads":[{"thread_key":"../x"}]}`,
+			"dat/../x.dat": "",
+		})},
+		{"too large", "", func() []byte {
+			buf := &bytes.Buffer{}
+			zw := zip.NewWriter(buf)
+			writeArchiveFile(zw, "meta.json", []byte(`{"version":1,"board_name":"news4vip","threads":[{"thread_key":"1234567890"}]}`), time.Now())
+			writeArchiveFile(zw, "dat/1234567890.dat", make([]byte, archive_file_max+1), time.Now())
+			zw.Close()
+			return buf.Bytes()
+		}()},
+		{"setting", "", writeZip(map[string]string{
+			"meta.json": `{"version":1,"board_name":"news4vip","settings":[{"name":"STUB_THREAD_COUNT","value":"x"}]}`})},
+	}
+	for _, tt := range tests {
+		repo := testutil.EmptyBoardStub()
+		sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Now()}), AdminConf(repo, nil))
+		if _, err := sv.Admin.ImportBoard(tt.boardName, bytes.NewReader(tt.archive), int64(len(tt.archive))); err == nil {
+			t.Errorf("%v: err is nil", tt.name)
+		}
+		// 何も書き込まない
+		if len(repo.BoardMap) != 0 {
+			t.Errorf("%v: BoardMap = %v", tt.name, repo.BoardMap)
+		}
+	}
+}
+
+func TestExportBoard_Error(t *testing.T) {
+
+	repo := testutil.EmptyBoardStub()
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Now()}), AdminConf(repo, nil))
+
+	// 存在しない板
+	if err := sv.Admin.ExportBoard("xxxx", &bytes.Buffer{}); err == nil {
+		t.Error("err is nil")
+	}
+	// 作られていない板
+	if err := sv.Admin.ExportBoard("news4vip", &bytes.Buffer{}); err == nil {
+		t.Error("err is nil")
+	}
+
+	// datが無いスレは飛ばす
+	repo.BoardMap["news4vip"] = &board.Entity{}
+	repo.TxPutThread(nil, repo.ThreadKey("1234567890", repo.BoardKey("news4vip")), &thread.Entity{Live: true})
+	repo.DatMap["news4vip"] = map[string]*dat.Entity{}
+	if err := sv.Admin.ExportBoard("news4vip", &bytes.Buffer{}); err != nil {
+		t.Error(err)
+	}
+}
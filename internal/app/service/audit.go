@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/audit"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	audit_session_id_length = 16   // セッションIDはハッシュの先頭だけ記録する
+	audit_scan_max          = 1000 // 絞り込み前に読む件数の上限
+	audit_limit_default     = 100
+)
+
+// 監査ログの絞り込み条件。空の項目は条件にしない。
+type AuditFilter struct {
+	Action    string // 前方一致
+	SessionId string // 前方一致
+	IpAddr    string // 前方一致
+	Outcome   string // success or failure
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+// 管理者の操作を記録する。
+// actErrが nil なら成功、そうでなければ失敗として記録する。
+func (admin *AdminFunction) WriteAuditLog(entity *audit.Entity, actErr error) error {
+	if actErr == nil {
+		entity.Outcome = audit.OUTCOME_SUCCESS
+	} else {
+		entity.Outcome = audit.OUTCOME_FAILURE
+		entity.Message = fmt.Sprint(actErr)
+	}
+	if err := admin.repo.PutAuditLog(entity); err != nil {
+		log.Printf("WriteAuditLog: %v, %v", entity, err)
+		return err
+	}
+	return nil
+}
+
+// 監査ログを新しい順に取得する。
+// 期間はデータストアで、それ以外はメモリ上で絞り込む。
+func (admin *AdminFunction) GetAuditLogs(filter *AuditFilter) ([]*audit.Entity, error) {
+
+	limit := filter.Limit
+	if limit <= 0 || limit > audit_scan_max {
+		limit = audit_limit_default
+	}
+
+	var entities []*audit.Entity
+	if err := admin.repo.GetAuditLogs(filter.Since, filter.Until, audit_scan_max, &entities); err != nil {
+		return nil, err
+	}
+
+	logs := []*audit.Entity{}
+	for _, e := range entities {
+		if !strings.HasPrefix(e.Action, filter.Action) ||
+			!strings.HasPrefix(e.SessionId, filter.SessionId) ||
+			!strings.HasPrefix(e.IpAddr, filter.IpAddr) ||
+			(filter.Outcome != "" && e.Outcome != filter.Outcome) {
+			continue
+		}
+		logs = append(logs, e)
+		if len(logs) == limit {
+			break
+		}
+	}
+	return logs, nil
+}
+
+// 監査ログに残すセッションID。
+// ログからセッションを乗っ取れないようにハッシュにする。
+// セッション一覧のIDの先頭と一致する。
+func AuditSessionId(sessionId string) string {
+	if sessionId == "" {
+		return ""
+	}
+	return hashSessionId(sessionId)[:audit_session_id_length]
+}
+
+// 監査ログに記録されるセッションID
+func (s *AdminSession) AuditId() string {
+	return s.Id[:audit_session_id_length]
+}
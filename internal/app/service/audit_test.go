@@ -0,0 +1,99 @@
+package service
+
+import (
+	"fmt"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/audit"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"testing"
+	"time"
+)
+
+func TestWriteAuditLog(t *testing.T) {
+
+	repo := testutil.EmptyBoardStub()
+	admin := &AdminFunction{repo: repo}
+
+	now := time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)
+
+	// Exercise
+	err1 := admin.WriteAuditLog(&audit.Entity{Time: now, Action: "login"}, nil)
+	err2 := admin.WriteAuditLog(&audit.Entity{Time: now, Action: "logout"}, fmt.Errorf("dummy"))
+
+	// Verify
+	if err1 != nil || err2 != nil {
+		t.Fatalf("err = %v, %v", err1, err2)
+	}
+	if len(repo.AuditLog) != 2 {
+		t.Fatalf("len = %v", len(repo.AuditLog))
+	}
+	if e := repo.AuditLog[0]; e.Outcome != audit.OUTCOME_SUCCESS || e.Message != "" {
+		t.Errorf("%v", e)
+	}
+	if e := repo.AuditLog[1]; e.Outcome != audit.OUTCOME_FAILURE || e.Message != "dummy" {
+		t.Errorf("%v", e)
+	}
+}
+
+func TestGetAuditLogs(t *testing.T) {
+
+	repo := testutil.EmptyBoardStub()
+	admin := &AdminFunction{repo: repo}
+
+	base := time.Date(2019, 11, 23, 0, 0, 0, 0, time.UTC)
+	repo.AuditLog = []*audit.Entity{
+		{Time: base.Add(1 * time.Hour), Action: "login", SessionId: "aaaa", IpAddr: "1.1.1.1", Outcome: audit.OUTCOME_SUCCESS},
+		{Time: base.Add(2 * time.Hour), Action: "create-board/news4vip", SessionId: "aaaa", IpAddr: "1.1.1.1", Outcome: audit.OUTCOME_FAILURE},
+		{Time: base.Add(3 * time.Hour), Action: "write-limit/reset", SessionId: "bbbb", IpAddr: "2.2.2.2", Outcome: audit.OUTCOME_SUCCESS},
+		{Time: base.Add(25 * time.Hour), Action: "logout", SessionId: "bbbb", IpAddr: "2.2.2.2", Outcome: audit.OUTCOME_SUCCESS},
+	}
+
+	tests := []struct {
+		filter *AuditFilter
+		want   []string
+	}{
+		{&AuditFilter{}, []string{"logout", "write-limit/reset", "create-board/news4vip", "login"}},
+		{&AuditFilter{Action: "create-board"}, []string{"create-board/news4vip"}},
+		{&AuditFilter{SessionId: "aa"}, []string{"create-board/news4vip", "login"}},
+		{&AuditFilter{IpAddr: "2.2.2.2"}, []string{"logout", "write-limit/reset"}},
+		{&AuditFilter{Outcome: audit.OUTCOME_FAILURE}, []string{"create-board/news4vip"}},
+		{&AuditFilter{Since: base.Add(2 * time.Hour), Until: base.AddDate(0, 0, 1)}, []string{"write-limit/reset", "create-board/news4vip"}},
+		{&AuditFilter{Limit: 1}, []string{"logout"}},
+	}
+
+	for i, tt := range tests {
+		// Exercise
+		logs, err := admin.GetAuditLogs(tt.filter)
+
+		// Verify
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		actions := []string{}
+		for _, e := range logs {
+			actions = append(actions, e.Action)
+		}
+		if fmt.Sprint(actions) != fmt.Sprint(tt.want) {
+			t.Errorf("%d: got %v, want %v", i, actions, tt.want)
+		}
+	}
+}
+
+func TestGetAuditLogs_DatastoreError(t *testing.T) {
+	admin := &AdminFunction{repo: testutil.NewBrokenBoardStub()}
+	if _, err := admin.GetAuditLogs(&AuditFilter{}); err == nil {
+		t.Errorf("err is nil")
+	}
+}
+
+func TestAuditSessionId(t *testing.T) {
+	if id := AuditSessionId(""); id != "" {
+		t.Errorf("id = %v", id)
+	}
+	id := AuditSessionId("0f8fad5b-d9cb-469f-a165-70867728950e")
+	if len(id) != audit_session_id_length {
+		t.Errorf("id = %v", id)
+	}
+	if id == AuditSessionId("7c9e6679-7425-40de-944b-e07fc1f90ae7") {
+		t.Errorf("same id: %v", id)
+	}
+}
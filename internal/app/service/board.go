@@ -0,0 +1,76 @@
+package service
+
+import (
+	"bytes"
+	"cloud.google.com/go/datastore"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	jdat "github.com/tempxla/stub2ch/internal/app/types/json/dat"
+	"log"
+	"time"
+)
+
+// 板トップに出すもの
+type BoardTop struct {
+	Subjects     []board.Subject   // 全スレ (subject.txtと同じ並び)
+	Threads      []*BoardTopThread // 本文まで出すスレ
+	LastModified time.Time         // 一番新しいスレの更新時刻
+}
+
+// 板トップに本文まで出すスレ
+type BoardTopThread struct {
+	board.Subject
+	Num      int            // 一覧での番号 (1から)。datが無いスレは飛ばすので並びとずれる
+	Messages []jdat.Message // >>1と最新recentLimit件
+}
+
+// 板トップを組み立てる。
+// 一覧の上からthreadLimit件は>>1と最新recentLimit件のレスも読む。
+// subback.htmlのように一覧だけならthreadLimitを0にする。
+func (sv *BoardService) MakeBoardTop(boardName string, threadLimit, recentLimit int) (_ *BoardTop, err error) {
+
+	subjects, err := loadSubjects(sv.repo, boardName)
+	if err != nil {
+		return
+	}
+
+	top := &BoardTop{
+		Subjects: subjects,
+		Threads:  []*BoardTopThread{},
+	}
+	for _, sbj := range subjects {
+		if sbj.LastModified.After(top.LastModified) {
+			top.LastModified = sbj.LastModified
+		}
+	}
+
+	boardKey := sv.repo.BoardKey(boardName)
+	for i := 0; i < threadLimit && i < len(subjects); i++ {
+		datEntity := &dat.Entity{}
+		err = sv.repo.GetDat(sv.repo.DatKey(subjects[i].ThreadKey, boardKey), datEntity)
+		if err == datastore.ErrNoSuchEntity {
+			// datが無いスレはリンクだけ出す
+			log.Printf("MakeBoardTop: dat not found: %v", subjects[i].ThreadKey)
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		datObj, err := sv.parseDat(datEntity, 1, bytes.Count(datEntity.Bytes, []byte{'\n'}))
+		if err != nil {
+			return nil, err
+		}
+		msgs := datObj.Messages
+		if len(msgs) > recentLimit+1 {
+			msgs = append(msgs[:1], msgs[len(msgs)-recentLimit:]...)
+		}
+		top.Threads = append(top.Threads, &BoardTopThread{
+			Subject:  subjects[i],
+			Num:      i + 1,
+			Messages: msgs,
+		})
+	}
+
+	return top, nil
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"testing"
+	"time"
+)
+
+func TestMakeBoardTop(t *testing.T) {
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	newest := time.Date(2020, 1, 18, 12, 5, 0, 0, jst)
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{
+			ThreadKey:    "1579316400",
+			ThreadTitle:  "スレ1",
+			MessageCount: 5,
+			LastModified: time.Date(2020, 1, 18, 12, 4, 0, 0, jst),
+			Dat: "名無し<><>2020/01/18(土) 12:00:00.000 ID:a<> 本文1 <>スレ1\n" +
+				"名無し<><>2020/01/18(土) 12:01:00.000 ID:b<> 本文2 <>\n" +
+				"名無し<><>2020/01/18(土) 12:02:00.000 ID:c<> 本文3 <>\n" +
+				"名無し<><>2020/01/18(土) 12:03:00.000 ID:d<> 本文4 <>\n" +
+				"名無し<><>2020/01/18(土) 12:04:00.000 ID:e<> 本文5 <>\n",
+		},
+		{ThreadKey: "1579316401", ThreadTitle: "datが無い", MessageCount: 1, LastModified: newest},
+		{
+			ThreadKey:    "1579316402",
+			ThreadTitle:  "スレ3",
+			MessageCount: 1,
+			Dat:          "名無し<><>2020/01/18(土) 12:00:00.000 ID:a<> 本文1 <>スレ3\n",
+		},
+	})
+	delete(repo.DatMap["news4vip"], "1579316401")
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Now()}))
+
+	top, err := sv.MakeBoardTop("news4vip", 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(top.Subjects) != 3 {
+		t.Errorf("subjects = %+v", top.Subjects)
+	}
+	if !top.LastModified.Equal(newest) {
+		t.Errorf("LastModified = %v, want: %v", top.LastModified, newest)
+	}
+	// datが無いスレは飛ばし、3番目は読まない
+	if len(top.Threads) != 1 {
+		t.Fatalf("threads = %+v", top.Threads)
+	}
+	th := top.Threads[0]
+	if th.ThreadKey != "1579316400" || len(th.Messages) != 3 {
+		t.Fatalf("thread = %+v", th)
+	}
+	for i, num := range []int{1, 4, 5} {
+		if th.Messages[i].Num != num {
+			t.Errorf("Messages[%d].Num = %v, want: %v", i, th.Messages[i].Num, num)
+		}
+	}
+	if th.Messages[2].Content != "本文5" {
+		t.Errorf("Content = %v", th.Messages[2].Content)
+	}
+
+	// 飛ばしたスレの後ろも一覧での番号を持つ
+	top, err = sv.MakeBoardTop("news4vip", 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top.Threads) != 2 || top.Threads[0].Num != 1 || top.Threads[1].Num != 3 {
+		t.Errorf("threads = %+v", top.Threads)
+	}
+
+	// 一覧だけ
+	top, err = sv.MakeBoardTop("news4vip", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top.Subjects) != 3 || len(top.Threads) != 0 {
+		t.Errorf("top = %+v", top)
+	}
+}
+
+func TestMakeBoardTop_NotFound(t *testing.T) {
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{})
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Now()}))
+	if _, err := sv.MakeBoardTop("unknown", 10, 10); err == nil {
+		t.Error("no error")
+	}
+}
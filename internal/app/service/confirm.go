@@ -0,0 +1,131 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/tempxla/stub2ch/configs/app/secretcfg"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 書き込み確認トークンの有効期間
+	CONFIRM_TOKEN_TTL = 7 * 24 * time.Hour
+	// 発行時刻が未来になっていても許す幅 (インスタンス間の時計のずれ)
+	confirm_token_skew = 5 * time.Minute
+)
+
+// 起動時に読んだ署名鍵 (鍵ID -> 鍵)
+var confirmKeys = struct {
+	sync.Mutex
+	keys map[string]string
+}{}
+
+// 書き込み確認トークンの署名鍵を設定する。起動時に一度だけ呼ぶ。
+// valueは「鍵ID:鍵」をカンマで区切ったもの。
+// secretcfg.CONFIRM_KEY_ID の鍵が無ければエラーにする。
+func ConfigureConfirmKeys(value string) error {
+	keys := make(map[string]string)
+	for i, kv := range strings.Split(value, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		n := strings.Index(kv, ":")
+		if n <= 0 || n == len(kv)-1 {
+			// 鍵をログに出さないように位置だけ返す
+			return fmt.Errorf("malformed confirm key: #%d", i+1)
+		}
+		keys[kv[:n]] = kv[n+1:]
+	}
+	if keys[secretcfg.CONFIRM_KEY_ID] == "" {
+		return fmt.Errorf("no confirm key: %v", secretcfg.CONFIRM_KEY_ID)
+	}
+
+	confirmKeys.Lock()
+	defer confirmKeys.Unlock()
+	confirmKeys.keys = keys
+	return nil
+}
+
+// 設定した署名鍵を返す。設定していなければエラーにする。
+func loadConfirmKeys() (map[string]string, error) {
+	confirmKeys.Lock()
+	defer confirmKeys.Unlock()
+
+	if confirmKeys.keys == nil {
+		return nil, fmt.Errorf("confirm keys are not configured")
+	}
+	return confirmKeys.keys, nil
+}
+
+// 書き込み確認を済ませた人に渡すトークンを作る。
+// IPアドレスの上位、User-Agent、発行時刻に署名する。
+// 形式は 鍵ID.発行時刻.署名
+func (sv *BoardService) IssueConfirmToken(ipAddr, userAgent string) (string, error) {
+	keyId, secret := sv.env.ConfirmKey()
+	if secret == "" {
+		return "", fmt.Errorf("no confirm key: %v", keyId)
+	}
+	issued := strconv.FormatInt(sv.env.StartedAt().Unix(), 10)
+	sig := signConfirmToken(secret, keyId, issued, ipAddr, userAgent)
+	return keyId + "." + issued + "." + sig, nil
+}
+
+// 書き込み確認トークンを検証する。
+// 署名を替えた後も、古い鍵が残っていれば有効期間内のトークンは通す。
+func (sv *BoardService) VerifyConfirmToken(token, ipAddr, userAgent string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed confirm token")
+	}
+	keyId, issued, sig := parts[0], parts[1], parts[2]
+
+	secret, ok := sv.env.ConfirmSecret(keyId)
+	if !ok || secret == "" {
+		return fmt.Errorf("unknown confirm key: %v", keyId)
+	}
+	expected := signConfirmToken(secret, keyId, issued, ipAddr, userAgent)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return fmt.Errorf("invalid confirm token signature")
+	}
+
+	sec, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed confirm token: %v", err)
+	}
+	issuedAt := time.Unix(sec, 0)
+	now := sv.env.StartedAt()
+	if issuedAt.After(now.Add(confirm_token_skew)) || now.Sub(issuedAt) > CONFIRM_TOKEN_TTL {
+		return fmt.Errorf("confirm token expired: %v", issuedAt)
+	}
+	return nil
+}
+
+func signConfirmToken(secret, keyId, issued, ipAddr, userAgent string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	// 区切りを入れて、値の境目をずらした別のトークンを作れないようにする
+	for _, s := range []string{keyId, issued, ipPrefix(ipAddr), userAgent} {
+		mac.Write([]byte(s))
+		mac.Write([]byte{0})
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// モバイル回線などでアドレスの末尾が変わっても確認し直さなくていいように、
+// IPv4は/24、IPv6は/48だけを使う
+func ipPrefix(ipAddr string) string {
+	ip := net.ParseIP(ipAddr)
+	if ip == nil {
+		return ipAddr
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
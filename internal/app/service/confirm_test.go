@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"github.com/tempxla/stub2ch/configs/app/secretcfg"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfirmToken(t *testing.T) {
+
+	issuedAt := testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")
+	env := &SysEnv{
+		StartedTime:  issuedAt,
+		ConfirmKeyId: "k1",
+		ConfirmKeys:  map[string]string{"k1": "secret1"},
+	}
+	sv := NewBoardService(EnvConf(env))
+
+	token, err := sv.IssueConfirmToken("192.0.2.10", "Monazilla/1.00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, "k1.") {
+		t.Errorf("token = %v", token)
+	}
+
+	tests := []struct {
+		token, ipAddr, userAgent string
+		now                      time.Time
+		ok                       bool
+	}{
+		{token, "192.0.2.10", "Monazilla/1.00", issuedAt, true},
+		// 同じ/24なら通す
+		{token, "192.0.2.200", "Monazilla/1.00", issuedAt, true},
+		{token, "192.0.3.10", "Monazilla/1.00", issuedAt, false},
+		{token, "192.0.2.10", "Mozilla/5.0", issuedAt, false},
+		// 有効期間
+		{token, "192.0.2.10", "Monazilla/1.00", issuedAt.Add(CONFIRM_TOKEN_TTL - time.Second), true},
+		{token, "192.0.2.10", "Monazilla/1.00", issuedAt.Add(CONFIRM_TOKEN_TTL + time.Second), false},
+		{token, "192.0.2.10", "Monazilla/1.00", issuedAt.Add(-time.Hour), false},
+		// 改ざん
+		{strings.Replace(token, ".", ".1", 1), "192.0.2.10", "Monazilla/1.00", issuedAt, false},
+		{"k2" + token[2:], "192.0.2.10", "Monazilla/1.00", issuedAt, false},
+		{"192.0.2.10", "192.0.2.10", "Monazilla/1.00", issuedAt, false},
+		{"", "192.0.2.10", "Monazilla/1.00", issuedAt, false},
+	}
+	for i, tt := range tests {
+		env.StartedTime = tt.now
+		if err := sv.VerifyConfirmToken(tt.token, tt.ipAddr, tt.userAgent); (err == nil) != tt.ok {
+			t.Errorf("%d: VerifyConfirmToken() = %v, want ok: %v", i, err, tt.ok)
+		}
+	}
+}
+
+func TestConfirmToken_Rotation(t *testing.T) {
+
+	now := testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")
+	env := &SysEnv{
+		StartedTime:  now,
+		ConfirmKeyId: "k1",
+		ConfirmKeys:  map[string]string{"k1": "secret1"},
+	}
+	sv := NewBoardService(EnvConf(env))
+	oldToken, err := sv.IssueConfirmToken("2001:db8::1", "Monazilla/1.00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 新しい鍵で署名し、古い鍵も残す
+	env.ConfirmKeyId = "k2"
+	env.ConfirmKeys = map[string]string{"k1": "secret1", "k2": "secret2"}
+	newToken, err := sv.IssueConfirmToken("2001:db8::1", "Monazilla/1.00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(newToken, "k2.") {
+		t.Errorf("newToken = %v", newToken)
+	}
+	// 同じ/48なら通す
+	for _, token := range []string{oldToken, newToken} {
+		if err := sv.VerifyConfirmToken(token, "2001:db8:0:1::2", "Monazilla/1.00"); err != nil {
+			t.Errorf("%v: %v", token, err)
+		}
+	}
+
+	// 古い鍵を消す
+	env.ConfirmKeys = map[string]string{"k2": "secret2"}
+	if err := sv.VerifyConfirmToken(oldToken, "2001:db8::1", "Monazilla/1.00"); err == nil {
+		t.Error("err is nil")
+	}
+	if err := sv.VerifyConfirmToken(newToken, "2001:db8::1", "Monazilla/1.00"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIssueConfirmToken_NoKey(t *testing.T) {
+	sv := NewBoardService(EnvConf(&SysEnv{StartedTime: time.Now()}))
+	if _, err := sv.IssueConfirmToken("192.0.2.10", "Monazilla/1.00"); err == nil {
+		t.Error("err is nil")
+	}
+}
+
+func TestConfigureConfirmKeys(t *testing.T) {
+	defer func(keys map[string]string) { confirmKeys.keys = keys }(confirmKeys.keys)
+
+	// 鍵が無ければサービスを作らない
+	confirmKeys.keys = nil
+	if sv, err := DefaultBoardService(context.Background()); sv != nil || err == nil {
+		t.Errorf("DefaultBoardService = %v, %v", sv, err)
+	}
+
+	id := secretcfg.CONFIRM_KEY_ID
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"", false},
+		{"old:secret0", false},
+		{id + ":", false},
+		{":secret1", false},
+		{id + ":secret1", true},
+		{"old:secret0, " + id + ":secret1,", true},
+	}
+	for _, tt := range tests {
+		confirmKeys.keys = nil
+		err := ConfigureConfirmKeys(tt.value)
+		if (err == nil) != tt.ok {
+			t.Errorf("ConfigureConfirmKeys(%q) = %v", tt.value, err)
+			continue
+		}
+		keys, err := loadConfirmKeys()
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%q: keys = %v", tt.value, keys)
+			}
+			continue
+		}
+		if err != nil || keys[id] != "secret1" {
+			t.Errorf("%q: keys = %v, %v", tt.value, keys, err)
+		}
+	}
+}
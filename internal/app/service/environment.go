@@ -7,11 +7,15 @@ import (
 type BoardEnvironment interface {
 	StartedAt() time.Time
 	SaltComputeId() string
+	ConfirmKey() (id, secret string)
+	ConfirmSecret(id string) (secret string, ok bool)
 }
 
 type SysEnv struct {
 	StartedTime   time.Time
 	ComputeIdSalt string
+	ConfirmKeyId  string            // 書き込み確認クッキーに署名する鍵
+	ConfirmKeys   map[string]string // 書き込み確認クッキーを検証できる鍵
 }
 
 func (env *SysEnv) StartedAt() time.Time {
@@ -21,3 +25,12 @@ func (env *SysEnv) StartedAt() time.Time {
 func (env *SysEnv) SaltComputeId() string {
 	return env.ComputeIdSalt
 }
+
+func (env *SysEnv) ConfirmKey() (id, secret string) {
+	return env.ConfirmKeyId, env.ConfirmKeys[env.ConfirmKeyId]
+}
+
+func (env *SysEnv) ConfirmSecret(id string) (secret string, ok bool) {
+	secret, ok = env.ConfirmKeys[id]
+	return
+}
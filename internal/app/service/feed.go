@@ -0,0 +1,170 @@
+package service
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/tempxla/stub2ch/configs/app/bbscfg"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/xml/feed"
+	"github.com/tempxla/stub2ch/internal/app/util"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FEED_ITEM_MAX = 20 // フィードに載せる件数
+)
+
+// 新しく立ったスレの一覧をRSS 1.0で返す。
+// 更新時刻は一番新しいスレが立った時刻 (スレが無ければゼロ)。
+// baseURLは http://host の形でリンクに使う。
+func (sv *BoardService) MakeBoardRdf(stng bbscfg.Setting, boardName, baseURL string) (
+	_ []byte, lastModified time.Time, err error) {
+
+	subjects, err := loadSubjects(sv.repo, boardName)
+	if err != nil {
+		return
+	}
+
+	// スレッドキーはスレが立った時刻
+	sort.SliceStable(subjects, func(i, j int) bool {
+		return subjects[i].ThreadKey > subjects[j].ThreadKey
+	})
+	if len(subjects) > FEED_ITEM_MAX {
+		subjects = subjects[:FEED_ITEM_MAX]
+	}
+
+	boardURL := baseURL + "/" + boardName + "/"
+	rdf := &feed.RDF{
+		XmlnsRdf: feed.RDF_NS,
+		Xmlns:    feed.RSS_NS,
+		XmlnsDc:  feed.DC_NS,
+		Channel: feed.Channel{
+			About:       boardURL + "index.rdf",
+			Title:       stng.BBS_TITLE(),
+			Link:        boardURL,
+			Description: stng.BBS_TITLE() + " の新着スレッド",
+		},
+	}
+	for _, sbj := range subjects {
+		sec, _ := strconv.ParseInt(sbj.ThreadKey, 10, 64)
+		createdAt := time.Unix(sec, 0).In(sv.StartedAt().Location())
+		if createdAt.After(lastModified) {
+			lastModified = createdAt
+		}
+		link := readCgiURL(baseURL, boardName, sbj.ThreadKey)
+		rdf.Channel.Resources = append(rdf.Channel.Resources, feed.RDFLi{Resource: link})
+		rdf.Items = append(rdf.Items, feed.RDFItem{
+			About: link,
+			Title: html.UnescapeString(sbj.ThreadTitle),
+			Link:  link,
+			Date:  createdAt.Format(time.RFC3339),
+		})
+	}
+	if !lastModified.IsZero() {
+		rdf.Channel.Date = lastModified.Format(time.RFC3339)
+	}
+
+	b, err := marshalFeed(rdf)
+	return b, lastModified, err
+}
+
+// スレの新しいレスをAtomで返す。新しい順に最大limit件。
+// 日付の無い行 (あぼーん、1001など) は載せない。
+// 更新時刻はdatの更新時刻。
+func (sv *BoardService) MakeThreadAtom(stng bbscfg.Setting, boardName, threadKey, baseURL string, limit int) (
+	_ []byte, lastModified time.Time, err error) {
+
+	key := sv.repo.DatKey(threadKey, sv.repo.BoardKey(boardName))
+	datEntity := &dat.Entity{}
+	if err = sv.repo.GetDat(key, datEntity); err != nil {
+		return
+	}
+
+	utf8Dat := datEntity.Bytes
+	if datEntity.Sjis {
+		utf8Dat = util.SJIStoUTF8(datEntity.Bytes)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(utf8Dat), "\n"), "\n")
+
+	title := ""
+	if fields := strings.SplitN(lines[0], "<>", 5); len(fields) == 5 {
+		title = html.UnescapeString(fields[4])
+	}
+
+	loc := sv.StartedAt().Location()
+	lastModified = datEntity.LastModified
+	threadURL := readCgiURL(baseURL, boardName, threadKey)
+	atom := &feed.Atom{
+		Xmlns:   feed.ATOM_NS,
+		Id:      threadURL,
+		Title:   title,
+		Updated: lastModified.In(loc).Format(time.RFC3339),
+		Links: []feed.AtomLink{
+			{Rel: "alternate", Type: "text/html", Href: threadURL},
+			{Rel: "self", Type: "application/atom+xml", Href: atomURL(baseURL, boardName, threadKey)},
+		},
+		Author: feed.AtomAuthor{Name: stng.BBS_TITLE()},
+	}
+
+	for i := len(lines) - 1; i >= 0 && len(atom.Entries) < limit; i-- {
+		resnum := i + 1
+		fields := strings.Split(lines[i], "<>")
+		if len(fields) != 5 {
+			continue
+		}
+		date, ok := parseDat2chDate(fields[2], loc)
+		if !ok {
+			continue
+		}
+		name := strings.TrimSpace(datTextToPlain(fields[0]))
+		entryURL := threadURL + strconv.Itoa(resnum)
+		atom.Entries = append(atom.Entries, feed.AtomEntry{
+			Id:      entryURL,
+			Title:   fmt.Sprintf("%d: %s", resnum, name),
+			Updated: date.Format(time.RFC3339),
+			Link:    feed.AtomLink{Rel: "alternate", Type: "text/html", Href: entryURL},
+			Author:  feed.AtomAuthor{Name: name},
+			Content: feed.AtomContent{Type: "html", Body: datMessageToHTML(fields[3])},
+		})
+	}
+
+	b, err := marshalFeed(atom)
+	return b, lastModified, err
+}
+
+func readCgiURL(baseURL, boardName, threadKey string) string {
+	return baseURL + "/test/read.cgi/" + boardName + "/" + threadKey + "/"
+}
+
+func atomURL(baseURL, boardName, threadKey string) string {
+	return baseURL + "/" + boardName + "/atom/" + threadKey
+}
+
+func marshalFeed(v interface{}) ([]byte, error) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// datの名前などを表示する文字列にする (トリップの<b>などは消す)
+func datTextToPlain(s string) string {
+	return html.UnescapeString(html_tag_regexp.ReplaceAllString(s, ""))
+}
+
+// datの本文をフィード用のHTMLにする。
+// 過去ログから取り込んだdatにはタグが入っていることがあるので、
+// 一度文字列に戻してエスケープし直し、改行だけ<br>にする。AAのため空白は残す。
+func datMessageToHTML(s string) string {
+	s = strings.Trim(s, " ")
+	lines := strings.Split(s, "<br>")
+	for i, line := range lines {
+		lines[i] = html.EscapeString(datTextToPlain(line))
+	}
+	return strings.Join(lines, "<br>")
+}
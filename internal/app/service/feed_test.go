@@ -0,0 +1,113 @@
+package service
+
+import (
+	"encoding/xml"
+	"github.com/tempxla/stub2ch/internal/app/types/xml/feed"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMakeBoardRdf(t *testing.T) {
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	// 一覧の並び (age順) と立った順は違う
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{ThreadKey: "1579316400", ThreadTitle: "古い&amp;スレ", MessageCount: 10},
+		{ThreadKey: "1579320000", ThreadTitle: "新しいスレ", MessageCount: 1},
+	})
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Date(2020, 1, 18, 13, 0, 0, 0, jst)}))
+
+	b, lastModified, err := sv.MakeBoardRdf(testutil.NewSettingStub(), "news4vip", "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Unix(1579320000, 0); !lastModified.Equal(want) {
+		t.Errorf("lastModified = %v, want: %v", lastModified, want)
+	}
+
+	// encoding/xmlは接頭辞付きの名前を読めないので文字列で確かめる
+	rdf := string(b)
+	wants := []string{
+		`<?xml version="1.0" encoding="UTF-8"?>`,
+		`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/">`,
+		`<channel rdf:about="https://example.com/news4vip/index.rdf">`,
+		`<link>https://example.com/news4vip/</link>`,
+		`<dc:date>2020-01-18T13:00:00+09:00</dc:date>`,
+		`<rdf:li rdf:resource="https://example.com/test/read.cgi/news4vip/1579320000/"></rdf:li>`,
+		`<item rdf:about="https://example.com/test/read.cgi/news4vip/1579320000/">`,
+		`<title>古い&amp;スレ</title>`,
+		`<dc:date>2020-01-18T12:00:00+09:00</dc:date>`,
+	}
+	for _, want := range wants {
+		if !strings.Contains(rdf, want) {
+			t.Errorf("%v not found: %v", want, rdf)
+		}
+	}
+	// 新しく立った順
+	if strings.Index(rdf, "<title>新しいスレ</title>") > strings.Index(rdf, "<title>古い&amp;スレ</title>") {
+		t.Errorf("order: %v", rdf)
+	}
+}
+
+func TestMakeThreadAtom(t *testing.T) {
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	lastModified := time.Date(2020, 1, 18, 12, 5, 0, 0, jst)
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{
+			ThreadKey:    "1579316400",
+			ThreadTitle:  "スレタイ&amp;",
+			MessageCount: 4,
+			LastModified: lastModified,
+			Dat: "名無し<>sage<>2020/01/18(土) 12:00:00.000 ID:aaa<> 本文1 <>スレタイ&amp;\n" +
+				"名無し </b>◆trip <b><><>2020/01/18(土) 12:01:00.000 ID:bbb<> &gt;&gt;1<br>  (´・ω・`)<br><a href=\"x\">link</a> <>\n" +
+				"あぼーん<>あぼーん<>あぼーん<>あぼーん<>\n" +
+				"名無し<><>2020/01/18(土) 12:05:00.000 ID:ccc<> 本文4 <>\n",
+		},
+	})
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Date(2020, 1, 18, 13, 0, 0, 0, jst)}))
+
+	b, lm, err := sv.MakeThreadAtom(testutil.NewSettingStub(), "news4vip", "1579316400", "http://localhost:8080", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lm.Equal(lastModified) {
+		t.Errorf("lastModified = %v, want: %v", lm, lastModified)
+	}
+
+	atom := &feed.Atom{}
+	if err := xml.Unmarshal(b, atom); err != nil {
+		t.Fatalf("%v: %s", err, b)
+	}
+	if atom.Title != "スレタイ&" || atom.Id != "http://localhost:8080/test/read.cgi/news4vip/1579316400/" ||
+		atom.Updated != "2020-01-18T12:05:00+09:00" {
+		t.Errorf("atom = %+v", atom)
+	}
+	if len(atom.Links) != 2 || atom.Links[1].Href != "http://localhost:8080/news4vip/atom/1579316400" {
+		t.Errorf("links = %+v", atom.Links)
+	}
+
+	// 新しい順に2件。あぼーんは飛ばす
+	if len(atom.Entries) != 2 {
+		t.Fatalf("entries = %+v", atom.Entries)
+	}
+	if e := atom.Entries[0]; e.Id != "http://localhost:8080/test/read.cgi/news4vip/1579316400/4" ||
+		e.Title != "4: 名無し" || e.Content.Body != "本文4" {
+		t.Errorf("entries[0] = %+v", e)
+	}
+	e := atom.Entries[1]
+	if e.Title != "2: 名無し ◆trip" || e.Updated != "2020-01-18T12:01:00+09:00" || e.Content.Type != "html" {
+		t.Errorf("entries[1] = %+v", e)
+	}
+	if want := "&gt;&gt;1<br>  (´・ω・`)<br>link"; e.Content.Body != want {
+		t.Errorf("content = %q, want: %q", e.Content.Body, want)
+	}
+}
+
+func TestMakeThreadAtom_NotFound(t *testing.T) {
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{})
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Now()}))
+	if _, _, err := sv.MakeThreadAtom(testutil.NewSettingStub(), "news4vip", "1579316400", "", 10); err == nil {
+		t.Error("no error")
+	}
+}
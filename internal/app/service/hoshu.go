@@ -0,0 +1,145 @@
+package service
+
+import (
+	"cloud.google.com/go/datastore"
+	"github.com/tempxla/stub2ch/configs/app/bbscfg"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/thread"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// 即落ちを判定するまでの時間
+	sokuochi_period = time.Hour
+	// プレビューに出すスレの数
+	stale_preview_max = 20
+
+	STALE_HOSHU    = "hoshu"    // 書き込みが無い
+	STALE_SOKUOCHI = "sokuochi" // 立ってすぐにレスが付かない
+)
+
+// 保守の決まりで落ちる予定のスレ
+type StaleThread struct {
+	board.Subject
+	DropAt time.Time
+	Reason string
+}
+
+// 保守の決まりでスレが落ちる時刻を返す。落ちないスレは ok = false
+func staleDropAt(stng bbscfg.Setting, sbj *board.Subject) (dropAt time.Time, reason string, ok bool) {
+	// 固定したスレは落とさない
+	if sbj.Pinned {
+		return
+	}
+	if hours := stng.STUB_HOSHU_HOURS(); hours > 0 {
+		dropAt, reason, ok = sbj.LastModified.Add(time.Duration(hours)*time.Hour), STALE_HOSHU, true
+	}
+	// スレッドキーがスレ立ての時刻
+	if min := stng.STUB_SOKUOCHI_RES(); min > 0 && sbj.MessageCount < min {
+		if sec, err := strconv.ParseInt(sbj.ThreadKey, 10, 64); err == nil {
+			at := time.Unix(sec, 0).Add(sokuochi_period)
+			if !ok || at.Before(dropAt) {
+				dropAt, reason, ok = at, STALE_SOKUOCHI, true
+			}
+		}
+	}
+	return
+}
+
+// 板のスレのうち、次に保守の決まりで落ちるものを落ちる順に返す。
+// DropAt が過ぎているスレは次の掃除で落ちる。
+func (admin *AdminFunction) PreviewStaleThreads(boardName string) ([]*StaleThread, error) {
+	stng, _, err := loadSetting(admin.repo, boardName)
+	if err != nil {
+		return nil, err
+	}
+	subjects, err := loadSubjects(admin.repo, boardName)
+	if err != nil {
+		return nil, err
+	}
+	if stng == nil {
+		return []*StaleThread{}, nil
+	}
+
+	stales := []*StaleThread{}
+	for _, sbj := range subjects {
+		if dropAt, reason, ok := staleDropAt(stng, &sbj); ok {
+			stales = append(stales, &StaleThread{Subject: sbj, DropAt: dropAt, Reason: reason})
+		}
+	}
+	sort.SliceStable(stales, func(i, j int) bool {
+		return stales[i].DropAt.Before(stales[j].DropAt)
+	})
+	if len(stales) > stale_preview_max {
+		stales = stales[:stale_preview_max]
+	}
+	return stales, nil
+}
+
+// 全ての板で、保守の決まりで落ちる時刻を過ぎたスレをdat落ちさせる。
+// datは残す。落としたスレの数を返す。
+func (admin *AdminFunction) SweepStaleThreads() (count int, err error) {
+
+	keys, err := admin.repo.GetAllBoard(&[]*board.Entity{})
+	if err != nil {
+		return
+	}
+
+	now := admin.env.StartedAt()
+	for _, boardKey := range keys {
+		boardName := boardKey.DSKey.Name
+		var stng bbscfg.Setting
+		stng, _, err = loadSetting(admin.repo, boardName)
+		if err != nil {
+			return
+		}
+		if stng == nil {
+			continue
+		}
+
+		var subjects []board.Subject
+		subjects, err = loadSubjects(admin.repo, boardName)
+		if err != nil {
+			return
+		}
+
+		for _, sbj := range subjects {
+			if dropAt, _, ok := staleDropAt(stng, &sbj); !ok || dropAt.After(now) {
+				continue
+			}
+			dropped := false
+			threadKey := admin.repo.ThreadKey(sbj.ThreadKey, boardKey)
+			err = admin.repo.RunInTransaction(func(tx *datastore.Transaction) error {
+				dropped = false
+				entity := &thread.Entity{}
+				if err := admin.repo.TxGetThread(tx, threadKey, entity); err != nil {
+					return err
+				}
+				// 一覧を読んだ後に書き込まれたスレは残す
+				latest := toSubject(sbj.ThreadKey, entity)
+				if dropAt, _, ok := staleDropAt(stng, &latest); !entity.Live || !ok || dropAt.After(now) {
+					return nil
+				}
+				entity.Live = false
+				dropped = true
+				return admin.repo.TxPutThread(tx, threadKey, entity)
+			})
+			if err == datastore.ErrNoSuchEntity {
+				err = nil
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if dropped {
+				count++
+			}
+		}
+	}
+
+	log.Printf("SweepStaleThreads: %v threads", count)
+	return count, nil
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"github.com/tempxla/stub2ch/configs/app/bbscfg"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/setting"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/thread"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 24時間書き込みが無いか、1時間で5レスに届かないスレを落とす
+func newHoshuTestRepo(now time.Time) *testutil.BoardStub {
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		// 書き込みがある
+		{ThreadKey: threadKeyAt(now.Add(-48 * time.Hour)), MessageCount: 100, LastModified: now.Add(-time.Hour)},
+		// 書き込みが無い
+		{ThreadKey: threadKeyAt(now.Add(-47 * time.Hour)), MessageCount: 100, LastModified: now.Add(-25 * time.Hour)},
+		// 立ってすぐ伸びなかった
+		{ThreadKey: threadKeyAt(now.Add(-3 * time.Hour)), MessageCount: 4, LastModified: now.Add(-90 * time.Minute)},
+		// 立ったばかり
+		{ThreadKey: threadKeyAt(now.Add(-30 * time.Minute)), MessageCount: 1, LastModified: now.Add(-30 * time.Minute)},
+	})
+	repo.PutBoardSetting(repo.SettingKey("news4vip"), &setting.Entity{
+		Values: []setting.Value{
+			{Name: "STUB_HOSHU_HOURS", Value: "24"},
+			{Name: "STUB_SOKUOCHI_RES", Value: "5"},
+		},
+	})
+	return repo
+}
+
+func threadKeyAt(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+func TestStaleDropAt(t *testing.T) {
+
+	created := testutil.NewTimeJST(t, "2020-01-18 18:00:00.000")
+	lastModified := created.Add(2 * time.Hour)
+	tests := []struct {
+		hours, res   string
+		messageCount int
+		pinned       bool
+		dropAt       time.Time
+		reason       string
+		ok           bool
+	}{
+		// 落とさない設定
+		{"0", "0", 1, false, time.Time{}, "", false},
+		{"24", "0", 1, false, lastModified.Add(24 * time.Hour), STALE_HOSHU, true},
+		{"0", "5", 4, false, created.Add(time.Hour), STALE_SOKUOCHI, true},
+		// レス数が足りている
+		{"0", "5", 5, false, time.Time{}, "", false},
+		// 早い方
+		{"1", "5", 4, false, created.Add(time.Hour), STALE_SOKUOCHI, true},
+		// 固定したスレ
+		{"24", "5", 1, true, time.Time{}, "", false},
+	}
+
+	for i, tt := range tests {
+		stng := &bbscfg.Override{
+			Base:   &testutil.SettingStub{},
+			Values: map[string]string{"STUB_HOSHU_HOURS": tt.hours, "STUB_SOKUOCHI_RES": tt.res},
+		}
+		sbj := &board.Subject{
+			ThreadKey:    threadKeyAt(created),
+			MessageCount: tt.messageCount,
+			LastModified: lastModified,
+			Pinned:       tt.pinned,
+		}
+		dropAt, reason, ok := staleDropAt(stng, sbj)
+		if !dropAt.Equal(tt.dropAt) || reason != tt.reason || ok != tt.ok {
+			t.Errorf("%d: staleDropAt() = %v, %v, %v. want: %v, %v, %v", i, dropAt, reason, ok, tt.dropAt, tt.reason, tt.ok)
+		}
+	}
+}
+
+func TestPreviewStaleThreads(t *testing.T) {
+
+	now := testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")
+	repo := newHoshuTestRepo(now)
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: now}), AdminConf(repo, nil))
+	subjects := repo.Subjects("news4vip")
+
+	stales, err := sv.Admin.PreviewStaleThreads("news4vip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 落ちる順
+	expected := []struct {
+		threadKey string
+		reason    string
+		dropAt    time.Time
+	}{
+		{subjects[2].ThreadKey, STALE_SOKUOCHI, time.Unix(now.Add(-2*time.Hour).Unix(), 0)},
+		{subjects[1].ThreadKey, STALE_HOSHU, now.Add(-time.Hour)},
+		{subjects[3].ThreadKey, STALE_SOKUOCHI, time.Unix(now.Add(30*time.Minute).Unix(), 0)},
+		{subjects[0].ThreadKey, STALE_HOSHU, now.Add(23 * time.Hour)},
+	}
+	if len(stales) != len(expected) {
+		t.Fatalf("len(stales) = %v, want: %v", len(stales), len(expected))
+	}
+	for i, e := range expected {
+		s := stales[i]
+		if s.ThreadKey != e.threadKey || s.Reason != e.reason || !s.DropAt.Equal(e.dropAt) {
+			t.Errorf("%d: stale = %v %v %v, want: %v %v %v", i, s.ThreadKey, s.Reason, s.DropAt, e.threadKey, e.reason, e.dropAt)
+		}
+	}
+
+	// 固定したスレは落とさない
+	if err := sv.Admin.PinThread("news4vip", subjects[1].ThreadKey, true); err != nil {
+		t.Fatal(err)
+	}
+	stales, err = sv.Admin.PreviewStaleThreads("news4vip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stales) != 3 || stales[0].ThreadKey != subjects[2].ThreadKey {
+		t.Errorf("stales = %v", stales)
+	}
+
+	// 存在しない板
+	if _, err := sv.Admin.PreviewStaleThreads("poverty"); err == nil {
+		t.Error("err is nil")
+	}
+}
+
+func TestSweepStaleThreads(t *testing.T) {
+
+	now := testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")
+	repo := newHoshuTestRepo(now)
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: now}), AdminConf(repo, nil))
+	subjects := repo.Subjects("news4vip")
+
+	count, err := sv.Admin.SweepStaleThreads()
+	if count != 2 || err != nil {
+		t.Errorf("SweepStaleThreads() = %v, %v. want: 2, nil", count, err)
+	}
+
+	after := repo.Subjects("news4vip")
+	if len(after) != 2 || after[0].ThreadKey != subjects[0].ThreadKey || after[1].ThreadKey != subjects[3].ThreadKey {
+		t.Errorf("subjects = %v", after)
+	}
+	// datは残る
+	for _, sbj := range subjects {
+		if _, ok := repo.DatMap["news4vip"][sbj.ThreadKey]; !ok {
+			t.Errorf("dat is deleted: %v", sbj.ThreadKey)
+		}
+	}
+	// 落ちたスレには書き込めない
+	stng, _ := sv.GetSetting("news4vip")
+	if _, err := sv.WriteDat(stng, "news4vip", subjects[1].ThreadKey, "name", "", "ABCDEFGH", "message"); err == nil {
+		t.Error("err is nil")
+	}
+
+	// 2回目は何もしない
+	count, err = sv.Admin.SweepStaleThreads()
+	if count != 0 || err != nil {
+		t.Errorf("SweepStaleThreads() = %v, %v. want: 0, nil", count, err)
+	}
+}
+
+func TestSweepStaleThreads_Written(t *testing.T) {
+
+	now := testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")
+	repo := newHoshuTestRepo(now)
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: now}), AdminConf(repo, nil))
+	subjects := repo.Subjects("news4vip")
+
+	// 一覧を読んだ後に書き込まれたスレは残す
+	threadKey := repo.ThreadKey(subjects[1].ThreadKey, repo.BoardKey("news4vip"))
+	entity := &thread.Entity{}
+	repo.TxGetThread(nil, threadKey, entity)
+	entity.LastModified = now
+	repo.TxPutThread(nil, threadKey, entity)
+
+	count, err := sv.Admin.SweepStaleThreads()
+	if count != 1 || err != nil {
+		t.Errorf("SweepStaleThreads() = %v, %v. want: 1, nil", count, err)
+	}
+}
+
+func TestSweepStaleThreads_Error(t *testing.T) {
+
+	admin := &AdminFunction{
+		repo: testutil.NewBrokenBoardStub(),
+		env:  &SysEnv{StartedTime: time.Now()},
+	}
+	if _, err := admin.SweepStaleThreads(); err == nil {
+		t.Error("err is nil")
+	}
+}
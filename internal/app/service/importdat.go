@@ -0,0 +1,272 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/tempxla/stub2ch/configs/app/bbscfg"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/util"
+	"html"
+	"io"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// 年/月/日(曜) 時:分:秒.ミリ秒 (ミリ秒の桁数は鯖による)
+	dat2ch_date_regexp = regexp.MustCompile(`^(\d{2,4})/(\d{1,2})/(\d{1,2})\([^)]*\) ?(\d{1,2}):(\d{2}):(\d{2})(?:\.(\d+))?`)
+	// 1234567890.dat<>スレタイ (10)
+	subject_line_regexp = regexp.MustCompile(`^(\d{10})\.dat<>`)
+)
+
+// 2ch形式のdatをまとめたzipを板に取り込む。
+// zipには *.dat (Shift_JIS) と、あれば subject.txt を入れる。
+// subject.txtにあるスレはその順に、subject.txtが無ければ新しい順にスレッド一覧に並べ、
+// subject.txtに無いスレやarchivedがtrueのときはdat落ちとして取り込む。
+// 一覧に入るのは板のSTUB_THREAD_COUNTまでで、残りはdat落ちになる。
+// 1つでも形式が違うdatがあれば何も書き込まない。
+func (admin *AdminFunction) ImportDats(boardName string, r io.ReaderAt, size int64, archived bool) (count int, err error) {
+
+	if bbscfg.GetSetting(boardName) == nil {
+		return 0, fmt.Errorf("unknown board: %v", boardName)
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return
+	}
+
+	jst, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		return
+	}
+
+	var order []string
+	threads := make(map[string]*importThread)
+	for _, f := range zr.File {
+		name := path.Base(f.Name)
+		switch {
+		case name == archive_subject_name:
+			var b []byte
+			if b, err = readArchiveFile(f); err != nil {
+				return
+			}
+			if order, err = parseSubjectTxt2ch(b); err != nil {
+				return 0, fmt.Errorf("%v: %v", f.Name, err)
+			}
+		case strings.HasSuffix(name, ".dat"):
+			threadKey := strings.TrimSuffix(name, ".dat")
+			if !isThreadKey(threadKey) {
+				return 0, fmt.Errorf("%v: invalid thread key", f.Name)
+			}
+			if _, ok := threads[threadKey]; ok {
+				return 0, fmt.Errorf("%v: duplicate thread key", f.Name)
+			}
+			var b []byte
+			if b, err = readArchiveFile(f); err != nil {
+				return
+			}
+			var t *importThread
+			if t, err = parseDat2ch(threadKey, b, jst); err != nil {
+				return 0, fmt.Errorf("%v: %v", f.Name, err)
+			}
+			threads[threadKey] = t
+		}
+	}
+	if len(threads) == 0 {
+		return 0, fmt.Errorf("no dat in archive")
+	}
+
+	// スレッド一覧の順番
+	var sorted []*importThread
+	if order != nil {
+		for _, threadKey := range order {
+			t, ok := threads[threadKey]
+			if !ok {
+				return 0, fmt.Errorf("%v: dat not found: %v", archive_subject_name, threadKey)
+			}
+			t.Live = true
+			sorted = append(sorted, t)
+		}
+		var rest []*importThread
+		for _, t := range threads {
+			if !t.Live {
+				rest = append(rest, t)
+			}
+		}
+		sortThreadsByLastModified(rest)
+		sorted = append(sorted, rest...)
+	} else {
+		for _, t := range threads {
+			t.Live = true
+			sorted = append(sorted, t)
+		}
+		sortThreadsByLastModified(sorted)
+	}
+	if archived {
+		for _, t := range sorted {
+			t.Live = false
+		}
+	}
+
+	stng, _, err := loadSetting(admin.repo, boardName)
+	if err != nil {
+		return
+	}
+	if err = admin.limitLiveThreads(boardName, stng, sorted); err != nil {
+		return
+	}
+
+	log.Printf("ImportDats: %v (%v dats, archived: %v)", boardName, len(sorted), archived)
+	return admin.importThreads(boardName, 0, sorted)
+}
+
+// スレッド一覧に入れるスレをstngのSTUB_THREAD_COUNTまでにする。
+// 取り込みで置き換えない一覧のスレも数え、はみ出した分はdat落ちとして取り込む。
+func (admin *AdminFunction) limitLiveThreads(boardName string, stng bbscfg.Setting, threads []*importThread) error {
+	subjects, err := loadSubjects(admin.repo, boardName)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+
+	importing := make(map[string]bool)
+	for _, t := range threads {
+		importing[t.Subject.ThreadKey] = true
+	}
+	rest := stng.STUB_THREAD_COUNT()
+	for _, sbj := range subjects {
+		if !importing[sbj.ThreadKey] {
+			rest--
+		}
+	}
+	for _, t := range threads {
+		if !t.Live {
+			continue
+		}
+		if rest > 0 {
+			rest--
+		} else {
+			t.Live = false
+		}
+	}
+	return nil
+}
+
+func sortThreadsByLastModified(threads []*importThread) {
+	sort.SliceStable(threads, func(i, j int) bool {
+		a, b := threads[i].Subject, threads[j].Subject
+		if !a.LastModified.Equal(b.LastModified) {
+			return a.LastModified.After(b.LastModified)
+		}
+		return a.ThreadKey > b.ThreadKey
+	})
+}
+
+// 2ch形式のdatを確認して、スレッド一覧の項目と安価の索引を作る。
+// 名前<>メール<>日付 ID<> 本文 <>スレタイ (2行目以降はスレタイが空)
+func parseDat2ch(threadKey string, b []byte, jst *time.Location) (*importThread, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty dat")
+	}
+	if b[len(b)-1] != '\n' {
+		b = append(append([]byte{}, b...), '\n')
+	}
+
+	utf8Dat := util.SJIStoUTF8(b)
+	if bytes.ContainsRune(utf8Dat, utf8.RuneError) {
+		return nil, fmt.Errorf("not Shift_JIS")
+	}
+
+	t := &importThread{
+		Dat: &dat.Entity{
+			Bytes: b,
+			Size:  len(b),
+			Sjis:  true,
+		},
+	}
+	var lastModified time.Time
+	lines := strings.Split(strings.TrimSuffix(string(utf8Dat), "\n"), "\n")
+	for i, line := range lines {
+		resnum := i + 1
+		fields := strings.Split(line, "<>")
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("line %d: %d fields, want: 5", resnum, len(fields))
+		}
+		if resnum == 1 && strings.TrimSpace(fields[4]) == "" {
+			return nil, fmt.Errorf("line 1: no title")
+		}
+		if resnum > 1 && fields[4] != "" {
+			return nil, fmt.Errorf("line %d: title must be empty", resnum)
+		}
+		// あぼーんなど日付が無い行もある
+		if date, ok := parseDat2chDate(fields[2], jst); ok {
+			lastModified = date
+		}
+		message := html.UnescapeString(strings.ReplaceAll(strings.Trim(fields[3], " "), "<br>", "\n"))
+		indexAnchors(t.Dat, resnum, message)
+	}
+
+	if lastModified.IsZero() {
+		// スレッドキーはスレ立ての時刻
+		sec, _ := strconv.ParseInt(threadKey, 10, 64)
+		lastModified = time.Unix(sec, 0).In(jst)
+	}
+	t.Dat.LastModified = lastModified
+	t.Subject = board.Subject{
+		ThreadKey:    threadKey,
+		ThreadTitle:  strings.SplitN(lines[0], "<>", 5)[4],
+		MessageCount: len(lines),
+		LastModified: lastModified,
+	}
+	return t, nil
+}
+
+func parseDat2chDate(s string, jst *time.Location) (time.Time, bool) {
+	m := dat2ch_date_regexp.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}, false
+	}
+	n := make([]int, 6)
+	for i := range n {
+		n[i], _ = strconv.Atoi(m[i+1])
+	}
+	if n[0] < 100 {
+		n[0] += 2000
+	}
+	nsec := 0
+	if ms := m[7]; ms != "" {
+		// 小数部を9桁にそろえる
+		ms = (ms + "000000000")[:9]
+		nsec, _ = strconv.Atoi(ms)
+	}
+	return time.Date(n[0], time.Month(n[1]), n[2], n[3], n[4], n[5], nsec, jst), true
+}
+
+// subject.txtからスレッドキーを並び順に返す
+func parseSubjectTxt2ch(b []byte) ([]string, error) {
+	order := []string{}
+	seen := make(map[string]bool)
+	for i, line := range strings.Split(string(util.SJIStoUTF8(b)), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		m := subject_line_regexp.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("line %d: invalid format", i+1)
+		}
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			order = append(order, m[1])
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/setting"
+	"github.com/tempxla/stub2ch/internal/app/util"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"testing"
+	"time"
+)
+
+func makeDatZip(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, data := range files {
+		if err := writeArchiveFile(zw, name, util.UTF8toSJIS([]byte(data)), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const (
+	test_dat_1 = "名無し<>sage<>2019/12/01(日) 10:00:00.12 ID:aaa<> スレ立て <>スレタイ1\n" +
+		"名無し<><>2019/12/01(日) 10:05:00.345 ID:bbb<> &gt;&gt;1<br>乙 <>\n" +
+		"あぼーん<>あぼーん<>あぼーん<>あぼーん<>\n"
+	test_dat_2 = "名無し<><>19/12/02(月) 09:00:00 ID:ccc<> 本文 <>スレタイ2\n"
+	test_dat_3 = "名無し<><>2019/11/30(土) 09:00:00.000 ID:ddd<> 本文 <>スレタイ3\n"
+)
+
+func TestImportDats(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4vip")
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Now()}), AdminConf(repo, nil))
+
+	archive := makeDatZip(t, map[string]string{
+		"dats/1575162000.dat": test_dat_1,
+		"dats/1575244800.dat": test_dat_2,
+		"dats/1575072000.dat": test_dat_3,
+	})
+	count, err := sv.Admin.ImportDats("news4vip", bytes.NewReader(archive), int64(len(archive)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("count = %v, want: 3", count)
+	}
+
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	subjects := repo.Subjects("news4vip")
+	if len(subjects) != 3 {
+		t.Fatalf("subjects = %v", subjects)
+	}
+	// 新しい順
+	tests := []struct {
+		threadKey    string
+		title        string
+		messageCount int
+		lastModified time.Time
+	}{
+		{"1575244800", "スレタイ2", 1, time.Date(2019, 12, 2, 9, 0, 0, 0, jst)},
+		{"1575162000", "スレタイ1", 3, time.Date(2019, 12, 1, 10, 5, 0, 345000000, jst)},
+		{"1575072000", "スレタイ3", 1, time.Date(2019, 11, 30, 9, 0, 0, 0, jst)},
+	}
+	for i, tt := range tests {
+		sbj := subjects[i]
+		if sbj.ThreadKey != tt.threadKey || sbj.ThreadTitle != tt.title ||
+			sbj.MessageCount != tt.messageCount || !sbj.LastModified.Equal(tt.lastModified) {
+			t.Errorf("%d: subject = %v, want: %v", i, sbj, tt)
+		}
+	}
+
+	entity := repo.DatMap["news4vip"]["1575162000"]
+	if !bytes.Equal(entity.Bytes, util.UTF8toSJIS([]byte(test_dat_1))) || !entity.Sjis || entity.Size != len(entity.Bytes) {
+		t.Errorf("dat = %v", entity)
+	}
+	if len(entity.Anchors) != 1 || entity.Anchors[0] != (dat.Anchor{From: 2, To: 1}) {
+		t.Errorf("Anchors = %v", entity.Anchors)
+	}
+
+	// 取り込んだスレに書き込める
+	stng := testutil.NewSettingStub()
+	if _, err := sv.WriteDat(stng, "news4vip", "1575162000", "名前", "sage", "ABCDEFGH", "本文"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportDats_SubjectTxt(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4vip")
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Now()}), AdminConf(repo, nil))
+
+	archive := makeDatZip(t, map[string]string{
+		"subject.txt":    "1575072000.dat<>スレタイ3 (1)\n1575162000.dat<>スレタイ1 (3)\n",
+		"1575162000.dat": test_dat_1,
+		"1575244800.dat": test_dat_2,
+		"1575072000.dat": test_dat_3,
+	})
+	if _, err := sv.Admin.ImportDats("news4vip", bytes.NewReader(archive), int64(len(archive)), false); err != nil {
+		t.Fatal(err)
+	}
+
+	// subject.txtに無いスレはdat落ち
+	subjects := repo.Subjects("news4vip")
+	if len(subjects) != 2 || subjects[0].ThreadKey != "1575072000" || subjects[1].ThreadKey != "1575162000" {
+		t.Errorf("subjects = %v", subjects)
+	}
+	if _, ok := repo.DatMap["news4vip"]["1575244800"]; !ok {
+		t.Error("archived dat is not imported")
+	}
+}
+
+func TestImportDats_Archived(t *testing.T) {
+
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{ThreadKey: "1575162000", ThreadTitle: "既存", MessageCount: 1, Dat: "a<>b<>c<>d<>既存\n"},
+		{ThreadKey: "1111111111", ThreadTitle: "既存", MessageCount: 1, Dat: "a<>b<>c<>d<>既存\n"},
+	})
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Now()}), AdminConf(repo, nil))
+
+	archive := makeDatZip(t, map[string]string{
+		"1575162000.dat": test_dat_1,
+		"1575244800.dat": test_dat_2,
+	})
+	if _, err := sv.Admin.ImportDats("news4vip", bytes.NewReader(archive), int64(len(archive)), true); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同じスレッドキーのスレもdat落ちになる
+	subjects := repo.Subjects("news4vip")
+	if len(subjects) != 1 || subjects[0].ThreadKey != "1111111111" {
+		t.Errorf("subjects = %v", subjects)
+	}
+	if n := len(repo.DatMap["news4vip"]); n != 3 {
+		t.Errorf("len(DatMap) = %v, want: 3", n)
+	}
+}
+
+// 一覧に入るのはSTUB_THREAD_COUNTまで
+func TestImportDats_ThreadLimit(t *testing.T) {
+
+	repo := testutil.NewBoardStub("news4vip", []testutil.ThreadStub{
+		{ThreadKey: "1575162000", ThreadTitle: "置き換える", MessageCount: 1, Dat: "a<>b<>c<>d<>既存\n"},
+		{ThreadKey: "1111111111", ThreadTitle: "既存", MessageCount: 1, Dat: "a<>b<>c<>d<>既存\n"},
+	})
+	repo.PutBoardSetting(repo.SettingKey("news4vip"), &setting.Entity{
+		Values: []setting.Value{{Name: "STUB_THREAD_COUNT", Value: "2"}},
+	})
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Now()}), AdminConf(repo, nil))
+
+	archive := makeDatZip(t, map[string]string{
+		"1575162000.dat": test_dat_1,
+		"1575244800.dat": test_dat_2,
+		"1575072000.dat": test_dat_3,
+	})
+	count, err := sv.Admin.ImportDats("news4vip", bytes.NewReader(archive), int64(len(archive)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("count = %v, want: 3", count)
+	}
+
+	// 既存のスレで1つ埋まっているので、新しい1つだけ一覧に入る
+	subjects := repo.Subjects("news4vip")
+	if len(subjects) != 2 || subjects[0].ThreadKey != "1575244800" || subjects[1].ThreadKey != "1111111111" {
+		t.Errorf("subjects = %v", subjects)
+	}
+	if n := len(repo.DatMap["news4vip"]); n != 4 {
+		t.Errorf("len(DatMap) = %v, want: 4", n)
+	}
+}
+
+func TestImportDats_Invalid(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		boardName string
+		files     map[string]string
+	}{
+		{"unknown board", "xxxx", map[string]string{"1575162000.dat": test_dat_1}},
+		{"no dat", "news4vip", map[string]string{"readme.txt": ""}},
+		{"thread key", "news4vip", map[string]string{"abc.dat": test_dat_1}},
+		{"empty", "news4vip", map[string]string{"1575162000.dat": ""}},
+		{"fields", "news4vip", map[string]string{"1575162000.dat": "a<>b<>c<>スレタイ\n"}},
+		{"no title", "news4vip", map[string]string{"1575162000.dat": "a<>b<>c<>d<>\n"}},
+		{"title", "news4vip", map[string]string{"1575162000.dat": "a<>b<>c<>d<>e\na<>b<>c<>d<>e\n"}},
+		{"subject.txt", "news4vip", map[string]string{"1575162000.dat": test_dat_1, "subject.txt": "xxx\n"}},
+		{"subject.txt dat", "news4vip", map[string]string{"1575162000.dat": test_dat_1, "subject.txt": "1575244800.dat<>x (1)\n"}},
+	}
+	for _, tt := range tests {
+		repo := testutil.EmptyBoardStub()
+		sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Now()}), AdminConf(repo, nil))
+		archive := makeDatZip(t, tt.files)
+		if _, err := sv.Admin.ImportDats(tt.boardName, bytes.NewReader(archive), int64(len(archive)), false); err == nil {
+			t.Errorf("%v: err is nil", tt.name)
+		}
+		if len(repo.BoardMap) != 0 {
+			t.Errorf("%v: BoardMap = %v", tt.name, repo.BoardMap)
+		}
+	}
+
+	// Shift_JISではない
+	if _, err := parseDat2ch("1575162000", []byte{0x82, 0x20, '<', '>', '\n'}, time.UTC); err == nil {
+		t.Error("err is nil")
+	}
+}
+
+func TestParseDat2chDate(t *testing.T) {
+
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	tests := []struct {
+		in   string
+		want time.Time
+		ok   bool
+	}{
+		{"2020/01/18(土) 18:16:51.345 ID:X", time.Date(2020, 1, 18, 18, 16, 51, 345000000, jst), true},
+		{"2020/01/18(土) 18:16:51.34 ID:X", time.Date(2020, 1, 18, 18, 16, 51, 340000000, jst), true},
+		{"2020/1/8(水) 8:16:51", time.Date(2020, 1, 8, 8, 16, 51, 0, jst), true},
+		{"04/01/18(日) 18:16:51", time.Date(2004, 1, 18, 18, 16, 51, 0, jst), true},
+		{"あぼーん", time.Time{}, false},
+		{"停止", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseDat2chDate(tt.in, jst)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("parseDat2chDate(%v) = %v, %v, want: %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/tempxla/stub2ch/configs/app/bbscfg"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/maintenance"
+	"log"
+	"sort"
+	"time"
+)
+
+// 管理画面用のメンテナンス予定
+type MaintenanceSchedule struct {
+	BoardName string // 空なら全体
+	maintenance.Entity
+	Active bool
+}
+
+func maintenanceKeyName(boardName string) string {
+	if boardName == "" {
+		return maintenance.GLOBAL
+	}
+	return boardName
+}
+
+// 今の板のメンテナンス状態を返す。メンテナンス中でなければ nil を返す。
+// 全体と板の両方がメンテナンス中ならclosedの方を返す。
+func (sv *BoardService) GetMaintenance(boardName string) (*maintenance.Entity, error) {
+	now := sv.StartedAt()
+
+	var active *maintenance.Entity
+	for _, name := range []string{maintenance.GLOBAL, boardName} {
+		entity := &maintenance.Entity{}
+		err := sv.repo.GetMaintenance(sv.repo.MaintenanceKey(name), entity)
+		if err == datastore.ErrNoSuchEntity {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !entity.Active(now) {
+			continue
+		}
+		if active == nil || (active.Mode != maintenance.MODE_CLOSED && entity.Mode == maintenance.MODE_CLOSED) {
+			active = entity
+		}
+	}
+	return active, nil
+}
+
+// 全体と各板のメンテナンス予定を返す
+func (admin *AdminFunction) ListMaintenance() ([]*MaintenanceSchedule, error) {
+	now := admin.env.StartedAt()
+
+	boardNames := bbscfg.GetAllBoardName()
+	sort.Strings(boardNames)
+
+	list := []*MaintenanceSchedule{}
+	for _, boardName := range append([]string{""}, boardNames...) {
+		entity := &maintenance.Entity{}
+		err := admin.repo.GetMaintenance(admin.repo.MaintenanceKey(maintenanceKeyName(boardName)), entity)
+		if err == datastore.ErrNoSuchEntity {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, &MaintenanceSchedule{
+			BoardName: boardName,
+			Entity:    *entity,
+			Active:    entity.Active(now),
+		})
+	}
+	return list, nil
+}
+
+// メンテナンスを予定する。boardNameが空なら全ての板が対象。
+// startAt, endAtはゼロなら期限なし。
+func (admin *AdminFunction) SetMaintenance(boardName, mode, message string, startAt, endAt time.Time) error {
+	log.Printf("SetMaintenance: %q %v %v - %v", boardName, mode, startAt, endAt)
+
+	if boardName != "" && bbscfg.GetSetting(boardName) == nil {
+		return fmt.Errorf("unknown board: %v", boardName)
+	}
+	switch mode {
+	case maintenance.MODE_READ_ONLY, maintenance.MODE_CLOSED:
+	default:
+		return fmt.Errorf("unknown mode: %v", mode)
+	}
+	if !startAt.IsZero() && !endAt.IsZero() && !startAt.Before(endAt) {
+		return fmt.Errorf("end must be after start")
+	}
+
+	entity := &maintenance.Entity{
+		Mode:      mode,
+		Message:   message,
+		StartAt:   startAt,
+		EndAt:     endAt,
+		UpdatedAt: admin.env.StartedAt(),
+	}
+	return admin.repo.PutMaintenance(admin.repo.MaintenanceKey(maintenanceKeyName(boardName)), entity)
+}
+
+// メンテナンスを終わらせる
+func (admin *AdminFunction) ClearMaintenance(boardName string) error {
+	log.Printf("ClearMaintenance: %q", boardName)
+
+	if boardName != "" && bbscfg.GetSetting(boardName) == nil {
+		return fmt.Errorf("unknown board: %v", boardName)
+	}
+	return admin.repo.DeleteMaintenance(admin.repo.MaintenanceKey(maintenanceKeyName(boardName)))
+}
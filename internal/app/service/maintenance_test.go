@@ -0,0 +1,117 @@
+package service
+
+import (
+	"github.com/tempxla/stub2ch/internal/app/types/entity/maintenance"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"testing"
+	"time"
+)
+
+func TestGetMaintenance(t *testing.T) {
+
+	now := time.Date(2020, 1, 18, 12, 0, 0, 0, time.UTC)
+	repo := testutil.EmptyBoardStub()
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: now}), AdminConf(repo, nil))
+
+	// メンテナンスなし
+	if m, err := sv.GetMaintenance("news4vip"); m != nil || err != nil {
+		t.Errorf("GetMaintenance() = %v, %v", m, err)
+	}
+
+	// 板だけ
+	if err := sv.Admin.SetMaintenance("news4vip", maintenance.MODE_READ_ONLY, "よみこみ", time.Time{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := sv.GetMaintenance("news4vip"); err != nil || m == nil || m.Mode != maintenance.MODE_READ_ONLY {
+		t.Errorf("GetMaintenance() = %v, %v", m, err)
+	}
+	if m, err := sv.GetMaintenance("poverty"); m != nil || err != nil {
+		t.Errorf("GetMaintenance(poverty) = %v, %v", m, err)
+	}
+
+	// 全体がclosedならそちらが優先
+	if err := sv.Admin.SetMaintenance("", maintenance.MODE_CLOSED, "しまってます", now.Add(-time.Hour), now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for _, boardName := range []string{"news4vip", "poverty"} {
+		if m, err := sv.GetMaintenance(boardName); err != nil || m == nil || m.Mode != maintenance.MODE_CLOSED || m.Message != "しまってます" {
+			t.Errorf("GetMaintenance(%v) = %v, %v", boardName, m, err)
+		}
+	}
+
+	// 期間外
+	if err := sv.Admin.SetMaintenance("", maintenance.MODE_CLOSED, "", now.Add(time.Hour), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := sv.GetMaintenance("news4vip"); err != nil || m == nil || m.Mode != maintenance.MODE_READ_ONLY {
+		t.Errorf("GetMaintenance() = %v, %v", m, err)
+	}
+	if err := sv.Admin.SetMaintenance("", maintenance.MODE_CLOSED, "", time.Time{}, now); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := sv.GetMaintenance("poverty"); m != nil || err != nil {
+		t.Errorf("GetMaintenance(poverty) = %v, %v", m, err)
+	}
+
+	// データストアのエラー
+	sv = NewBoardService(RepoConf(testutil.NewBrokenBoardStub()), EnvConf(&SysEnv{StartedTime: now}))
+	if _, err := sv.GetMaintenance("news4vip"); err == nil {
+		t.Error("err is nil")
+	}
+}
+
+func TestListMaintenance(t *testing.T) {
+
+	now := time.Date(2020, 1, 18, 12, 0, 0, 0, time.UTC)
+	repo := testutil.EmptyBoardStub()
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: now}), AdminConf(repo, nil))
+
+	sv.Admin.SetMaintenance("", maintenance.MODE_CLOSED, "", now.Add(time.Hour), time.Time{})
+	sv.Admin.SetMaintenance("poverty", maintenance.MODE_READ_ONLY, "", time.Time{}, time.Time{})
+
+	list, err := sv.Admin.ListMaintenance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 ||
+		list[0].BoardName != "" || list[0].Active ||
+		list[1].BoardName != "poverty" || !list[1].Active {
+		t.Errorf("ListMaintenance() = %v", list)
+	}
+
+	if err := sv.Admin.ClearMaintenance(""); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := sv.Admin.ListMaintenance(); len(list) != 1 {
+		t.Errorf("ListMaintenance() = %v", list)
+	}
+}
+
+func TestSetMaintenance_Invalid(t *testing.T) {
+
+	now := time.Date(2020, 1, 18, 12, 0, 0, 0, time.UTC)
+	repo := testutil.EmptyBoardStub()
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: now}), AdminConf(repo, nil))
+
+	tests := []struct {
+		boardName string
+		mode      string
+		startAt   time.Time
+		endAt     time.Time
+	}{
+		{"xxxx", maintenance.MODE_CLOSED, time.Time{}, time.Time{}},
+		{"news4vip", "xxxx", time.Time{}, time.Time{}},
+		{"news4vip", maintenance.MODE_CLOSED, now, now},
+	}
+	for _, tt := range tests {
+		if err := sv.Admin.SetMaintenance(tt.boardName, tt.mode, "", tt.startAt, tt.endAt); err == nil {
+			t.Errorf("%v: err is nil", tt)
+		}
+	}
+	if err := sv.Admin.ClearMaintenance("xxxx"); err == nil {
+		t.Error("err is nil")
+	}
+	if len(repo.MaintMap) != 0 {
+		t.Errorf("MaintMap = %v", repo.MaintMap)
+	}
+}
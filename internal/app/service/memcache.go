@@ -40,11 +40,14 @@ func (mem *AlterMemcache) Get(key string) (*memcache.Item, error) {
 	err := mem.client.Get(mem.context, dskey, memItem)
 	if err != nil {
 		if err == datastore.ErrNoSuchEntity {
+			metricCacheRequests.Inc("miss")
 			return nil, memcache.ErrCacheMiss
 		} else {
+			metricCacheRequests.Inc("error")
 			return nil, err
 		}
 	}
+	metricCacheRequests.Inc("hit")
 	item := &memcache.Item{
 		Key:        key,
 		Value:      memItem.Value,
@@ -0,0 +1,19 @@
+package service
+
+import (
+	"github.com/tempxla/stub2ch/internal/app/metrics"
+)
+
+var (
+	metricWriteCount = metrics.NewGauge("stub2ch_board_write_count",
+		"Writes counted against STUB_WRITE_ENTITY_LIMIT by board.", "board")
+	metricWriteLimit = metrics.NewGauge("stub2ch_board_write_limit",
+		"STUB_WRITE_ENTITY_LIMIT by board.", "board")
+	metricCacheRequests = metrics.NewCounter("stub2ch_cache_requests_total",
+		"Memcache lookups by result (hit, miss, error).", "result")
+)
+
+func setWriteCountMetric(boardName string, writeCount, limit int) {
+	metricWriteCount.Set(float64(writeCount), boardName)
+	metricWriteLimit.Set(float64(limit), boardName)
+}
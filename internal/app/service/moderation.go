@@ -0,0 +1,247 @@
+package service
+
+import (
+	"bytes"
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/thread"
+	"github.com/tempxla/stub2ch/internal/app/types/errors"
+	"github.com/tempxla/stub2ch/internal/app/util"
+	"log"
+	"strings"
+)
+
+// 一覧にあるスレのエンティティを読む
+func txGetLiveThread(admin *AdminFunction, tx *datastore.Transaction, key *thread.Key, entity *thread.Entity) error {
+	err := admin.repo.TxGetThread(tx, key, entity)
+	if err == datastore.ErrNoSuchEntity || (err == nil && !entity.Live) {
+		return fmt.Errorf("no such thread: %v", key.DSKey.Name)
+	}
+	return err
+}
+
+// スレッドを停止する。
+// 停止したことをdatに追記し、以降の書き込みはできなくなる。
+func (admin *AdminFunction) StopThread(boardName, threadKey string) error {
+	log.Printf("StopThread: %v/%v", boardName, threadKey)
+
+	now := admin.env.StartedAt()
+	boardKey := admin.repo.BoardKey(boardName)
+	datKey := admin.repo.DatKey(threadKey, boardKey)
+	threadEntityKey := admin.repo.ThreadKey(threadKey, boardKey)
+
+	return admin.repo.RunInTransaction(func(tx *datastore.Transaction) error {
+		th := &thread.Entity{}
+		if err := txGetLiveThread(admin, tx, threadEntityKey, th); err != nil {
+			return err
+		}
+		if th.Stopped {
+			return fmt.Errorf("already stopped: %v", threadKey)
+		}
+
+		datEntity := &dat.Entity{}
+		if err := admin.repo.TxGetDatMeta(tx, datKey, datEntity); err != nil {
+			return err
+		}
+		// 旧形式のエンティティはサイズを持っていない
+		if datEntity.ChunkCount == 0 && !datEntity.Sjis {
+			datEntity.Size = len(util.UTF8toSJIS(datEntity.Bytes))
+		}
+
+		line := []byte(dat_format_stop)
+		sjisLine := util.UTF8toSJIS(line)
+		datEntity.Size += len(sjisLine)
+		if datEntity.Sjis {
+			line = sjisLine
+		}
+		datEntity.LastModified = now
+
+		th.Stopped = true
+		th.MessageCount++
+		th.LastModified = now
+
+		if err := admin.repo.TxAppendDat(tx, datKey, datEntity, line); err != nil {
+			return err
+		}
+		return admin.repo.TxPutThread(tx, threadEntityKey, th)
+	})
+}
+
+// スレタイを変更する。subject.txtとdatの1行目の両方を書き換える。
+func (admin *AdminFunction) RenameThread(boardName, threadKey, title string) error {
+	log.Printf("RenameThread: %v/%v %v", boardName, threadKey, title)
+
+	title = escapeDat(util.EscapeHTML(strings.TrimSpace(title)))
+	if title == "" {
+		return fmt.Errorf("title is blank")
+	}
+
+	boardKey := admin.repo.BoardKey(boardName)
+	datKey := admin.repo.DatKey(threadKey, boardKey)
+	threadEntityKey := admin.repo.ThreadKey(threadKey, boardKey)
+
+	return admin.repo.RunInTransaction(func(tx *datastore.Transaction) error {
+		th := &thread.Entity{}
+		if err := txGetLiveThread(admin, tx, threadEntityKey, th); err != nil {
+			return err
+		}
+
+		datEntity := &dat.Entity{}
+		if err := admin.repo.TxGetDat(tx, datKey, datEntity); err != nil {
+			return err
+		}
+		if err := renameDatTitle(datEntity, title); err != nil {
+			return err
+		}
+		th.ThreadTitle = title
+
+		if err := admin.repo.TxPutDat(tx, datKey, datEntity); err != nil {
+			return err
+		}
+		return admin.repo.TxPutThread(tx, threadEntityKey, th)
+	})
+}
+
+// 1行目の最後の<>以降がスレタイ
+func renameDatTitle(datEntity *dat.Entity, title string) error {
+	b := datEntity.Bytes
+	end := bytes.IndexByte(b, '\n')
+	if end == -1 {
+		return fmt.Errorf("broken dat: no lines")
+	}
+	start := bytes.LastIndex(b[:end], []byte("<>"))
+	if start == -1 {
+		return fmt.Errorf("broken dat: no title")
+	}
+	start += len("<>")
+
+	t := []byte(title)
+	if datEntity.Sjis {
+		t = util.UTF8toSJIS(t)
+	}
+
+	buf := make([]byte, 0, len(b)-(end-start)+len(t))
+	buf = append(buf, b[:start]...)
+	buf = append(buf, t...)
+	buf = append(buf, b[end:]...)
+	datEntity.Bytes = buf
+	if datEntity.Sjis {
+		datEntity.Size = len(buf)
+	}
+	return nil
+}
+
+// スレを先頭に固定する、または固定をやめる
+func (admin *AdminFunction) PinThread(boardName, threadKey string, pinned bool) error {
+	log.Printf("PinThread: %v/%v %v", boardName, threadKey, pinned)
+
+	threadEntityKey := admin.repo.ThreadKey(threadKey, admin.repo.BoardKey(boardName))
+
+	return admin.repo.RunInTransaction(func(tx *datastore.Transaction) error {
+		th := &thread.Entity{}
+		if err := txGetLiveThread(admin, tx, threadEntityKey, th); err != nil {
+			return err
+		}
+		th.Pinned = pinned
+		return admin.repo.TxPutThread(tx, threadEntityKey, th)
+	})
+}
+
+// スレを別の板に移動する。
+// datとスレのエンティティを移動先の板の下に作り直す。
+// 移動先では一覧の先頭に来る。移動先がSTUB_THREAD_COUNTに達していれば移さない。
+// 検索の索引も移動先の板に付け替える。
+func (admin *AdminFunction) MoveThread(boardName, threadKey, toBoardName string) error {
+	log.Printf("MoveThread: %v/%v -> %v", boardName, threadKey, toBoardName)
+
+	if boardName == toBoardName {
+		return fmt.Errorf("same board: %v", boardName)
+	}
+
+	stng, _, err := loadSetting(admin.repo, toBoardName)
+	if err != nil {
+		return err
+	}
+	if stng == nil {
+		return fmt.Errorf("unknown board: %v", toBoardName)
+	}
+
+	now := admin.env.StartedAt()
+	fromBoardKey := admin.repo.BoardKey(boardName)
+	fromDatKey := admin.repo.DatKey(threadKey, fromBoardKey)
+	fromThreadKey := admin.repo.ThreadKey(threadKey, fromBoardKey)
+	toBoardKey := admin.repo.BoardKey(toBoardName)
+	toDatKey := admin.repo.DatKey(threadKey, toBoardKey)
+	toThreadKey := admin.repo.ThreadKey(threadKey, toBoardKey)
+
+	err = admin.repo.RunInTransaction(func(tx *datastore.Transaction) error {
+		// 移動先の板
+		if err := admin.repo.TxGetBoard(tx, toBoardKey, &board.Entity{}); err != nil {
+			return err
+		}
+		// 移動先がスレ立てできない数まで埋まっていたら移さない。
+		// 同時にスレが立ったときに超えないよう、トランザクションの中で数える
+		n, err := admin.repo.TxCountLiveThreads(tx, toBoardKey)
+		if err != nil {
+			return err
+		}
+		if n >= stng.STUB_THREAD_COUNT() {
+			return errors.New(errors.THREAD_LIMIT, "%d: 移動先の板はこれ以上スレを置けません。", n)
+		}
+
+		th := &thread.Entity{}
+		if err := txGetLiveThread(admin, tx, fromThreadKey, th); err != nil {
+			return err
+		}
+		// dat落ちしたスレが残っているかもしれない
+		err = admin.repo.TxGetThread(tx, toThreadKey, &thread.Entity{})
+		if err == nil {
+			return fmt.Errorf("thread key is duplicate: %v/%v", toBoardName, threadKey)
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		err = admin.repo.TxGetDatMeta(tx, toDatKey, &dat.Entity{})
+		if err == nil {
+			return fmt.Errorf("thread key is duplicate: %v/%v", toBoardName, threadKey)
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		datEntity := &dat.Entity{}
+		if err := admin.repo.TxGetDat(tx, fromDatKey, datEntity); err != nil {
+			return err
+		}
+
+		// スレッド一覧
+		th.Pinned = false
+		th.AgedAt = now
+
+		// 移動先にはまだチャンクが無い
+		moved := *datEntity
+		moved.ChunkCount = 0
+
+		if err := admin.repo.TxPutDat(tx, toDatKey, &moved); err != nil {
+			return err
+		}
+		if err := admin.repo.TxDeleteDat(tx, fromDatKey, datEntity); err != nil {
+			return err
+		}
+		if err := admin.repo.TxPutThread(tx, toThreadKey, th); err != nil {
+			return err
+		}
+		return admin.repo.TxDeleteThread(tx, fromThreadKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	// 索引が移せなくてもスレは移っているので、作り直せばよい
+	if err := admin.moveThreadPosts(boardName, threadKey, toBoardName); err != nil {
+		log.Printf("ERROR: MoveThread: search index is not moved, rebuild it: %v", err)
+	}
+	return nil
+}
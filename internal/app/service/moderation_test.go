@@ -0,0 +1,276 @@
+package service
+
+import (
+	"bytes"
+	"cloud.google.com/go/datastore"
+	"github.com/tempxla/stub2ch/internal/app/types/errors"
+	"github.com/tempxla/stub2ch/internal/app/util"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStopThread(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4test")
+	sysEnv := &SysEnv{StartedTime: testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")}
+	sv := NewBoardService(RepoConf(repo), EnvConf(sysEnv), AdminConf(repo, nil))
+	stng := testutil.NewSettingStub()
+
+	threadKey, err := sv.CreateThread(stng, "news4test", "name1", "mail1", "ABCDEFGH01", "message1", "title1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sv.Admin.StopThread("news4test", threadKey); err != nil {
+		t.Fatal(err)
+	}
+
+	sbj := repo.Subjects("news4test")[0]
+	if !sbj.Stopped || sbj.MessageCount != 2 {
+		t.Errorf("subject = %v", sbj)
+	}
+	entity := repo.DatMap["news4test"][threadKey]
+	if !bytes.HasSuffix(entity.Bytes, util.UTF8toSJIS([]byte(dat_format_stop))) {
+		t.Errorf("dat = %v", string(util.SJIStoUTF8(entity.Bytes)))
+	}
+	if entity.Size != len(entity.Bytes) {
+		t.Errorf("entity.Size = %v, want: %v", entity.Size, len(entity.Bytes))
+	}
+
+	// 停止したスレには書き込めない
+	if _, err := sv.WriteDat(stng, "news4test", threadKey, "name2", "", "ABCDEFGH02", "message2"); err == nil {
+		t.Error("err is nil")
+	}
+	// 二重に停止しない
+	if err := sv.Admin.StopThread("news4test", threadKey); err == nil {
+		t.Error("err is nil")
+	}
+	// 存在しないスレ
+	if err := sv.Admin.StopThread("news4test", "9999999999"); err == nil {
+		t.Error("err is nil")
+	}
+}
+
+func TestRenameThread(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4test")
+	sysEnv := &SysEnv{StartedTime: testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")}
+	sv := NewBoardService(RepoConf(repo), EnvConf(sysEnv), AdminConf(repo, nil))
+	stng := testutil.NewSettingStub()
+
+	threadKey, err := sv.CreateThread(stng, "news4test", "name1", "mail1", "ABCDEFGH01", "message1", "title1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.WriteDat(stng, "news4test", threadKey, "name2", "", "ABCDEFGH02", "message2"); err != nil {
+		t.Fatal(err)
+	}
+	before := strings.Split(string(util.SJIStoUTF8(repo.DatMap["news4test"][threadKey].Bytes)), "\n")
+
+	if err := sv.Admin.RenameThread("news4test", threadKey, " 新しい<スレタイ> "); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "新しい&lt;スレタイ&gt;"
+	if title := repo.Subjects("news4test")[0].ThreadTitle; title != want {
+		t.Errorf("ThreadTitle = %v, want: %v", title, want)
+	}
+	entity := repo.DatMap["news4test"][threadKey]
+	after := strings.Split(string(util.SJIStoUTF8(entity.Bytes)), "\n")
+	if !strings.HasSuffix(after[0], "<>"+want) || strings.HasSuffix(before[0], "<>"+want) {
+		t.Errorf("first line = %v", after[0])
+	}
+	if strings.TrimSuffix(after[0], want) != strings.TrimSuffix(before[0], "title1") {
+		t.Errorf("first line = %v, before: %v", after[0], before[0])
+	}
+	if after[1] != before[1] {
+		t.Errorf("second line = %v, want: %v", after[1], before[1])
+	}
+	if entity.Size != len(entity.Bytes) {
+		t.Errorf("entity.Size = %v, want: %v", entity.Size, len(entity.Bytes))
+	}
+
+	// 空のスレタイ
+	if err := sv.Admin.RenameThread("news4test", threadKey, "  "); err == nil {
+		t.Error("err is nil")
+	}
+}
+
+func TestPinThread(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4test")
+	env := &SysEnv{StartedTime: testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")}
+	sv := NewBoardService(RepoConf(repo), EnvConf(env), AdminConf(repo, nil))
+	stng := testutil.NewSettingStub()
+
+	var keys []string
+	for _, title := range []string{"title1", "title2", "title3"} {
+		// スレッドキーが重複しないように時刻をずらす
+		env.StartedTime = env.StartedTime.Add(time.Second)
+		key, err := sv.CreateThread(stng, "news4test", "name", "", "ABCDEFGH01", "message", title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := sv.Admin.PinThread("news4test", keys[0], true); err != nil {
+		t.Fatal(err)
+	}
+	subjects := repo.Subjects("news4test")
+	if subjects[0].ThreadKey != keys[0] || !subjects[0].Pinned {
+		t.Errorf("subjects = %v", subjects)
+	}
+
+	// 書き込んでも固定したスレが先頭
+	env.StartedTime = env.StartedTime.Add(time.Second)
+	if _, err := sv.WriteDat(stng, "news4test", keys[1], "name", "", "ABCDEFGH02", "message"); err != nil {
+		t.Fatal(err)
+	}
+	subjects = repo.Subjects("news4test")
+	if subjects[0].ThreadKey != keys[0] || subjects[1].ThreadKey != keys[1] {
+		t.Errorf("subjects = %v", subjects)
+	}
+
+	if err := sv.Admin.PinThread("news4test", keys[0], false); err != nil {
+		t.Fatal(err)
+	}
+	if repo.Subjects("news4test")[0].Pinned {
+		t.Error("still pinned")
+	}
+}
+
+func TestMoveThread(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4test", "poverty")
+	sysEnv := &SysEnv{StartedTime: testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")}
+	sv := NewBoardService(RepoConf(repo), EnvConf(sysEnv), AdminConf(repo, nil))
+	stng := testutil.NewSettingStub()
+
+	threadKey, err := sv.CreateThread(stng, "news4test", "name1", "mail1", "ABCDEFGH01", "message1", "title1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	datBytes := repo.DatMap["news4test"][threadKey].Bytes
+
+	if err := sv.Admin.MoveThread("news4test", threadKey, "poverty"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(repo.Subjects("news4test")); n != 0 {
+		t.Errorf("len(from.Subjects) = %v", n)
+	}
+	if _, ok := repo.DatMap["news4test"][threadKey]; ok {
+		t.Error("dat is not deleted")
+	}
+	subjects := repo.Subjects("poverty")
+	if len(subjects) != 1 || subjects[0].ThreadKey != threadKey || subjects[0].ThreadTitle != "title1" {
+		t.Errorf("to.Subjects = %v", subjects)
+	}
+	entity, ok := repo.DatMap["poverty"][threadKey]
+	if !ok || !bytes.Equal(entity.Bytes, datBytes) {
+		t.Errorf("dat is not moved: %v", entity)
+	}
+	// 検索の索引も移る
+	if _, ok := repo.PostMap["news4test/"+threadKey+"/1"]; ok {
+		t.Error("post is not deleted")
+	}
+	if e, ok := repo.PostMap["poverty/"+threadKey+"/1"]; !ok || e.Board != "poverty" {
+		t.Errorf("post is not moved: %v", e)
+	}
+
+	// 移動したスレに書き込める
+	if _, err := sv.WriteDat(stng, "poverty", threadKey, "name2", "", "ABCDEFGH02", "message2"); err != nil {
+		t.Error(err)
+	}
+
+	// 同じ板
+	if err := sv.Admin.MoveThread("poverty", threadKey, "poverty"); err == nil {
+		t.Error("err is nil")
+	}
+	// 存在しない板
+	if err := sv.Admin.MoveThread("poverty", threadKey, "xxxx"); err == nil {
+		t.Error("err is nil")
+	}
+	// 移動元にないスレ
+	if err := sv.Admin.MoveThread("news4test", threadKey, "poverty"); err == nil {
+		t.Error("err is nil")
+	}
+}
+
+// 移動先がいっぱい
+func TestMoveThread_ThreadLimit(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4test", "poverty")
+	sysEnv := &SysEnv{StartedTime: testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")}
+	sv := NewBoardService(RepoConf(repo), EnvConf(sysEnv), AdminConf(repo, nil))
+	stng := testutil.NewSettingStub()
+
+	if err := sv.Admin.UpdateBoardSetting("poverty", map[string]string{"STUB_THREAD_COUNT": "1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.CreateThread(stng, "poverty", "name1", "", "ABCDEFGH01", "message1", "title1"); err != nil {
+		t.Fatal(err)
+	}
+	threadKey, err := sv.CreateThread(stng, "news4test", "name2", "", "ABCDEFGH02", "message2", "title2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sv.Admin.MoveThread("news4test", threadKey, "poverty")
+	if errors.KindOf(err) != errors.THREAD_LIMIT {
+		t.Errorf("err = %v", err)
+	}
+	if n := len(repo.Subjects("news4test")); n != 1 {
+		t.Errorf("len(from.Subjects) = %v", n)
+	}
+	if n := len(repo.Subjects("poverty")); n != 1 {
+		t.Errorf("len(to.Subjects) = %v", n)
+	}
+}
+
+// トランザクションを始める直前に別のリクエストでスレが立つ
+type raceThreadStub struct {
+	*testutil.BoardStub
+	race func()
+}
+
+func (repo *raceThreadStub) RunInTransaction(f func(tx *datastore.Transaction) error) error {
+	if race := repo.race; race != nil {
+		repo.race = nil
+		race()
+	}
+	return repo.BoardStub.RunInTransaction(f)
+}
+
+// 移動先の数はトランザクションの中で数える
+func TestMoveThread_ThreadLimitRace(t *testing.T) {
+
+	stub := testutil.InitialBoardStub("news4test", "poverty")
+	repo := &raceThreadStub{BoardStub: stub}
+	sysEnv := &SysEnv{StartedTime: testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")}
+	sv := NewBoardService(RepoConf(repo), EnvConf(sysEnv), AdminConf(repo, nil))
+	stng := testutil.NewSettingStub()
+
+	if err := sv.Admin.UpdateBoardSetting("poverty", map[string]string{"STUB_THREAD_COUNT": "1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	threadKey, err := sv.CreateThread(stng, "news4test", "name1", "", "ABCDEFGH01", "message1", "title1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo.race = func() {
+		if _, err := sv.CreateThread(stng, "poverty", "name2", "", "ABCDEFGH02", "message2", "title2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = sv.Admin.MoveThread("news4test", threadKey, "poverty")
+	if errors.KindOf(err) != errors.THREAD_LIMIT {
+		t.Errorf("err = %v", err)
+	}
+	if n := len(stub.Subjects("poverty")); n != 1 {
+		t.Errorf("len(to.Subjects) = %v", n)
+	}
+}
@@ -0,0 +1,23 @@
+package repository
+
+import (
+	"github.com/tempxla/stub2ch/internal/app/metrics"
+	"time"
+)
+
+var (
+	metricOperationSeconds = metrics.NewHistogram("stub2ch_repository_operation_duration_seconds",
+		"Datastore operation latency by operation.", metrics.DefaultBuckets, "op")
+	metricTransactions = metrics.NewCounter("stub2ch_repository_transactions_total",
+		"Datastore transactions run.")
+	metricTransactionRetries = metrics.NewCounter("stub2ch_repository_transaction_retries_total",
+		"Datastore transaction attempts retried after a conflict.")
+)
+
+// defer observe("GetBoard")() のように使う
+func observe(op string) func() {
+	start := time.Now()
+	return func() {
+		metricOperationSeconds.Observe(time.Since(start).Seconds(), op)
+	}
+}
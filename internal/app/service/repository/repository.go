@@ -1,10 +1,21 @@
 package repository
 
 import (
+	"bytes"
 	"cloud.google.com/go/datastore"
 	"context"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/audit"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/counter"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/maintenance"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/post"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/session"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/setting"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/thread"
+	"google.golang.org/api/iterator"
+	"strconv"
+	"time"
 )
 
 type BoardRepository interface {
@@ -19,11 +30,54 @@ type BoardRepository interface {
 	TxGetBoard(tx *datastore.Transaction, key *board.Key, entity *board.Entity) (err error)
 	TxPutBoard(tx *datastore.Transaction, key *board.Key, entity *board.Entity) (err error)
 	TxGetDat(tx *datastore.Transaction, key *dat.Key, entity *dat.Entity) (err error)
+	TxGetDatMeta(tx *datastore.Transaction, key *dat.Key, entity *dat.Entity) (err error)
 	TxPutDat(tx *datastore.Transaction, key *dat.Key, entity *dat.Entity) (err error)
+	TxAppendDat(tx *datastore.Transaction, key *dat.Key, entity *dat.Entity, line []byte) (err error)
+	TxDeleteDat(tx *datastore.Transaction, key *dat.Key, entity *dat.Entity) (err error)
 	TxGetAllBoard(tx *datastore.Transaction, entities *[]*board.Entity) (keys []*board.Key, err error)
 	TxPutMultiBoard(tx *datastore.Transaction, keys []*board.Key, entities []*board.Entity) (err error)
+	PutAuditLog(entity *audit.Entity) (err error)
+	GetAuditLogs(since, until time.Time, limit int, entities *[]*audit.Entity) (err error)
+	SessionKey(name string) (key *session.Key)
+	GetSession(key *session.Key, entity *session.Entity) (err error)
+	PutSession(key *session.Key, entity *session.Entity) (err error)
+	DeleteSession(key *session.Key) (err error)
+	GetAllSession(entities *[]*session.Entity) (keys []*session.Key, err error)
+	SettingKey(name string) (key *setting.Key)
+	GetBoardSetting(key *setting.Key, entity *setting.Entity) (err error)
+	PutBoardSetting(key *setting.Key, entity *setting.Entity) (err error)
+	DeleteBoardSetting(key *setting.Key) (err error)
+	MaintenanceKey(name string) (key *maintenance.Key)
+	GetMaintenance(key *maintenance.Key, entity *maintenance.Entity) (err error)
+	PutMaintenance(key *maintenance.Key, entity *maintenance.Entity) (err error)
+	DeleteMaintenance(key *maintenance.Key) (err error)
+	ThreadKey(name string, parent *board.Key) (key *thread.Key)
+	GetThreads(parent *board.Key, liveOnly bool, entities *[]*thread.Entity) (keys []*thread.Key, err error)
+	GetDatKeys(parent *board.Key) (keys []*dat.Key, err error)
+	TxCountLiveThreads(tx *datastore.Transaction, parent *board.Key) (count int, err error)
+	TxGetThread(tx *datastore.Transaction, key *thread.Key, entity *thread.Entity) (err error)
+	TxPutThread(tx *datastore.Transaction, key *thread.Key, entity *thread.Entity) (err error)
+	TxDeleteThread(tx *datastore.Transaction, key *thread.Key) (err error)
+	WriteCounterKey(shard int, parent *board.Key) (key *counter.Key)
+	GetWriteCounters(parent *board.Key, entities *[]*counter.Entity) (keys []*counter.Key, err error)
+	TxGetWriteCounter(tx *datastore.Transaction, key *counter.Key, entity *counter.Entity) (err error)
+	TxPutWriteCounter(tx *datastore.Transaction, key *counter.Key, entity *counter.Entity) (err error)
+	DeleteWriteCounters(keys []*counter.Key) (err error)
+	PostKey(boardName, threadKey string, resnum int) (key *post.Key)
+	TxPutPost(tx *datastore.Transaction, key *post.Key, entity *post.Entity) (err error)
+	PutMultiPost(keys []*post.Key, entities []*post.Entity) (err error)
+	SearchPosts(query *post.Query, cursor string, limit int, entities *[]*post.Entity) (next string, err error)
+	DeleteMultiPost(keys []*post.Key) (err error)
+	DeleteBoardPosts(boardName string) (err error)
 }
 
+var (
+	// エンティティの上限(1MiB)に収まるように分割する
+	dat_chunk_size = 512 * 1024
+	// PutMulti, DeleteMulti の1回の上限
+	multi_batch_size = 500
+)
+
 type BoardStore struct {
 	context context.Context
 	client  *datastore.Client
@@ -49,26 +103,44 @@ func (repo *BoardStore) DatKey(name string, parent *board.Key) (key *dat.Key) {
 }
 
 func (repo *BoardStore) GetBoard(key *board.Key, entity *board.Entity) (err error) {
+	defer observe("GetBoard")()
 	err = repo.client.Get(repo.context, key.DSKey, entity)
 	return
 }
 
 func (repo *BoardStore) PutBoard(key *board.Key, entity *board.Entity) (err error) {
+	defer observe("PutBoard")()
 	_, err = repo.client.Put(repo.context, key.DSKey, entity)
 	return
 }
 
 func (repo *BoardStore) GetDat(key *dat.Key, entity *dat.Entity) (err error) {
-	err = repo.client.Get(repo.context, key.DSKey, entity)
+	defer observe("GetDat")()
+	if err = repo.client.Get(repo.context, key.DSKey, entity); err != nil {
+		return
+	}
+	if entity.ChunkCount == 0 {
+		// 旧形式
+		return
+	}
+	chunks := make([]dat.Chunk, entity.ChunkCount)
+	if err = repo.client.GetMulti(repo.context, datChunkKeys(key, entity.ChunkCount), chunks); err != nil {
+		return
+	}
+	entity.Bytes = joinDatChunks(chunks)
 	return
 }
 
 func (repo *BoardStore) PutDat(key *dat.Key, entity *dat.Entity) (err error) {
-	_, err = repo.client.Put(repo.context, key.DSKey, entity)
+	defer observe("PutDat")()
+	_, err = repo.client.RunInTransaction(repo.context, func(tx *datastore.Transaction) error {
+		return repo.TxPutDat(tx, key, entity)
+	})
 	return
 }
 
 func (repo *BoardStore) GetAllBoard(entities *[]*board.Entity) (keys []*board.Key, err error) {
+	defer observe("GetAllBoard")()
 	ks, err := repo.client.GetAll(repo.context, datastore.NewQuery(board.KIND), entities)
 	if err != nil {
 		return
@@ -80,31 +152,160 @@ func (repo *BoardStore) GetAllBoard(entities *[]*board.Entity) (keys []*board.Ke
 }
 
 func (repo *BoardStore) RunInTransaction(f func(tx *datastore.Transaction) error) (err error) {
-	_, err = repo.client.RunInTransaction(repo.context, f)
+	defer observe("RunInTransaction")()
+	attempts := 0
+	_, err = repo.client.RunInTransaction(repo.context, func(tx *datastore.Transaction) error {
+		attempts++
+		return f(tx)
+	})
+	metricTransactions.Inc()
+	if attempts > 1 {
+		metricTransactionRetries.Add(float64(attempts - 1))
+	}
 	return
 }
 
 func (repo *BoardStore) TxGetBoard(tx *datastore.Transaction, key *board.Key, entity *board.Entity) (err error) {
+	defer observe("TxGetBoard")()
 	err = tx.Get(key.DSKey, entity)
 	return
 }
 
 func (repo *BoardStore) TxPutBoard(tx *datastore.Transaction, key *board.Key, entity *board.Entity) (err error) {
+	defer observe("TxPutBoard")()
 	_, err = tx.Put(key.DSKey, entity)
 	return
 }
 
 func (repo *BoardStore) TxGetDat(tx *datastore.Transaction, key *dat.Key, entity *dat.Entity) (err error) {
+	defer observe("TxGetDat")()
+	if err = tx.Get(key.DSKey, entity); err != nil {
+		return
+	}
+	if entity.ChunkCount == 0 {
+		// 旧形式
+		return
+	}
+	chunks := make([]dat.Chunk, entity.ChunkCount)
+	if err = tx.GetMulti(datChunkKeys(key, entity.ChunkCount), chunks); err != nil {
+		return
+	}
+	entity.Bytes = joinDatChunks(chunks)
+	return
+}
+
+// チャンクを読まずにdatの管理情報だけ取得する
+// 旧形式の場合はBytesも入っている
+func (repo *BoardStore) TxGetDatMeta(tx *datastore.Transaction, key *dat.Key, entity *dat.Entity) (err error) {
+	defer observe("TxGetDatMeta")()
 	err = tx.Get(key.DSKey, entity)
 	return
 }
 
+// datの中身を全部書き直す
+// entity.ChunkCountは書き込み前の値とし、余ったチャンクは削除する
 func (repo *BoardStore) TxPutDat(tx *datastore.Transaction, key *dat.Key, entity *dat.Entity) (err error) {
-	_, err = tx.Put(key.DSKey, entity)
+	defer observe("TxPutDat")()
+	chunks := splitDatChunks(entity.Bytes)
+
+	meta := *entity
+	meta.Bytes = nil
+	meta.ChunkCount = len(chunks)
+
+	keys := append([]*datastore.Key{key.DSKey}, datChunkKeys(key, len(chunks))...)
+	src := []interface{}{&meta}
+	for i := range chunks {
+		src = append(src, &chunks[i])
+	}
+	if _, err = tx.PutMulti(keys, src); err != nil {
+		return
+	}
+
+	if n := entity.ChunkCount; n > len(chunks) {
+		if err = tx.DeleteMulti(datChunkKeys(key, n)[len(chunks):]); err != nil {
+			return
+		}
+	}
+	entity.ChunkCount = len(chunks)
 	return
 }
 
+// 最後のチャンクにだけ追記する
+// entityはTxGetDatMetaで取得したものとする
+func (repo *BoardStore) TxAppendDat(tx *datastore.Transaction, key *dat.Key, entity *dat.Entity, line []byte) (err error) {
+	defer observe("TxAppendDat")()
+	if entity.ChunkCount == 0 {
+		// 旧形式なのでここで分割する
+		entity.Bytes = append(entity.Bytes, line...)
+		return repo.TxPutDat(tx, key, entity)
+	}
+
+	chunkKey := datChunkKey(key, entity.ChunkCount)
+	chunk := &dat.Chunk{}
+	if err = tx.Get(chunkKey, chunk); err != nil {
+		return
+	}
+	if len(chunk.Bytes)+len(line) > dat_chunk_size {
+		// 新しいチャンクにする
+		entity.ChunkCount++
+		chunkKey = datChunkKey(key, entity.ChunkCount)
+		chunk.Bytes = nil
+	}
+	chunk.Bytes = append(chunk.Bytes, line...)
+
+	meta := *entity
+	meta.Bytes = nil
+	_, err = tx.PutMulti([]*datastore.Key{key.DSKey, chunkKey}, []interface{}{&meta, chunk})
+	return
+}
+
+// datをチャンクごと削除する
+// entityはTxGetDatMetaかTxGetDatで取得したものとする
+func (repo *BoardStore) TxDeleteDat(tx *datastore.Transaction, key *dat.Key, entity *dat.Entity) (err error) {
+	defer observe("TxDeleteDat")()
+	keys := append([]*datastore.Key{key.DSKey}, datChunkKeys(key, entity.ChunkCount)...)
+	err = tx.DeleteMulti(keys)
+	return
+}
+
+func datChunkKey(parent *dat.Key, index int) *datastore.Key {
+	return datastore.IDKey(dat.CHUNK_KIND, int64(index), parent.DSKey)
+}
+
+func datChunkKeys(parent *dat.Key, count int) []*datastore.Key {
+	keys := make([]*datastore.Key, count)
+	for i := range keys {
+		keys[i] = datChunkKey(parent, i+1)
+	}
+	return keys
+}
+
+// 行の途中で切らないように分割する
+func splitDatChunks(b []byte) (chunks []dat.Chunk) {
+	for len(b) > 0 {
+		n := len(b)
+		if n > dat_chunk_size {
+			n = bytes.LastIndexByte(b[:dat_chunk_size], '\n') + 1
+			if n == 0 {
+				n = dat_chunk_size
+			}
+		}
+		chunks = append(chunks, dat.Chunk{Bytes: b[:n]})
+		b = b[n:]
+	}
+	return
+}
+
+func joinDatChunks(chunks []dat.Chunk) []byte {
+	buf := &bytes.Buffer{}
+	for _, c := range chunks {
+		buf.Write(c.Bytes)
+	}
+	return buf.Bytes()
+}
+
 func (repo *BoardStore) TxGetAllBoard(tx *datastore.Transaction, entities *[]*board.Entity) (keys []*board.Key, err error) {
+	defer observe("TxGetAllBoard")()
 
 	// あやしい
 	multiKey, err := repo.client.GetAll(repo.context, datastore.NewQuery(board.KIND).KeysOnly(), nil)
@@ -128,6 +329,7 @@ func (repo *BoardStore) TxGetAllBoard(tx *datastore.Transaction, entities *[]*bo
 }
 
 func (repo *BoardStore) TxPutMultiBoard(tx *datastore.Transaction, keys []*board.Key, entities []*board.Entity) (err error) {
+	defer observe("TxPutMultiBoard")()
 
 	multiKey := make([]*datastore.Key, len(keys))
 	for i, k := range keys {
@@ -137,3 +339,333 @@ func (repo *BoardStore) TxPutMultiBoard(tx *datastore.Transaction, keys []*board
 	_, err = tx.PutMulti(multiKey, entities)
 	return
 }
+
+// 監査ログは追記するだけ
+func (repo *BoardStore) PutAuditLog(entity *audit.Entity) (err error) {
+	defer observe("PutAuditLog")()
+	_, err = repo.client.Put(repo.context, datastore.IncompleteKey(audit.KIND, nil), entity)
+	return
+}
+
+// [since, until) の監査ログを新しい順に取得する。ゼロ値の場合は制限しない。
+func (repo *BoardStore) GetAuditLogs(since, until time.Time, limit int, entities *[]*audit.Entity) (err error) {
+	defer observe("GetAuditLogs")()
+	query := datastore.NewQuery(audit.KIND)
+	if !since.IsZero() {
+		query = query.Filter("Time >=", since)
+	}
+	if !until.IsZero() {
+		query = query.Filter("Time <", until)
+	}
+	query = query.Order("-Time").Limit(limit)
+	_, err = repo.client.GetAll(repo.context, query, entities)
+	return
+}
+
+func (repo *BoardStore) SessionKey(name string) (key *session.Key) {
+	k := datastore.NameKey(session.KIND, name, nil)
+	key = &session.Key{DSKey: k}
+	return
+}
+
+func (repo *BoardStore) GetSession(key *session.Key, entity *session.Entity) (err error) {
+	defer observe("GetSession")()
+	err = repo.client.Get(repo.context, key.DSKey, entity)
+	return
+}
+
+func (repo *BoardStore) PutSession(key *session.Key, entity *session.Entity) (err error) {
+	defer observe("PutSession")()
+	_, err = repo.client.Put(repo.context, key.DSKey, entity)
+	return
+}
+
+func (repo *BoardStore) DeleteSession(key *session.Key) (err error) {
+	defer observe("DeleteSession")()
+	// if no such entities, err is nil.
+	err = repo.client.Delete(repo.context, key.DSKey)
+	return
+}
+
+func (repo *BoardStore) GetAllSession(entities *[]*session.Entity) (keys []*session.Key, err error) {
+	defer observe("GetAllSession")()
+	ks, err := repo.client.GetAll(repo.context, datastore.NewQuery(session.KIND).Order("CreatedAt"), entities)
+	if err != nil {
+		return
+	}
+	for _, k := range ks {
+		keys = append(keys, &session.Key{DSKey: k})
+	}
+	return
+}
+
+func (repo *BoardStore) SettingKey(name string) (key *setting.Key) {
+	k := datastore.NameKey(setting.KIND, name, nil)
+	key = &setting.Key{DSKey: k}
+	return
+}
+
+func (repo *BoardStore) GetBoardSetting(key *setting.Key, entity *setting.Entity) (err error) {
+	defer observe("GetBoardSetting")()
+	err = repo.client.Get(repo.context, key.DSKey, entity)
+	return
+}
+
+func (repo *BoardStore) PutBoardSetting(key *setting.Key, entity *setting.Entity) (err error) {
+	defer observe("PutBoardSetting")()
+	_, err = repo.client.Put(repo.context, key.DSKey, entity)
+	return
+}
+
+func (repo *BoardStore) DeleteBoardSetting(key *setting.Key) (err error) {
+	defer observe("DeleteBoardSetting")()
+	// if no such entities, err is nil.
+	err = repo.client.Delete(repo.context, key.DSKey)
+	return
+}
+
+func (repo *BoardStore) MaintenanceKey(name string) (key *maintenance.Key) {
+	k := datastore.NameKey(maintenance.KIND, name, nil)
+	key = &maintenance.Key{DSKey: k}
+	return
+}
+
+func (repo *BoardStore) GetMaintenance(key *maintenance.Key, entity *maintenance.Entity) (err error) {
+	defer observe("GetMaintenance")()
+	err = repo.client.Get(repo.context, key.DSKey, entity)
+	return
+}
+
+func (repo *BoardStore) PutMaintenance(key *maintenance.Key, entity *maintenance.Entity) (err error) {
+	defer observe("PutMaintenance")()
+	_, err = repo.client.Put(repo.context, key.DSKey, entity)
+	return
+}
+
+func (repo *BoardStore) DeleteMaintenance(key *maintenance.Key) (err error) {
+	defer observe("DeleteMaintenance")()
+	// if no such entities, err is nil.
+	err = repo.client.Delete(repo.context, key.DSKey)
+	return
+}
+
+func (repo *BoardStore) ThreadKey(name string, parent *board.Key) (key *thread.Key) {
+	k := datastore.NameKey(thread.KIND, name, parent.DSKey)
+	key = &thread.Key{DSKey: k}
+	return
+}
+
+// 板のスレを一覧の順 (AgedAtの新しい順) に取得する。
+// 祖先クエリなので書き込んだ直後でも結果に反映されている。
+func (repo *BoardStore) GetThreads(parent *board.Key, liveOnly bool, entities *[]*thread.Entity) (keys []*thread.Key, err error) {
+	defer observe("GetThreads")()
+	query := datastore.NewQuery(thread.KIND).Ancestor(parent.DSKey)
+	if liveOnly {
+		query = query.Filter("Live =", true)
+	}
+	ks, err := repo.client.GetAll(repo.context, query.Order("-AgedAt"), entities)
+	if err != nil {
+		return
+	}
+	for _, k := range ks {
+		keys = append(keys, &thread.Key{DSKey: k})
+	}
+	return
+}
+
+// 板の下のdatのキーを全部取得する。Threadエンティティが無いdatも含む。
+func (repo *BoardStore) GetDatKeys(parent *board.Key) (keys []*dat.Key, err error) {
+	defer observe("GetDatKeys")()
+	query := datastore.NewQuery(dat.KIND).Ancestor(parent.DSKey).KeysOnly()
+	ks, err := repo.client.GetAll(repo.context, query, nil)
+	if err != nil {
+		return
+	}
+	for _, k := range ks {
+		keys = append(keys, &dat.Key{DSKey: k})
+	}
+	return
+}
+
+// 一覧にあるスレを数える。
+// 祖先クエリなので、トランザクションの間に板にスレが増えるとコミットが失敗する。
+func (repo *BoardStore) TxCountLiveThreads(tx *datastore.Transaction, parent *board.Key) (count int, err error) {
+	defer observe("TxCountLiveThreads")()
+	query := datastore.NewQuery(thread.KIND).Ancestor(parent.DSKey).Filter("Live =", true).
+		Order("-AgedAt").KeysOnly().Transaction(tx)
+	keys, err := repo.client.GetAll(repo.context, query, nil)
+	return len(keys), err
+}
+
+func (repo *BoardStore) TxGetThread(tx *datastore.Transaction, key *thread.Key, entity *thread.Entity) (err error) {
+	defer observe("TxGetThread")()
+	err = tx.Get(key.DSKey, entity)
+	return
+}
+
+func (repo *BoardStore) TxPutThread(tx *datastore.Transaction, key *thread.Key, entity *thread.Entity) (err error) {
+	defer observe("TxPutThread")()
+	_, err = tx.Put(key.DSKey, entity)
+	return
+}
+
+func (repo *BoardStore) TxDeleteThread(tx *datastore.Transaction, key *thread.Key) (err error) {
+	defer observe("TxDeleteThread")()
+	err = tx.Delete(key.DSKey)
+	return
+}
+
+func (repo *BoardStore) WriteCounterKey(shard int, parent *board.Key) (key *counter.Key) {
+	k := datastore.IDKey(counter.KIND, int64(shard), parent.DSKey)
+	key = &counter.Key{DSKey: k}
+	return
+}
+
+func (repo *BoardStore) GetWriteCounters(parent *board.Key, entities *[]*counter.Entity) (keys []*counter.Key, err error) {
+	defer observe("GetWriteCounters")()
+	ks, err := repo.client.GetAll(repo.context, datastore.NewQuery(counter.KIND).Ancestor(parent.DSKey), entities)
+	if err != nil {
+		return
+	}
+	for _, k := range ks {
+		keys = append(keys, &counter.Key{DSKey: k})
+	}
+	return
+}
+
+func (repo *BoardStore) TxGetWriteCounter(tx *datastore.Transaction, key *counter.Key, entity *counter.Entity) (err error) {
+	defer observe("TxGetWriteCounter")()
+	err = tx.Get(key.DSKey, entity)
+	return
+}
+
+func (repo *BoardStore) TxPutWriteCounter(tx *datastore.Transaction, key *counter.Key, entity *counter.Entity) (err error) {
+	defer observe("TxPutWriteCounter")()
+	_, err = tx.Put(key.DSKey, entity)
+	return
+}
+
+func (repo *BoardStore) DeleteWriteCounters(keys []*counter.Key) (err error) {
+	defer observe("DeleteWriteCounters")()
+	multiKey := make([]*datastore.Key, len(keys))
+	for i, k := range keys {
+		multiKey[i] = k.DSKey
+	}
+	err = repo.client.DeleteMulti(repo.context, multiKey)
+	return
+}
+
+func (repo *BoardStore) PostKey(boardName, threadKey string, resnum int) (key *post.Key) {
+	k := datastore.NameKey(post.KIND, boardName+"/"+threadKey+"/"+strconv.Itoa(resnum), nil)
+	key = &post.Key{DSKey: k}
+	return
+}
+
+func (repo *BoardStore) TxPutPost(tx *datastore.Transaction, key *post.Key, entity *post.Entity) (err error) {
+	defer observe("TxPutPost")()
+	_, err = tx.Put(key.DSKey, entity)
+	return
+}
+
+func (repo *BoardStore) PutMultiPost(keys []*post.Key, entities []*post.Entity) (err error) {
+	defer observe("PutMultiPost")()
+	for i := 0; i < len(keys); i += multi_batch_size {
+		j := i + multi_batch_size
+		if j > len(keys) {
+			j = len(keys)
+		}
+		multiKey := make([]*datastore.Key, j-i)
+		for n, k := range keys[i:j] {
+			multiKey[n] = k.DSKey
+		}
+		if _, err = repo.client.PutMulti(repo.context, multiKey, entities[i:j]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// 新しい順にlimit件まで返す。
+// cursorが空でなければ前に返したnextの続きから読む。
+// 並べるのに deployments/index.yaml の複合インデックスを使う。
+func (repo *BoardStore) SearchPosts(query *post.Query, cursor string, limit int, entities *[]*post.Entity) (next string, err error) {
+	defer observe("SearchPosts")()
+	q := datastore.NewQuery(post.KIND)
+	if query.Board != "" {
+		q = q.Filter("Board =", query.Board)
+	}
+	if query.Gram != "" {
+		q = q.Filter("Grams =", query.Gram)
+	}
+	if query.Id != "" {
+		q = q.Filter("Id =", query.Id)
+	}
+	if !query.Since.IsZero() {
+		q = q.Filter("Date >=", query.Since)
+	}
+	if !query.Until.IsZero() {
+		q = q.Filter("Date <", query.Until)
+	}
+	q = q.Order("-Date").Limit(limit)
+	if cursor != "" {
+		c, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return "", err
+		}
+		q = q.Start(c)
+	}
+
+	it := repo.client.Run(repo.context, q)
+	for {
+		e := &post.Entity{}
+		if _, err = it.Next(e); err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return
+		}
+		*entities = append(*entities, e)
+	}
+	c, err := it.Cursor()
+	if err != nil {
+		return
+	}
+	return c.String(), nil
+}
+
+func (repo *BoardStore) DeleteMultiPost(keys []*post.Key) (err error) {
+	defer observe("DeleteMultiPost")()
+	for i := 0; i < len(keys); i += multi_batch_size {
+		j := i + multi_batch_size
+		if j > len(keys) {
+			j = len(keys)
+		}
+		multiKey := make([]*datastore.Key, j-i)
+		for n, k := range keys[i:j] {
+			multiKey[n] = k.DSKey
+		}
+		if err = repo.client.DeleteMulti(repo.context, multiKey); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (repo *BoardStore) DeleteBoardPosts(boardName string) (err error) {
+	defer observe("DeleteBoardPosts")()
+	q := datastore.NewQuery(post.KIND).Filter("Board =", boardName).KeysOnly()
+	keys, err := repo.client.GetAll(repo.context, q, nil)
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(keys); i += multi_batch_size {
+		j := i + multi_batch_size
+		if j > len(keys) {
+			j = len(keys)
+		}
+		if err = repo.client.DeleteMulti(repo.context, keys[i:j]); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -4,9 +4,13 @@ import (
 	"cloud.google.com/go/datastore"
 	"fmt"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/counter"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/post"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/thread"
 	"github.com/tempxla/stub2ch/tools/app/testutil"
 	"testing"
+	"time"
 )
 
 // Put したものを Getできるか？
@@ -301,3 +305,300 @@ func TestTxPutMultiAndGetAllBoard(t *testing.T) {
 		t.Errorf("entities1 != entities2: \n%v \n%v", entities1, entities2)
 	}
 }
+
+// チャンクに分けて追記できるか？
+func TestTxAppendDat(t *testing.T) {
+
+	ctx, client := testutil.NewContextAndClient(t)
+	testutil.CleanDatastoreBy(t, ctx, client)
+
+	defer func(size int) { dat_chunk_size = size }(dat_chunk_size)
+	dat_chunk_size = 8
+
+	repo := NewBoardStore(ctx, client)
+
+	datKey := repo.DatKey("012", repo.BoardKey("news4test"))
+	// 旧形式
+	if _, err := client.Put(ctx, datKey.DSKey, &dat.Entity{Bytes: []byte("1234\n")}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"abc\n", "de\n", "fghij\n"} {
+		err := repo.RunInTransaction(func(tx *datastore.Transaction) error {
+			meta := &dat.Entity{}
+			if err := repo.TxGetDatMeta(tx, datKey, meta); err != nil {
+				return err
+			}
+			return repo.TxAppendDat(tx, datKey, meta, []byte(line))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Verify
+	entity := &dat.Entity{}
+	if err := repo.GetDat(datKey, entity); err != nil {
+		t.Fatal(err)
+	}
+	if string(entity.Bytes) != "1234\nabc\nde\nfghij\n" {
+		t.Errorf("entity.Bytes = %q", entity.Bytes)
+	}
+	if entity.ChunkCount != 3 {
+		t.Errorf("entity.ChunkCount = %v", entity.ChunkCount)
+	}
+}
+
+// チャンクに分けて書いたdatに、チャンクをまたいで何度も追記できるか？
+func TestTxAppendDat_MultiChunk(t *testing.T) {
+
+	ctx, client := testutil.NewContextAndClient(t)
+	testutil.CleanDatastoreBy(t, ctx, client)
+
+	defer func(size int) { dat_chunk_size = size }(dat_chunk_size)
+	dat_chunk_size = 8
+
+	repo := NewBoardStore(ctx, client)
+
+	datKey := repo.DatKey("012", repo.BoardKey("news4test"))
+	err := repo.RunInTransaction(func(tx *datastore.Transaction) error {
+		return repo.TxPutDat(tx, datKey, &dat.Entity{Bytes: []byte("1234\n5678\n")})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "1234\n5678\n"
+	for _, line := range []string{"a\n", "bc\n", "defg\n", "hijklmnopq\n", "r\n"} {
+		err := repo.RunInTransaction(func(tx *datastore.Transaction) error {
+			meta := &dat.Entity{}
+			if err := repo.TxGetDatMeta(tx, datKey, meta); err != nil {
+				return err
+			}
+			// 中身は読まない
+			if len(meta.Bytes) != 0 {
+				t.Errorf("meta.Bytes = %q", meta.Bytes)
+			}
+			return repo.TxAppendDat(tx, datKey, meta, []byte(line))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want += line
+	}
+
+	// Verify
+	entity := &dat.Entity{}
+	if err := repo.GetDat(datKey, entity); err != nil {
+		t.Fatal(err)
+	}
+	if string(entity.Bytes) != want {
+		t.Errorf("entity.Bytes = %q, want: %q", entity.Bytes, want)
+	}
+	// 1234 | 5678 a | bc defg | hijklmnopq | r
+	if entity.ChunkCount != 5 {
+		t.Errorf("entity.ChunkCount = %v, want: 5", entity.ChunkCount)
+	}
+}
+
+// AgedAtの新しい順で、dat落ちしたスレは除けるか？
+func TestTxPutAndGetThreads(t *testing.T) {
+
+	ctx, client := testutil.NewContextAndClient(t)
+	testutil.CleanDatastoreBy(t, ctx, client)
+
+	repo := NewBoardStore(ctx, client)
+
+	boardKey := repo.BoardKey("news4test")
+	now := testutil.NewTimeJST(t, "2020-01-18 11:45:56.123")
+	threads := map[string]*thread.Entity{
+		"111": {ThreadTitle: "a", AgedAt: now.Add(-2 * time.Second), Live: true},
+		"222": {ThreadTitle: "b", AgedAt: now, Live: true},
+		"333": {ThreadTitle: "c", AgedAt: now.Add(-1 * time.Second), Live: false},
+	}
+	err := repo.RunInTransaction(func(tx *datastore.Transaction) error {
+		for name, e := range threads {
+			if err := repo.TxPutThread(tx, repo.ThreadKey(name, boardKey), e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		liveOnly bool
+		want     []string
+	}{
+		{true, []string{"222", "111"}},
+		{false, []string{"222", "333", "111"}},
+	} {
+		var entities []*thread.Entity
+		keys, err := repo.GetThreads(boardKey, tt.liveOnly, &entities)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, k := range keys {
+			names = append(names, k.DSKey.Name)
+		}
+		if fmt.Sprint(names) != fmt.Sprint(tt.want) {
+			t.Errorf("GetThreads(%v) = %v, want: %v", tt.liveOnly, names, tt.want)
+		}
+	}
+
+	var count int
+	err = repo.RunInTransaction(func(tx *datastore.Transaction) (err error) {
+		count, err = repo.TxCountLiveThreads(tx, boardKey)
+		return
+	})
+	if err != nil || count != 2 {
+		t.Errorf("TxCountLiveThreads() = %v, %v", count, err)
+	}
+}
+
+// シャードの合計と削除
+func TestWriteCounters(t *testing.T) {
+
+	ctx, client := testutil.NewContextAndClient(t)
+	testutil.CleanDatastoreBy(t, ctx, client)
+
+	repo := NewBoardStore(ctx, client)
+
+	boardKey := repo.BoardKey("news4test")
+	err := repo.RunInTransaction(func(tx *datastore.Transaction) error {
+		for shard := 1; shard <= 3; shard++ {
+			if err := repo.TxPutWriteCounter(tx, repo.WriteCounterKey(shard, boardKey), &counter.Entity{Count: shard}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entities []*counter.Entity
+	keys, err := repo.GetWriteCounters(boardKey, &entities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := 0
+	for _, e := range entities {
+		sum += e.Count
+	}
+	if len(keys) != 3 || sum != 6 {
+		t.Errorf("len(keys) = %v, sum = %v", len(keys), sum)
+	}
+
+	if err := repo.DeleteWriteCounters(keys); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = repo.GetWriteCounters(boardKey, &[]*counter.Entity{})
+	if err != nil || len(keys) != 0 {
+		t.Errorf("GetWriteCounters() = %v, %v", keys, err)
+	}
+}
+
+func TestSearchPosts(t *testing.T) {
+
+	ctx, client := testutil.NewContextAndClient(t)
+	testutil.CleanDatastoreBy(t, ctx, client)
+
+	repo := NewBoardStore(ctx, client)
+
+	now := time.Now().Truncate(time.Second)
+	posts := []*post.Entity{
+		{Board: "news4test", ThreadKey: "111", ResNum: 1, Id: "AAA", Date: now.Add(-2 * time.Minute), Grams: []string{"あい", "いう"}},
+		{Board: "news4test", ThreadKey: "111", ResNum: 2, Id: "BBB", Date: now.Add(-time.Minute), Grams: []string{"あい"}},
+		{Board: "news4vip", ThreadKey: "222", ResNum: 1, Id: "AAA", Date: now, Grams: []string{"あい", "いう"}},
+	}
+	keys := make([]*post.Key, len(posts))
+	for i, p := range posts {
+		keys[i] = repo.PostKey(p.Board, p.ThreadKey, p.ResNum)
+	}
+	if err := repo.PutMultiPost(keys, posts); err != nil {
+		t.Fatal(err)
+	}
+
+	// 新しい順
+	tests := []struct {
+		query *post.Query
+		want  []string
+	}{
+		{&post.Query{Gram: "あい"}, []string{"222/1", "111/2", "111/1"}},
+		{&post.Query{Gram: "いう"}, []string{"222/1", "111/1"}},
+		{&post.Query{Board: "news4test", Gram: "あい"}, []string{"111/2", "111/1"}},
+		{&post.Query{Id: "AAA"}, []string{"222/1", "111/1"}},
+		{&post.Query{Board: "news4test", Id: "AAA"}, []string{"111/1"}},
+		{&post.Query{Gram: "あい", Since: now.Add(-time.Minute), Until: now}, []string{"111/2"}},
+		{&post.Query{Gram: "えお"}, nil},
+	}
+	for i, tt := range tests {
+		var entities []*post.Entity
+		if _, err := repo.SearchPosts(tt.query, "", 10, &entities); err != nil {
+			t.Fatal(err)
+		}
+		var actual []string
+		for _, e := range entities {
+			actual = append(actual, fmt.Sprintf("%v/%v", e.ThreadKey, e.ResNum))
+		}
+		if fmt.Sprint(actual) != fmt.Sprint(tt.want) {
+			t.Errorf("%d: SearchPosts() = %v, want: %v", i, actual, tt.want)
+		}
+	}
+
+	// 続きから読む
+	var entities []*post.Entity
+	next, err := repo.SearchPosts(&post.Query{Gram: "あい"}, "", 2, &entities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities = nil
+	if _, err := repo.SearchPosts(&post.Query{Gram: "あい"}, next, 2, &entities); err != nil || len(entities) != 1 || entities[0].ResNum != 1 {
+		t.Errorf("SearchPosts(next) = %v, %v", entities, err)
+	}
+
+	if err := repo.DeleteMultiPost(keys[2:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteBoardPosts("news4test"); err != nil {
+		t.Fatal(err)
+	}
+	entities = nil
+	if _, err := repo.SearchPosts(&post.Query{}, "", 10, &entities); err != nil || len(entities) != 0 {
+		t.Errorf("SearchPosts() = %v, %v", entities, err)
+	}
+}
+
+func TestSplitDatChunks(t *testing.T) {
+
+	defer func(size int) { dat_chunk_size = size }(dat_chunk_size)
+	dat_chunk_size = 8
+
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"", nil},
+		{"1234\n", []string{"1234\n"}},
+		{"1234\n567\n89\n", []string{"1234\n", "567\n89\n"}},
+		{"123456789\n", []string{"12345678", "9\n"}},
+	}
+
+	for i, tt := range tests {
+		chunks := splitDatChunks([]byte(tt.src))
+		actual := []string(nil)
+		for _, c := range chunks {
+			actual = append(actual, string(c.Bytes))
+		}
+		if fmt.Sprint(actual) != fmt.Sprint(tt.want) {
+			t.Errorf("%d: splitDatChunks(%q) = %q, want: %q", i, tt.src, actual, tt.want)
+		}
+		if joined := string(joinDatChunks(chunks)); joined != tt.src {
+			t.Errorf("%d: joinDatChunks = %q, want: %q", i, joined, tt.src)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package service
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/tempxla/stub2ch/configs/app/bbscfg"
+	"github.com/tempxla/stub2ch/internal/app/service/repository"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/post"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/thread"
+	"github.com/tempxla/stub2ch/internal/app/types/errors"
+	"github.com/tempxla/stub2ch/internal/app/util"
+	"golang.org/x/text/width"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	SEARCH_RESULT_MAX = 50 // 検索結果の最大件数
+
+	search_grams_max  = 1000 // 1レスで索引にするgramの上限
+	search_index_size = 500  // 作り直すときにまとめて書く件数
+)
+
+var (
+	// 1回に読む件数
+	search_page_size = 500
+	// 絞り込むために読む件数の上限。超えたら打ち切ってそう返す
+	search_fetch_max = 5000
+
+	// 日付の後ろのID (無い行もある)
+	dat_id_regexp   = regexp.MustCompile(` ID:(\S+)`)
+	html_tag_regexp = regexp.MustCompile(`<[^>]*>`)
+)
+
+// 検索条件。空の項目では絞らない。
+type SearchQuery struct {
+	Board string
+	Text  string // 空白で区切った語を全部含むもの
+	Id    string
+	Name  string // 名前に含むもの
+	Since time.Time
+	Until time.Time // この時刻は含まない
+}
+
+// 検索結果の1件
+type SearchResult struct {
+	Board       string
+	ThreadKey   string
+	ResNum      int
+	ThreadTitle string
+	Name        string
+	Id          string
+	Date        time.Time
+	Content     string // datの本文 (HTML)
+}
+
+// 検索用に文字列をそろえる。
+// タグを消して実体参照を戻し、全角英数は半角に、半角カナは全角にして小文字にする。
+func normalizeSearchText(s string) string {
+	s = strings.ReplaceAll(s, "<br>", " ")
+	s = html_tag_regexp.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	s = width.Fold.String(s)
+	return strings.ToLower(s)
+}
+
+// 空白と記号で区切って、語ごとに1-gramと2-gramを作る。
+// 日本語は単語に分けられないので、n-gramで部分一致を探す。
+func searchGrams(s string) []string {
+	var grams []string
+	seen := make(map[string]bool)
+	add := func(g string) {
+		if !seen[g] {
+			seen[g] = true
+			grams = append(grams, g)
+		}
+	}
+	for _, term := range splitSearchTerms(s) {
+		rs := []rune(term)
+		for i := range rs {
+			add(string(rs[i]))
+			if i+1 < len(rs) {
+				add(string(rs[i : i+2]))
+			}
+		}
+	}
+	return grams
+}
+
+// 検索語からインデックスを引くgramを作る。
+// 2文字以上の語は2-gramだけ、1文字の語はその文字を使う。
+func searchQueryGrams(s string) []string {
+	var grams []string
+	seen := make(map[string]bool)
+	add := func(g string) {
+		if !seen[g] {
+			seen[g] = true
+			grams = append(grams, g)
+		}
+	}
+	for _, term := range splitSearchTerms(s) {
+		rs := []rune(term)
+		if len(rs) == 1 {
+			add(term)
+		}
+		for i := 0; i+1 < len(rs); i++ {
+			add(string(rs[i : i+2]))
+		}
+	}
+	return grams
+}
+
+func splitSearchTerms(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})
+}
+
+// datの1行 (UTF-8) から検索用のエンティティを作る。
+// 日付が読めない行 (あぼーんなど) は索引にしない。
+func newPostEntity(boardName, threadKey string, resnum int,
+	line, title string, jst *time.Location) (*post.Entity, bool) {
+
+	fields := strings.Split(strings.TrimSuffix(line, "\n"), "<>")
+	if len(fields) != 5 {
+		return nil, false
+	}
+	date, ok := parseDat2chDate(fields[2], jst)
+	if !ok {
+		return nil, false
+	}
+	id := ""
+	if m := dat_id_regexp.FindStringSubmatch(fields[2]); m != nil {
+		id = m[1]
+	}
+	content := strings.Trim(fields[3], " ")
+
+	text := normalizeSearchText(content)
+	if resnum == 1 {
+		text = normalizeSearchText(title) + " " + text
+	}
+	grams := searchGrams(text)
+	if len(grams) > search_grams_max {
+		grams = grams[:search_grams_max]
+	}
+
+	return &post.Entity{
+		Board:       boardName,
+		ThreadKey:   threadKey,
+		ResNum:      resnum,
+		ThreadTitle: title,
+		Name:        fields[0],
+		Id:          id,
+		Date:        date,
+		Content:     content,
+		Grams:       grams,
+	}, true
+}
+
+// 書き込みと同じトランザクションで索引を作る。
+// 索引が作れなくても書き込みは止めない。
+func (sv *BoardService) txPutPost(tx *datastore.Transaction,
+	boardName, threadKey string, resnum int, line []byte, title string) error {
+
+	jst, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		return err
+	}
+	entity, ok := newPostEntity(boardName, threadKey, resnum, string(line), title, jst)
+	if !ok {
+		log.Printf("txPutPost: cannot parse: %v/%v/%v", boardName, threadKey, resnum)
+		return nil
+	}
+	return sv.repo.TxPutPost(tx, sv.repo.PostKey(boardName, threadKey, resnum), entity)
+}
+
+// レスを検索して新しい順に返す。
+// インデックスはIDか1つのgramで新しい方から引くだけなので、語や名前はここで確かめる。
+// limit件集まるまで古い方へ読み進め、search_fetch_max件読んでも終わらなければ
+// 打ち切ってtruncatedをtrueにする。
+func (sv *BoardService) SearchPosts(q *SearchQuery, limit int) (results []*SearchResult, truncated bool, err error) {
+	text := normalizeSearchText(q.Text)
+	terms := splitSearchTerms(text)
+	grams := searchQueryGrams(text)
+	if len(grams) == 0 && q.Id == "" {
+		return nil, false, errors.NO_SEARCH_TERMS
+	}
+
+	query := &post.Query{Board: q.Board, Id: q.Id, Since: q.Since, Until: q.Until}
+	if q.Id == "" {
+		query.Gram = rarestGram(grams)
+	}
+
+	name := normalizeSearchText(q.Name)
+	results = []*SearchResult{}
+	cursor := ""
+	for fetched := 0; ; {
+		var entities []*post.Entity
+		if cursor, err = sv.repo.SearchPosts(query, cursor, search_page_size, &entities); err != nil {
+			return nil, false, err
+		}
+		for _, e := range entities {
+			if !matchPost(e, terms, name, q.Since, q.Until) {
+				continue
+			}
+			results = append(results, &SearchResult{
+				Board:       e.Board,
+				ThreadKey:   e.ThreadKey,
+				ResNum:      e.ResNum,
+				ThreadTitle: e.ThreadTitle,
+				Name:        e.Name,
+				Id:          e.Id,
+				Date:        e.Date,
+				Content:     e.Content,
+			})
+			if len(results) >= limit {
+				return results, false, nil
+			}
+		}
+		if len(entities) < search_page_size {
+			return results, false, nil
+		}
+		if fetched += len(entities); fetched >= search_fetch_max {
+			return results, true, nil
+		}
+	}
+}
+
+// インデックスを引くgramを選ぶ。件数はわからないので、
+// 2文字のもの、ひらがなの少ないものほど当たるレスが少ないとみなす。
+func rarestGram(grams []string) string {
+	best, bestScore := "", -1
+	for _, g := range grams {
+		score := 0
+		for _, r := range g {
+			if unicode.Is(unicode.Hiragana, r) {
+				score++
+			} else {
+				score += 2
+			}
+		}
+		if score > bestScore {
+			best, bestScore = g, score
+		}
+	}
+	return best
+}
+
+func matchPost(e *post.Entity, terms []string, name string, since, until time.Time) bool {
+	text := normalizeSearchText(e.Content)
+	if e.ResNum == 1 {
+		text = normalizeSearchText(e.ThreadTitle) + " " + text
+	}
+	for _, t := range terms {
+		if !strings.Contains(text, t) {
+			return false
+		}
+	}
+	if name != "" && !strings.Contains(normalizeSearchText(e.Name), name) {
+		return false
+	}
+	if !since.IsZero() && e.Date.Before(since) {
+		return false
+	}
+	if !until.IsZero() && !e.Date.Before(until) {
+		return false
+	}
+	return true
+}
+
+// 検索の索引をdatから作り直す。boardNameが空なら全部の板。
+// スレタイの変更は索引に反映されないので、そのときもこれで直す。
+// Dateをインデックスにする前に作った索引は新しい順に引けないので、一度作り直すこと。
+// 索引にしたレスの数を返す。
+func (admin *AdminFunction) RebuildSearchIndex(boardName string) (count int, err error) {
+
+	var boardNames []string
+	if boardName != "" {
+		if bbscfg.GetSetting(boardName) == nil {
+			return 0, fmt.Errorf("unknown board: %v", boardName)
+		}
+		boardNames = []string{boardName}
+	} else {
+		var keys []*board.Key
+		if keys, err = admin.repo.GetAllBoard(&[]*board.Entity{}); err != nil {
+			return
+		}
+		for _, k := range keys {
+			boardNames = append(boardNames, k.DSKey.Name)
+		}
+	}
+
+	jst, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		return
+	}
+
+	for _, name := range boardNames {
+		var n int
+		if n, err = admin.rebuildBoardIndex(name, jst); err != nil {
+			return
+		}
+		count += n
+	}
+
+	log.Printf("RebuildSearchIndex: %v posts", count)
+	return count, nil
+}
+
+func (admin *AdminFunction) rebuildBoardIndex(boardName string, jst *time.Location) (count int, err error) {
+	if err = admin.repo.DeleteBoardPosts(boardName); err != nil {
+		return
+	}
+
+	boardKey := admin.repo.BoardKey(boardName)
+	// dat落ちしたスレも検索できるようにする
+	threadKeys, err := admin.repo.GetThreads(boardKey, false, &[]*thread.Entity{})
+	if err != nil {
+		return
+	}
+
+	var keys []*post.Key
+	var entities []*post.Entity
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		if err := admin.repo.PutMultiPost(keys, entities); err != nil {
+			return err
+		}
+		count += len(keys)
+		keys, entities = nil, nil
+		return nil
+	}
+
+	for _, threadKey := range threadKeys {
+		datEntity := &dat.Entity{}
+		err = admin.repo.GetDat(admin.repo.DatKey(threadKey.DSKey.Name, boardKey), datEntity)
+		if err == datastore.ErrNoSuchEntity {
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		k, e := threadPosts(admin.repo, boardName, threadKey.DSKey.Name, datEntity, jst)
+		for i := range k {
+			keys = append(keys, k[i])
+			entities = append(entities, e[i])
+			if len(keys) >= search_index_size {
+				if err = flush(); err != nil {
+					return
+				}
+			}
+		}
+	}
+	err = flush()
+	return
+}
+
+// 1スレ分の検索用エンティティをdatから作る
+func threadPosts(repo repository.BoardRepository, boardName, threadKey string,
+	datEntity *dat.Entity, jst *time.Location) ([]*post.Key, []*post.Entity) {
+
+	utf8Dat := datEntity.Bytes
+	if datEntity.Sjis {
+		utf8Dat = util.SJIStoUTF8(datEntity.Bytes)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(utf8Dat), "\n"), "\n")
+	title := ""
+	if fields := strings.SplitN(lines[0], "<>", 5); len(fields) == 5 {
+		title = fields[4]
+	}
+
+	var keys []*post.Key
+	var entities []*post.Entity
+	for i, line := range lines {
+		resnum := i + 1
+		entity, ok := newPostEntity(boardName, threadKey, resnum, line, title, jst)
+		if !ok {
+			continue
+		}
+		keys = append(keys, repo.PostKey(boardName, threadKey, resnum))
+		entities = append(entities, entity)
+	}
+	return keys, entities
+}
+
+// 移動したスレの索引を移動先の板で作り、移動元の分を消す
+func (admin *AdminFunction) moveThreadPosts(boardName, threadKey, toBoardName string) error {
+	jst, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		return err
+	}
+	datEntity := &dat.Entity{}
+	if err := admin.repo.GetDat(admin.repo.DatKey(threadKey, admin.repo.BoardKey(toBoardName)), datEntity); err != nil {
+		return err
+	}
+
+	keys, entities := threadPosts(admin.repo, toBoardName, threadKey, datEntity, jst)
+	if err := admin.repo.PutMultiPost(keys, entities); err != nil {
+		return err
+	}
+	oldKeys := make([]*post.Key, len(entities))
+	for i, e := range entities {
+		oldKeys[i] = admin.repo.PostKey(boardName, threadKey, e.ResNum)
+	}
+	return admin.repo.DeleteMultiPost(oldKeys)
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"bytes"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/post"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalizeSearchText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"ＡＢＣ１２３", "abc123"},
+		{"ｱｲｳ", "アイウ"},
+		{"&gt;&gt;1<br>乙", ">>1 乙"},
+		{`<a href="x">リンク</a>`, "リンク"},
+		{"名無し </b>◆trip<b>", "名無し ◆trip"},
+	}
+	for _, tt := range tests {
+		if got := normalizeSearchText(tt.in); got != tt.want {
+			t.Errorf("normalizeSearchText(%q) = %q, want: %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSearchGrams(t *testing.T) {
+	got := searchGrams("日本語 a、日本")
+	want := []string{"日", "日本", "本", "本語", "語", "a"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("searchGrams = %v, want: %v", got, want)
+	}
+
+	got = searchQueryGrams("日本語 a")
+	want = []string{"日本", "本語", "a"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("searchQueryGrams = %v, want: %v", got, want)
+	}
+}
+
+func TestNewPostEntity(t *testing.T) {
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+
+	line := "名無し<>sage<>2019/12/01(日) 10:05:00.345 ID:bbb<> &gt;&gt;1<br>乙 <>\n"
+	e, ok := newPostEntity("news4vip", "1575162000", 2, line, "スレタイ", jst)
+	if !ok {
+		t.Fatal("not ok")
+	}
+	if e.Id != "bbb" || e.Name != "名無し" || e.ThreadTitle != "スレタイ" || e.ResNum != 2 {
+		t.Errorf("entity = %+v", e)
+	}
+	if want := time.Date(2019, 12, 1, 10, 5, 0, 345000000, jst); !e.Date.Equal(want) {
+		t.Errorf("Date = %v, want: %v", e.Date, want)
+	}
+	if e.Content != "&gt;&gt;1<br>乙" {
+		t.Errorf("Content = %q", e.Content)
+	}
+	// 2レス目以降はスレタイを索引にしない
+	for _, g := range e.Grams {
+		if g == "スレ" {
+			t.Errorf("Grams = %v", e.Grams)
+		}
+	}
+
+	// あぼーんは索引にしない
+	if _, ok := newPostEntity("news4vip", "1575162000", 3, "あぼーん<>あぼーん<>あぼーん<>あぼーん<>", "", jst); ok {
+		t.Error("あぼーん is indexed")
+	}
+}
+
+func TestSearchPosts(t *testing.T) {
+	repo := testutil.InitialBoardStub("news4vip", "poverty")
+	stng := testutil.NewSettingStub()
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Date(2020, 1, 1, 12, 0, 0, 0, jst)}))
+
+	key1, err := sv.CreateThread(stng, "news4vip", "名無し", "", "AAA", "ＶＩＰから来ました", "検索のテスト")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sv = NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Date(2020, 1, 2, 12, 0, 0, 0, jst)}))
+	if _, err := sv.WriteDat(stng, "news4vip", key1, "ななし", "sage", "BBB", "vipでやれ\n>>1"); err != nil {
+		t.Fatal(err)
+	}
+	sv = NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Date(2020, 1, 2, 13, 0, 0, 0, jst)}))
+	key2, err := sv.CreateThread(stng, "poverty", "名無し", "", "CCC", "VIPじゃないよ", "別の板")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type hit struct {
+		board, threadKey string
+		resnum           int
+	}
+	tests := []struct {
+		query *SearchQuery
+		want  []hit
+	}{
+		// 全角半角と大文字小文字はそろえる。新しい順
+		{&SearchQuery{Text: "vip"}, []hit{{"poverty", key2, 1}, {"news4vip", key1, 2}, {"news4vip", key1, 1}}},
+		{&SearchQuery{Board: "news4vip", Text: "ＶＩＰ"}, []hit{{"news4vip", key1, 2}, {"news4vip", key1, 1}}},
+		// 1レス目はスレタイも探す
+		{&SearchQuery{Text: "検索"}, []hit{{"news4vip", key1, 1}}},
+		// 全部の語を含むもの
+		{&SearchQuery{Text: "vip やれ"}, []hit{{"news4vip", key1, 2}}},
+		// gramは全部あっても並びが違う
+		{&SearchQuery{Text: "らvip"}, []hit{}},
+		{&SearchQuery{Text: "vip", Id: "AAA"}, []hit{{"news4vip", key1, 1}}},
+		{&SearchQuery{Id: "BBB"}, []hit{{"news4vip", key1, 2}}},
+		{&SearchQuery{Text: "vip", Name: "なな"}, []hit{{"news4vip", key1, 2}}},
+		{&SearchQuery{Text: "vip", Until: time.Date(2020, 1, 2, 0, 0, 0, 0, jst)}, []hit{{"news4vip", key1, 1}}},
+		{&SearchQuery{Text: "vip", Since: time.Date(2020, 1, 2, 0, 0, 0, 0, jst)},
+			[]hit{{"poverty", key2, 1}, {"news4vip", key1, 2}}},
+	}
+	for i, tt := range tests {
+		results, truncated, err := sv.SearchPosts(tt.query, SEARCH_RESULT_MAX)
+		if err != nil || truncated {
+			t.Fatalf("%d: %v, %v", i, truncated, err)
+		}
+		got := []hit{}
+		for _, r := range results {
+			got = append(got, hit{r.Board, r.ThreadKey, r.ResNum})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d: %+v = %v, want: %v", i, tt.query, got, tt.want)
+		}
+	}
+
+	if _, _, err := sv.SearchPosts(&SearchQuery{Text: " 、"}, SEARCH_RESULT_MAX); err == nil {
+		t.Error("no error with empty query")
+	}
+	results, _, err := sv.SearchPosts(&SearchQuery{Text: "vip"}, 1)
+	if err != nil || len(results) != 1 {
+		t.Errorf("limit: %v, %v", results, err)
+	}
+}
+
+// 1回で読み切れなければ続きを読み、上限で打ち切る
+func TestSearchPosts_Paging(t *testing.T) {
+	defer func(size, max int) { search_page_size, search_fetch_max = size, max }(search_page_size, search_fetch_max)
+	search_page_size, search_fetch_max = 2, 8
+
+	repo := testutil.EmptyBoardStub()
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	date := time.Date(2020, 1, 1, 12, 0, 0, 0, jst)
+	// 新しい方から3つ目, 4つ目, 7つ目が当たり
+	contents := []string{"vip", "vip", "vip やれ", "vip やれ", "vip", "vip", "vip やれ"}
+	for i, c := range contents {
+		line := "a<>b<>2020/01/01(水) 12:00:00.000<> " + c + " <>"
+		e, _ := newPostEntity("news4vip", "1234567890", i+1, line, "", jst)
+		e.Date = date.Add(-time.Duration(i) * time.Minute)
+		repo.PostMap[repo.PostKey("news4vip", "1234567890", i+1).DSKey.Name] = e
+	}
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: date}))
+
+	results, truncated, err := sv.SearchPosts(&SearchQuery{Text: "vip やれ"}, 3)
+	if err != nil || truncated || len(results) != 3 || results[0].ResNum != 3 || results[2].ResNum != 7 {
+		t.Errorf("results = %v, %v, %v", results, truncated, err)
+	}
+
+	// 上限まで読んだら打ち切ったことを返す
+	search_fetch_max = 4
+	results, truncated, err = sv.SearchPosts(&SearchQuery{Text: "vip やれ"}, 3)
+	if err != nil || !truncated || len(results) != 2 {
+		t.Errorf("results = %v, %v, %v", results, truncated, err)
+	}
+}
+
+func TestRarestGram(t *testing.T) {
+	tests := []struct {
+		grams []string
+		want  string
+	}{
+		{[]string{"の", "検索"}, "検索"},
+		{[]string{"です", "す検", "検索"}, "検索"},
+		{[]string{"vi", "ip"}, "vi"},
+	}
+	for _, tt := range tests {
+		if got := rarestGram(tt.grams); got != tt.want {
+			t.Errorf("rarestGram(%v) = %v, want: %v", tt.grams, got, tt.want)
+		}
+	}
+}
+
+func TestSearchPosts_Error(t *testing.T) {
+	sv := NewBoardService(RepoConf(&testutil.BrokenBoardStub{}), EnvConf(&SysEnv{StartedTime: time.Now()}))
+	if _, _, err := sv.SearchPosts(&SearchQuery{Text: "vip"}, SEARCH_RESULT_MAX); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestRebuildSearchIndex(t *testing.T) {
+	repo := testutil.InitialBoardStub("news4vip")
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: time.Now()}), AdminConf(repo, nil))
+
+	archive := makeDatZip(t, map[string]string{
+		"1575162000.dat": test_dat_1,
+		"1575244800.dat": test_dat_2,
+	})
+	// 取り込むと索引もできる
+	if _, err := sv.Admin.ImportDats("news4vip", bytes.NewReader(archive), int64(len(archive)), false); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.PostMap) != 3 {
+		t.Errorf("posts = %v", repo.PostMap)
+	}
+	// 古い索引は消える
+	repo.PostMap["news4vip/1/1"] = &post.Entity{Board: "news4vip", Grams: []string{"乙"}}
+
+	count, err := sv.Admin.RebuildSearchIndex("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// あぼーんは索引にしない
+	if count != 3 || len(repo.PostMap) != 3 {
+		t.Errorf("count = %v, posts = %v", count, repo.PostMap)
+	}
+
+	results, _, err := sv.SearchPosts(&SearchQuery{Board: "news4vip", Text: "スレタイ2"}, SEARCH_RESULT_MAX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ThreadKey != "1575244800" {
+		t.Errorf("results = %v", results)
+	}
+
+	results, _, err = sv.SearchPosts(&SearchQuery{Text: "乙"}, SEARCH_RESULT_MAX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ResNum != 2 || results[0].ThreadTitle != "スレタイ1" {
+		t.Errorf("results = %v", results)
+	}
+
+	if _, err := sv.Admin.RebuildSearchIndex("unknown"); err == nil {
+		t.Error("no error with unknown board")
+	}
+}
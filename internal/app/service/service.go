@@ -10,16 +10,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/tempxla/stub2ch/configs/app/bbscfg"
-	"github.com/tempxla/stub2ch/configs/app/config"
 	"github.com/tempxla/stub2ch/configs/app/secretcfg"
 	"github.com/tempxla/stub2ch/internal/app/service/repository"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/thread"
 	"github.com/tempxla/stub2ch/internal/app/types/errors"
 	jboard "github.com/tempxla/stub2ch/internal/app/types/json/board"
 	jdat "github.com/tempxla/stub2ch/internal/app/types/json/dat"
 	"github.com/tempxla/stub2ch/internal/app/util"
-	"html"
 	"net/http"
 	"strconv"
 	"strings"
@@ -32,6 +31,9 @@ const (
 	// 名前<>メール欄<>年/月/日(曜) 時:分:秒.ミリ秒 ID:hogehoge0<> 本文 <>スレタイ
 	dat_format      = "%s<>%s<>%s(%s) %s ID:%s<> %s <>%s\n"
 	dat_format_1001 = "%d<><>Over %d Thread<> このスレッドは%dを超えました。 <br> 新しいスレッドを立ててください。 <>\n"
+	dat_format_stop = "停止しました。。。<>停止<>停止<> 真・スレッドストッパー。。。(￣ー￣)ﾆﾔﾘｯ <>\n"
+
+	thread_key_probe_max = 60 // スレッドキーの空きを探す秒数
 )
 
 var (
@@ -45,17 +47,17 @@ type BoardService struct {
 	Admin *AdminFunction
 }
 
-func DefaultBoardService() (*BoardService, error) {
-
-	ctx := context.Background()
+// リクエストごとのサービスを作る。
+// ctxはリクエストのコンテキストで、データストアへの呼び出しはすべてこれを使う。
+func DefaultBoardService(ctx context.Context) (*BoardService, error) {
 
-	// Creates a client.
-	client, err := datastore.NewClient(ctx, config.PROJECT_ID)
+	// 署名鍵が無ければ書き込み確認を偽造できてしまうので動かさない
+	confirmKeys, err := loadConfirmKeys()
 	if err != nil {
-		return nil, fmt.Errorf("Failed to create client: %v", err)
+		return nil, err
 	}
 
-	jst, err := time.LoadLocation("Asia/Tokyo")
+	client, jst, err := storageClient()
 	if err != nil {
 		return nil, err
 	}
@@ -65,6 +67,8 @@ func DefaultBoardService() (*BoardService, error) {
 	sysEnv := &SysEnv{
 		StartedTime:   time.Now().In(jst),
 		ComputeIdSalt: secretcfg.COMPUTE_ID_SALT,
+		ConfirmKeyId:  secretcfg.CONFIRM_KEY_ID,
+		ConfirmKeys:   confirmKeys,
 	}
 	mem := NewAlterMemcache(ctx, client)
 
@@ -91,6 +95,7 @@ func RepoConf(repo repository.BoardRepository) func(*BoardService) *BoardService
 func EnvConf(env BoardEnvironment) func(*BoardService) *BoardService {
 	return func(sv *BoardService) *BoardService {
 		sv.env = env
+		sv.Admin.env = env
 		return sv
 	}
 }
@@ -103,7 +108,7 @@ func AdminConf(repo repository.BoardRepository, mem BoardMemcache) func(*BoardSe
 	}
 }
 
-// データストアからエンティティを取得しdatを返す
+// データストアからエンティティを取得しdatをShift_JISで返す
 func (sv *BoardService) MakeDat(boardName, threadKey string) (_ []byte, _ time.Time, err error) {
 	// Creates a Key instance.
 	key := sv.repo.DatKey(threadKey, sv.repo.BoardKey(boardName))
@@ -114,26 +119,28 @@ func (sv *BoardService) MakeDat(boardName, threadKey string) (_ []byte, _ time.T
 		return
 	}
 
+	if !dat.Sjis {
+		// 移行前のdat
+		return util.UTF8toSJIS(dat.Bytes), dat.LastModified, nil
+	}
 	return dat.Bytes, dat.LastModified, nil
 }
 
 // データストアからエンティティを取得しsubject.txtとして返す
 func (sv *BoardService) MakeSubjectTxt(boardName string) (_ []byte, err error) {
-	// Creates a Key instance.
-	key := sv.repo.BoardKey(boardName)
-
-	// Gets a Board
-	e := new(board.Entity)
-	if err = sv.repo.GetBoard(key, e); err != nil {
+	subjects, err := loadSubjects(sv.repo, boardName)
+	if err != nil {
 		return
 	}
+	return makeSubjectTxt(subjects), nil
+}
 
+func makeSubjectTxt(subjects []board.Subject) []byte {
 	buf := new(bytes.Buffer)
-	for _, s := range e.Subjects {
+	for _, s := range subjects {
 		fmt.Fprintf(buf, "%s.dat<>%s \t (%d)\n", s.ThreadKey, s.ThreadTitle, s.MessageCount)
 	}
-
-	return buf.Bytes(), nil
+	return buf.Bytes()
 }
 
 // Creates a Thread
@@ -141,162 +148,217 @@ func (sv *BoardService) CreateThread(stng bbscfg.Setting, boardName string,
 	name, mail, id, message, title string) (threadKey string, err error) {
 
 	// New Thread
-	subject := createSubject(sv.StartedAt(), title)
-	dat := createDat(name, mail, sv.StartedAt(), id, message, title)
-	threadKey = subject.ThreadKey
+	now := sv.StartedAt()
+	subject := createSubject(now, title)
+	dat := createDat(name, mail, now, id, message, title)
+	firstLine := dat.Bytes // 検索の索引はUTF-8で作る
+	encodeDatSjis(dat)
 
 	// Key
 	boardKey := sv.repo.BoardKey(boardName)
-	datKey := sv.repo.DatKey(threadKey, boardKey)
+
+	// 制限チェキ
+	// トランザクションの外で数えるので、同時にスレ立てされると少し超えることがある
+	subjects, err := loadSubjects(sv.repo, boardName)
+	if err == datastore.ErrNoSuchEntity {
+		return "", errors.New(errors.BOARD_NOT_FOUND, "unknown board: %v", boardName)
+	}
+	if err != nil {
+		return
+	}
+	if n := len(subjects); n >= stng.STUB_THREAD_COUNT() {
+		return "", errors.New(errors.THREAD_LIMIT, "%d: これ以上スレ立てできません。。。", n)
+	}
+	writeCount, err := loadWriteCount(sv.repo, boardKey)
+	if err != nil {
+		return
+	}
+	if writeCount >= stng.STUB_WRITE_ENTITY_LIMIT() {
+		return "", errors.New(errors.WRITE_LIMIT, "%d: 今日はこれ以上スレ立てできません。。。", writeCount)
+	}
 
 	// Start transaction
 	err = sv.repo.RunInTransaction(func(tx *datastore.Transaction) error {
-		// Get And Check
-		boardEntity := &board.Entity{}
-		if err := sv.repo.TxGetBoard(tx, boardKey, boardEntity); err != nil {
+		// 空いているスレッドキーを探す
+		datKey, err := sv.findFreeDatKey(tx, boardKey, now)
+		if err != nil {
 			return err
 		}
-		for _, sbj := range boardEntity.Subjects {
-			if sbj.ThreadKey == datKey.DSKey.Name {
-				return fmt.Errorf("thread key is duplicate")
-			}
-		}
-
-		// 制限チェキ
-		if n := len(boardEntity.Subjects); n >= stng.STUB_THREAD_COUNT() {
-			return fmt.Errorf("%d: これ以上スレ立てできません。。。", n)
-		}
-		if n := boardEntity.WriteCount; n >= stng.STUB_WRITE_ENTITY_LIMIT() {
-			return fmt.Errorf("%d: 今日はこれ以上スレ立てできません。。。", n)
-		}
-
-		// 先頭に追加
-		appendSubject(boardEntity, subject)
+		subject.ThreadKey = datKey.DSKey.Name
 
 		// Save
-		if err := sv.repo.TxPutBoard(tx, boardKey, boardEntity); err != nil {
+		threadKey := sv.repo.ThreadKey(subject.ThreadKey, boardKey)
+		if err := sv.repo.TxPutThread(tx, threadKey, newThreadEntity(subject, now, true)); err != nil {
 			return err
 		}
 		if err := sv.repo.TxPutDat(tx, datKey, dat); err != nil {
 			return err
 		}
-		return nil
+		if err := sv.txPutPost(tx, boardName, subject.ThreadKey, 1, firstLine, subject.ThreadTitle); err != nil {
+			return err
+		}
+		return txAddWriteCount(sv.repo, tx, boardKey, 1)
 	})
-	return
+	if err != nil {
+		return
+	}
+	setWriteCountMetric(boardName, writeCount+1, stng.STUB_WRITE_ENTITY_LIMIT())
+
+	return subject.ThreadKey, nil
+}
+
+// 同じ秒にスレが立っていたら1秒ずつ後ろにずらして空きを探す。
+// datをトランザクション内で読んでいるので、
+// 別インスタンスで同じキーを取った場合はどちらかのコミットが失敗してやり直しになる。
+func (sv *BoardService) findFreeDatKey(tx *datastore.Transaction,
+	boardKey *board.Key, now time.Time) (*dat.Key, error) {
+
+	for i := int64(0); i < thread_key_probe_max; i++ {
+		threadKey := strconv.FormatInt(now.Unix()+i, 10)
+		// dat落ちしたスレのdatも残っている
+		datKey := sv.repo.DatKey(threadKey, boardKey)
+		err := sv.repo.TxGetDatMeta(tx, datKey, &dat.Entity{})
+		if err == datastore.ErrNoSuchEntity {
+			return datKey, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, errors.New(errors.DUPLICATE_KEY, "thread key is duplicate")
 }
 
 func createSubject(now time.Time, title string) *board.Subject {
 	return &board.Subject{
 		ThreadKey:    strconv.FormatInt(now.Unix(), 10),
-		ThreadTitle:  escapeDat(html.EscapeString(title)),
+		ThreadTitle:  escapeDat(util.EscapeHTML(title)),
 		MessageCount: 1,
 		LastModified: now,
 	}
 }
 
-func appendSubject(boardEntity *board.Entity, subject *board.Subject) {
-	boardEntity.Subjects = append([]board.Subject{*subject}, boardEntity.Subjects...)
-	boardEntity.WriteCount++
-	return
-}
-
+// レスを書き込む。
+// 書き込むスレのエンティティだけを更新するので、別のスレへの書き込みとはぶつからない。
 func (sv *BoardService) WriteDat(stng bbscfg.Setting, boardName, threadKey,
 	name, mail, id, message string) (resnum int, err error) {
 
 	// Creates a Key instance.
 	boardKey := sv.repo.BoardKey(boardName)
 	datKey := sv.repo.DatKey(threadKey, boardKey)
+	threadEntityKey := sv.repo.ThreadKey(threadKey, boardKey)
+	now := sv.env.StartedAt()
+
+	// データストア制限チェック
+	// 板全体の数をトランザクションで読むとほかのスレへの書き込みとぶつかるので外で数える
+	writeCount, err := loadWriteCount(sv.repo, boardKey)
+	if err != nil {
+		return
+	}
+	if writeCount >= stng.STUB_WRITE_ENTITY_LIMIT() {
+		return 0, errors.New(errors.WRITE_LIMIT, "%d: 今日はこれ以上書き込めません。。。", writeCount)
+	}
 
 	err = sv.repo.RunInTransaction(func(tx *datastore.Transaction) error {
 		// Get Entities
+		// datの中身は読まない
 		dat := new(dat.Entity)
-		if err := sv.repo.TxGetDat(tx, datKey, dat); err != nil {
-			return err
+		th := new(thread.Entity)
+		err := sv.repo.TxGetDatMeta(tx, datKey, dat)
+		if err == nil {
+			err = sv.repo.TxGetThread(tx, threadEntityKey, th)
 		}
-		board := new(board.Entity)
-		if err := sv.repo.TxGetBoard(tx, boardKey, board); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return errors.New(errors.THREAD_NOT_FOUND, "no such thread: %v", threadKey)
+		}
+		if err != nil {
 			return err
 		}
 
-		// 容量オーバー
-		if len(util.UTF8toSJIS(dat.Bytes)) >= stng.STUB_DAT_CAPACITY() {
-			return fmt.Errorf("容量超過: これ以上書き込めません。。。")
+		// 旧形式のエンティティはサイズを持っていない
+		if dat.ChunkCount == 0 && !dat.Sjis {
+			dat.Size = len(util.UTF8toSJIS(dat.Bytes))
 		}
 
-		// 書き込み
-		appendDat(dat, name, mail, sv.env.StartedAt(), id, message)
+		// 容量オーバー
+		if dat.Size >= stng.STUB_DAT_CAPACITY() {
+			return errors.New(errors.DAT_OVER_CAPACITY, "容量超過: これ以上書き込めません。。。")
+		}
 
 		// subject.txtの更新
-		resnum, err = updateSubjectsWhenWriteDat(stng, board, threadKey, mail, sv.env.StartedAt())
+		resnum, err = updateThreadWhenWriteDat(stng, th, threadKey, mail, now)
 		if err != nil {
 			return err
 		}
 
+		// 書き込み
+		line := appendDat(dat, resnum, name, mail, now, id, message)
+		if err := sv.txPutPost(tx, boardName, threadKey, resnum, line, th.ThreadTitle); err != nil {
+			return err
+		}
+
 		// 1001カキコ
 		if n := stng.STUB_MESSAGE_COUNT(); resnum == n {
-			dat.Bytes = append(dat.Bytes, []byte(fmt.Sprintf(dat_format_1001, n+1, n, n))...)
+			line = append(line, []byte(fmt.Sprintf(dat_format_1001, n+1, n, n))...)
+		}
+
+		// datと同じ文字コードで追記する
+		sjisLine := util.UTF8toSJIS(line)
+		dat.Size += len(sjisLine)
+		if dat.Sjis {
+			line = sjisLine
 		}
 
 		// Push Entities
-		if err := sv.repo.TxPutDat(tx, datKey, dat); err != nil {
+		if err := sv.repo.TxAppendDat(tx, datKey, dat, line); err != nil {
 			return err
 		}
-		if err = sv.repo.TxPutBoard(tx, boardKey, board); err != nil {
+		if err := sv.repo.TxPutThread(tx, threadEntityKey, th); err != nil {
 			return err
 		}
-		return nil
+		return txAddWriteCount(sv.repo, tx, boardKey, 1)
 	})
+	if err != nil {
+		return
+	}
+	setWriteCountMetric(boardName, writeCount+1, stng.STUB_WRITE_ENTITY_LIMIT())
 	return
 }
 
-func updateSubjectsWhenWriteDat(stng bbscfg.Setting, board *board.Entity,
+func updateThreadWhenWriteDat(stng bbscfg.Setting, th *thread.Entity,
 	threadKey string, mail string, now time.Time) (resnum int, err error) {
 
-	sbjLen := len(board.Subjects)
-	pos := -1
-	for i, sbj := range board.Subjects {
-		if sbj.ThreadKey == threadKey {
-			pos = i
-			break
-		}
-	}
-	if pos == -1 {
-		err = fmt.Errorf("fail update subjects. len:%v key:%v", sbjLen, threadKey)
+	// dat落ちしたスレ
+	if !th.Live {
+		err = errors.New(errors.THREAD_NOT_FOUND, "fail update subjects. key:%v", threadKey)
 		return
 	}
 
-	// データストア制限チェック
-	if board.WriteCount >= stng.STUB_WRITE_ENTITY_LIMIT() {
-		err = fmt.Errorf("%d: 今日はこれ以上書き込めません。。。", board.WriteCount)
+	// 停止したスレ
+	if th.Stopped {
+		err = errors.New(errors.THREAD_STOPPED, "%v: このスレッドは停止されています。", threadKey)
 		return
 	}
 
-	resnum = board.Subjects[pos].MessageCount + 1
+	resnum = th.MessageCount + 1
 
 	// 1001チェキ
 	maxMsgCnt := stng.STUB_MESSAGE_COUNT()
 	if resnum > maxMsgCnt {
-		err = fmt.Errorf("%d: これ以上書き込めません。。。", resnum)
+		err = errors.New(errors.THREAD_FULL, "%d: これ以上書き込めません。。。", resnum)
 		return
 	}
 
 	// エンティティ更新
 	if resnum == maxMsgCnt {
-		board.Subjects[pos].MessageCount = resnum + 1 // 1000だったら1001にしてしまう
+		th.MessageCount = resnum + 1 // 1000だったら1001にしてしまう
 	} else {
-		board.Subjects[pos].MessageCount = resnum
+		th.MessageCount = resnum
 	}
-	board.Subjects[pos].LastModified = now
-	board.WriteCount++
+	th.LastModified = now
 
 	// (´∀`∩)↑age↑
-	if sbjLen > 1 && mail != "sage" {
-		// 切り出し
-		sbj := board.Subjects[pos]
-		board.Subjects = append(board.Subjects[:pos], board.Subjects[pos+1:]...)
-		// 先頭に追加
-		board.Subjects, board.Subjects[0] =
-			append(board.Subjects[0:1], board.Subjects[0:]...), sbj
+	if mail != "sage" {
+		th.AgedAt = now
 	}
 	return
 }
@@ -304,37 +366,50 @@ func updateSubjectsWhenWriteDat(stng bbscfg.Setting, board *board.Entity,
 // create dat. line: 1
 func createDat(name string, mail string, date time.Time, id string, message string, title string) *dat.Entity {
 	dat := &dat.Entity{}
-	writeDat(dat, dat_format, name, mail, date, id, message, title)
+	dat.Bytes = writeDat(dat, dat_format, 1, name, mail, date, id, message, title)
 	return dat
 }
 
 // append dat. line: 2..
-func appendDat(dat *dat.Entity,
-	name string, mail string, date time.Time, id string, message string) {
+// 追記する1行を返す。datの中身には追加しない。
+func appendDat(dat *dat.Entity, resnum int,
+	name string, mail string, date time.Time, id string, message string) []byte {
 
-	writeDat(dat, dat_format, name, mail, date, id, message, "")
+	return writeDat(dat, dat_format, resnum, name, mail, date, id, message, "")
 }
 
-func writeDat(dat *dat.Entity, format string,
-	name string, mail string, date time.Time, id string, message string, title string) {
+func writeDat(dat *dat.Entity, format string, resnum int,
+	name string, mail string, date time.Time, id string, message string, title string) []byte {
 
-	wr := bytes.NewBuffer(dat.Bytes)
+	// 安価の索引
+	indexAnchors(dat, resnum, message)
+
+	wr := &bytes.Buffer{}
 	// 名前<>メール欄<>年/月/日(曜) 時:分:秒.ミリ秒 ID:hogehoge0<> 本文 <>スレタイ
 	// 2行目以降はスレタイは無し
 	fmt.Fprintf(wr, format,
-		// 名前: トリップの関係でhtml.EscapeStringはトリップのところでやる
+		// 名前: トリップの関係でエスケープはトリップのところでやる
 		escapeDat(name),
-		escapeDat(html.EscapeString(mail)), // メール
-		date.Format(dat_date_layout),       // 年月日
-		week_days_jp[date.Weekday()],       // 曜
-		date.Format(dat_time_layout),       // 時分秒
-		id,                                 // ID
-		escapeDatMessage(html.EscapeString(message)), // 本文
-		escapeDat(html.EscapeString(title)),          // スレタイ
+		escapeDat(util.EscapeHTML(mail)), // メール
+		date.Format(dat_date_layout),     // 年月日
+		week_days_jp[date.Weekday()],     // 曜
+		date.Format(dat_time_layout),     // 時分秒
+		id,                               // ID
+		escapeDatMessage(util.EscapeHTML(message)), // 本文
+		escapeDat(util.EscapeHTML(title)),          // スレタイ
 	)
 
-	dat.Bytes = wr.Bytes()
 	dat.LastModified = date
+	return wr.Bytes()
+}
+
+// datをShift_JISにしてサイズを記録する
+func encodeDatSjis(dat *dat.Entity) {
+	if !dat.Sjis {
+		dat.Bytes = util.UTF8toSJIS(dat.Bytes)
+		dat.Sjis = true
+	}
+	dat.Size = len(dat.Bytes)
 }
 
 func escapeDatMessage(str string) string {
@@ -345,7 +420,7 @@ func escapeDatMessage(str string) string {
 // メッセージはAAがあるので削除しない。
 func escapeDat(str string) string {
 	s := strings.ReplaceAll(str, "\n", "")
-	s = strings.ReplaceAll(str, "\t", "")
+	s = strings.ReplaceAll(s, "\t", "")
 	return s
 }
 
@@ -370,12 +445,8 @@ func (sv *BoardService) StartedAt() time.Time {
 // データストアからエンティティを取得しjsonとして返す
 func (sv *BoardService) MakeSubjectJson(boardName string, limit int) (_ []byte, err error) {
 
-	// Creates a Key instance.
-	key := sv.repo.BoardKey(boardName)
-
-	// Gets a Board
-	e := new(board.Entity)
-	if err = sv.repo.GetBoard(key, e); err != nil {
+	subjects, err := loadSubjects(sv.repo, boardName)
+	if err != nil {
 		return
 	}
 
@@ -389,13 +460,13 @@ func (sv *BoardService) MakeSubjectJson(boardName string, limit int) (_ []byte,
 		return nil, err
 	}
 
-	ln := len(e.Subjects)
+	ln := len(subjects)
 	for i := 0; i < limit && i < ln; i++ {
 		var sbj jboard.Subject
-		sbj.ThreadKey = e.Subjects[i].ThreadKey
-		sbj.ThreadTitle = e.Subjects[i].ThreadTitle
-		sbj.MessageCount = e.Subjects[i].MessageCount
-		sbj.LastModified = e.Subjects[i].LastModified.In(jst).Format("2006/01/02 15:04:05")
+		sbj.ThreadKey = subjects[i].ThreadKey
+		sbj.ThreadTitle = subjects[i].ThreadTitle
+		sbj.MessageCount = subjects[i].MessageCount
+		sbj.LastModified = subjects[i].LastModified.In(jst).Format("2006/01/02 15:04:05")
 		jsonObj.Subjects = append(jsonObj.Subjects, sbj)
 	}
 
@@ -415,6 +486,36 @@ func (sv *BoardService) MakeDatJson(boardName, threadKey string,
 		return
 	}
 
+	jsonObj, err := sv.parseDat(dat, min, max)
+	if err != nil {
+		return
+	}
+	if jsonObj.LastModified == ifModifiedSince {
+		err = errors.NOT_MODIFIED
+		return
+	}
+
+	return json.Marshal(jsonObj)
+}
+
+// データストアからエンティティを取得し全レスを解析して返す
+func (sv *BoardService) MakeDatObject(boardName, threadKey string) (_ *jdat.Object, err error) {
+
+	// Creates a Key instance.
+	key := sv.repo.DatKey(threadKey, sv.repo.BoardKey(boardName))
+
+	// Gets a Board
+	dat := new(dat.Entity)
+	if err = sv.repo.GetDat(key, dat); err != nil {
+		return
+	}
+
+	return sv.parseDat(dat, 1, bytes.Count(dat.Bytes, []byte{'\n'}))
+}
+
+// datを解析してmin番目からmax番目までのレスを返す
+func (sv *BoardService) parseDat(dat *dat.Entity, min, max int) (_ *jdat.Object, err error) {
+
 	jst, err := time.LoadLocation("Asia/Tokyo")
 	if err != nil {
 		return
@@ -425,12 +526,15 @@ func (sv *BoardService) MakeDatJson(boardName, threadKey string,
 		LastModified: dat.LastModified.In(jst).Format(http.TimeFormat),
 		Precure:      sv.StartedAt().Unix(),
 	}
-	if jsonObj.LastModified == ifModifiedSince {
-		err = errors.NOT_MODIFIED
-		return
+
+	anchors, replies := makeAnchorMaps(dat.Anchors)
+
+	utf8Dat := dat.Bytes
+	if dat.Sjis {
+		utf8Dat = util.SJIStoUTF8(dat.Bytes)
 	}
 
-	msgs := bytes.Split(dat.Bytes, []byte{'\n'})
+	msgs := bytes.Split(utf8Dat, []byte{'\n'})
 	ln := len(msgs) - 1 // 最後の\nのため空文字のため-1する
 	for i := util.MaxInt(0, min-1); i < max && i < ln; i++ {
 		parsed := bytes.Split(msgs[i], []byte("<>"))
@@ -440,11 +544,13 @@ func (sv *BoardService) MakeDatJson(boardName, threadKey string,
 			Mail:      string(parsed[1]),
 			DateAndId: string(parsed[2]),
 			Content:   string(bytes.Trim(parsed[3], " ")),
+			Anchors:   append([]int{}, anchors[i+1]...),
+			Replies:   append([]int{}, replies[i+1]...),
 		}
 		jsonObj.Messages = append(jsonObj.Messages, msg)
 	}
 
 	jsonObj.ThreadTitle = string(bytes.Split(msgs[0], []byte("<>"))[4])
 
-	return json.Marshal(jsonObj)
+	return jsonObj, nil
 }
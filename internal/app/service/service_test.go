@@ -3,20 +3,29 @@ package service
 import (
 	"bytes"
 	"cloud.google.com/go/datastore"
+	"context"
 	"fmt"
+	"github.com/tempxla/stub2ch/configs/app/secretcfg"
 	"github.com/tempxla/stub2ch/internal/app/service/repository"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/thread"
+	"github.com/tempxla/stub2ch/internal/app/types/errors"
+	"github.com/tempxla/stub2ch/internal/app/util"
 	"github.com/tempxla/stub2ch/tools/app/testutil"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestDefaultBoardService(t *testing.T) {
-	sv, err := DefaultBoardService()
+	if err := ConfigureConfirmKeys(secretcfg.CONFIRM_KEY_ID + ":test"); err != nil {
+		t.Fatal(err)
+	}
+	sv, err := DefaultBoardService(context.Background())
 	if sv == nil || err != nil {
-		t.Errorf("DefaultBoardService() = %v, %v", sv, err)
+		t.Errorf("DefaultBoardService(context.Background()) = %v, %v", sv, err)
 	}
 }
 
@@ -63,7 +72,7 @@ func TestMakeDat(t *testing.T) {
 		lastModified         time.Time
 		err                  error
 	}{
-		{"news4test", "123", []byte("1行目\n2行目\n"), now, nil},
+		{"news4test", "123", util.UTF8toSJIS([]byte("1行目\n2行目\n")), now, nil},
 		{"news4test", "999", nil, time.Time{}, datastore.ErrNoSuchEntity},
 	}
 
@@ -159,16 +168,16 @@ func TestCreateThread(t *testing.T) {
 				nil,
 			},
 		},
-		// Error: 同時刻でのスレ立て
+		// OK: 同時刻でのスレ立ては次の秒のキーになる
 		{
-			{"news4test", "名前2", "メール2", "ABCDEFGH02", "メッセージ2", "タイトル2",
+			{"news4test", "名前3", "メール3", "ABCDEFGH03", "メッセージ3", "タイトル3",
 				testutil.NewTimeJST(t, "2020-01-18 11:45:57.123"),
-				fmt.Errorf("thread key is duplicate"),
+				nil,
 			},
 		},
 		// OK: 板単位のスレッド数制限まで立て続ける
-		makeTestSequence("news4test", stng.STUB_THREAD_COUNT()-2,
-			3, testutil.NewTimeJST(t, "2020-01-18 11:45:58.123")),
+		makeTestSequence("news4test", stng.STUB_THREAD_COUNT()-3,
+			4, testutil.NewTimeJST(t, "2020-01-18 11:46:00.123")),
 		// Error: 板単位のスレッド数制限
 		{
 			{"news4test", "名前2", "メール2", "ABCDEFGH02", "メッセージ2", "タイトル2",
@@ -178,7 +187,7 @@ func TestCreateThread(t *testing.T) {
 		},
 	}
 
-	expected := testutil.InitialBoardStub("news4test")
+	expected := &board.Entity{Subjects: []board.Subject{}}
 	for i, tts := range tests {
 		for j, tt := range tts {
 
@@ -199,9 +208,15 @@ func TestCreateThread(t *testing.T) {
 
 			// [expected data]
 			expectedSubject := createSubject(tt.time, tt.title)
-			expectedBoardEntity := expected.BoardMap[tt.boardName]
-			appendSubject(expectedBoardEntity, expectedSubject)
+			for _, sbj := range expected.Subjects {
+				if sbj.ThreadKey == expectedSubject.ThreadKey {
+					expectedSubject.ThreadKey = strconv.FormatInt(tt.time.Unix()+1, 10)
+				}
+			}
+			expected.Subjects = append([]board.Subject{*expectedSubject}, expected.Subjects...)
+			expected.WriteCount++
 			expectedDatEntity := createDat(tt.name, tt.mail, tt.time, tt.id, tt.message, tt.title)
+			encodeDatSjis(expectedDatEntity)
 
 			// verify return value.
 			if err != nil {
@@ -218,17 +233,14 @@ func TestCreateThread(t *testing.T) {
 			}
 
 			// verify datastore.
-			boardEntity := &board.Entity{}
+			boardEntity := repo.BoardView(tt.boardName)
 			boardKey := repo.BoardKey(tt.boardName)
-			if err := repo.GetBoard(boardKey, boardEntity); err != nil {
-				t.Errorf("(%d,%d) GetBoard: %v", i, j, err)
-			}
 			datEntity := &dat.Entity{}
 			if err := repo.GetDat(repo.DatKey(threadKey, boardKey), datEntity); err != nil {
 				t.Errorf("(%d,%d) GetDat: %v", i, j, err)
 			}
-			if !testutil.EqualBoardEntity(t, boardEntity, expectedBoardEntity) {
-				t.Errorf("(%d,%d): unexpected BoardEntity: \nact:%v \nexp:%v", i, j, boardEntity, expectedBoardEntity)
+			if !testutil.EqualBoardEntity(t, boardEntity, expected) {
+				t.Errorf("(%d,%d): unexpected BoardEntity: \nact:%v \nexp:%v", i, j, boardEntity, expected)
 			}
 			if !testutil.EqualDatEntity(t, datEntity, expectedDatEntity) {
 				t.Errorf("(%d,%d): unexpected DatEntity: \nact:%v \nexp:%v", i, j, datEntity, expectedDatEntity)
@@ -237,6 +249,49 @@ func TestCreateThread(t *testing.T) {
 	}
 }
 
+func TestCreateThread_KeyProbe(t *testing.T) {
+
+	now := testutil.NewTimeJST(t, "2020-01-18 11:45:57.123")
+	repo := testutil.NewBoardStub("news4test", []testutil.ThreadStub{
+		{ThreadKey: strconv.FormatInt(now.Unix(), 10)},
+	})
+	// dat落ちしたスレのdatだけ残っている
+	repo.DatMap["news4test"][strconv.FormatInt(now.Unix()+1, 10)] = &dat.Entity{}
+	stng := testutil.NewSettingStub()
+
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: now}))
+
+	threadKey, err := sv.CreateThread(stng, "news4test", "name", "mail", "ABCDEFGH", "message", "title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strconv.FormatInt(now.Unix()+2, 10); threadKey != want {
+		t.Errorf("threadKey = %v, want: %v", threadKey, want)
+	}
+	if sbj := repo.Subjects("news4test")[0]; sbj.ThreadKey != threadKey {
+		t.Errorf("Subjects[0].ThreadKey = %v, want: %v", sbj.ThreadKey, threadKey)
+	}
+	if _, ok := repo.DatMap["news4test"][threadKey]; !ok {
+		t.Errorf("dat not found: %v", threadKey)
+	}
+}
+
+func TestCreateThread_KeyExhausted(t *testing.T) {
+
+	now := testutil.NewTimeJST(t, "2020-01-18 11:45:57.123")
+	repo := testutil.InitialBoardStub("news4test")
+	for i := int64(0); i < thread_key_probe_max; i++ {
+		repo.DatMap["news4test"][strconv.FormatInt(now.Unix()+i, 10)] = &dat.Entity{}
+	}
+	stng := testutil.NewSettingStub()
+
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: now}))
+
+	if _, err := sv.CreateThread(stng, "news4test", "name", "mail", "ABCDEFGH", "message", "title"); errors.KindOf(err) != errors.DUPLICATE_KEY {
+		t.Errorf("err = %v, want: DUPLICATE_KEY", err)
+	}
+}
+
 func TestCreateThread_EntityLimit(t *testing.T) {
 
 	repo := testutil.InitialBoardStub("news4test")
@@ -263,8 +318,126 @@ func TestCreateThread_EntityLimit(t *testing.T) {
 		EnvConf(&SysEnv{StartedTime: testutil.NewTimeJST(t, "2020-01-18 12:45:58.123")}),
 	)
 	_, err = sv.CreateThread(stng, "news4test", "nameN", "mailN", "ABCDEFGH0N", "messageN", "titleN")
-	if err == nil {
-		t.Errorf("err is nil, want: %v", fmt.Errorf("%d: 今日はこれ以上スレ立てできません。。。", stng.STUB_WRITE_ENTITY_LIMIT()))
+	if errors.KindOf(err) != errors.WRITE_LIMIT {
+		t.Errorf("err = %v, want: %v", err, fmt.Errorf("%d: 今日はこれ以上スレ立てできません。。。", stng.STUB_WRITE_ENTITY_LIMIT()))
+	}
+}
+
+func TestWriteDat_Size(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4test")
+	stng := testutil.NewSettingStub()
+
+	sv := NewBoardService(
+		RepoConf(repo),
+		EnvConf(&SysEnv{StartedTime: testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")}),
+	)
+	threadKey, err := sv.CreateThread(stng, "news4test", "name1", "mail1", "ABCDEFGH01", "message1", "title1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.WriteDat(stng, "news4test", threadKey, "名前", "", "ABCDEFGH02", "本文"); err != nil {
+		t.Fatal(err)
+	}
+
+	// メトリクス
+	if n := metricWriteCount.Value("news4test"); n != 2 {
+		t.Errorf("metricWriteCount = %v, want: 2", n)
+	}
+	if n := metricWriteLimit.Value("news4test"); n != float64(stng.STUB_WRITE_ENTITY_LIMIT()) {
+		t.Errorf("metricWriteLimit = %v, want: %v", n, stng.STUB_WRITE_ENTITY_LIMIT())
+	}
+
+	entity := repo.DatMap["news4test"][threadKey]
+	if want := len(entity.Bytes); !entity.Sjis || entity.Size != want {
+		t.Errorf("entity.Size = %v, want: %v", entity.Size, want)
+	}
+
+	// 容量オーバー
+	entity.ChunkCount = 1
+	entity.Size = stng.STUB_DAT_CAPACITY()
+	if _, err := sv.WriteDat(stng, "news4test", threadKey, "名前", "", "ABCDEFGH02", "本文"); errors.KindOf(err) != errors.DAT_OVER_CAPACITY {
+		t.Errorf("err = %v, want: DAT_OVER_CAPACITY", err)
+	}
+
+	// 存在しないスレ
+	if _, err := sv.WriteDat(stng, "news4test", "9999999999", "名前", "", "ABCDEFGH02", "本文"); errors.KindOf(err) != errors.THREAD_NOT_FOUND {
+		t.Errorf("err = %v, want: THREAD_NOT_FOUND", err)
+	}
+}
+
+// Shift_JISに無い文字は数値文字参照にして、行を壊さない
+func TestWriteDat_NotSjis(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4test")
+	stng := testutil.NewSettingStub()
+
+	sv := NewBoardService(
+		RepoConf(repo),
+		EnvConf(&SysEnv{StartedTime: testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")}),
+	)
+	threadKey, err := sv.CreateThread(stng, "news4test", "name1", "", "ABCDEFGH01", "\ufffd", "title😀")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.WriteDat(stng, "news4test", threadKey, "名前", "", "ABCDEFGH02", "😀本文"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sv.WriteDat(stng, "news4test", threadKey, "名前", "", "ABCDEFGH03", "本文3"); err != nil {
+		t.Fatal(err)
+	}
+
+	entity := repo.DatMap["news4test"][threadKey]
+	lines := strings.Split(string(util.SJIStoUTF8(entity.Bytes)), "\n")
+	if len(lines) != 4 ||
+		!strings.HasSuffix(lines[0], "<> &#65533; <>title&#128512;") ||
+		!strings.HasSuffix(lines[1], "<> &#128512;本文 <>") ||
+		!strings.HasSuffix(lines[2], "<> 本文3 <>") {
+		t.Errorf("dat = %q", lines)
+	}
+	if entity.Size != len(entity.Bytes) {
+		t.Errorf("entity.Size = %v, want: %v", entity.Size, len(entity.Bytes))
+	}
+}
+
+func TestWriteDat_Utf8Dat(t *testing.T) {
+
+	now := testutil.NewTimeJST(t, "2020-01-18 18:16:51.345")
+	repo := testutil.NewBoardStub("news4test", []testutil.ThreadStub{
+		{
+			ThreadKey:    "123",
+			MessageCount: 1,
+			Dat:          "名前<>メール<>日付 ID:X<> 本文1 <>スレタイ\n",
+		},
+	})
+	stng := testutil.NewSettingStub()
+
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: now}))
+	if _, err := sv.WriteDat(stng, "news4test", "123", "名前", "", "ABCDEFGH02", "本文2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 移行前のdatにはUTF-8のまま追記する
+	entity := repo.DatMap["news4test"]["123"]
+	if entity.Sjis {
+		t.Errorf("entity.Sjis = %v", entity.Sjis)
+	}
+	if want := len(util.UTF8toSJIS(entity.Bytes)); entity.Size != want {
+		t.Errorf("entity.Size = %v, want: %v", entity.Size, want)
+	}
+	if !bytes.Contains(entity.Bytes, []byte(" 本文2 ")) {
+		t.Errorf("entity.Bytes = %v", string(entity.Bytes))
+	}
+}
+
+func TestEncodeDatSjis(t *testing.T) {
+	entity := &dat.Entity{Bytes: []byte("あいうえお\n")}
+
+	encodeDatSjis(entity)
+	encodeDatSjis(entity) // 2回やっても変わらない
+
+	if !entity.Sjis || !bytes.Equal(entity.Bytes, util.UTF8toSJIS([]byte("あいうえお\n"))) || entity.Size != 11 {
+		t.Errorf("entity = %v, %v, %v", entity.Sjis, entity.Bytes, entity.Size)
 	}
 }
 
@@ -306,51 +479,15 @@ func TestCreateSubject(t *testing.T) {
 	}
 }
 
-func TestAppendSubject(t *testing.T) {
-	sbj1 := createSubject(testutil.NewTimeJST(t, "2020-01-18 23:05:01.234"), "title1")
-	sbj2 := createSubject(testutil.NewTimeJST(t, "2020-01-18 23:05:02.234"), "title2")
-	sbj3 := createSubject(testutil.NewTimeJST(t, "2020-01-18 23:05:03.234"), "title3")
-	boardEntity := &board.Entity{}
-	appendSubject(boardEntity, sbj1)
-	appendSubject(boardEntity, sbj2)
-	appendSubject(boardEntity, sbj3)
-
-	if ln := len(boardEntity.Subjects); ln != 3 {
-		t.Errorf("len = %v, want = %v, boardEntity.Subjects = %v", ln, 3, boardEntity.Subjects)
-	}
-	if boardEntity.Subjects[0] != *sbj3 ||
-		boardEntity.Subjects[1] != *sbj2 ||
-		boardEntity.Subjects[2] != *sbj1 {
-		t.Errorf("boardEntity.Subjects = %v", boardEntity.Subjects)
-	}
-	if boardEntity.WriteCount != 3 {
-		t.Errorf("boardEntity.WriteCount = %v, want: %v", boardEntity.WriteCount, 3)
-	}
-}
-
-func TestUpdateSubjectsWhenWriteDat_age(t *testing.T) {
+func TestUpdateThreadWhenWriteDat_age(t *testing.T) {
 	// Setup
 	t1 := time.Now().Add(time.Duration(-1) * time.Hour)
-	t2 := time.Now().Add(time.Duration(-2) * time.Hour)
-	t3 := time.Now().Add(time.Duration(-3) * time.Hour)
-	board := &board.Entity{
-		Subjects: []board.Subject{
-			{
-				ThreadKey:    "123",
-				MessageCount: 100,
-				LastModified: t1,
-			},
-			{
-				ThreadKey:    "999",
-				MessageCount: 200,
-				LastModified: t2,
-			},
-			{
-				ThreadKey:    "456",
-				MessageCount: 300,
-				LastModified: t3,
-			},
-		}}
+	th := &thread.Entity{
+		MessageCount: 200,
+		LastModified: t1,
+		AgedAt:       t1,
+		Live:         true,
+	}
 	threadKey := "999"
 	mail := ""
 	now := time.Now()
@@ -359,48 +496,31 @@ func TestUpdateSubjectsWhenWriteDat_age(t *testing.T) {
 	stng := testutil.NewSettingStub()
 
 	// Exercise
-	resunum, err := updateSubjectsWhenWriteDat(stng, board, threadKey, mail, now)
+	resnum, err := updateThreadWhenWriteDat(stng, th, threadKey, mail, now)
 	if err != nil {
 		t.Errorf("%v", err)
 	}
 
 	// Verify
-	if len(board.Subjects) != 3 {
-		t.Errorf("board count: %v", len(board.Subjects))
-	}
-	if resunum != 201 {
-		t.Errorf("wrong resnum: %v", resunum)
-	}
-	if board.Subjects[0].ThreadKey != "999" ||
-		board.Subjects[0].MessageCount != 201 ||
-		board.Subjects[0].LastModified != now ||
-		board.Subjects[1].ThreadKey != "123" ||
-		board.Subjects[1].MessageCount != 100 ||
-		board.Subjects[1].LastModified != t1 ||
-		board.Subjects[2].ThreadKey != "456" ||
-		board.Subjects[2].MessageCount != 300 ||
-		board.Subjects[2].LastModified != t3 {
-		t.Errorf("board content: %v", board.Subjects)
+	if resnum != 201 {
+		t.Errorf("wrong resnum: %v", resnum)
+	}
+	if th.MessageCount != 201 ||
+		th.LastModified != now ||
+		th.AgedAt != now {
+		t.Errorf("thread content: %v", th)
 	}
 }
 
-func TestUpdateSubjectsWhenWriteDat_sage(t *testing.T) {
+func TestUpdateThreadWhenWriteDat_sage(t *testing.T) {
 	// Setup
 	t1 := time.Now().Add(time.Duration(-1) * time.Hour)
-	t2 := time.Now().Add(time.Duration(-2) * time.Hour)
-	board := &board.Entity{
-		Subjects: []board.Subject{
-			{
-				ThreadKey:    "123",
-				MessageCount: 100,
-				LastModified: t1,
-			},
-			{
-				ThreadKey:    "999",
-				MessageCount: 200,
-				LastModified: t2,
-			},
-		}}
+	th := &thread.Entity{
+		MessageCount: 200,
+		LastModified: t1,
+		AgedAt:       t1,
+		Live:         true,
+	}
 	threadKey := "999"
 	mail := "sage"
 	now := time.Now()
@@ -409,32 +529,27 @@ func TestUpdateSubjectsWhenWriteDat_sage(t *testing.T) {
 	stng := testutil.NewSettingStub()
 
 	// Exercise
-	resnum, err := updateSubjectsWhenWriteDat(stng, board, threadKey, mail, now)
+	resnum, err := updateThreadWhenWriteDat(stng, th, threadKey, mail, now)
 	if err != nil {
 		t.Errorf("%v", err)
 	}
 
 	// Verify
-	if len(board.Subjects) != 2 {
-		t.Errorf("board count: %v", len(board.Subjects))
-	}
 	if resnum != 201 {
 		t.Errorf("wrong resnum: %v", resnum)
 	}
-	if board.Subjects[1].ThreadKey != "999" ||
-		board.Subjects[1].MessageCount != 201 ||
-		board.Subjects[1].LastModified != now ||
-		board.Subjects[0].ThreadKey != "123" ||
-		board.Subjects[0].MessageCount != 100 ||
-		board.Subjects[0].LastModified != t1 {
-		t.Errorf("board content: %v", board.Subjects)
+	if th.MessageCount != 201 ||
+		th.LastModified != now ||
+		th.AgedAt != t1 {
+		t.Errorf("thread content: %v", th)
 	}
 }
 
-func TestUpdateSubjectsWhenWriteDat_fail(t *testing.T) {
-	// Setup
-	board := &board.Entity{
-		Subjects: []board.Subject{},
+func TestUpdateThreadWhenWriteDat_fail(t *testing.T) {
+	// Setup: dat落ちしたスレ
+	th := &thread.Entity{
+		MessageCount: 200,
+		Live:         false,
 	}
 	threadKey := "888"
 	mail := "sage"
@@ -444,25 +559,22 @@ func TestUpdateSubjectsWhenWriteDat_fail(t *testing.T) {
 	stng := testutil.NewSettingStub()
 
 	// Exercise
-	_, err := updateSubjectsWhenWriteDat(stng, board, threadKey, mail, now)
+	_, err := updateThreadWhenWriteDat(stng, th, threadKey, mail, now)
 
 	// Verify
-	if err == nil {
-		t.Errorf("error is nil")
+	if errors.KindOf(err) != errors.THREAD_NOT_FOUND {
+		t.Errorf("err = %v, want: THREAD_NOT_FOUND", err)
 	}
 }
 
-func TestUpdateSubjectsWhenWriteDat_1001(t *testing.T) {
+func TestUpdateThreadWhenWriteDat_1001(t *testing.T) {
 	// Setup
 	t1 := time.Now().Add(time.Duration(-1) * time.Hour)
-	board := &board.Entity{
-		Subjects: []board.Subject{
-			{
-				ThreadKey:    "123",
-				MessageCount: 999,
-				LastModified: t1,
-			},
-		}}
+	th := &thread.Entity{
+		MessageCount: 999,
+		LastModified: t1,
+		Live:         true,
+	}
 	threadKey := "123"
 	mail := "sage"
 	now := time.Now()
@@ -471,22 +583,18 @@ func TestUpdateSubjectsWhenWriteDat_1001(t *testing.T) {
 	stng := testutil.NewSettingStub()
 
 	// Exercise
-	resnum, err := updateSubjectsWhenWriteDat(stng, board, threadKey, mail, now)
+	resnum, err := updateThreadWhenWriteDat(stng, th, threadKey, mail, now)
 	if err != nil {
 		t.Errorf("%v", err)
 	}
 
 	// Verify
-	if len(board.Subjects) != 1 {
-		t.Errorf("board count: %v", len(board.Subjects))
-	}
 	if resnum != 1000 {
 		t.Errorf("wrong resnum: %v", resnum)
 	}
-	if board.Subjects[0].ThreadKey != "123" ||
-		board.Subjects[0].MessageCount != 1001 ||
-		board.Subjects[0].LastModified != now {
-		t.Errorf("board content: %v", board.Subjects)
+	if th.MessageCount != 1001 ||
+		th.LastModified != now {
+		t.Errorf("thread content: %v", th)
 	}
 }
 
@@ -503,6 +611,13 @@ func TestCreateDat(t *testing.T) {
 	}
 }
 
+// 名前・メール・スレタイの改行とタブはdatを壊すので消す
+func TestEscapeDat(t *testing.T) {
+	if actual := escapeDat("a\nb\tc\n"); actual != "abc" {
+		t.Errorf("actual: %q", actual)
+	}
+}
+
 func TestAppendDat(t *testing.T) {
 	// Setup
 	date1, _ := time.ParseInLocation("2006-01-02 15:04:05.000",
@@ -512,7 +627,7 @@ func TestAppendDat(t *testing.T) {
 	// Exercise
 	date2, _ := time.ParseInLocation("2006-01-02 15:04:05.000",
 		"2019-11-24 22:29:01.123", time.Local)
-	appendDat(dat, "名前2", "メール2", date2, "XYZ", "本文2")
+	dat.Bytes = append(dat.Bytes, appendDat(dat, 2, "名前2", "メール2", date2, "XYZ", "本文2")...)
 
 	// Verify
 	excepted := []byte("名前<>メール<>2019/11/23(土) 22:29:01.123 ID:ABC<> 本文 <>スレタイ" +
@@ -522,6 +637,65 @@ func TestAppendDat(t *testing.T) {
 	}
 }
 
+func TestAppendDat_Anchors(t *testing.T) {
+	// Setup
+	date, _ := time.ParseInLocation("2006-01-02 15:04:05.000",
+		"2019-11-23 22:29:01.123", time.Local)
+	dat := createDat("名前", "メール", date, "ABC", "本文", "スレタイ")
+
+	// Exercise
+	appendDat(dat, 2, "名前2", "", date, "XYZ", ">>1")
+	appendDat(dat, 3, "名前3", "", date, "XYZ", ">>1-2 >>5")
+
+	// Verify
+	want := fmt.Sprint([]struct{ From, To int }{{2, 1}, {3, 1}, {3, 2}})
+	if have := fmt.Sprint(dat.Anchors); have != want {
+		t.Errorf("dat.Anchors = %v, want: %v", have, want)
+	}
+}
+
+func TestMakeDatObject(t *testing.T) {
+	// Setup
+	now := testutil.NewTimeJST(t, "2020-01-13 20:54:12.123")
+	repo := testutil.NewBoardStub("news4test", []testutil.ThreadStub{
+		{
+			ThreadKey:    "123",
+			Dat:          "名前<>メール<>日付 ID:X<> 本文1 <>スレタイ\n名前<><>日付 ID:Y<> &gt;&gt;1 <>\n",
+			LastModified: now,
+		},
+	})
+	datKey := repo.DatKey("123", repo.BoardKey("news4test"))
+	repo.DatMap["news4test"]["123"].Anchors = []dat.Anchor{{From: 2, To: 1}}
+
+	sv := NewBoardService(RepoConf(repo), EnvConf(&SysEnv{StartedTime: now}))
+
+	// Exercise
+	obj, err := sv.MakeDatObject("news4test", datKey.DSKey.Name)
+
+	// Verify
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.ThreadTitle != "スレタイ" {
+		t.Errorf("obj.ThreadTitle = %v", obj.ThreadTitle)
+	}
+	if len(obj.Messages) != 2 {
+		t.Fatalf("len(obj.Messages) = %v", len(obj.Messages))
+	}
+	if m := obj.Messages[0]; m.Num != 1 || m.Content != "本文1" ||
+		fmt.Sprint(m.Anchors) != "[]" || fmt.Sprint(m.Replies) != "[2]" {
+		t.Errorf("obj.Messages[0] = %v", m)
+	}
+	if m := obj.Messages[1]; m.Num != 2 || m.Content != "&gt;&gt;1" ||
+		fmt.Sprint(m.Anchors) != "[1]" || fmt.Sprint(m.Replies) != "[]" {
+		t.Errorf("obj.Messages[1] = %v", m)
+	}
+
+	if _, err := sv.MakeDatObject("news4test", "999"); err != datastore.ErrNoSuchEntity {
+		t.Errorf("err = %v, want: %v", err, datastore.ErrNoSuchEntity)
+	}
+}
+
 func TestComputeId(t *testing.T) {
 	// http://age.s22.xrea.com/talk2ch/id.txt
 	now, _ := time.ParseInLocation("2006/01/02", "2019/12/26", time.Local)
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/tempxla/stub2ch/configs/app/bbscfg"
+	"github.com/tempxla/stub2ch/internal/app/service/repository"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/setting"
+	"log"
+)
+
+// 管理画面用の設定値
+type BoardSettingValue struct {
+	Name       string
+	Value      string
+	Default    string
+	Overridden bool
+}
+
+type BoardSetting struct {
+	BoardName      string
+	Values         []BoardSettingValue
+	HeadTxt        string
+	DefaultHeadTxt string
+}
+
+// 板の設定を返す。管理者が変更した値があればそちらを使う。
+// 存在しない板の場合は nil を返す。
+func (sv *BoardService) GetSetting(boardName string) (bbscfg.Setting, error) {
+	stng, _, err := loadSetting(sv.repo, boardName)
+	return stng, err
+}
+
+// head.txt を返す。存在しない場合は nil を返す。
+func (sv *BoardService) MakeHeadTxt(boardName string) ([]byte, error) {
+	base, entity, err := loadSetting(sv.repo, boardName)
+	if err != nil || base == nil {
+		return nil, err
+	}
+	if entity.HeadTxt != "" {
+		return []byte(entity.HeadTxt), nil
+	}
+	return bbscfg.MakeHeadTxt(boardName), nil
+}
+
+func loadSetting(repo repository.BoardRepository, boardName string) (bbscfg.Setting, *setting.Entity, error) {
+	entity := &setting.Entity{}
+	base := bbscfg.GetSetting(boardName)
+	if base == nil {
+		return nil, entity, nil
+	}
+
+	err := repo.GetBoardSetting(repo.SettingKey(boardName), entity)
+	if err == datastore.ErrNoSuchEntity {
+		return base, entity, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make(map[string]string)
+	for _, v := range entity.Values {
+		values[v.Name] = v.Value
+	}
+	return &bbscfg.Override{Base: base, Values: values}, entity, nil
+}
+
+func (admin *AdminFunction) GetBoardSetting(boardName string) (*BoardSetting, error) {
+	base := bbscfg.GetSetting(boardName)
+	if base == nil {
+		return nil, fmt.Errorf("unknown board: %v", boardName)
+	}
+	stng, entity, err := loadSetting(admin.repo, boardName)
+	if err != nil {
+		return nil, err
+	}
+
+	overridden := make(map[string]bool)
+	for _, v := range entity.Values {
+		overridden[v.Name] = true
+	}
+
+	bs := &BoardSetting{
+		BoardName:      boardName,
+		HeadTxt:        entity.HeadTxt,
+		DefaultHeadTxt: string(bbscfg.MakeHeadTxt(boardName)),
+	}
+	defaults := bbscfg.SettingValues(base)
+	for i, v := range bbscfg.SettingValues(stng) {
+		bs.Values = append(bs.Values, BoardSettingValue{
+			Name:       v.Name,
+			Value:      v.Value,
+			Default:    defaults[i].Value,
+			Overridden: overridden[v.Name],
+		})
+	}
+	return bs, nil
+}
+
+// 設定を変更する。
+// 初期値と同じ値にした項目は上書きをやめる。
+// headTxtが nil なら変更せず、空なら初期値に戻す。
+func (admin *AdminFunction) UpdateBoardSetting(boardName string, values map[string]string, headTxt *string) error {
+	log.Printf("UpdateBoardSetting: %v %v", boardName, values)
+
+	base := bbscfg.GetSetting(boardName)
+	if base == nil {
+		return fmt.Errorf("unknown board: %v", boardName)
+	}
+	for name, value := range values {
+		if err := bbscfg.ValidateValue(name, value); err != nil {
+			return err
+		}
+	}
+
+	current := make(map[string]string)
+	_, entity, err := loadSetting(admin.repo, boardName)
+	if err != nil {
+		return err
+	}
+	for _, v := range entity.Values {
+		current[v.Name] = v.Value
+	}
+	for name, value := range values {
+		current[name] = value
+	}
+
+	newEntity := &setting.Entity{
+		HeadTxt:   entity.HeadTxt,
+		UpdatedAt: admin.env.StartedAt(),
+	}
+	if headTxt != nil {
+		newEntity.HeadTxt = *headTxt
+	}
+	for _, v := range bbscfg.SettingValues(base) {
+		if value, ok := current[v.Name]; ok && value != v.Value {
+			newEntity.Values = append(newEntity.Values, setting.Value{Name: v.Name, Value: value})
+		}
+	}
+
+	key := admin.repo.SettingKey(boardName)
+	if len(newEntity.Values) == 0 && newEntity.HeadTxt == "" {
+		return admin.repo.DeleteBoardSetting(key)
+	}
+	return admin.repo.PutBoardSetting(key, newEntity)
+}
+
+// 設定を全て初期値に戻す
+func (admin *AdminFunction) ResetBoardSetting(boardName string) error {
+	log.Printf("ResetBoardSetting: %v", boardName)
+	if bbscfg.GetSetting(boardName) == nil {
+		return fmt.Errorf("unknown board: %v", boardName)
+	}
+	return admin.repo.DeleteBoardSetting(admin.repo.SettingKey(boardName))
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"github.com/tempxla/stub2ch/configs/app/bbscfg"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/setting"
+	"github.com/tempxla/stub2ch/tools/app/testutil"
+	"testing"
+	"time"
+)
+
+func TestGetSetting(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4vip")
+	sysEnv := &SysEnv{StartedTime: time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)}
+	sv := NewBoardService(RepoConf(repo), EnvConf(sysEnv), AdminConf(repo, nil))
+	base := bbscfg.GetSetting("news4vip")
+
+	// 上書きなし
+	stng, err := sv.GetSetting("news4vip")
+	if err != nil || stng != base {
+		t.Errorf("GetSetting() = %v, %v", stng, err)
+	}
+
+	// 上書きあり
+	repo.PutBoardSetting(repo.SettingKey("news4vip"), &setting.Entity{
+		Values: []setting.Value{
+			{Name: "BBS_TITLE", Value: "TEST"},
+			{Name: "STUB_THREAD_COUNT", Value: "3"},
+		},
+	})
+	stng, err = sv.GetSetting("news4vip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stng.BBS_TITLE() != "TEST" || stng.STUB_THREAD_COUNT() != 3 {
+		t.Errorf("override is ignored: %v, %v", stng.BBS_TITLE(), stng.STUB_THREAD_COUNT())
+	}
+	if stng.BBS_NONAME_NAME() != base.BBS_NONAME_NAME() {
+		t.Errorf("BBS_NONAME_NAME() = %v", stng.BBS_NONAME_NAME())
+	}
+
+	// 存在しない板
+	if stng, err := sv.GetSetting("xxxx"); stng != nil || err != nil {
+		t.Errorf("GetSetting(xxxx) = %v, %v", stng, err)
+	}
+
+	// データストアのエラー
+	sv = NewBoardService(RepoConf(testutil.NewBrokenBoardStub()))
+	if _, err := sv.GetSetting("news4vip"); err == nil {
+		t.Error("err is nil")
+	}
+}
+
+func TestMakeHeadTxt(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4vip")
+	sysEnv := &SysEnv{StartedTime: time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)}
+	sv := NewBoardService(RepoConf(repo), EnvConf(sysEnv), AdminConf(repo, nil))
+
+	head, err := sv.MakeHeadTxt("news4vip")
+	if err != nil || string(head) != string(bbscfg.MakeHeadTxt("news4vip")) {
+		t.Errorf("MakeHeadTxt() = %s, %v", head, err)
+	}
+
+	repo.PutBoardSetting(repo.SettingKey("news4vip"), &setting.Entity{HeadTxt: "HEAD"})
+	head, err = sv.MakeHeadTxt("news4vip")
+	if err != nil || string(head) != "HEAD" {
+		t.Errorf("MakeHeadTxt() = %s, %v", head, err)
+	}
+
+	if head, err := sv.MakeHeadTxt("xxxx"); head != nil || err != nil {
+		t.Errorf("MakeHeadTxt(xxxx) = %s, %v", head, err)
+	}
+}
+
+func TestUpdateBoardSetting(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4vip")
+	sysEnv := &SysEnv{StartedTime: time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)}
+	sv := NewBoardService(RepoConf(repo), EnvConf(sysEnv), AdminConf(repo, nil))
+	base := bbscfg.GetSetting("news4vip")
+	head := "HEAD"
+
+	// Exercise
+	err := sv.Admin.UpdateBoardSetting("news4vip", map[string]string{
+		"BBS_TITLE":       "TEST",
+		"BBS_NONAME_NAME": base.BBS_NONAME_NAME(), // 初期値と同じ
+	}, &head)
+
+	// Verify
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity := repo.SettingMap["news4vip"]
+	if len(entity.Values) != 1 || entity.Values[0].Name != "BBS_TITLE" || entity.HeadTxt != "HEAD" {
+		t.Errorf("entity = %v", entity)
+	}
+
+	bs, err := sv.Admin.GetBoardSetting("news4vip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range bs.Values {
+		if v.Name == "BBS_TITLE" && (v.Value != "TEST" || v.Default != base.BBS_TITLE() || !v.Overridden) {
+			t.Errorf("%v", v)
+		}
+		if v.Name == "BBS_NONAME_NAME" && v.Overridden {
+			t.Errorf("%v", v)
+		}
+	}
+
+	// headTxtを変えずに初期値に戻す
+	err = sv.Admin.UpdateBoardSetting("news4vip", map[string]string{"BBS_TITLE": base.BBS_TITLE()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity = repo.SettingMap["news4vip"]
+	if len(entity.Values) != 0 || entity.HeadTxt != "HEAD" {
+		t.Errorf("entity = %v", entity)
+	}
+
+	// 全部初期値なら消す
+	empty := ""
+	if err := sv.Admin.UpdateBoardSetting("news4vip", nil, &empty); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := repo.SettingMap["news4vip"]; ok {
+		t.Errorf("entity is not deleted")
+	}
+}
+
+func TestUpdateBoardSetting_Invalid(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4vip")
+	sysEnv := &SysEnv{StartedTime: time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)}
+	sv := NewBoardService(RepoConf(repo), EnvConf(sysEnv), AdminConf(repo, nil))
+
+	tests := []struct {
+		board  string
+		values map[string]string
+	}{
+		{"xxxx", map[string]string{"BBS_TITLE": "TEST"}},
+		{"news4vip", map[string]string{"UNKNOWN": "1"}},
+		{"news4vip", map[string]string{"STUB_THREAD_COUNT": "many"}},
+		{"news4vip", map[string]string{"STUB_THREAD_COUNT": "-1"}},
+	}
+	for i, tt := range tests {
+		if err := sv.Admin.UpdateBoardSetting(tt.board, tt.values, nil); err == nil {
+			t.Errorf("%d: err is nil", i)
+		}
+	}
+	if len(repo.SettingMap) != 0 {
+		t.Errorf("SettingMap = %v", repo.SettingMap)
+	}
+}
+
+func TestResetBoardSetting(t *testing.T) {
+
+	repo := testutil.InitialBoardStub("news4vip")
+	sysEnv := &SysEnv{StartedTime: time.Date(2019, 11, 23, 12, 0, 0, 0, time.UTC)}
+	sv := NewBoardService(RepoConf(repo), EnvConf(sysEnv), AdminConf(repo, nil))
+	repo.PutBoardSetting(repo.SettingKey("news4vip"), &setting.Entity{HeadTxt: "HEAD"})
+
+	if err := sv.Admin.ResetBoardSetting("news4vip"); err != nil {
+		t.Error(err)
+	}
+	if len(repo.SettingMap) != 0 {
+		t.Errorf("SettingMap = %v", repo.SettingMap)
+	}
+	if err := sv.Admin.ResetBoardSetting("xxxx"); err == nil {
+		t.Error("err is nil")
+	}
+}
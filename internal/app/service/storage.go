@@ -0,0 +1,72 @@
+package service
+
+import (
+	"cloud.google.com/go/datastore"
+	"context"
+	"fmt"
+	"github.com/tempxla/stub2ch/configs/app/config"
+	"sync"
+	"time"
+)
+
+// 保存先のCloud Datastore
+// クライアントはコネクションプールを持つので、リクエストをまたいで使い回す。
+var storage = struct {
+	sync.Mutex
+	projectId string
+	client    *datastore.Client
+	jst       *time.Location
+}{projectId: config.PROJECT_ID}
+
+// 保存先のプロジェクトを設定する。起動時に一度だけ呼ぶ。
+// projectIdが空なら既定のプロジェクトにする。
+func ConfigureStorage(projectId string) {
+	if projectId == "" {
+		projectId = config.PROJECT_ID
+	}
+
+	storage.Lock()
+	defer storage.Unlock()
+
+	if storage.client != nil && storage.projectId != projectId {
+		storage.client.Close()
+		storage.client = nil
+	}
+	storage.projectId = projectId
+}
+
+// 共有のクライアントを返す。初めて呼んだときに作る。
+// クライアントの寿命はリクエストと関係ないのでcontext.Background()で作る。
+func storageClient() (*datastore.Client, *time.Location, error) {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if storage.jst == nil {
+		jst, err := time.LoadLocation("Asia/Tokyo")
+		if err != nil {
+			return nil, nil, err
+		}
+		storage.jst = jst
+	}
+	if storage.client == nil {
+		client, err := datastore.NewClient(context.Background(), storage.projectId)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to create client: %v", err)
+		}
+		storage.client = client
+	}
+	return storage.client, storage.jst, nil
+}
+
+// 共有のクライアントを閉じる。終了するときに呼ぶ。
+func CloseStorage() error {
+	storage.Lock()
+	defer storage.Unlock()
+
+	if storage.client == nil {
+		return nil
+	}
+	err := storage.client.Close()
+	storage.client = nil
+	return err
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"github.com/tempxla/stub2ch/configs/app/config"
+	"testing"
+)
+
+func TestConfigureStorage(t *testing.T) {
+	defer ConfigureStorage("")
+
+	ConfigureStorage("test-project")
+	if storage.projectId != "test-project" {
+		t.Errorf("projectId = %v", storage.projectId)
+	}
+
+	ConfigureStorage("")
+	if storage.projectId != config.PROJECT_ID {
+		t.Errorf("projectId = %v", storage.projectId)
+	}
+}
+
+func TestStorageClient(t *testing.T) {
+	defer ConfigureStorage("")
+
+	ConfigureStorage("test-project")
+	client1, jst, err := storageClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jst.String() != "Asia/Tokyo" {
+		t.Errorf("jst = %v", jst)
+	}
+
+	// 使い回す
+	client2, _, err := storageClient()
+	if err != nil || client1 != client2 {
+		t.Errorf("storageClient() = %p, %v, want: %p", client2, err, client1)
+	}
+
+	// プロジェクトを変えたら作り直す
+	ConfigureStorage("test-project2")
+	if storage.client != nil {
+		t.Error("client is not reset")
+	}
+
+	if err := CloseStorage(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"cloud.google.com/go/datastore"
+	"github.com/tempxla/stub2ch/internal/app/service/repository"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/counter"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/thread"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	// 書き込み数のシャード数
+	// 同じシャードに同時に書き込んだときだけトランザクションがぶつかる
+	write_counter_shards = 16
+)
+
+// スレッド一覧を並び順で返す。板が無ければ datastore.ErrNoSuchEntity を返す。
+func loadSubjects(repo repository.BoardRepository, boardName string) ([]board.Subject, error) {
+	boardKey := repo.BoardKey(boardName)
+	if err := repo.GetBoard(boardKey, &board.Entity{}); err != nil {
+		return nil, err
+	}
+
+	var entities []*thread.Entity
+	keys, err := repo.GetThreads(boardKey, true, &entities)
+	if err != nil {
+		return nil, err
+	}
+
+	subjects := make([]board.Subject, len(keys))
+	for i, key := range keys {
+		subjects[i] = toSubject(key.DSKey.Name, entities[i])
+	}
+	pinSubjects(subjects)
+	return subjects, nil
+}
+
+func toSubject(threadKey string, e *thread.Entity) board.Subject {
+	return board.Subject{
+		ThreadKey:    threadKey,
+		ThreadTitle:  e.ThreadTitle,
+		MessageCount: e.MessageCount,
+		LastModified: e.LastModified,
+		Stopped:      e.Stopped,
+		Pinned:       e.Pinned,
+	}
+}
+
+func newThreadEntity(sbj *board.Subject, agedAt time.Time, live bool) *thread.Entity {
+	return &thread.Entity{
+		ThreadTitle:  sbj.ThreadTitle,
+		MessageCount: sbj.MessageCount,
+		LastModified: sbj.LastModified,
+		AgedAt:       agedAt,
+		Stopped:      sbj.Stopped,
+		Pinned:       sbj.Pinned,
+		Live:         live,
+	}
+}
+
+// 固定されたスレを先頭に寄せる
+func pinSubjects(subjects []board.Subject) {
+	sort.SliceStable(subjects, func(i, j int) bool {
+		return subjects[i].Pinned && !subjects[j].Pinned
+	})
+}
+
+// 板の書き込み数を返す
+func loadWriteCount(repo repository.BoardRepository, boardKey *board.Key) (int, error) {
+	var entities []*counter.Entity
+	if _, err := repo.GetWriteCounters(boardKey, &entities); err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, e := range entities {
+		count += e.Count
+	}
+	return count, nil
+}
+
+// 適当なシャードに書き込み数を足す
+func txAddWriteCount(repo repository.BoardRepository, tx *datastore.Transaction, boardKey *board.Key, n int) error {
+	key := repo.WriteCounterKey(rand.Intn(write_counter_shards)+1, boardKey)
+	entity := &counter.Entity{}
+	if err := repo.TxGetWriteCounter(tx, key, entity); err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+	entity.Count += n
+	return repo.TxPutWriteCounter(tx, key, entity)
+}
@@ -0,0 +1,36 @@
+package audit
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"time"
+)
+
+const (
+	KIND = "AuditLog"
+
+	OUTCOME_SUCCESS = "success"
+	OUTCOME_FAILURE = "failure"
+)
+
+type Key struct {
+	DSKey *datastore.Key
+}
+
+// 管理者の操作記録。追記のみで更新・削除はしない。
+// Kind=AuditLog
+// Key=自動採番
+type Entity struct {
+	Time      time.Time
+	Action    string   `datastore:",noindex"`
+	SessionId string   `datastore:",noindex"` // セッションIDそのものではなくハッシュの先頭
+	IpAddr    string   `datastore:",noindex"`
+	Params    []string `datastore:",noindex"` // name=value
+	Outcome   string   `datastore:",noindex"` // success or failure
+	Message   string   `datastore:",noindex"` // エラーの内容
+}
+
+func (e *Entity) String() string {
+	return fmt.Sprintf("::%p:: Time:%v, Action:%v, SessionId:%v, IpAddr:%v, Params:%v, Outcome:%v, Message:%v",
+		e, e.Time, e.Action, e.SessionId, e.IpAddr, e.Params, e.Outcome, e.Message)
+}
@@ -16,6 +16,8 @@ type Key struct {
 
 // Kind=Board
 // Key=BoardName
+// スレッド一覧はThread、書き込み数はWriteCounterに移した。
+// SubjectsとWriteCountは移行前のデータを読むためだけに残している。
 type Entity struct {
 	Subjects   []Subject `datastore:",noindex"`
 	WriteCount int       `datastore:",noindex"`
@@ -26,6 +28,8 @@ type Subject struct {
 	ThreadTitle  string    `datastore:",noindex"`
 	MessageCount int       `datastore:",noindex"`
 	LastModified time.Time `datastore:",noindex"` // dat落ちとかで使う予定
+	Stopped      bool      `datastore:",noindex"` // 書き込み不可
+	Pinned       bool      `datastore:",noindex"` // 先頭に固定
 }
 
 func (e *Entity) String() string {
@@ -0,0 +1,22 @@
+package counter
+
+import (
+	"cloud.google.com/go/datastore"
+)
+
+const (
+	KIND = "WriteCounter"
+)
+
+type Key struct {
+	DSKey *datastore.Key
+}
+
+// 板の書き込み数を分割して数える
+// 合計は全部のシャードを足したもの
+// Kind=WriteCounter
+// Ancestor=Board
+// Key=シャード番号 (1から)
+type Entity struct {
+	Count int `datastore:",noindex"`
+}
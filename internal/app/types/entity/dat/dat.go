@@ -6,7 +6,8 @@ import (
 )
 
 const (
-	KIND = "Dat"
+	KIND       = "Dat"
+	CHUNK_KIND = "DatChunk"
 )
 
 type Key struct {
@@ -17,6 +18,24 @@ type Key struct {
 // Ancestor=Board
 // Key=ThreadKey
 type Entity struct {
-	Bytes        []byte    `datastore:",noindex"`
+	Bytes        []byte    `datastore:",noindex"` // ChunkCount=0 の旧形式のときだけ保存される
 	LastModified time.Time `datastore:",noindex"`
+	Anchors      []Anchor  `datastore:",noindex"` // 安価の逆引き用
+	ChunkCount   int       `datastore:",noindex"`
+	Size         int       `datastore:",noindex"` // Shift_JISでのバイト数 (容量チェック用)
+	Sjis         bool      `datastore:",noindex"` // BytesがShift_JISで保存されている
+}
+
+// From番目のレスが >>To を含む
+type Anchor struct {
+	From int `datastore:",noindex"`
+	To   int `datastore:",noindex"`
+}
+
+// datの中身を分割したもの
+// Kind=DatChunk
+// Ancestor=Dat
+// Key=チャンク番号 (1から)
+type Chunk struct {
+	Bytes []byte `datastore:",noindex"`
 }
@@ -0,0 +1,37 @@
+package maintenance
+
+import (
+	"cloud.google.com/go/datastore"
+	"time"
+)
+
+const (
+	KIND = "Maintenance"
+
+	// 全ての板に効くメンテナンスのキー
+	GLOBAL = "_global"
+
+	MODE_READ_ONLY = "read-only" // 読むだけ (書き込み不可)
+	MODE_CLOSED    = "closed"    // 全部閉じる
+)
+
+type Key struct {
+	DSKey *datastore.Key
+}
+
+// メンテナンス予定
+// Kind=Maintenance
+// Key=板名 または GLOBAL
+type Entity struct {
+	Mode      string    `datastore:",noindex"`
+	Message   string    `datastore:",noindex"`
+	StartAt   time.Time `datastore:",noindex"` // ゼロなら今すぐ
+	EndAt     time.Time `datastore:",noindex"` // ゼロなら解除するまで
+	UpdatedAt time.Time
+}
+
+// nowがメンテナンス中か
+func (e *Entity) Active(now time.Time) bool {
+	return (e.StartAt.IsZero() || !now.Before(e.StartAt)) &&
+		(e.EndAt.IsZero() || now.Before(e.EndAt))
+}
@@ -0,0 +1,40 @@
+package post
+
+import (
+	"cloud.google.com/go/datastore"
+	"time"
+)
+
+const (
+	KIND = "Post"
+)
+
+type Key struct {
+	DSKey *datastore.Key
+}
+
+// 検索用のレス1件分。datから作るので、消えても作り直せる。
+// 板をまたいで検索するので、板を祖先にしない。
+// Kind=Post
+// Key=板名/スレッドキー/レス番号
+type Entity struct {
+	Board       string
+	ThreadKey   string    `datastore:",noindex"`
+	ResNum      int       `datastore:",noindex"`
+	ThreadTitle string    `datastore:",noindex"`
+	Name        string    `datastore:",noindex"`
+	Id          string    // 書き込みID
+	Date        time.Time // 新しい順に引くため
+	Content     string    `datastore:",noindex"` // datの本文 (HTML)
+	Grams       []string  // 本文 (1レス目はスレタイも) の2-gram
+}
+
+// 検索条件。空の項目では絞らない。
+// 複合インデックスが要るので、GramとIdはどちらか一方だけ使う。
+type Query struct {
+	Board string
+	Gram  string
+	Id    string
+	Since time.Time
+	Until time.Time // この時刻は含まない
+}
@@ -0,0 +1,31 @@
+package session
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"time"
+)
+
+const (
+	KIND = "AdminSession"
+)
+
+type Key struct {
+	DSKey *datastore.Key
+}
+
+// 管理者のセッション
+// Kind=AdminSession
+// Key=セッションIDのハッシュ
+type Entity struct {
+	CreatedAt  time.Time
+	LastAccess time.Time `datastore:",noindex"`
+	ExpiresAt  time.Time `datastore:",noindex"`
+	IpAddr     string    `datastore:",noindex"`
+	UserAgent  string    `datastore:",noindex"`
+}
+
+func (e *Entity) String() string {
+	return fmt.Sprintf("::%p:: CreatedAt:%v, LastAccess:%v, ExpiresAt:%v, IpAddr:%v, UserAgent:%v",
+		e, e.CreatedAt, e.LastAccess, e.ExpiresAt, e.IpAddr, e.UserAgent)
+}
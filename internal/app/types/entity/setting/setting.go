@@ -0,0 +1,28 @@
+package setting
+
+import (
+	"cloud.google.com/go/datastore"
+	"time"
+)
+
+const (
+	KIND = "BoardSetting"
+)
+
+type Key struct {
+	DSKey *datastore.Key
+}
+
+// 板の設定。bbscfgの値を上書きする。
+// Kind=BoardSetting
+// Key=板名
+type Entity struct {
+	Values    []Value `datastore:",noindex"`
+	HeadTxt   string  `datastore:",noindex"` // 空なら上書きしない
+	UpdatedAt time.Time
+}
+
+type Value struct {
+	Name  string `datastore:",noindex"`
+	Value string `datastore:",noindex"`
+}
@@ -0,0 +1,29 @@
+package thread
+
+import (
+	"cloud.google.com/go/datastore"
+	"time"
+)
+
+const (
+	KIND = "Thread"
+)
+
+type Key struct {
+	DSKey *datastore.Key
+}
+
+// スレッド一覧の1行分
+// 板エンティティに全スレをまとめて持つと書き込みが1つのエンティティに集中するので、スレごとに分ける。
+// Kind=Thread
+// Ancestor=Board
+// Key=ThreadKey
+type Entity struct {
+	ThreadTitle  string    `datastore:",noindex"`
+	MessageCount int       `datastore:",noindex"`
+	LastModified time.Time `datastore:",noindex"`
+	AgedAt       time.Time // スレッド一覧の並び順 (新しい順)
+	Stopped      bool      `datastore:",noindex"` // 書き込み不可
+	Pinned       bool      `datastore:",noindex"` // 先頭に固定
+	Live         bool      // falseならdat落ち
+}
@@ -2,8 +2,48 @@ package errors
 
 import (
 	goerr "errors"
+	"fmt"
 )
 
 var (
-	NOT_MODIFIED = goerr.New("Not Modified")
+	NOT_MODIFIED    = goerr.New("Not Modified")
+	NO_SEARCH_TERMS = goerr.New("No search terms") // 検索語が記号だけのときなど
 )
+
+// 書き込めなかった理由の種類
+type Kind int
+
+const (
+	// 0 は利用者のせいではない失敗 (Datastoreの障害など)
+	_ Kind = iota
+	BOARD_NOT_FOUND
+	THREAD_NOT_FOUND  // 存在しない or dat落ち
+	THREAD_STOPPED    // 停止したスレ
+	THREAD_FULL       // 1001
+	DAT_OVER_CAPACITY // 容量オーバー
+	THREAD_LIMIT      // 板のスレ数の上限
+	WRITE_LIMIT       // 1日の書き込み数の上限
+	DUPLICATE_KEY     // 空いているスレッドキーが見つからない
+)
+
+// 利用者の書き込みが決まりで弾かれたときのエラー
+type BoardError struct {
+	Kind    Kind
+	Message string
+}
+
+func (e *BoardError) Error() string {
+	return e.Message
+}
+
+func New(kind Kind, format string, a ...interface{}) error {
+	return &BoardError{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+// BoardError でなければ 0 を返す
+func KindOf(err error) Kind {
+	if e, ok := err.(*BoardError); ok {
+		return e.Kind
+	}
+	return 0
+}
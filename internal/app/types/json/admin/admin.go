@@ -0,0 +1,71 @@
+package admin
+
+// 管理APIの結果
+type Result struct {
+	Ok           bool          `json:"ok"`
+	Message      string        `json:"message"`
+	WriteCount   *int          `json:"write_count,omitempty"`
+	MigrateCount *int          `json:"migrate_count,omitempty"`
+	ImportCount  *int          `json:"import_count,omitempty"`
+	Sessions     []Session     `json:"sessions,omitempty"`
+	AuditLogs    []AuditLog    `json:"audit_logs,omitempty"`
+	BoardSetting *BoardSetting `json:"board_setting,omitempty"`
+	Maintenance  []Maintenance `json:"maintenance,omitempty"`
+	SweepCount   *int          `json:"sweep_count,omitempty"`
+	StaleThreads []StaleThread `json:"stale_threads,omitempty"`
+	IndexCount   *int          `json:"index_count,omitempty"`
+}
+
+type Session struct {
+	Id         string `json:"id"`
+	AuditId    string `json:"audit_id"`
+	CreatedAt  string `json:"created_at"`
+	LastAccess string `json:"last_access"`
+	ExpiresAt  string `json:"expires_at"`
+	IpAddr     string `json:"ip_addr"`
+	UserAgent  string `json:"user_agent"`
+	Current    bool   `json:"current"`
+}
+
+type AuditLog struct {
+	Time      string `json:"time"`
+	Action    string `json:"action"`
+	SessionId string `json:"session_id"`
+	IpAddr    string `json:"ip_addr"`
+	Params    string `json:"params"`
+	Outcome   string `json:"outcome"`
+	Message   string `json:"message"`
+}
+
+type BoardSetting struct {
+	BoardName      string         `json:"board_name"`
+	Values         []SettingValue `json:"values"`
+	HeadTxt        string         `json:"head_txt"`
+	DefaultHeadTxt string         `json:"default_head_txt"`
+}
+
+type SettingValue struct {
+	Name       string `json:"name"`
+	Value      string `json:"value"`
+	Default    string `json:"default"`
+	Overridden bool   `json:"overridden"`
+}
+
+type Maintenance struct {
+	Board   string `json:"board"`
+	Mode    string `json:"mode"`
+	Message string `json:"message"`
+	StartAt string `json:"start_at"`
+	EndAt   string `json:"end_at"`
+	Active  bool   `json:"active"`
+}
+
+type StaleThread struct {
+	ThreadKey    string `json:"thread_key"`
+	ThreadTitle  string `json:"thread_title"`
+	MessageCount int    `json:"message_count"`
+	LastModified string `json:"last_modified"`
+	DropAt       string `json:"drop_at"`
+	Reason       string `json:"reason"`
+	Due          bool   `json:"due"`
+}
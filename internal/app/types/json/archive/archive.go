@@ -0,0 +1,39 @@
+package archive
+
+import (
+	"time"
+)
+
+// 板のアーカイブに入れる管理情報 (meta.json)
+type Meta struct {
+	Version    int       `json:"version"`
+	BoardName  string    `json:"board_name"`
+	ExportedAt time.Time `json:"exported_at"`
+	WriteCount int       `json:"write_count"`
+	Threads    []Thread  `json:"threads"`
+	Settings   []Setting `json:"settings,omitempty"` // 管理者が変更した設定だけ
+	HeadTxt    string    `json:"head_txt,omitempty"` // 管理者が変更したときだけ
+}
+
+// datの中身は dat/<ThreadKey>.dat に入れる
+type Thread struct {
+	ThreadKey       string    `json:"thread_key"`
+	ThreadTitle     string    `json:"thread_title"`
+	MessageCount    int       `json:"message_count"`
+	LastModified    time.Time `json:"last_modified"`
+	DatLastModified time.Time `json:"dat_last_modified"`
+	Stopped         bool      `json:"stopped,omitempty"`
+	Pinned          bool      `json:"pinned,omitempty"`
+	Archived        bool      `json:"archived,omitempty"` // dat落ち
+	Anchors         []Anchor  `json:"anchors,omitempty"`
+}
+
+type Anchor struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+type Setting struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
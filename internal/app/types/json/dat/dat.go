@@ -13,4 +13,6 @@ type Message struct {
 	Mail      string `json:"mail"`
 	DateAndId string `json:"date_and_id"`
 	Content   string `json:"content"`
+	Anchors   []int  `json:"anchors"` // このレスからの安価
+	Replies   []int  `json:"replies"` // このレスへの安価
 }
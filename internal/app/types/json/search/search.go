@@ -0,0 +1,17 @@
+package search
+
+type Object struct {
+	Results   []Result `json:"results"`
+	Truncated bool     `json:"truncated"` // 読み切れずに打ち切った
+}
+
+type Result struct {
+	Board       string `json:"board"`
+	ThreadKey   string `json:"thread_key"`
+	ResNum      int    `json:"res_num"`
+	ThreadTitle string `json:"thread_title"`
+	Name        string `json:"name"`
+	Id          string `json:"id"`
+	Date        string `json:"date"`
+	Content     string `json:"content"`
+}
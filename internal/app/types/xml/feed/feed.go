@@ -0,0 +1,79 @@
+package feed
+
+import (
+	"encoding/xml"
+)
+
+const (
+	RDF_NS  = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	RSS_NS  = "http://purl.org/rss/1.0/"
+	DC_NS   = "http://purl.org/dc/elements/1.1/"
+	ATOM_NS = "http://www.w3.org/2005/Atom"
+)
+
+// RSS 1.0 (index.rdf)
+// encoding/xmlは接頭辞を付けられないので、要素名に直接書く
+type RDF struct {
+	XMLName  xml.Name  `xml:"rdf:RDF"`
+	XmlnsRdf string    `xml:"xmlns:rdf,attr"`
+	Xmlns    string    `xml:"xmlns,attr"`
+	XmlnsDc  string    `xml:"xmlns:dc,attr"`
+	Channel  Channel   `xml:"channel"`
+	Items    []RDFItem `xml:"item"`
+}
+
+type Channel struct {
+	About       string  `xml:"rdf:about,attr"`
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	Date        string  `xml:"dc:date,omitempty"`
+	Resources   []RDFLi `xml:"items>rdf:Seq>rdf:li"`
+}
+
+type RDFLi struct {
+	Resource string `xml:"rdf:resource,attr"`
+}
+
+type RDFItem struct {
+	About string `xml:"rdf:about,attr"`
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+	Date  string `xml:"dc:date"`
+}
+
+// Atom 1.0
+type Atom struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []AtomLink  `xml:"link"`
+	Author  AtomAuthor  `xml:"author"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+type AtomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type AtomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type AtomEntry struct {
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    AtomLink    `xml:"link"`
+	Author  AtomAuthor  `xml:"author"`
+	Content AtomContent `xml:"content"`
+}
+
+type AtomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
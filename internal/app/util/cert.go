@@ -2,10 +2,13 @@ package util
 
 import (
 	"crypto"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
+	"fmt"
 )
 
 func VerifyPKCS1v15(base64Pub string, sha256Digest [sha256.Size]byte, base64Sig string) error {
@@ -32,3 +35,34 @@ func VerifyPKCS1v15(base64Pub string, sha256Digest [sha256.Size]byte, base64Sig
 
 	return nil
 }
+
+// PEM形式の秘密鍵(PKCS#1 or PKCS#8)で署名してBase64で返す。
+// openssl dgst -sha256 -sign key.pem | base64 と同じ。
+func SignPKCS1v15(pemKey []byte, sha256Digest [sha256.Size]byte) (string, error) {
+
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return "", fmt.Errorf("no PEM data found.")
+	}
+
+	var priv *rsa.PrivateKey
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		priv = key
+	} else {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return "", err
+		}
+		var ok bool
+		if priv, ok = key.(*rsa.PrivateKey); !ok {
+			return "", fmt.Errorf("not a RSA private key.")
+		}
+	}
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, sha256Digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
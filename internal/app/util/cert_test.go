@@ -1,8 +1,13 @@
 package util
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/pem"
 	"github.com/tempxla/stub2ch/configs/app/admincfg"
 	"io/ioutil"
 	"testing"
@@ -78,3 +83,54 @@ func TestVerifyPKCS1v15_Bad(t *testing.T) {
 		}
 	}
 }
+
+func TestSignPKCS1v15(t *testing.T) {
+
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base64Pub := base64.StdEncoding.EncodeToString(pubKey)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := [][]byte{
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+	}
+
+	digest := sha256.Sum256([]byte("passphrase"))
+
+	for i, key := range keys {
+		// Exercise
+		sig, err := SignPKCS1v15(key, digest)
+
+		// Verify
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if err := VerifyPKCS1v15(base64Pub, digest, sig); err != nil {
+			t.Errorf("%d: %v", i, err)
+		}
+	}
+}
+
+func TestSignPKCS1v15_Bad(t *testing.T) {
+	digest := sha256.Sum256([]byte("passphrase"))
+	tests := [][]byte{
+		[]byte("NOT PEM"),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("NOT KEY")}),
+	}
+	for i, key := range tests {
+		if _, err := SignPKCS1v15(key, digest); err == nil {
+			t.Errorf("%d: err is nil", i)
+		}
+	}
+}
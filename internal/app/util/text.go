@@ -2,17 +2,26 @@ package util
 
 import (
 	"bytes"
+	"fmt"
 	"golang.org/x/text/encoding/japanese"
 	"golang.org/x/text/transform"
+	"html"
 	"io/ioutil"
+	"regexp"
 	"strings"
 )
 
+var (
+	escapedNCR = regexp.MustCompile(`&amp;(#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6});`)
+)
+
+// SJISに無い文字は数値文字参照 (&#NNNN;) にする。
+// 途中で切ると区切りの <> や改行まで消えてdatが壊れるので、最後まで変換する。
 func UTF8toSJIS(utf8 []byte) []byte {
-	buf := new(bytes.Buffer)
-	w := transform.NewWriter(buf, japanese.ShiftJIS.NewEncoder())
-	w.Write(utf8)
-	return buf.Bytes()
+	if sjis, err := japanese.ShiftJIS.NewEncoder().Bytes(utf8); err == nil {
+		return sjis
+	}
+	return []byte(UTF8toSJISStringNCR(string(utf8)))
 }
 
 func SJIStoUTF8(sjis []byte) []byte {
@@ -23,10 +32,7 @@ func SJIStoUTF8(sjis []byte) []byte {
 }
 
 func UTF8toSJISString(utf8 string) string {
-	buf := new(bytes.Buffer)
-	w := transform.NewWriter(buf, japanese.ShiftJIS.NewEncoder())
-	w.Write([]byte(utf8))
-	return buf.String()
+	return string(UTF8toSJIS([]byte(utf8)))
 }
 
 func SJIStoUTF8String(sjis string) string {
@@ -35,3 +41,25 @@ func SJIStoUTF8String(sjis string) string {
 	buf, _ := ioutil.ReadAll(wrap)
 	return string(buf)
 }
+
+// SJISに無い文字は数値文字参照 (&#NNNN;) にする。
+// SJISのフォームからブラウザが送ってくるのと同じ形になる。
+func UTF8toSJISStringNCR(utf8 string) string {
+	enc := japanese.ShiftJIS.NewEncoder()
+	sb := &strings.Builder{}
+	for _, r := range utf8 {
+		s, err := enc.String(string(r))
+		if err != nil {
+			fmt.Fprintf(sb, "&#%d;", r)
+			continue
+		}
+		sb.WriteString(s)
+	}
+	return sb.String()
+}
+
+// html.EscapeString と同じだが、数値文字参照 (&#NNNN;) はそのまま残す。
+// SJISに無い文字はこの形で送られてくる。
+func EscapeHTML(s string) string {
+	return escapedNCR.ReplaceAllString(html.EscapeString(s), "&$1;")
+}
@@ -20,6 +20,25 @@ func TestUTF8toSJIS(t *testing.T) {
 	}
 }
 
+// SJISに無い文字があっても後ろを落とさない
+func TestUTF8toSJIS_NCR(t *testing.T) {
+	tests := []struct {
+		utf8, want string
+	}{
+		{"a<>\ufffd<>b\n", "a<>&#65533;<>b\n"},
+		{"\u0080<>\n", "&#128;<>\n"},
+		{"😀<>あ\n", "&#128512;<>" + UTF8toSJISString("あ") + "\n"},
+	}
+	for _, tt := range tests {
+		if sjis := string(UTF8toSJIS([]byte(tt.utf8))); sjis != tt.want {
+			t.Errorf("UTF8toSJIS(%q) = %q, want: %q", tt.utf8, sjis, tt.want)
+		}
+		if sjis := UTF8toSJISString(tt.utf8); sjis != tt.want {
+			t.Errorf("UTF8toSJISString(%q) = %q, want: %q", tt.utf8, sjis, tt.want)
+		}
+	}
+}
+
 func TestSJIStoUTF8(t *testing.T) {
 	sjis := []byte{
 		0x82, 0xa0, // あ
@@ -64,3 +83,34 @@ func TestSJIStoUTF8String(t *testing.T) {
 		t.Errorf("%v", utf8)
 	}
 }
+
+func TestUTF8toSJISStringNCR(t *testing.T) {
+	tests := []struct {
+		utf8, want string
+	}{
+		{"あいうえお", UTF8toSJISString("あいうえお")},
+		{"A😀B", "A&#128512;B"},
+		{"①㈱", UTF8toSJISString("①㈱")},
+		{"한글", "&#54620;&#44544;"},
+	}
+	for _, tt := range tests {
+		if sjis := UTF8toSJISStringNCR(tt.utf8); sjis != tt.want {
+			t.Errorf("UTF8toSJISStringNCR(%v) = %v, want: %v", tt.utf8, sjis, tt.want)
+		}
+	}
+}
+
+func TestEscapeHTML(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{`<a href="x">&</a>`, "&lt;a href=&#34;x&#34;&gt;&amp;&lt;/a&gt;"},
+		{"A&#128512;B&#x1F600;", "A&#128512;B&#x1F600;"},
+		{"&#;&#12a;&amp;", "&amp;#;&amp;#12a;&amp;amp;"},
+	}
+	for _, tt := range tests {
+		if s := EscapeHTML(tt.s); s != tt.want {
+			t.Errorf("EscapeHTML(%v) = %v, want: %v", tt.s, s, tt.want)
+		}
+	}
+}
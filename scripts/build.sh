@@ -1,2 +1,3 @@
 #!/bin/sh
 go build ./cmd/stub2ch/main.go
+go build ./cmd/stub2chctl
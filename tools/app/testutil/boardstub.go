@@ -3,15 +3,31 @@ package testutil
 import (
 	"cloud.google.com/go/datastore"
 	"fmt"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/audit"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/counter"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/maintenance"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/post"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/session"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/setting"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/thread"
+	"sort"
+	"strconv"
 	"time"
 )
 
 // A injection for google datastore
 type BoardStub struct {
-	BoardMap map[string]*board.Entity
-	DatMap   map[string]map[string]*dat.Entity
+	BoardMap   map[string]*board.Entity
+	DatMap     map[string]map[string]*dat.Entity
+	AuditLog   []*audit.Entity
+	SessionMap map[string]*session.Entity
+	SettingMap map[string]*setting.Entity
+	MaintMap   map[string]*maintenance.Entity
+	ThreadMap  map[string]map[string]*thread.Entity
+	CounterMap map[string]map[int64]*counter.Entity
+	PostMap    map[string]*post.Entity
 }
 
 func (repo *BoardStub) BoardKey(name string) (key *board.Key) {
@@ -52,6 +68,9 @@ func (repo *BoardStub) GetDat(key *dat.Key, entity *dat.Entity) (err error) {
 }
 
 func (repo *BoardStub) PutDat(key *dat.Key, entity *dat.Entity) (err error) {
+	if _, ok := repo.DatMap[key.DSKey.Parent.Name]; !ok {
+		repo.DatMap[key.DSKey.Parent.Name] = make(map[string]*dat.Entity)
+	}
 	repo.DatMap[key.DSKey.Parent.Name][key.DSKey.Name] = entity
 	return
 }
@@ -83,8 +102,59 @@ func (repo *BoardStub) TxGetDat(tx *datastore.Transaction, key *dat.Key, entity
 	return
 }
 
+// 本物と同じく、チャンクに分けたdatの中身は返さない
+func (repo *BoardStub) TxGetDatMeta(tx *datastore.Transaction, key *dat.Key, entity *dat.Entity) (err error) {
+	if err = repo.GetDat(key, entity); err != nil {
+		return
+	}
+	if entity.ChunkCount > 0 {
+		entity.Bytes = nil
+	}
+	return
+}
+
+// スタブは1つのチャンクにまとめて持つ
 func (repo *BoardStub) TxPutDat(tx *datastore.Transaction, key *dat.Key, entity *dat.Entity) (err error) {
-	err = repo.PutDat(key, entity)
+	e := *entity
+	e.Bytes = append([]byte{}, entity.Bytes...)
+	e.ChunkCount = 1
+	entity.ChunkCount = 1
+	err = repo.PutDat(key, &e)
+	return
+}
+
+// entityはTxGetDatMetaで取得したものとする
+func (repo *BoardStub) TxAppendDat(tx *datastore.Transaction, key *dat.Key, entity *dat.Entity, line []byte) (err error) {
+	if entity.ChunkCount == 0 {
+		// 旧形式
+		entity.Bytes = append(entity.Bytes, line...)
+		return repo.TxPutDat(tx, key, entity)
+	}
+	stored := &dat.Entity{}
+	if err = repo.GetDat(key, stored); err != nil {
+		return
+	}
+	e := *entity
+	e.Bytes = append(append([]byte{}, stored.Bytes...), line...)
+	err = repo.PutDat(key, &e)
+	return
+}
+
+func (repo *BoardStub) TxDeleteDat(tx *datastore.Transaction, key *dat.Key, entity *dat.Entity) (err error) {
+	delete(repo.DatMap[key.DSKey.Parent.Name], key.DSKey.Name)
+	return
+}
+
+// キーの順に返す
+func (repo *BoardStub) GetDatKeys(parent *board.Key) (keys []*dat.Key, err error) {
+	names := make([]string, 0, len(repo.DatMap[parent.DSKey.Name]))
+	for name := range repo.DatMap[parent.DSKey.Name] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		keys = append(keys, repo.DatKey(name, parent))
+	}
 	return
 }
 
@@ -99,6 +169,373 @@ func (repo *BoardStub) TxPutMultiBoard(tx *datastore.Transaction, keys []*board.
 	return
 }
 
+func (repo *BoardStub) PutAuditLog(entity *audit.Entity) (err error) {
+	e := *entity
+	repo.AuditLog = append(repo.AuditLog, &e)
+	return
+}
+
+func (repo *BoardStub) GetAuditLogs(since, until time.Time, limit int, entities *[]*audit.Entity) (err error) {
+	var logs []*audit.Entity
+	for _, e := range repo.AuditLog {
+		if !since.IsZero() && e.Time.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !e.Time.Before(until) {
+			continue
+		}
+		logs = append(logs, e)
+	}
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].Time.After(logs[j].Time)
+	})
+	if len(logs) > limit {
+		logs = logs[:limit]
+	}
+	*entities = append(*entities, logs...)
+	return
+}
+
+func (repo *BoardStub) SessionKey(name string) (key *session.Key) {
+	k := datastore.NameKey(session.KIND, name, nil)
+	key = &session.Key{DSKey: k}
+	return
+}
+
+func (repo *BoardStub) GetSession(key *session.Key, entity *session.Entity) (err error) {
+	if e, ok := repo.SessionMap[key.DSKey.Name]; !ok {
+		return datastore.ErrNoSuchEntity
+	} else {
+		*entity = *e
+		return
+	}
+}
+
+func (repo *BoardStub) PutSession(key *session.Key, entity *session.Entity) (err error) {
+	if repo.SessionMap == nil {
+		repo.SessionMap = make(map[string]*session.Entity)
+	}
+	e := *entity
+	repo.SessionMap[key.DSKey.Name] = &e
+	return
+}
+
+func (repo *BoardStub) DeleteSession(key *session.Key) (err error) {
+	delete(repo.SessionMap, key.DSKey.Name)
+	return
+}
+
+func (repo *BoardStub) GetAllSession(entities *[]*session.Entity) (keys []*session.Key, err error) {
+	names := []string{}
+	for k := range repo.SessionMap {
+		names = append(names, k)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return repo.SessionMap[names[i]].CreatedAt.Before(repo.SessionMap[names[j]].CreatedAt)
+	})
+	for _, k := range names {
+		*entities = append(*entities, repo.SessionMap[k])
+		keys = append(keys, repo.SessionKey(k))
+	}
+	return
+}
+
+func (repo *BoardStub) SettingKey(name string) (key *setting.Key) {
+	k := datastore.NameKey(setting.KIND, name, nil)
+	key = &setting.Key{DSKey: k}
+	return
+}
+
+func (repo *BoardStub) GetBoardSetting(key *setting.Key, entity *setting.Entity) (err error) {
+	if e, ok := repo.SettingMap[key.DSKey.Name]; !ok {
+		return datastore.ErrNoSuchEntity
+	} else {
+		*entity = *e
+		return
+	}
+}
+
+func (repo *BoardStub) PutBoardSetting(key *setting.Key, entity *setting.Entity) (err error) {
+	if repo.SettingMap == nil {
+		repo.SettingMap = make(map[string]*setting.Entity)
+	}
+	e := *entity
+	repo.SettingMap[key.DSKey.Name] = &e
+	return
+}
+
+func (repo *BoardStub) DeleteBoardSetting(key *setting.Key) (err error) {
+	delete(repo.SettingMap, key.DSKey.Name)
+	return
+}
+
+func (repo *BoardStub) MaintenanceKey(name string) (key *maintenance.Key) {
+	k := datastore.NameKey(maintenance.KIND, name, nil)
+	key = &maintenance.Key{DSKey: k}
+	return
+}
+
+func (repo *BoardStub) GetMaintenance(key *maintenance.Key, entity *maintenance.Entity) (err error) {
+	if e, ok := repo.MaintMap[key.DSKey.Name]; !ok {
+		return datastore.ErrNoSuchEntity
+	} else {
+		*entity = *e
+		return
+	}
+}
+
+func (repo *BoardStub) PutMaintenance(key *maintenance.Key, entity *maintenance.Entity) (err error) {
+	if repo.MaintMap == nil {
+		repo.MaintMap = make(map[string]*maintenance.Entity)
+	}
+	e := *entity
+	repo.MaintMap[key.DSKey.Name] = &e
+	return
+}
+
+func (repo *BoardStub) DeleteMaintenance(key *maintenance.Key) (err error) {
+	delete(repo.MaintMap, key.DSKey.Name)
+	return
+}
+
+func (repo *BoardStub) ThreadKey(name string, parent *board.Key) (key *thread.Key) {
+	k := datastore.NameKey(thread.KIND, name, parent.DSKey)
+	key = &thread.Key{DSKey: k}
+	return
+}
+
+// AgedAtの新しい順、同じならスレッドキーの大きい順
+func (repo *BoardStub) GetThreads(parent *board.Key, liveOnly bool, entities *[]*thread.Entity) (keys []*thread.Key, err error) {
+	threads := repo.ThreadMap[parent.DSKey.Name]
+	names := []string{}
+	for k, v := range threads {
+		if liveOnly && !v.Live {
+			continue
+		}
+		names = append(names, k)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := threads[names[i]].AgedAt, threads[names[j]].AgedAt
+		if a.Equal(b) {
+			return names[i] > names[j]
+		}
+		return a.After(b)
+	})
+	for _, k := range names {
+		e := *threads[k]
+		*entities = append(*entities, &e)
+		keys = append(keys, repo.ThreadKey(k, parent))
+	}
+	return
+}
+
+func (repo *BoardStub) TxCountLiveThreads(tx *datastore.Transaction, parent *board.Key) (count int, err error) {
+	for _, v := range repo.ThreadMap[parent.DSKey.Name] {
+		if v.Live {
+			count++
+		}
+	}
+	return
+}
+
+func (repo *BoardStub) TxGetThread(tx *datastore.Transaction, key *thread.Key, entity *thread.Entity) (err error) {
+	if threads, ok := repo.ThreadMap[key.DSKey.Parent.Name]; !ok {
+		return datastore.ErrNoSuchEntity
+	} else if e, ok := threads[key.DSKey.Name]; !ok {
+		return datastore.ErrNoSuchEntity
+	} else {
+		*entity = *e
+		return
+	}
+}
+
+func (repo *BoardStub) TxPutThread(tx *datastore.Transaction, key *thread.Key, entity *thread.Entity) (err error) {
+	if repo.ThreadMap == nil {
+		repo.ThreadMap = make(map[string]map[string]*thread.Entity)
+	}
+	if _, ok := repo.ThreadMap[key.DSKey.Parent.Name]; !ok {
+		repo.ThreadMap[key.DSKey.Parent.Name] = make(map[string]*thread.Entity)
+	}
+	e := *entity
+	repo.ThreadMap[key.DSKey.Parent.Name][key.DSKey.Name] = &e
+	return
+}
+
+func (repo *BoardStub) TxDeleteThread(tx *datastore.Transaction, key *thread.Key) (err error) {
+	delete(repo.ThreadMap[key.DSKey.Parent.Name], key.DSKey.Name)
+	return
+}
+
+func (repo *BoardStub) WriteCounterKey(shard int, parent *board.Key) (key *counter.Key) {
+	k := datastore.IDKey(counter.KIND, int64(shard), parent.DSKey)
+	key = &counter.Key{DSKey: k}
+	return
+}
+
+func (repo *BoardStub) GetWriteCounters(parent *board.Key, entities *[]*counter.Entity) (keys []*counter.Key, err error) {
+	for id, v := range repo.CounterMap[parent.DSKey.Name] {
+		e := *v
+		*entities = append(*entities, &e)
+		keys = append(keys, repo.WriteCounterKey(int(id), parent))
+	}
+	return
+}
+
+func (repo *BoardStub) TxGetWriteCounter(tx *datastore.Transaction, key *counter.Key, entity *counter.Entity) (err error) {
+	if counters, ok := repo.CounterMap[key.DSKey.Parent.Name]; !ok {
+		return datastore.ErrNoSuchEntity
+	} else if e, ok := counters[key.DSKey.ID]; !ok {
+		return datastore.ErrNoSuchEntity
+	} else {
+		*entity = *e
+		return
+	}
+}
+
+func (repo *BoardStub) TxPutWriteCounter(tx *datastore.Transaction, key *counter.Key, entity *counter.Entity) (err error) {
+	if repo.CounterMap == nil {
+		repo.CounterMap = make(map[string]map[int64]*counter.Entity)
+	}
+	if _, ok := repo.CounterMap[key.DSKey.Parent.Name]; !ok {
+		repo.CounterMap[key.DSKey.Parent.Name] = make(map[int64]*counter.Entity)
+	}
+	e := *entity
+	repo.CounterMap[key.DSKey.Parent.Name][key.DSKey.ID] = &e
+	return
+}
+
+func (repo *BoardStub) DeleteWriteCounters(keys []*counter.Key) (err error) {
+	for _, key := range keys {
+		delete(repo.CounterMap[key.DSKey.Parent.Name], key.DSKey.ID)
+	}
+	return
+}
+
+func (repo *BoardStub) PostKey(boardName, threadKey string, resnum int) (key *post.Key) {
+	k := datastore.NameKey(post.KIND, boardName+"/"+threadKey+"/"+strconv.Itoa(resnum), nil)
+	key = &post.Key{DSKey: k}
+	return
+}
+
+func (repo *BoardStub) TxPutPost(tx *datastore.Transaction, key *post.Key, entity *post.Entity) (err error) {
+	e := *entity
+	repo.PostMap[key.DSKey.Name] = &e
+	return
+}
+
+func (repo *BoardStub) PutMultiPost(keys []*post.Key, entities []*post.Entity) (err error) {
+	for i, key := range keys {
+		repo.TxPutPost(nil, key, entities[i])
+	}
+	return
+}
+
+// 新しい順に返す (同じ時刻ならキーの順)
+// カーソルは読んだ件数
+func (repo *BoardStub) SearchPosts(query *post.Query, cursor string, limit int, entities *[]*post.Entity) (next string, err error) {
+	names := make([]string, 0, len(repo.PostMap))
+	for name := range repo.PostMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sort.SliceStable(names, func(i, j int) bool {
+		return repo.PostMap[names[i]].Date.After(repo.PostMap[names[j]].Date)
+	})
+
+	skip := 0
+	if cursor != "" {
+		if skip, err = strconv.Atoi(cursor); err != nil {
+			return
+		}
+	}
+	read := 0
+	for _, name := range names {
+		if len(*entities) >= limit {
+			break
+		}
+		e := repo.PostMap[name]
+		if (query.Board != "" && e.Board != query.Board) || (query.Id != "" && e.Id != query.Id) {
+			continue
+		}
+		if (!query.Since.IsZero() && e.Date.Before(query.Since)) || (!query.Until.IsZero() && !e.Date.Before(query.Until)) {
+			continue
+		}
+		match := query.Gram == ""
+		for _, g := range e.Grams {
+			match = match || g == query.Gram
+		}
+		if !match {
+			continue
+		}
+		if read++; read <= skip {
+			continue
+		}
+		v := *e
+		*entities = append(*entities, &v)
+	}
+	return strconv.Itoa(skip + len(*entities)), nil
+}
+
+func (repo *BoardStub) DeleteMultiPost(keys []*post.Key) (err error) {
+	for _, key := range keys {
+		delete(repo.PostMap, key.DSKey.Name)
+	}
+	return
+}
+
+func (repo *BoardStub) DeleteBoardPosts(boardName string) (err error) {
+	for name, e := range repo.PostMap {
+		if e.Board == boardName {
+			delete(repo.PostMap, name)
+		}
+	}
+	return
+}
+
+// 板のスレッド一覧を subject.txt の並び順で返す
+func (repo *BoardStub) Subjects(boardName string) []board.Subject {
+	var entities []*thread.Entity
+	keys, _ := repo.GetThreads(repo.BoardKey(boardName), true, &entities)
+	subjects := []board.Subject{}
+	for i, key := range keys {
+		e := entities[i]
+		subjects = append(subjects, board.Subject{
+			ThreadKey:    key.DSKey.Name,
+			ThreadTitle:  e.ThreadTitle,
+			MessageCount: e.MessageCount,
+			LastModified: e.LastModified,
+			Stopped:      e.Stopped,
+			Pinned:       e.Pinned,
+		})
+	}
+	sort.SliceStable(subjects, func(i, j int) bool {
+		return subjects[i].Pinned && !subjects[j].Pinned
+	})
+	return subjects
+}
+
+// 板の書き込み数を返す
+func (repo *BoardStub) WriteCount(boardName string) (count int) {
+	for _, e := range repo.CounterMap[boardName] {
+		count += e.Count
+	}
+	return
+}
+
+// スレッド一覧と書き込み数を、移行前の板エンティティの形にまとめる
+func (repo *BoardStub) BoardView(boardName string) *board.Entity {
+	return &board.Entity{
+		Subjects:   repo.Subjects(boardName),
+		WriteCount: repo.WriteCount(boardName),
+	}
+}
+
+// 板の書き込み数を1つ目のシャードにまとめて設定する
+func (repo *BoardStub) SetWriteCount(boardName string, n int) {
+	delete(repo.CounterMap, boardName)
+	repo.TxPutWriteCounter(nil, repo.WriteCounterKey(1, repo.BoardKey(boardName)), &counter.Entity{Count: n})
+}
+
 type ThreadStub struct {
 	ThreadKey    string
 	ThreadTitle  string
@@ -109,8 +546,11 @@ type ThreadStub struct {
 
 func EmptyBoardStub() *BoardStub {
 	return &BoardStub{
-		BoardMap: make(map[string]*board.Entity),
-		DatMap:   make(map[string]map[string]*dat.Entity),
+		BoardMap:   make(map[string]*board.Entity),
+		DatMap:     make(map[string]map[string]*dat.Entity),
+		ThreadMap:  make(map[string]map[string]*thread.Entity),
+		CounterMap: make(map[string]map[int64]*counter.Entity),
+		PostMap:    make(map[string]*post.Entity),
 	}
 }
 
@@ -119,29 +559,22 @@ func InitialBoardStub(boardNameList ...string) *BoardStub {
 	for _, boardName := range boardNameList {
 		boardStub.BoardMap[boardName] = &board.Entity{}
 		boardStub.DatMap[boardName] = map[string]*dat.Entity{}
+		boardStub.ThreadMap[boardName] = map[string]*thread.Entity{}
 	}
 	return boardStub
 }
 
+// threadsの順にスレッド一覧に並べる
 func NewBoardStub(boardName string, threads []ThreadStub) *BoardStub {
-	stub := &BoardStub{
-		BoardMap: map[string]*board.Entity{
-			boardName: &board.Entity{
-				Subjects: []board.Subject{},
-			},
-		},
-		DatMap: map[string]map[string]*dat.Entity{
-			boardName: make(map[string]*dat.Entity),
-		},
-	}
-	boardEntity := stub.BoardMap[boardName]
-	for _, v := range threads {
-		boardEntity.Subjects = append(boardEntity.Subjects, board.Subject{
-			ThreadKey:    v.ThreadKey,
+	stub := InitialBoardStub(boardName)
+	for i, v := range threads {
+		stub.ThreadMap[boardName][v.ThreadKey] = &thread.Entity{
 			ThreadTitle:  v.ThreadTitle,
 			MessageCount: v.MessageCount,
 			LastModified: v.LastModified,
-		})
+			AgedAt:       time.Unix(0, 0).Add(-time.Duration(i) * time.Second),
+			Live:         true,
+		}
 		stub.DatMap[boardName][v.ThreadKey] = &dat.Entity{
 			Bytes:        []byte(v.Dat),
 			LastModified: v.LastModified,
@@ -173,3 +606,51 @@ func (repo *BrokenBoardStub) TxGetAllBoard(tx *datastore.Transaction, entities *
 func (repo *BrokenBoardStub) TxPutMultiBoard(tx *datastore.Transaction, keys []*board.Key, entities []*board.Entity) (err error) {
 	return fmt.Errorf("[boardstub dummy error] TxPutMultiBoard(tx, %v)", keys)
 }
+
+func (repo *BrokenBoardStub) PutAuditLog(entity *audit.Entity) (err error) {
+	return fmt.Errorf("[boardstub dummy error] PutAuditLog(%v)", entity)
+}
+
+func (repo *BrokenBoardStub) GetAuditLogs(since, until time.Time, limit int, entities *[]*audit.Entity) (err error) {
+	return fmt.Errorf("[boardstub dummy error] GetAuditLogs(%v, %v, %v)", since, until, limit)
+}
+
+func (repo *BrokenBoardStub) GetSession(key *session.Key, entity *session.Entity) (err error) {
+	return fmt.Errorf("[boardstub dummy error] GetSession(%v, %v)", key, entity)
+}
+
+func (repo *BrokenBoardStub) PutSession(key *session.Key, entity *session.Entity) (err error) {
+	return fmt.Errorf("[boardstub dummy error] PutSession(%v, %v)", key, entity)
+}
+
+func (repo *BrokenBoardStub) GetAllSession(entities *[]*session.Entity) (keys []*session.Key, err error) {
+	return nil, fmt.Errorf("[boardstub dummy error] GetAllSession(%v)", entities)
+}
+
+func (repo *BrokenBoardStub) GetBoardSetting(key *setting.Key, entity *setting.Entity) (err error) {
+	return fmt.Errorf("[boardstub dummy error] GetBoardSetting(%v, %v)", key, entity)
+}
+
+func (repo *BrokenBoardStub) PutBoardSetting(key *setting.Key, entity *setting.Entity) (err error) {
+	return fmt.Errorf("[boardstub dummy error] PutBoardSetting(%v, %v)", key, entity)
+}
+
+func (repo *BrokenBoardStub) GetMaintenance(key *maintenance.Key, entity *maintenance.Entity) (err error) {
+	return fmt.Errorf("[boardstub dummy error] GetMaintenance(%v, %v)", key, entity)
+}
+
+func (repo *BrokenBoardStub) PutMaintenance(key *maintenance.Key, entity *maintenance.Entity) (err error) {
+	return fmt.Errorf("[boardstub dummy error] PutMaintenance(%v, %v)", key, entity)
+}
+
+func (repo *BrokenBoardStub) GetThreads(parent *board.Key, liveOnly bool, entities *[]*thread.Entity) (keys []*thread.Key, err error) {
+	return nil, fmt.Errorf("[boardstub dummy error] GetThreads(%v, %v)", parent, liveOnly)
+}
+
+func (repo *BrokenBoardStub) SearchPosts(query *post.Query, cursor string, limit int, entities *[]*post.Entity) (next string, err error) {
+	return "", fmt.Errorf("[boardstub dummy error] SearchPosts(%v, %v, %v)", query, cursor, limit)
+}
+
+func (repo *BrokenBoardStub) GetWriteCounters(parent *board.Key, entities *[]*counter.Entity) (keys []*counter.Key, err error) {
+	return nil, fmt.Errorf("[boardstub dummy error] GetWriteCounters(%v)", parent)
+}
@@ -4,9 +4,16 @@ import (
 	"cloud.google.com/go/datastore"
 	"context"
 	"github.com/tempxla/stub2ch/configs/app/config"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/audit"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/board"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/counter"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/dat"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/maintenance"
 	"github.com/tempxla/stub2ch/internal/app/types/entity/memcache"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/post"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/session"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/setting"
+	"github.com/tempxla/stub2ch/internal/app/types/entity/thread"
 	"testing"
 )
 
@@ -34,7 +41,15 @@ func CleanDatastoreBy(t *testing.T, ctx context.Context, client *datastore.Clien
 	kinds := []string{
 		board.KIND,
 		dat.KIND,
+		dat.CHUNK_KIND,
 		memcache.KIND,
+		audit.KIND,
+		session.KIND,
+		setting.KIND,
+		maintenance.KIND,
+		thread.KIND,
+		counter.KIND,
+		post.KIND,
 	}
 
 	for _, kind := range kinds {
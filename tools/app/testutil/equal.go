@@ -35,6 +35,14 @@ func EqualBoardEntity(t *testing.T, a *board.Entity, b *board.Entity) bool {
 			t.Errorf("%d: v.LastModified = %v, w.LastModified = %v", i, v.LastModified, w.LastModified)
 			ret = false
 		}
+		if v.Stopped != w.Stopped {
+			t.Errorf("%d: v.Stopped = %v, w.Stopped = %v", i, v.Stopped, w.Stopped)
+			ret = false
+		}
+		if v.Pinned != w.Pinned {
+			t.Errorf("%d: v.Pinned = %v, w.Pinned = %v", i, v.Pinned, w.Pinned)
+			ret = false
+		}
 	}
 
 	// WriteCount
@@ -100,5 +108,26 @@ func EqualDatEntity(t *testing.T, a *dat.Entity, b *dat.Entity) bool {
 		ret = false
 	}
 
+	if a.Sjis != b.Sjis {
+		t.Errorf("a.Sjis = %v, b.Sjis = %v", a.Sjis, b.Sjis)
+		ret = false
+	}
+
+	if a.Size != b.Size {
+		t.Errorf("a.Size = %v, b.Size = %v", a.Size, b.Size)
+		ret = false
+	}
+
+	if la, lb := len(a.Anchors), len(b.Anchors); la != lb {
+		t.Errorf("len(a.Anchors) = %d, len(b.Anchors) = %d", la, lb)
+		return false
+	}
+	for i, v := range a.Anchors {
+		if w := b.Anchors[i]; v != w {
+			t.Errorf("%d: v.Anchors = %v, w.Anchors = %v", i, v, w)
+			ret = false
+		}
+	}
+
 	return ret
 }
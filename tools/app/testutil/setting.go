@@ -13,6 +13,8 @@ func (_ *SettingStub) BBS_SUBJECT_COUNT() int       { return 128 }
 func (_ *SettingStub) BBS_NAME_COUNT() int          { return 96 }
 func (_ *SettingStub) BBS_MAIL_COUNT() int          { return 32 }
 func (_ *SettingStub) BBS_MESSAGE_COUNT() int       { return 4096 }
+func (_ *SettingStub) BBS_LINE_NUMBER() int         { return 16 }
+func (_ *SettingStub) BBS_COLUMN_NUMBER() int       { return 256 }
 func (_ *SettingStub) BBS_THREAD_TATESUGI() int     { return 8 }
 func (_ *SettingStub) BBS_SLIP() string             { return "verbose" }
 func (_ *SettingStub) BBS_DISP_IP() string          { return "" }
@@ -28,6 +30,8 @@ func (_ *SettingStub) STUB_WRITE_ENTITY_LIMIT() int { return 7 }
 func (_ *SettingStub) STUB_THREAD_COUNT() int       { return 5 }
 func (_ *SettingStub) STUB_MESSAGE_COUNT() int      { return 1000 }
 func (_ *SettingStub) STUB_DAT_CAPACITY() int       { return 500 * 1024 }
+func (_ *SettingStub) STUB_HOSHU_HOURS() int        { return 0 }
+func (_ *SettingStub) STUB_SOKUOCHI_RES() int       { return 0 }
 
 func NewSettingStub() bbscfg.Setting {
 	return &SettingStub{}
@@ -15,3 +15,67 @@ function WriteCount(mode){
     frm.action = "/test/_admin/func/write-limit/" + mode
     frm.submit();
 }
+
+function Migrate(mode){
+    var frm = document.getElementById("f1");
+    frm.action = "/test/_admin/func/migrate/" + mode
+    frm.submit();
+}
+
+function Session(mode){
+    var frm = document.getElementById("f1");
+    frm.action = "/test/_admin/func/session/" + mode
+    frm.submit();
+}
+
+function RevokeSession(id){
+    var frm = document.getElementById("f1");
+    var input = document.getElementById("f1-id");
+    input.value = id;
+    input.disabled = false;
+    frm.action = "/test/_admin/func/session/revoke"
+    frm.submit();
+}
+
+function BoardSetting(mode, board){
+    var frm = document.getElementById("f1");
+    var input = document.getElementById("f1-board");
+    input.value = board;
+    input.disabled = false;
+    frm.action = "/test/_admin/func/setting/" + mode
+    frm.submit();
+}
+
+function Thread(mode){
+    var frm = document.getElementById("f4");
+    frm.action = "/test/_admin/func/thread/" + mode
+    frm.submit();
+}
+
+function Export(board){
+    var frm = document.getElementById("f1");
+    var input = document.getElementById("f1-board");
+    input.value = board;
+    input.disabled = false;
+    frm.action = "/test/_admin/archive/export"
+    frm.submit();
+    input.disabled = true;
+}
+
+function Maintenance(mode){
+    var frm = document.getElementById("f7");
+    frm.action = "/test/_admin/func/maintenance/" + mode
+    frm.submit();
+}
+
+function Hoshu(mode){
+    var frm = document.getElementById("f8");
+    frm.action = "/test/_admin/func/hoshu/" + mode
+    frm.submit();
+}
+
+function Search(mode){
+    var frm = document.getElementById("f9");
+    frm.action = "/test/_admin/func/search/" + mode
+    frm.submit();
+}
@@ -22,6 +22,17 @@ function BodyOnload(boardName, threadKey) {
         }
     }
 
+    // which precure
+    var p = localStorage.getItem("precure");
+    if (p != null) {
+        precure.innerHTML = p;
+    }
+
+    // server rendered
+    if (messages.children.length > 0) {
+        return;
+    }
+
     // display cache
     var jsonDat = localStorage.getItem(boardName + "_" + threadKey);
     if (!jsonDat) {
@@ -42,12 +53,6 @@ function BodyOnload(boardName, threadKey) {
 
     document.title = dat.thread_title;
     title.innerHTML = dat.thread_title;
-
-    // which precure
-    var p = localStorage.getItem("precure");
-    if (p != null) {
-        precure.innerHTML = p;
-    }
 }
 
 function GetDat(btn, boardName, threadKey) {
@@ -170,6 +175,14 @@ function createMessageFragment(obj) {
         // message
         row = createDivInnerHtml("row", msg.content);
         frag.appendChild(row);
+        // replies
+        if (msg.replies && msg.replies.length > 0) {
+            var replies = "";
+            for (var j = 0; j < msg.replies.length; j++) {
+                replies += "&lt;&lt;" + msg.replies[j] + " ";
+            }
+            frag.appendChild(createDivInnerHtml("row", replies));
+        }
         // br
         frag.appendChild(document.createElement("br"));
     }